@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/nace/brezno/internal/cli"
 	"github.com/nace/brezno/internal/container"
@@ -16,17 +22,109 @@ var version = "dev"
 
 var (
 	verbose bool
+	debug   bool
 	quiet   bool
 	noColor bool
+	useSudo bool
+
+	jsonErrors       bool
+	legacyJSONErrors bool
+	procRoot         string
+	promptTimeout    time.Duration
+	keyfileDir       string
 
 	ctx  *cli.GlobalContext
 	once sync.Once
+	// ready is closed once ctx has been rebuilt from the parsed flags.
+	// Until then the signal handler must not touch ctx.
+	ready = make(chan struct{})
 )
 
+// exit is os.Exit, replaced in tests of the signal handler
+var exit = os.Exit
+
+// interruptGracePeriod is how long a cancelled command gets to return and
+// run its own cleanup before the signal handler runs it instead
+const interruptGracePeriod = 3 * time.Second
+
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
+	cmd, err := rootCmd.ExecuteContextC(runCtx)
+	// Repeat the warnings whether or not the command failed; cobra's
+	// PersistentPostRun would skip them after an error, when they matter most
+	ctx.Logger.Summary()
+	if err != nil {
+		reportError(os.Stderr, cmd, err)
+		os.Exit(cli.ExitCode(err))
+	}
+}
+
+// reportError prints a failed command's error: as JSON with --json-errors
+// or --legacy-json-errors, else as cobra would, followed by the command's usage
+func reportError(w io.Writer, cmd *cobra.Command, err error) {
+	if jsonErrors || legacyJSONErrors {
+		cli.WriteJSONError(w, err, legacyJSONErrors)
+		return
+	}
+	fmt.Fprintln(w, cmd.ErrPrefix(), err.Error())
+	fmt.Fprintln(w, cmd.UsageString())
+}
+
+// handleSignals cancels the running command on Ctrl-C or SIGTERM so it can
+// release loop devices and mappers. If the command doesn't return in time
+// (e.g., it is blocked on a prompt) or a second signal arrives, the pending
+// cleanup stacks are run here before exiting.
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	onSignals(signals, cancel)
+}
+
+// onSignals waits for the first of signals and handles it as described for
+// handleSignals
+func onSignals(signals <-chan os.Signal, cancel context.CancelFunc) {
+	<-signals
+	// Ctrl-C at a password prompt would otherwise leave echo disabled
+	ui.RestoreTerminal()
+	select {
+	case <-ready:
+	default:
+		// Interrupted while parsing flags or building ctx: no command has
+		// started, so there is nothing to clean up
+		fmt.Fprintln(os.Stderr, "Interrupted")
+		cancel()
+		exit(130)
+		return
+	}
+	ctx.Logger.Warning("Interrupted, cleaning up...")
+	cancel()
+
+	select {
+	case <-signals:
+	case <-time.After(interruptGracePeriod):
+	}
+
+	if err := system.ExecuteActiveCleanups(); err != nil {
+		ctx.Logger.Warning("Cleanup errors occurred: %v", err)
 	}
+	exit(130)
+}
+
+// buildContext rebuilds ctx with the parsed flag values, in place since
+// every command holds a pointer to it, and then closes ready
+func buildContext() {
+	if useSudo {
+		system.EnableSudoReexec()
+	}
+	ui.SetPromptTimeout(promptTimeout)
+	system.SetKeyfileDir(keyfileDir)
+
+	*ctx = *cli.NewGlobalContext(verbose, quiet, noColor, verbose || debug)
+	ctx.Discovery.SetMountSource(container.ProcMountSource{Root: procRoot})
+	close(ready)
 }
 
 var rootCmd = &cobra.Command{
@@ -38,39 +136,51 @@ It provides a simple interface for creating, mounting, and managing
 dm-crypt containers similar to VeraCrypt but CLI-only and using
 standard Linux encryption tools (cryptsetup, dm-crypt).`,
 	Version: version,
+	// main reports errors itself, since a flag error is returned before
+	// PersistentPreRun could tell cobra about --json-errors
+	SilenceErrors: true,
+	SilenceUsage:  true,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		// Update context components with parsed flag values
-		once.Do(func() {
-			// Recreate executor and logger with parsed flags
-			ctx.Executor = system.NewExecutor(verbose)
-			ctx.Logger = ui.NewLogger(verbose, quiet, noColor)
-
-			// Recreate managers with new executor
-			ctx.LoopManager = container.NewLoopManager(ctx.Executor)
-			ctx.LUKSManager = container.NewLUKSManager(ctx.Executor)
-			ctx.MountMgr = container.NewMountManager(ctx.Executor)
-			ctx.Discovery = container.NewDiscovery(ctx.Executor)
-		})
+		once.Do(buildContext)
 	},
 }
 
 func init() {
 	// Global flags
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output (show debug info and commands)")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Print each external command as it is run (implied by --verbose)")
 	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Quiet mode (suppress non-error output)")
 	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable color output")
+	rootCmd.PersistentFlags().BoolVar(&jsonErrors, "json-errors", false, "Report errors on stderr as JSON: {\"error\": ..., \"code\": N}")
+	rootCmd.PersistentFlags().BoolVar(&legacyJSONErrors, "legacy-json-errors", false, "Like --json-errors, without the schema_version field")
+	rootCmd.PersistentFlags().StringVar(&procRoot, "proc-root", "/proc", "Read the mount table from this proc filesystem")
+	rootCmd.PersistentFlags().MarkHidden("proc-root")
+	rootCmd.PersistentFlags().BoolVar(&useSudo, "sudo", false, "Re-run commands that need root under sudo")
+	rootCmd.PersistentFlags().StringVar(&keyfileDir, "keyfile-dir", "", "Look up --keyfile names that are not an existing path in this directory")
+	rootCmd.PersistentFlags().DurationVar(&promptTimeout, "prompt-timeout", 0, "Give up on password prompts after this long without input (e.g., 60s; 0 waits forever)")
 
 	// Create initial context with default values
 	// Will be updated in PersistentPreRun with parsed flag values
-	ctx = cli.NewGlobalContext(false, false, false)
+	ctx = cli.NewGlobalContext(false, false, false, false)
 
 	// Register commands
 	rootCmd.AddCommand(cli.NewCreateCommand(ctx))
 	rootCmd.AddCommand(cli.NewMountCommand(ctx))
 	rootCmd.AddCommand(cli.NewUnmountCommand(ctx))
 	rootCmd.AddCommand(cli.NewListCommand(ctx))
+	rootCmd.AddCommand(cli.NewStatsCommand(ctx))
+	rootCmd.AddCommand(cli.NewHistoryCommand(ctx))
 	rootCmd.AddCommand(cli.NewResizeCommand(ctx))
 	rootCmd.AddCommand(cli.NewPasswordCommand(ctx))
+	rootCmd.AddCommand(cli.NewVerifyCommand(ctx))
+	rootCmd.AddCommand(cli.NewReencryptCommand(ctx))
+	rootCmd.AddCommand(cli.NewDestroyCommand(ctx))
+	rootCmd.AddCommand(cli.NewCompareCommand(ctx))
+	rootCmd.AddCommand(cli.NewCleanupCommand(ctx))
+	rootCmd.AddCommand(cli.NewKeyringCommand(ctx))
+	rootCmd.AddCommand(cli.NewTPMCommand(ctx))
+	rootCmd.AddCommand(cli.NewFIDO2Command(ctx))
 
 	// Set up help templates
 	rootCmd.SetHelpCommand(&cobra.Command{
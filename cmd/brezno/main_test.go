@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestJSONErrorForFlagError(t *testing.T) {
+	defer func() { jsonErrors = false }()
+
+	// cobra must print nothing itself, main reports the error
+	var cobraOut bytes.Buffer
+	rootCmd.SetOut(&cobraOut)
+	rootCmd.SetErr(&cobraOut)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+	rootCmd.SetArgs([]string{"--json-errors", "list", "--no-such-flag"})
+	defer rootCmd.SetArgs(nil)
+
+	cmd, err := rootCmd.ExecuteC()
+	if err == nil {
+		t.Fatal("unknown flag accepted")
+	}
+	if cobraOut.Len() != 0 {
+		t.Errorf("cobra printed %q", cobraOut.String())
+	}
+
+	var stderr bytes.Buffer
+	reportError(&stderr, cmd, err)
+	var got struct {
+		SchemaVersion int    `json:"schema_version"`
+		Error         string `json:"error"`
+		Code          int    `json:"code"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &got); err != nil {
+		t.Fatalf("stderr is not one JSON object: %q", stderr.String())
+	}
+	if got.SchemaVersion != 1 || got.Code != 1 || !strings.Contains(got.Error, "unknown flag: --no-such-flag") {
+		t.Errorf("JSON error = %+v", got)
+	}
+}
+
+func TestLegacyJSONError(t *testing.T) {
+	defer func() { legacyJSONErrors = false }()
+	rootCmd.SetArgs([]string{"--legacy-json-errors", "list", "--no-such-flag"})
+	defer rootCmd.SetArgs(nil)
+
+	cmd, err := rootCmd.ExecuteC()
+	if err == nil {
+		t.Fatal("unknown flag accepted")
+	}
+	var stderr bytes.Buffer
+	reportError(&stderr, cmd, err)
+	var got map[string]interface{}
+	if err := json.Unmarshal(stderr.Bytes(), &got); err != nil {
+		t.Fatalf("stderr is not one JSON object: %q", stderr.String())
+	}
+	if _, ok := got["schema_version"]; ok || got["code"] != 1.0 {
+		t.Errorf("legacy JSON error = %v", got)
+	}
+}
+
+func TestHumanErrorShowsUsage(t *testing.T) {
+	rootCmd.SetArgs([]string{"list", "--no-such-flag"})
+	defer rootCmd.SetArgs(nil)
+
+	cmd, err := rootCmd.ExecuteC()
+	if err == nil {
+		t.Fatal("unknown flag accepted")
+	}
+
+	var stderr bytes.Buffer
+	reportError(&stderr, cmd, err)
+	out := stderr.String()
+	if !strings.HasPrefix(out, "Error: unknown flag: --no-such-flag\n") || !strings.Contains(out, "Usage:\n  brezno list") {
+		t.Errorf("human error output = %q", out)
+	}
+}
+
+// TestSignalDuringInit interrupts while the context is being rebuilt from
+// the flags. Run with -race: the handler must not read ctx before ready.
+func TestSignalDuringInit(t *testing.T) {
+	defer func() { exit = os.Exit }()
+	codes := make(chan int, 1)
+	exit = func(code int) {
+		codes <- code
+		runtime.Goexit()
+	}
+
+	for i := 0; i < 20; i++ {
+		once = sync.Once{}
+		ready = make(chan struct{})
+
+		signals := make(chan os.Signal, 2)
+		cancelled := make(chan struct{})
+		go onSignals(signals, func() { close(cancelled) })
+
+		built := make(chan struct{})
+		go func() {
+			once.Do(buildContext)
+			close(built)
+		}()
+		// A second signal skips the grace period if the handler got as far
+		// as waiting for the command to return
+		signals <- os.Interrupt
+		signals <- os.Interrupt
+
+		if code := <-codes; code != 130 {
+			t.Fatalf("exit code = %d, want 130", code)
+		}
+		<-built
+		select {
+		case <-cancelled:
+		default:
+			t.Fatal("command not cancelled")
+		}
+	}
+}
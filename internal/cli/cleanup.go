@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// CleanupCommand handles removing orphaned loop devices and mappers
+type CleanupCommand struct {
+	ctx *GlobalContext
+	yes bool
+	all bool
+}
+
+// orphan is a loop device or crypt mapping left behind, e.g. by a crash
+type orphan struct {
+	Kind       string // "mapper" or "loop"
+	MapperName string // Set for mappers
+	LoopDevice string // Backing loop device of a mapper, or the loop device itself
+	Path       string // Backing file, if known
+	Reason     string
+	Managed    bool // Created or opened by brezno
+}
+
+// deletedSuffix is appended by the kernel to the backing file of a loop
+// device whose file has been deleted
+const deletedSuffix = " (deleted)"
+
+// NewCleanupCommand creates the cleanup command
+func NewCleanupCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &CleanupCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:     "cleanup",
+		Aliases: []string{"gc"},
+		Short:   "Close orphaned mappers and detach orphaned loop devices",
+		Long: `Find loop devices and crypt mappers left behind by crashes or interrupted
+operations, and offer to close and detach them.
+
+Orphans are:
+  - unmounted crypt mappers whose container file is gone
+  - loop devices whose backing file was deleted
+  - loop devices attached to a brezno container that is not open
+
+Only resources brezno created are touched unless --all is given. Mounted
+containers and containers opened with 'mount --open-only' are left alone.`,
+		Example: `  # Show orphans and confirm before removing them
+  brezno cleanup
+
+  # Non-interactive, including crypt devices brezno did not create
+  brezno cleanup --yes --all`,
+		Args: cobra.NoArgs,
+		RunE: cmd.Run,
+	}
+
+	cobraCmd.Flags().BoolVarP(&cmd.yes, "yes", "y", false, "Remove orphans without asking")
+	cobraCmd.Flags().BoolVar(&cmd.all, "all", false, "Also remove orphans brezno did not create")
+
+	return cobraCmd
+}
+
+// Run executes the cleanup command
+func (c *CleanupCommand) Run(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	containers, err := c.ctx.Discovery.DiscoverActive(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover containers: %w", err)
+	}
+	loops, err := c.ctx.LoopManager.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	isManagedFile := func(path string) bool {
+		token, err := c.ctx.LUKSManager.GetToken(ctx, path)
+		return err == nil && token != nil
+	}
+	orphans := findOrphans(containers, loops, fileExists, isManagedFile)
+
+	var selected, skipped []orphan
+	for _, o := range orphans {
+		if o.Managed || c.all {
+			selected = append(selected, o)
+		} else {
+			skipped = append(skipped, o)
+		}
+	}
+	if len(skipped) > 0 {
+		c.ctx.Logger.Info("Skipping %d orphan(s) not created by brezno (use --all to include them)", len(skipped))
+	}
+	if len(selected) == 0 {
+		fmt.Println("No orphaned resources found")
+		return nil
+	}
+
+	table := ui.NewTable("TYPE", "DEVICE", "CONTAINER", "REASON")
+	table.NoColor = c.ctx.Logger.NoColor
+	for _, o := range selected {
+		device := o.LoopDevice
+		if o.Kind == "mapper" {
+			device = "/dev/mapper/" + o.MapperName
+		}
+		path := o.Path
+		if path == "" {
+			path = "-"
+		}
+		table.AddRow(o.Kind, device, path, o.Reason)
+	}
+	table.Print()
+
+	if !c.yes && !ui.PromptConfirm(fmt.Sprintf("Remove %d orphaned resource(s)?", len(selected))) {
+		return fmt.Errorf("cleanup cancelled by user")
+	}
+
+	return c.remove(ctx, selected)
+}
+
+// remove closes orphaned mappers (and detaches their loop devices) and
+// detaches orphaned loop devices, carrying on past failures
+func (c *CleanupCommand) remove(ctx context.Context, orphans []orphan) error {
+	var errs []error
+	for _, o := range orphans {
+		if err := c.removeOne(ctx, o); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("some orphans could not be removed:\n%w", err)
+	}
+	c.ctx.Logger.Success("Removed %d orphaned resource(s)", len(orphans))
+	return nil
+}
+
+// removeOne removes a single orphan. A container lock it takes is released
+// before it returns, so containers already dealt with can be used again
+// while the rest are removed.
+func (c *CleanupCommand) removeOne(ctx context.Context, o orphan) error {
+	switch o.Kind {
+	case "mapper":
+		c.ctx.Logger.Info("Closing /dev/mapper/%s...", o.MapperName)
+		if err := c.ctx.closeMapper(ctx, o.MapperName); err != nil {
+			return fmt.Errorf("close %s: %w", o.MapperName, err)
+		}
+		if o.LoopDevice != "" {
+			if err := c.ctx.detachLoop(ctx, o.LoopDevice, o.MapperName); err != nil {
+				return fmt.Errorf("detach %s: %w", o.LoopDevice, err)
+			}
+		}
+	case "loop":
+		if fileExists(o.Path) {
+			// Another brezno may have just attached it and be about
+			// to open it; its lock says so
+			lock, err := lockContainer(o.Path)
+			if err != nil {
+				c.ctx.Logger.Warning("Skipping %s: %v", o.LoopDevice, err)
+				return nil
+			}
+			defer lock.Unlock()
+		}
+
+		c.ctx.Logger.Info("Detaching %s...", o.LoopDevice)
+		err := system.RetryBusy(ctx, func() error {
+			return c.ctx.LoopManager.Detach(ctx, o.LoopDevice)
+		})
+		if err != nil {
+			return fmt.Errorf("detach %s: %w", o.LoopDevice, err)
+		}
+	}
+	return nil
+}
+
+// findOrphans correlates open mappers with loop devices and the files
+// behind them. A mapper is an orphan if it is not mounted and its file is
+// gone. A loop device no mapper uses is an orphan if its file is gone, or
+// if the file is a brezno container (a leftover from a failed open).
+func findOrphans(containers []container.Container, loops map[string]string,
+	exists func(path string) bool, isManagedFile func(path string) bool) []orphan {
+	var orphans []orphan
+	usedLoops := make(map[string]bool)
+
+	for _, cont := range containers {
+		if cont.LoopDevice != "" {
+			usedLoops[cont.LoopDevice] = true
+		}
+		if cont.MountPoint != "" || len(cont.Unreadable) > 0 {
+			// Mounted, or too little is known to be sure it isn't
+			continue
+		}
+
+		path := strings.TrimSuffix(cont.Path, deletedSuffix)
+		o := orphan{
+			Kind:       "mapper",
+			MapperName: cont.MapperName,
+			LoopDevice: cont.LoopDevice,
+			Path:       path,
+			// Judge by name: the file the marker token is in is gone
+			Managed: container.IsManaged(container.Container{Path: path, MapperName: cont.MapperName}),
+		}
+		switch {
+		case cont.Path == "":
+			o.Reason = "no backing file"
+		case strings.HasSuffix(cont.Path, deletedSuffix) || !exists(path):
+			o.Reason = "container file deleted"
+		default:
+			// Open but not mounted, e.g. with mount --open-only
+			continue
+		}
+		orphans = append(orphans, o)
+	}
+
+	devices := make([]string, 0, len(loops))
+	for device := range loops {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	for _, device := range devices {
+		if usedLoops[device] {
+			continue
+		}
+
+		backFile := loops[device]
+		path := strings.TrimSuffix(backFile, deletedSuffix)
+		o := orphan{Kind: "loop", LoopDevice: device, Path: path}
+		switch {
+		case strings.HasSuffix(backFile, deletedSuffix) || !exists(path):
+			o.Reason = "backing file deleted"
+			// Whether brezno attached it can no longer be checked
+		case isManagedFile(path):
+			o.Reason = "container attached but not open"
+			o.Managed = true
+		default:
+			// Some other user of loop devices
+			continue
+		}
+		orphans = append(orphans, o)
+	}
+
+	return orphans
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
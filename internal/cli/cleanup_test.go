@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestFindOrphans(t *testing.T) {
+	existing := map[string]bool{
+		"/data/secrets.img": true,
+		"/data/open.img":    true,
+		"/data/other.img":   true,
+		"/data/stale.img":   true,
+	}
+	exists := func(path string) bool { return existing[path] }
+	isManagedFile := func(path string) bool { return path == "/data/stale.img" }
+
+	containers := []container.Container{
+		// Mounted: in use
+		{MapperName: "secrets_img", Path: "/data/secrets.img", LoopDevice: "/dev/loop0", MountPoint: "/mnt/secrets"},
+		// Open with --open-only, its file is still there
+		{MapperName: "open_img", Path: "/data/open.img", LoopDevice: "/dev/loop1"},
+		// File deleted while open, as losetup reports it
+		{MapperName: "gone_img", Path: "/data/gone.img (deleted)", LoopDevice: "/dev/loop2"},
+		// File deleted, and the mapper wasn't brezno's
+		{MapperName: "luks-1234", Path: "/data/moved.img", LoopDevice: "/dev/loop3"},
+		// No loop device behind it, e.g. a partition
+		{MapperName: "luks-part"},
+		// Mount table unreadable: not enough is known
+		{MapperName: "unknown_img", Path: "/data/unknown.img", Unreadable: []string{"mount point"}},
+	}
+	loops := map[string]string{
+		"/dev/loop0": "/data/secrets.img",
+		"/dev/loop1": "/data/open.img",
+		"/dev/loop2": "/data/gone.img (deleted)",
+		"/dev/loop3": "/data/moved.img",
+		"/dev/loop4": "/data/stale.img",             // brezno container attached but not open
+		"/dev/loop5": "/data/other.img",             // someone else's loop device
+		"/dev/loop6": "/data/deleted.iso (deleted)", // backing file gone
+	}
+
+	got := findOrphans(containers, loops, exists, isManagedFile)
+	want := []orphan{
+		{Kind: "mapper", MapperName: "gone_img", LoopDevice: "/dev/loop2", Path: "/data/gone.img", Reason: "container file deleted", Managed: true},
+		{Kind: "mapper", MapperName: "luks-1234", LoopDevice: "/dev/loop3", Path: "/data/moved.img", Reason: "container file deleted"},
+		{Kind: "mapper", MapperName: "luks-part", Reason: "no backing file"},
+		{Kind: "loop", LoopDevice: "/dev/loop4", Path: "/data/stale.img", Reason: "container attached but not open", Managed: true},
+		{Kind: "loop", LoopDevice: "/dev/loop6", Path: "/data/deleted.iso", Reason: "backing file deleted"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findOrphans =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestFindOrphansNone(t *testing.T) {
+	containers := []container.Container{
+		{MapperName: "secrets_img", Path: "/data/secrets.img", LoopDevice: "/dev/loop0", MountPoint: "/mnt/secrets"},
+	}
+	loops := map[string]string{"/dev/loop0": "/data/secrets.img"}
+	exists := func(string) bool { return true }
+	if got := findOrphans(containers, loops, exists, exists); len(got) != 0 {
+		t.Errorf("findOrphans = %+v, want none", got)
+	}
+}
+
+func TestCleanupRemoveOneReleasesLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.img")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := lockContainer(path)
+	if err != nil {
+		t.Skipf("cannot take container locks: %v", err)
+	}
+	lock.Unlock()
+
+	exec := testutil.NewFakeExecutor()
+	c := &CleanupCommand{ctx: newTestContext(exec, newFakeCryptSetup())}
+	o := orphan{Kind: "loop", LoopDevice: "/dev/loop4", Path: path, Managed: true}
+	if err := c.removeOne(context.Background(), o); err != nil {
+		t.Fatalf("removeOne: %v", err)
+	}
+	if _, ok := exec.Ran("losetup -d /dev/loop4"); !ok {
+		t.Errorf("loop device not detached: %v", exec.Lines())
+	}
+
+	lock, err = lockContainer(path)
+	if err != nil {
+		t.Fatalf("container still locked after removeOne: %v", err)
+	}
+	lock.Unlock()
+}
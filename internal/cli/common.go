@@ -2,7 +2,13 @@ package cli
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/nace/brezno/internal/container"
 	"github.com/nace/brezno/internal/system"
@@ -11,18 +17,30 @@ import (
 
 // GlobalContext holds shared resources for all commands
 type GlobalContext struct {
-	Executor    *system.Executor
+	Executor    system.Executor
 	Logger      *ui.Logger
 	LoopManager *container.LoopManager
-	LUKSManager *container.LUKSManager
+	LUKSManager container.CryptSetup
 	MountMgr    *container.MountManager
 	Discovery   *container.Discovery
+	// Warnings collects what Logger warns about during the command
+	Warnings *ui.WarningCollector
+
+	// dryRun is set by withDryRun
+	dryRun bool
 }
 
-// NewGlobalContext creates a new global context
-func NewGlobalContext(verbose, quiet, noColor bool) *GlobalContext {
-	executor := system.NewExecutor(verbose)
+// NewGlobalContext creates a new global context. debug makes the executor
+// print each external command it runs.
+func NewGlobalContext(verbose, quiet, noColor, debug bool) *GlobalContext {
+	executor := system.NewExecutor(debug)
 	logger := ui.NewLogger(verbose, quiet, noColor)
+	warnings := &ui.WarningCollector{}
+	logger.Collector = warnings
+
+	discovery := container.NewDiscovery(executor)
+	discovery.SetLogger(logger)
+	system.SetWarningLogger(logger)
 
 	return &GlobalContext{
 		Executor:    executor,
@@ -30,10 +48,25 @@ func NewGlobalContext(verbose, quiet, noColor bool) *GlobalContext {
 		LoopManager: container.NewLoopManager(executor),
 		LUKSManager: container.NewLUKSManager(executor),
 		MountMgr:    container.NewMountManager(executor),
-		Discovery:   container.NewDiscovery(executor),
+		Discovery:   discovery,
+		Warnings:    warnings,
 	}
 }
 
+// withDryRun returns a copy of ctx whose loop, LUKS, and mount operations
+// print their commands to out instead of running them. Discovery keeps
+// looking at the real system.
+func (ctx *GlobalContext) withDryRun(out io.Writer) *GlobalContext {
+	executor := system.NewDryRunExecutor(out)
+	dry := *ctx
+	dry.Executor = executor
+	dry.LoopManager = container.NewLoopManager(executor)
+	dry.LUKSManager = container.NewLUKSManager(executor)
+	dry.MountMgr = container.NewMountManager(executor)
+	dry.dryRun = true
+	return &dry
+}
+
 // CheckDependencies checks for required system commands
 func (ctx *GlobalContext) CheckDependencies() error {
 	deps := []string{
@@ -47,12 +80,233 @@ func (ctx *GlobalContext) CheckDependencies() error {
 	return ctx.Executor.CheckDependencies(deps)
 }
 
-// GetAuthMethod determines the authentication method based on keyfile flag.
+// CheckKernelModules verifies the dm_crypt and loop kernel modules are available.
+// If load is true, missing modules are loaded with modprobe.
+func (ctx *GlobalContext) CheckKernelModules(cmdCtx context.Context, load bool) error {
+	for _, module := range []string{"dm_crypt", "loop"} {
+		available, err := system.ModuleAvailable(module)
+		if err != nil {
+			// Can't tell, let the operation itself fail if it must
+			ctx.Logger.Debug("Could not check kernel module %s: %v", module, err)
+			continue
+		}
+		if available {
+			continue
+		}
+
+		if !load {
+			return fmt.Errorf("kernel module %s is not loaded\n"+
+				"Run: modprobe %s (or use --load-modules)", module, module)
+		}
+
+		ctx.Logger.Info("Loading kernel module %s...", module)
+		if err := ctx.Executor.Run(cmdCtx, "modprobe", module); err != nil {
+			return fmt.Errorf("failed to load kernel module %s: %w", module, err)
+		}
+	}
+	return nil
+}
+
+// mapperExists and loopAttached look for the device nodes cleanup acts on.
+// Tests replace them to stand in for devices they cannot create.
+var (
+	mapperExists = container.MapperExists
+	loopAttached = container.IsAttached
+)
+
+// closeMapper closes a LUKS mapping during cleanup. It does nothing if the
+// mapping is already gone, and retries while the device is busy.
+func (ctx *GlobalContext) closeMapper(cmdCtx context.Context, mapperName string) error {
+	// A dry run opened nothing, but prints the close it would do
+	if ctx.dryRun {
+		return ctx.LUKSManager.Close(cmdCtx, mapperName)
+	}
+	if !mapperExists(mapperName) {
+		return nil
+	}
+	return system.RetryBusy(cmdCtx, func() error {
+		return ctx.LUKSManager.Close(cmdCtx, mapperName)
+	})
+}
+
+// detachLoop detaches a loop device during cleanup. It does nothing if the
+// device is already detached, and refuses while mapperName still holds it,
+// since the detach could not succeed until the mapping is closed.
+func (ctx *GlobalContext) detachLoop(cmdCtx context.Context, loopDev, mapperName string) error {
+	if ctx.dryRun {
+		return ctx.LoopManager.Detach(cmdCtx, loopDev)
+	}
+	if !loopAttached(loopDev) {
+		return nil
+	}
+	if mapperExists(mapperName) {
+		return fmt.Errorf("skipped, still held by /dev/mapper/%s", mapperName)
+	}
+	return system.RetryBusy(cmdCtx, func() error {
+		return ctx.LoopManager.Detach(cmdCtx, loopDev)
+	})
+}
+
+// lockContainer takes the per-container lock so that two brezno invocations
+// cannot operate on the same container at once. Release it with Unlock.
+func lockContainer(path string) (*system.FileLock, error) {
+	// Lock the canonical path so symlinks to the container share the lock
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	lock, err := system.LockPath(path)
+	if errors.Is(err, system.ErrLocked) {
+		return nil, fmt.Errorf("another brezno operation is in progress for this container: %s", path)
+	}
+	return lock, err
+}
+
+// backupHeaderBefore saves a timestamped copy of the LUKS header of path
+// into dir ahead of an operation that rewrites it (--backup-header-before).
+// It does nothing if dir is empty. Callers must abort if it fails.
+func (ctx *GlobalContext) backupHeaderBefore(cmdCtx context.Context, dir, path string) error {
+	if dir == "" {
+		return nil
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("invalid header backup directory: %w", err)
+	}
+	if err := os.MkdirAll(absDir, 0700); err != nil {
+		return fmt.Errorf("failed to create header backup directory: %w", err)
+	}
+
+	base := filepath.Join(absDir, fmt.Sprintf("%s.%s", filepath.Base(path), time.Now().Format("20060102-150405")))
+	backupFile := base + ".header"
+	// cryptsetup won't overwrite a backup, e.g. one taken the same second
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(backupFile); os.IsNotExist(err) {
+			break
+		}
+		backupFile = fmt.Sprintf("%s-%d.header", base, n)
+	}
+
+	ctx.Logger.Info("Backing up LUKS header...")
+	if err := ctx.LUKSManager.BackupHeader(cmdCtx, path, backupFile); err != nil {
+		return fmt.Errorf("%w (operation aborted, nothing was changed)", err)
+	}
+	ctx.Logger.Success("LUKS header backed up to %s", backupFile)
+	return nil
+}
+
+// writeReport appends the outcome of an operation to the --report file, if
+// one was given, and with --print-json prints it on stdout if it succeeded.
+// Failing to write the report only produces a warning.
+func (ctx *GlobalContext) writeReport(cmdCtx context.Context, reportFile string, printJSON bool, record system.ReportRecord, opErr error) {
+	printJSON = printJSON && opErr == nil
+	if reportFile == "" && !printJSON {
+		return
+	}
+
+	record.Timestamp = time.Now().UTC()
+	record.Warnings = ctx.Warnings.List()
+	record.Success = opErr == nil
+	if opErr != nil {
+		record.Error = opErr.Error()
+	}
+	// Best effort: the container may not be LUKS if the operation failed early
+	if uuid, err := ctx.LUKSManager.UUID(context.WithoutCancel(cmdCtx), record.Path); err == nil {
+		record.UUID = uuid
+	}
+
+	if printJSON {
+		if err := ui.PrintJSON(ui.Envelope{Key: "result", Value: record}); err != nil {
+			ctx.Logger.Warning("Failed to print result: %v", err)
+		}
+	}
+	if reportFile == "" {
+		return
+	}
+	if err := system.AppendReport(reportFile, record); err != nil {
+		ctx.Logger.Warning("Failed to write report: %v", err)
+	}
+}
+
+// GetTokenAuth returns hardware token authentication if tpm or fido2 is set,
+// or nil if neither is, in which case GetAuthMethod should be used instead
+func (ctx *GlobalContext) GetTokenAuth(tpm, fido2 bool) (container.AuthMethod, error) {
+	switch {
+	case tpm && fido2:
+		return nil, fmt.Errorf("--tpm and --fido2 cannot be used together")
+	case tpm:
+		if err := ctx.LUKSManager.CheckTPM(); err != nil {
+			return nil, err
+		}
+		return &container.TPMAuth{}, nil
+	case fido2:
+		if err := ctx.LUKSManager.CheckFIDO2(); err != nil {
+			return nil, err
+		}
+		return &container.FIDO2Auth{}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// GetKeyfileStdinAuth reads a key from stdin for --keyfile-stdin. The other
+// credential flags are rejected since they would compete for stdin or the key.
+// Caller is responsible for calling Zeroize() on StdinKeyAuth.Key when done.
+func GetKeyfileStdinAuth(keyfile string, keyDescription string, passwordStdin bool) (container.AuthMethod, error) {
+	if keyfile != "" || keyDescription != "" || passwordStdin {
+		return nil, fmt.Errorf("--keyfile-stdin cannot be combined with --keyfile, --key-description or --password-stdin")
+	}
+
+	key, err := ui.ReadKeyFromStdin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key from stdin: %w", err)
+	}
+	if key.Len() == 0 {
+		return nil, fmt.Errorf("no key material on stdin")
+	}
+	return &container.StdinKeyAuth{Key: key}, nil
+}
+
+// GetPasswordFileAuth reads a passphrase from the first line of a file for
+// --password-file. Unlike a keyfile, it is used exactly as a typed
+// passphrase would be. The other credential flags are rejected.
+// Caller is responsible for calling Zeroize() on PasswordAuth.Password when done.
+func GetPasswordFileAuth(passwordFile string, keyfile string, keyDescription string, passwordStdin bool) (container.AuthMethod, error) {
+	if keyfile != "" || keyDescription != "" || passwordStdin {
+		return nil, fmt.Errorf("--password-file cannot be combined with --keyfile, --key-description or --password-stdin")
+	}
+
+	resolved, err := system.ValidatePasswordFilePath(passwordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := ui.ReadPasswordFromFile(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password file: %w", err)
+	}
+	if password.Len() == 0 {
+		password.Zeroize()
+		return nil, fmt.Errorf("password file is empty: %s", resolved)
+	}
+	return &container.PasswordAuth{Password: password}, nil
+}
+
+// GetAuthMethod determines the authentication method based on keyfile and key description flags.
+// If keyDescription is set, the passphrase is read from the kernel keyring.
 // If requireConfirmation is true, prompts for password confirmation (for create operations).
 // If passwordStdin is true, reads password from stdin instead of prompting.
 // promptText and confirmText allow customizing the password prompts (empty string = use defaults).
 // Caller is responsible for calling Zeroize() on PasswordAuth.Password when done.
-func GetAuthMethod(keyfile string, requireConfirmation bool, passwordStdin bool, promptText string, confirmText string) (container.AuthMethod, error) {
+func GetAuthMethod(keyfile string, keyDescription string, requireConfirmation bool, passwordStdin bool, promptText string, confirmText string) (container.AuthMethod, error) {
+	if keyDescription != "" {
+		if keyfile != "" {
+			return nil, fmt.Errorf("--keyfile and --key-description cannot be used together")
+		}
+		return &container.KeyringAuth{KeyDescription: keyDescription}, nil
+	}
+
 	if keyfile != "" {
 		// Validate and resolve keyfile path
 		resolvedKeyfile, err := system.ValidateKeyfilePath(keyfile)
@@ -92,6 +346,10 @@ func GetAuthMethod(keyfile string, requireConfirmation bool, passwordStdin bool,
 		if passwordStdin {
 			// Read confirmation from stdin
 			confirmPassword, err = ui.ReadPasswordFromStdin()
+			if errors.Is(err, io.EOF) {
+				password.Zeroize()
+				return nil, fmt.Errorf("expected the password twice on stdin (second line confirms it)")
+			}
 			if err != nil {
 				password.Zeroize()
 				return nil, fmt.Errorf("failed to read password confirmation from stdin: %w", err)
@@ -107,9 +365,48 @@ func GetAuthMethod(keyfile string, requireConfirmation bool, passwordStdin bool,
 
 		if !bytes.Equal(password.Bytes(), confirmPassword.Bytes()) {
 			password.Zeroize()
+			if passwordStdin {
+				return nil, fmt.Errorf("passwords don't match: the two lines on stdin differ")
+			}
 			return nil, fmt.Errorf("passwords don't match")
 		}
 	}
 
 	return &container.PasswordAuth{Password: password}, nil
 }
+
+// resolveExistingLUKS returns the absolute path of the container named in
+// args (or prompted for), verifying it exists and is LUKS formatted
+func (ctx *GlobalContext) resolveExistingLUKS(cmdCtx context.Context, args []string) (string, error) {
+	var containerPath string
+	if len(args) > 0 {
+		containerPath = args[0]
+	} else {
+		containerPath = ui.PromptString("Container file path")
+	}
+
+	// Convert to absolute path
+	absPath, err := filepath.Abs(containerPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %w", err)
+	}
+
+	// Verify container file exists
+	if _, err := os.Stat(absPath); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("container file not found: %s", absPath)
+		}
+		return "", fmt.Errorf("failed to access container: %w", err)
+	}
+
+	// Verify it's a LUKS container
+	isLuks, err := ctx.LUKSManager.IsLUKS(cmdCtx, absPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to check LUKS format: %w", err)
+	}
+	if !isLuks {
+		return "", fmt.Errorf("not a LUKS container: %s", absPath)
+	}
+
+	return absPath, nil
+}
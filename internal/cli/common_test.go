@@ -0,0 +1,425 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+	"github.com/nace/brezno/internal/ui"
+)
+
+// captureStderr returns what f writes to stderr
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stderr, f)
+}
+
+// captureStdout returns what f writes to stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stdout, f)
+}
+
+// capture swaps *stream for a pipe while f runs and returns what was
+// written to it
+func capture(t *testing.T, stream **os.File, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *stream
+	*stream = w
+	defer func() { *stream = saved }()
+
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+
+	f()
+	w.Close()
+	return string(<-done)
+}
+
+func TestNewGlobalContext(t *testing.T) {
+	for _, tt := range []struct{ verbose, quiet, noColor, debug bool }{
+		{},
+		{verbose: true},
+		{quiet: true},
+		{noColor: true},
+		{debug: true},
+		{verbose: true, noColor: true, debug: true},
+	} {
+		ctx := NewGlobalContext(tt.verbose, tt.quiet, tt.noColor, tt.debug)
+		if ctx.Executor == nil || ctx.LoopManager == nil || ctx.LUKSManager == nil ||
+			ctx.MountMgr == nil || ctx.Discovery == nil || ctx.Warnings == nil {
+			t.Fatalf("%+v: incomplete context %+v", tt, ctx)
+		}
+		l := ctx.Logger
+		if l.Verbose != tt.verbose || l.Quiet != tt.quiet || l.NoColor != tt.noColor {
+			t.Errorf("%+v: logger is verbose=%v quiet=%v noColor=%v", tt, l.Verbose, l.Quiet, l.NoColor)
+		}
+		if l.Collector != ctx.Warnings {
+			t.Errorf("%+v: logger warnings are not collected in ctx.Warnings", tt)
+		}
+	}
+}
+
+func TestNewGlobalContextDebug(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		ctx := NewGlobalContext(false, false, true, debug)
+		out := captureStderr(t, func() {
+			if err := ctx.Executor.Run(context.Background(), "true"); err != nil {
+				t.Fatalf("running true: %v", err)
+			}
+		})
+		if traced := strings.Contains(out, "[DEBUG] Executing: true"); traced != debug {
+			t.Errorf("debug=%v: executor printed %q", debug, out)
+		}
+	}
+}
+
+func TestKeyfileWarningFollowsLogger(t *testing.T) {
+	t.Cleanup(func() { system.SetWarningLogger(ui.NewLogger(false, true, true)) })
+	keyfile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyfile, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(keyfile, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, noColor := range []bool{true, false} {
+		ctx := NewGlobalContext(false, false, noColor, false)
+		out := captureStderr(t, func() {
+			if _, err := system.ValidateKeyfilePath(keyfile); err != nil {
+				t.Fatalf("ValidateKeyfilePath: %v", err)
+			}
+		})
+		if !strings.Contains(out, "[WARNING] Keyfile "+keyfile+" has insecure permissions (0644)") {
+			t.Errorf("noColor=%v: no permission warning in %q", noColor, out)
+		}
+		if colored := strings.Contains(out, "\033["); colored == noColor {
+			t.Errorf("noColor=%v: warning %q", noColor, out)
+		}
+		if len(ctx.Warnings.List()) != 1 {
+			t.Errorf("noColor=%v: warning not collected for the summary: %q", noColor, ctx.Warnings.List())
+		}
+	}
+}
+
+func TestWriteReportPrintJSON(t *testing.T) {
+	luks := newFakeCryptSetup()
+	luks.uuid = "0b5e3c1d-7f4a-4e2b-9c8d-1a2b3c4d5e6f"
+	ctx := newTestContext(testutil.NewFakeExecutor(), luks)
+	ctx.Logger.Warning("Keyfile is readable by others")
+	reportFile := filepath.Join(t.TempDir(), "report.jsonl")
+
+	record := system.ReportRecord{Operation: "create", Path: "/data/x.img", Size: 1 << 30, Filesystem: "ext4"}
+	out := captureStdout(t, func() { ctx.writeReport(context.Background(), reportFile, true, record, nil) })
+
+	var got struct {
+		SchemaVersion int                    `json:"schema_version"`
+		Result        map[string]interface{} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("stdout is not one JSON document: %q", out)
+	}
+	if got.SchemaVersion != 1 {
+		t.Errorf("schema_version = %d", got.SchemaVersion)
+	}
+	want := map[string]interface{}{
+		"operation":  "create",
+		"path":       "/data/x.img",
+		"uuid":       luks.uuid,
+		"size":       float64(1 << 30),
+		"filesystem": "ext4",
+		"success":    true,
+		"warnings":   []interface{}{"Keyfile is readable by others"},
+	}
+	timestamp, _ := got.Result["timestamp"].(string)
+	if _, err := time.Parse(time.RFC3339, timestamp); err != nil {
+		t.Errorf("timestamp %q: %v", timestamp, err)
+	}
+	delete(got.Result, "timestamp")
+	if !reflect.DeepEqual(got.Result, want) {
+		t.Errorf("result = %v, want %v", got.Result, want)
+	}
+
+	// The report file gets the same record
+	records, _, err := system.ReadReports(reportFile)
+	if err != nil || len(records) != 1 || records[0].UUID != luks.uuid || !records[0].Success {
+		t.Errorf("report = %+v, %v", records, err)
+	}
+}
+
+func TestWriteReportFailure(t *testing.T) {
+	ctx := newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup())
+	reportFile := filepath.Join(t.TempDir(), "report.jsonl")
+
+	// A failure prints nothing, but is reported
+	record := system.ReportRecord{Operation: "create", Path: "/data/x.img"}
+	out := captureStdout(t, func() {
+		ctx.writeReport(context.Background(), reportFile, true, record, errors.New("mkfs.ext4 failed"))
+	})
+	if out != "" {
+		t.Errorf("printed %q for a failure", out)
+	}
+	records, _, err := system.ReadReports(reportFile)
+	if err != nil || len(records) != 1 || records[0].Success || records[0].Error != "mkfs.ext4 failed" {
+		t.Errorf("report = %+v, %v", records, err)
+	}
+}
+
+// withStdin makes os.Stdin read input until the test ends
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	saved := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = saved
+		r.Close()
+	})
+}
+
+func TestGetAuthMethodStdinConfirmation(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		confirm bool
+		want    string // Password returned, if no error
+		wantErr string
+	}{
+		{name: "matching", input: "hunter2\nhunter2\n", confirm: true, want: "hunter2"},
+		{name: "matching, CRLF", input: "hunter2\r\nhunter2\r\n", confirm: true, want: "hunter2"},
+		{name: "matching, no final newline", input: "hunter2\nhunter2", confirm: true, want: "hunter2"},
+		{name: "mismatching", input: "hunter2\nhunter3\n", confirm: true, wantErr: "the two lines on stdin differ"},
+		{name: "one line", input: "hunter2\n", confirm: true, wantErr: "expected the password twice on stdin"},
+		{name: "unconfirmed", input: "hunter2\n", want: "hunter2"},
+	}
+	for _, tt := range tests {
+		withStdin(t, tt.input)
+		auth, err := GetAuthMethod("", "", tt.confirm, true, "", "")
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: GetAuthMethod() = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: GetAuthMethod() = %v", tt.name, err)
+			continue
+		}
+		pw, ok := auth.(*container.PasswordAuth)
+		if !ok || string(pw.Password.Bytes()) != tt.want {
+			t.Errorf("%s: GetAuthMethod() = %#v, want password %q", tt.name, auth, tt.want)
+		}
+	}
+}
+
+func TestGetPasswordFileAuth(t *testing.T) {
+	ctx := newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup())
+	system.SetWarningLogger(ctx.Logger)
+	t.Cleanup(func() { system.SetWarningLogger(ui.NewLogger(false, true, true)) })
+
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		perm    os.FileMode
+		want    string // Password returned, if no error
+		wantErr string
+		warned  bool
+	}{
+		{name: "trailing newline", content: "hunter2\n", perm: 0600, want: "hunter2"},
+		{name: "CRLF", content: "hunter2\r\n", perm: 0600, want: "hunter2"},
+		{name: "no newline", content: "hunter2", perm: 0600, want: "hunter2"},
+		{name: "first line only", content: "hunter2\nhunter3\n", perm: 0600, want: "hunter2"},
+		{name: "inner spaces kept", content: " hunter 2 \n", perm: 0600, want: " hunter 2 "},
+		{name: "empty", content: "", perm: 0600, wantErr: "password file is empty"},
+		{name: "empty line", content: "\nhunter2\n", perm: 0600, wantErr: "password file is empty"},
+		{name: "world readable", content: "hunter2\n", perm: 0644, want: "hunter2", warned: true},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("pw%d", i))
+		if err := os.WriteFile(path, []byte(tt.content), tt.perm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, tt.perm); err != nil { // Not subject to the umask
+			t.Fatal(err)
+		}
+		before := len(ctx.Warnings.List())
+
+		auth, err := GetPasswordFileAuth(path, "", "", false)
+		warnings := ctx.Warnings.List()[before:]
+		if tt.warned != (len(warnings) == 1 && strings.Contains(warnings[0], "insecure permissions (0644)")) {
+			t.Errorf("%s: warnings %q", tt.name, warnings)
+		}
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: GetPasswordFileAuth() = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		pw, ok := auth.(*container.PasswordAuth)
+		if err != nil || !ok || string(pw.Password.Bytes()) != tt.want {
+			t.Errorf("%s: GetPasswordFileAuth() = %#v, %v; want password %q", tt.name, auth, err, tt.want)
+		}
+	}
+
+	// Only one source of the passphrase may be given
+	path := filepath.Join(dir, "pw0")
+	for _, args := range [][3]string{{"/keys/k", "", ""}, {"", "brezno:pw", ""}, {"", "", "stdin"}} {
+		if _, err := GetPasswordFileAuth(path, args[0], args[1], args[2] != ""); err == nil {
+			t.Errorf("GetPasswordFileAuth accepted %q alongside --password-file", args)
+		}
+	}
+	if _, err := GetPasswordFileAuth(filepath.Join(dir, "missing"), "", "", false); err == nil {
+		t.Error("GetPasswordFileAuth accepted a missing file")
+	}
+	if _, err := GetPasswordFileAuth(dir, "", "", false); err == nil {
+		t.Error("GetPasswordFileAuth accepted a directory")
+	}
+}
+
+func TestCloseMapper(t *testing.T) {
+	errBusy := errors.New("cryptsetup failed: exit status 5\nStderr: Device new_img is still in use.")
+	errOther := errors.New("cryptsetup failed: exit status 1\nStderr: Operation not permitted.")
+
+	tests := []struct {
+		name      string
+		err       error
+		failTimes int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"closed", nil, 0, 1, false},
+		{"busy once, then closed", errBusy, 1, 2, false},
+		{"other error", errOther, 3, 1, true},
+	}
+	for _, tt := range tests {
+		luks := newFakeCryptSetup()
+		withDevices(t, luks)
+		if tt.err != nil {
+			luks.errs["Close"] = tt.err
+			luks.failTimes["Close"] = tt.failTimes
+		}
+		ctx := newTestContext(testutil.NewFakeExecutor(), luks)
+
+		err := ctx.closeMapper(context.Background(), "new_img")
+		if calls := luks.Calls(); len(calls) != tt.wantCalls || (err != nil) != tt.wantErr {
+			t.Errorf("%s: calls %v, err %v; want %d calls", tt.name, calls, err, tt.wantCalls)
+		}
+	}
+
+	// Cleaning up again finds the mapping gone and does nothing
+	luks := newFakeCryptSetup()
+	withDevices(t, luks)
+	ctx := newTestContext(testutil.NewFakeExecutor(), luks)
+	for i := 0; i < 2; i++ {
+		if err := ctx.closeMapper(context.Background(), "new_img"); err != nil {
+			t.Errorf("closeMapper #%d: %v", i+1, err)
+		}
+	}
+	if calls := luks.Calls(); len(calls) != 1 {
+		t.Errorf("calls = %v, want a single close", calls)
+	}
+}
+
+func TestDetachLoop(t *testing.T) {
+	luks := newFakeCryptSetup()
+	withDevices(t, luks)
+	exec := testutil.NewFakeExecutor()
+	ctx := newTestContext(exec, luks)
+
+	// The mapping still holds the loop device, so detaching can't work yet
+	if err := ctx.detachLoop(context.Background(), testLoopDevice, "new_img"); err == nil || !strings.Contains(err.Error(), "still held") {
+		t.Errorf("detachLoop while mapped = %v", err)
+	}
+	if lines := exec.Lines(); len(lines) != 0 {
+		t.Errorf("ran %v while the mapping was open", lines)
+	}
+
+	if err := ctx.closeMapper(context.Background(), "new_img"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.detachLoop(context.Background(), testLoopDevice, "new_img"); err != nil {
+		t.Errorf("detachLoop after close = %v", err)
+	}
+	if _, ok := exec.Ran("losetup -d " + testLoopDevice); !ok {
+		t.Errorf("ran %v, want the detach", exec.Lines())
+	}
+}
+
+// backupWriter is a CryptSetup whose header backups create the backup file,
+// as cryptsetup does
+type backupWriter struct {
+	*fakeCryptSetup
+}
+
+func (b *backupWriter) BackupHeader(ctx context.Context, device, backupFile string) error {
+	if err := b.fakeCryptSetup.BackupHeader(ctx, device, backupFile); err != nil {
+		return err
+	}
+	return os.WriteFile(backupFile, []byte("header"), 0600)
+}
+
+func TestBackupHeaderBefore(t *testing.T) {
+	luks := &backupWriter{newFakeCryptSetup()}
+	ctx := newTestContext(testutil.NewFakeExecutor(), luks)
+
+	// Not requested
+	if err := ctx.backupHeaderBefore(context.Background(), "", "/data/secrets.img"); err != nil || len(luks.Calls()) != 0 {
+		t.Errorf("without a directory: err %v, calls %v", err, luks.Calls())
+	}
+
+	// The directory is created private, and each backup gets its own
+	// timestamped file, even within the same second
+	dir := filepath.Join(t.TempDir(), "backups")
+	for i := 0; i < 3; i++ {
+		if err := ctx.backupHeaderBefore(context.Background(), dir, "/data/secrets.img"); err != nil {
+			t.Fatalf("backup %d: %v", i+1, err)
+		}
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("backup directory: %v, %v", info, err)
+	}
+	name := regexp.MustCompile(`^BackupHeader /data/secrets\.img ` + regexp.QuoteMeta(dir) + `/secrets\.img\.\d{8}-\d{6}(-\d)?\.header$`)
+	seen := make(map[string]bool)
+	for _, call := range luks.Calls() {
+		if !name.MatchString(call) || seen[call] {
+			t.Errorf("backup call %q is not a new timestamped file", call)
+		}
+		seen[call] = true
+	}
+
+	// A failed backup aborts
+	luks.errs["BackupHeader"] = errors.New("failed to back up LUKS header: disk full")
+	err := ctx.backupHeaderBefore(context.Background(), dir, "/data/secrets.img")
+	if err == nil || !strings.Contains(err.Error(), "operation aborted, nothing was changed") {
+		t.Errorf("backupHeaderBefore = %v", err)
+	}
+}
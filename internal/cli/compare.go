@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/spf13/cobra"
+)
+
+// CompareCommand handles checking whether two containers are identical
+type CompareCommand struct {
+	ctx           *GlobalContext
+	deep          bool
+	keyfile       string
+	keyfileB      string
+	passwordStdin bool
+}
+
+// containerDigest is what compare knows about one container
+type containerDigest struct {
+	Path string
+	UUID string
+	Size uint64
+	Hash string // SHA-256 of the decrypted contents, only with --deep
+}
+
+// hashDevice hashes the decrypted contents of an open mapper device.
+// Tests replace it, as they can't open real mappings.
+var hashDevice = container.HashDevice
+
+// NewCompareCommand creates the compare command
+func NewCompareCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &CompareCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "compare <container-a> <container-b>",
+		Short: "Check whether two containers are identical",
+		Long: `Check whether two unmounted containers are identical, e.g. after a migration.
+
+By default the LUKS UUIDs and file sizes are compared. With --deep, both
+containers are also opened read-only and their decrypted contents are
+hashed and compared, which reads every block of both.
+
+Exits with status 0 if the containers are identical and 1 otherwise.`,
+		Example: `  # Quick check of a copied container
+  brezno compare /data/secrets.img /backup/secrets.img
+
+  # Compare the decrypted contents, both unlocked with the same keyfile
+  brezno compare /data/secrets.img /backup/secrets.img --deep --keyfile ~/.keys/secret.key`,
+		Args: cobra.ExactArgs(2),
+		RunE: cmd.Run,
+	}
+
+	cobraCmd.Flags().BoolVar(&cmd.deep, "deep", false, "Open both containers and compare their decrypted contents")
+	cobraCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Keyfile for the first container (and the second, unless --keyfile-b is set)")
+	cobraCmd.Flags().StringVar(&cmd.keyfileB, "keyfile-b", "", "Keyfile for the second container")
+	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read passwords from stdin, one line per container (for automation)")
+
+	return cobraCmd
+}
+
+// Run executes the compare command
+func (c *CompareCommand) Run(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	var digests [2]containerDigest
+	for i, arg := range args {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		digests[i].Path = path
+	}
+	if digests[0].Path == digests[1].Path {
+		return fmt.Errorf("both arguments refer to the same container: %s", digests[0].Path)
+	}
+
+	for i := range digests {
+		lock, err := lockContainer(digests[i].Path)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		if err := c.inspect(ctx, &digests[i]); err != nil {
+			return err
+		}
+	}
+
+	if c.deep {
+		keyfiles := [2]string{c.keyfile, c.keyfile}
+		if c.keyfileB != "" {
+			keyfiles[1] = c.keyfileB
+		}
+		for i := range digests {
+			hash, err := c.hash(ctx, digests[i].Path, keyfiles[i])
+			if err != nil {
+				return err
+			}
+			digests[i].Hash = hash
+		}
+	}
+
+	differences := compareDigests(digests[0], digests[1])
+	if len(differences) > 0 {
+		return fmt.Errorf("containers differ:\n  %s", strings.Join(differences, "\n  "))
+	}
+
+	if c.deep {
+		c.ctx.Logger.Success("Containers are identical (UUID, size, and contents)")
+	} else {
+		c.ctx.Logger.Success("Containers have the same UUID and size (use --deep to compare contents)")
+	}
+	return nil
+}
+
+// inspect checks a container is an unused LUKS file and records its
+// UUID and size
+func (c *CompareCommand) inspect(ctx context.Context, digest *containerDigest) error {
+	info, err := os.Stat(digest.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("container file not found: %s", digest.Path)
+		}
+		return fmt.Errorf("failed to access container: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("container must be a regular file: %s", digest.Path)
+	}
+	digest.Size = uint64(info.Size())
+
+	isLuks, err := c.ctx.LUKSManager.IsLUKS(ctx, digest.Path)
+	if err != nil {
+		return fmt.Errorf("failed to check LUKS format: %w", err)
+	}
+	if !isLuks {
+		return fmt.Errorf("not a LUKS container: %s", digest.Path)
+	}
+
+	existing, err := c.ctx.Discovery.FindByPath(ctx, digest.Path)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("container must be unmounted to compare: %s\n"+
+			"Run 'brezno unmount %s' first", digest.Path, digest.Path)
+	}
+
+	digest.UUID, err = c.ctx.LUKSManager.UUID(ctx, digest.Path)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// hash opens a container read-only, hashes its decrypted contents, and
+// closes it again
+func (c *CompareCommand) hash(ctx context.Context, path, keyfile string) (string, error) {
+	// Cleanup must still run after ctx is cancelled (e.g., by Ctrl-C)
+	cleanupCtx := context.WithoutCancel(ctx)
+	cleanup := system.NewCleanupStack()
+	cleanup.SetLogger(c.ctx.Logger)
+	defer func() {
+		// Failed steps are logged individually by the stack
+		if err := cleanup.Execute(); err != nil {
+			c.ctx.Logger.Warning("Some resources may need to be removed manually")
+		}
+	}()
+
+	prompt := ""
+	if keyfile == "" && !c.passwordStdin {
+		prompt = "Enter password for " + filepath.Base(path)
+	}
+	auth, err := GetAuthMethod(keyfile, "", false, c.passwordStdin, prompt, "")
+	if err != nil {
+		return "", err
+	}
+	if pwAuth, ok := auth.(*container.PasswordAuth); ok {
+		defer pwAuth.Password.Zeroize()
+	}
+
+	// Distinct from the name mount would use, so an accidental mount of
+	// the same container can't collide with it
+	mapperName := container.GenerateMapperName(path) + "_compare"
+
+	loopDev, err := c.ctx.LoopManager.Attach(ctx, path, container.AttachOptions{ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	cleanup.Add("detach "+loopDev, func() error {
+		return c.ctx.detachLoop(cleanupCtx, loopDev, mapperName)
+	})
+
+	c.ctx.Logger.Info("Opening %s...", path)
+	if err := c.ctx.LUKSManager.Open(ctx, loopDev, mapperName, auth, true); err != nil {
+		return "", err
+	}
+	cleanup.Add("close "+mapperName, func() error {
+		return c.ctx.closeMapper(cleanupCtx, mapperName)
+	})
+
+	c.ctx.Logger.Info("Hashing decrypted contents of %s...", path)
+	hash, err := hashDevice(ctx, "/dev/mapper/"+mapperName)
+	if err != nil {
+		return "", err
+	}
+	c.ctx.Logger.Debug("SHA-256 of %s: %s", path, hash)
+	return hash, nil
+}
+
+// compareDigests lists how two containers differ, or nothing if they are
+// identical. Hashes are compared only when both were computed.
+func compareDigests(a, b containerDigest) []string {
+	var differences []string
+	if !strings.EqualFold(a.UUID, b.UUID) {
+		differences = append(differences, fmt.Sprintf("LUKS UUID: %s vs %s", a.UUID, b.UUID))
+	}
+	if a.Size != b.Size {
+		differences = append(differences, fmt.Sprintf("size: %s vs %s",
+			system.FormatSize(a.Size), system.FormatSize(b.Size)))
+	}
+	if a.Hash != "" && b.Hash != "" && a.Hash != b.Hash {
+		differences = append(differences, "decrypted contents differ")
+	}
+	return differences
+}
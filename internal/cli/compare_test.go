@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestCompareDigests(t *testing.T) {
+	base := containerDigest{Path: "/data/a.img", UUID: "0a1b-2c3d", Size: 1 << 30}
+	with := func(change func(d *containerDigest)) containerDigest {
+		d := base
+		d.Path = "/backup/a.img"
+		change(&d)
+		return d
+	}
+
+	tests := []struct {
+		name string
+		a, b containerDigest
+		want []string
+	}{
+		{"identical", base, with(func(*containerDigest) {}), nil},
+		{"UUID case", base, with(func(d *containerDigest) { d.UUID = "0A1B-2C3D" }), nil},
+		{"UUID", base, with(func(d *containerDigest) { d.UUID = "ffff-0000" }), []string{"LUKS UUID: 0a1b-2c3d vs ffff-0000"}},
+		{"size", base, with(func(d *containerDigest) { d.Size = 2 << 30 }), []string{"size: 1.0 GB vs 2.0 GB"}},
+		{"same contents", with(func(d *containerDigest) { d.Hash = "abc" }), with(func(d *containerDigest) { d.Hash = "abc" }), nil},
+		{"different contents", with(func(d *containerDigest) { d.Hash = "abc" }), with(func(d *containerDigest) { d.Hash = "def" }),
+			[]string{"decrypted contents differ"}},
+		{"contents not hashed", with(func(d *containerDigest) { d.Hash = "abc" }), base, nil},
+		{"everything", base, with(func(d *containerDigest) { d.UUID, d.Size, d.Hash = "x", 1, "def" }),
+			[]string{"LUKS UUID: 0a1b-2c3d vs x", "size: 1.0 GB vs 1 B"}},
+	}
+	for _, tt := range tests {
+		if got := compareDigests(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: compareDigests = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testKeyfile writes a keyfile the auth lookup accepts
+func testKeyfile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompareDeep(t *testing.T) {
+	old := hashDevice
+	t.Cleanup(func() { hashDevice = old })
+
+	const a, b = "/data/a.img", "/backup/b.img"
+	tests := []struct {
+		name         string
+		hashA, hashB string
+		same         bool
+	}{
+		{"same contents", "abc", "abc", true},
+		{"different contents", "abc", "abd", false},
+	}
+	mapperA := container.GenerateMapperName(a) + "_compare"
+	mapperB := container.GenerateMapperName(b) + "_compare"
+	keyfile := testKeyfile(t)
+	for _, tt := range tests {
+		hashDevice = func(ctx context.Context, device string) (string, error) {
+			switch device {
+			case "/dev/mapper/" + mapperA:
+				return tt.hashA, nil
+			case "/dev/mapper/" + mapperB:
+				return tt.hashB, nil
+			}
+			return "", errors.New("unexpected device " + device)
+		}
+		exec := testutil.NewFakeExecutor().
+			On("losetup -f --show -r "+a, "/dev/loop1\n", nil).
+			On("losetup -f --show -r "+b, "/dev/loop2\n", nil)
+		luks := newFakeCryptSetup()
+		withDevices(t, luks)
+		c := &CompareCommand{ctx: newTestContext(exec, luks), deep: true}
+
+		digests := [2]containerDigest{{Path: a, UUID: "u", Size: 1}, {Path: b, UUID: "u", Size: 1}}
+		for i := range digests {
+			hash, err := c.hash(context.Background(), digests[i].Path, keyfile)
+			if err != nil {
+				t.Fatalf("%s: hash(%s): %v", tt.name, digests[i].Path, err)
+			}
+			digests[i].Hash = hash
+		}
+		if differences := compareDigests(digests[0], digests[1]); (len(differences) == 0) != tt.same {
+			t.Errorf("%s: differences %q", tt.name, differences)
+		}
+
+		// Both were opened read-only under the compare mapper names, then
+		// closed and detached
+		wantLUKS := []string{
+			"Open /dev/loop1 " + mapperA + " true", "Close " + mapperA,
+			"Open /dev/loop2 " + mapperB + " true", "Close " + mapperB,
+		}
+		if !reflect.DeepEqual(luks.Calls(), wantLUKS) {
+			t.Errorf("%s: LUKS calls %q, want %q", tt.name, luks.Calls(), wantLUKS)
+		}
+		for _, dev := range []string{"/dev/loop1", "/dev/loop2"} {
+			if _, ok := exec.Ran("losetup -d " + dev); !ok {
+				t.Errorf("%s: %s left attached: %v", tt.name, dev, exec.Lines())
+			}
+		}
+	}
+}
+
+func TestCompareDeepHashFailure(t *testing.T) {
+	old := hashDevice
+	t.Cleanup(func() { hashDevice = old })
+	hashDevice = func(context.Context, string) (string, error) {
+		return "", errors.New("input/output error")
+	}
+
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", "/dev/loop1\n", nil)
+	luks := newFakeCryptSetup()
+	withDevices(t, luks)
+	c := &CompareCommand{ctx: newTestContext(exec, luks), deep: true}
+	if _, err := c.hash(context.Background(), "/data/a.img", testKeyfile(t)); err == nil {
+		t.Fatal("hash succeeded despite the read error")
+	}
+	if !luks.called("Close " + container.GenerateMapperName("/data/a.img") + "_compare") {
+		t.Errorf("mapping left open: %v", luks.Calls())
+	}
+	if _, ok := exec.Ran("losetup -d /dev/loop1"); !ok {
+		t.Errorf("loop device left attached: %v", exec.Lines())
+	}
+}
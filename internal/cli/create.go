@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,11 +14,39 @@ import (
 
 // CreateCommand handles container creation
 type CreateCommand struct {
-	ctx           *GlobalContext
-	size          string
-	filesystem    string
-	keyfile       string
-	passwordStdin bool
+	ctx            *GlobalContext
+	size           string
+	filesystem     string
+	keyfile        string
+	keyDescription string
+	passwordStdin  bool
+	passwordFile   string
+	loadModules    bool
+	force          bool
+	forceDevice    bool
+	formatOnly     bool
+	skipFilesystem bool // --format-only --filesystem none
+	yes            bool
+	minFree        string
+	label          string
+	estimateTime   bool
+	report         string
+	printJSON      bool
+	template       string
+	cipher         string
+	keySize        int
+	pbkdf          string
+	iterTime       int
+	weakKDF        bool
+	addKeyfiles    []string
+	addKeyFailure  string
+	dryRun         bool
+}
+
+// createTarget describes what already exists at the container path
+type createTarget struct {
+	exists      bool // Path exists and will be overwritten
+	blockDevice bool // Path is a block device rather than a regular file
 }
 
 // NewCreateCommand creates the create command
@@ -28,14 +57,52 @@ func NewCreateCommand(ctx *GlobalContext) *cobra.Command {
 		Use:   "create <container-path>",
 		Short: "Create a new encrypted container",
 		Long:  `Create a new LUKS2 encrypted container file with the specified size and filesystem.`,
-		Args:  cobra.MaximumNArgs(1),
-		RunE:  cmd.Run,
+		Example: `  # Create a 5G ext4 container, prompting for a password
+  brezno create /data/secrets.img --size 5G
+
+  # Use half of the free space and an xfs filesystem
+  brezno create /data/secrets.img --size 50% --filesystem xfs
+
+  # Automation: password from stdin (given twice for confirmation)
+  printf '%s\n%s\n' "$PW" "$PW" | brezno create /data/secrets.img --size 5G --password-stdin
+
+  # Protect with a keyfile instead of a password
+  brezno create /data/secrets.img --size 5G --keyfile ~/.keys/secret.key
+
+  # LUKS directly on a partition, no container file
+  brezno create /dev/sdb1 --format-only
+
+  # Same cipher, key size, PBKDF, and filesystem as an existing container
+  brezno create /data/more.img --size 5G --template /data/secrets.img`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: cmd.Run,
 	}
 
-	cobraCmd.Flags().StringVarP(&cmd.size, "size", "s", "", "Container size (e.g., 1G, 100M)")
-	cobraCmd.Flags().StringVarP(&cmd.filesystem, "filesystem", "f", "ext4", "Filesystem type (ext4, xfs, btrfs)")
+	cobraCmd.Flags().StringVarP(&cmd.size, "size", "s", "", "Container size (e.g., 1G, 100M, 50% of free space)")
+	cobraCmd.Flags().StringVarP(&cmd.filesystem, "filesystem", "f", "", "Filesystem type: ext4, xfs, btrfs (default: ext4, or a menu when run interactively)")
 	cobraCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Keyfile path (if not set, will prompt for password)")
-	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+	cobraCmd.Flags().StringVar(&cmd.keyDescription, "key-description", "", "Read the passphrase from the kernel keyring entry with this description")
+	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin, twice: the second line confirms it (for automation)")
+	cobraCmd.Flags().StringVar(&cmd.passwordFile, "password-file", "", "Read the password from the first line of this file")
+	cobraCmd.Flags().BoolVar(&cmd.loadModules, "load-modules", false, "Load missing kernel modules (dm_crypt, loop) with modprobe")
+	cobraCmd.Flags().BoolVar(&cmd.force, "force", false, "Overwrite an existing file (destroys its contents)")
+	cobraCmd.Flags().BoolVar(&cmd.forceDevice, "force-device", false, "Allow --force to format a block device (same as --format-only)")
+	cobraCmd.Flags().BoolVar(&cmd.formatOnly, "format-only", false, "Format a block device in place, without a file or loop device (destroys its contents; --filesystem none skips mkfs)")
+	cobraCmd.Flags().BoolVarP(&cmd.yes, "yes", "y", false, "Skip overwrite confirmation prompt")
+	cobraCmd.Flags().StringVar(&cmd.label, "label", "", "Label for the filesystem and LUKS header, usable with 'mount --label' (default: none)")
+	cobraCmd.Flags().StringVar(&cmd.cipher, "cipher", "", "LUKS cipher (e.g., aes-xts-plain64, default: cryptsetup's default)")
+	cobraCmd.Flags().IntVar(&cmd.keySize, "key-size", 0, "LUKS volume key size in bits (e.g., 512, default: cryptsetup's default)")
+	cobraCmd.Flags().StringVar(&cmd.pbkdf, "pbkdf", "", "LUKS key derivation function (argon2id, argon2i, pbkdf2)")
+	cobraCmd.Flags().IntVar(&cmd.iterTime, "iter-time", 0, fmt.Sprintf("Milliseconds unlocking should take (at least %d, default: cryptsetup's default)", minIterTime))
+	cobraCmd.Flags().BoolVar(&cmd.weakKDF, "i-know-what-im-doing", false, "Allow an --iter-time below the minimum")
+	cobraCmd.Flags().StringArrayVar(&cmd.addKeyfiles, "add-keyfile", nil, "Also enroll this keyfile in another keyslot (repeatable)")
+	cobraCmd.Flags().StringVar(&cmd.addKeyFailure, "add-keyfile-failure", "warn", "If --add-keyfile fails: warn (keep the container) or rollback (fail the create)")
+	cobraCmd.Flags().StringVar(&cmd.template, "template", "", "Copy the cipher, key size, PBKDF, and filesystem of this container (explicit flags win)")
+	cobraCmd.Flags().BoolVar(&cmd.estimateTime, "estimate-time", false, "Print a rough estimate of how long formatting will take")
+	cobraCmd.Flags().StringVar(&cmd.minFree, "min-free", "", "Refuse if the host would have less than this free once the container is full (e.g., 5G)")
+	cobraCmd.Flags().BoolVar(&cmd.dryRun, "dry-run", false, "Print the commands that would create the container instead of running them, without prompting (needs the path and --size)")
+	cobraCmd.Flags().BoolVar(&cmd.printJSON, "print-json", false, "On success, print a JSON object describing the result on stdout")
+	cobraCmd.Flags().StringVar(&cmd.report, "report", "", "Append a JSON record of the outcome to this file (JSONL)")
 
 	return cobraCmd
 }
@@ -46,15 +113,30 @@ func (c *CreateCommand) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	ctx := cmd.Context()
+
 	if err := c.ctx.CheckDependencies(); err != nil {
 		return err
 	}
 
+	if err := c.ctx.CheckKernelModules(ctx, c.loadModules); err != nil {
+		return err
+	}
+
+	return c.create(ctx, cmd, args)
+}
+
+// create is Run once the host has been checked for the tools and kernel
+// modules it needs
+func (c *CreateCommand) create(ctx context.Context, cmd *cobra.Command, args []string) error {
 	// Get container path
 	var containerPath string
-	if len(args) > 0 {
+	switch {
+	case len(args) > 0:
 		containerPath = args[0]
-	} else {
+	case c.dryRun:
+		return fmt.Errorf("--dry-run needs the container path as an argument")
+	default:
 		containerPath = ui.PromptString("Container file path")
 	}
 
@@ -65,35 +147,146 @@ func (c *CreateCommand) Run(cmd *cobra.Command, args []string) error {
 	}
 	containerPath = absPath
 
-	// Get size if not provided
-	if c.size == "" {
-		c.size = ui.PromptString("Container size (e.g., 1G, 10G)")
+	// Devices are often named through /dev/disk/by-* symlinks, check
+	// and format the device node itself
+	if resolved, err := filepath.EvalSymlinks(containerPath); err == nil {
+		if info, err := os.Stat(resolved); err == nil && isBlockDevice(info) {
+			containerPath = resolved
+		}
+	}
+
+	if err := checkIterTime(c.iterTime, c.weakKDF); err != nil {
+		return err
+	}
+	if c.addKeyFailure != "warn" && c.addKeyFailure != "rollback" {
+		return fmt.Errorf("invalid --add-keyfile-failure %q (use warn or rollback)", c.addKeyFailure)
+	}
+	// Resolve extra keyfiles up front so a typo fails before formatting
+	for i, keyfile := range c.addKeyfiles {
+		resolved, err := system.ValidateKeyfilePath(keyfile)
+		if err != nil {
+			return fmt.Errorf("invalid --add-keyfile: %w", err)
+		}
+		c.addKeyfiles[i] = resolved
 	}
 
-	// Parse size
-	sizeBytes, err := system.ParseSize(c.size)
+	// Hold the container lock for the whole operation. A dry run changes
+	// nothing, so it doesn't need it.
+	if !c.dryRun {
+		lock, err := lockContainer(containerPath)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	// Check for an existing file and whether it may be overwritten
+	target, err := c.checkTarget(ctx, containerPath)
 	if err != nil {
 		return err
 	}
 
-	// Get filesystem (already has default)
-	if c.filesystem == "" {
-		c.filesystem = ui.PromptStringWithDefault("Filesystem type", "ext4")
+	if c.template != "" {
+		if err := c.applyTemplate(ctx, cmd, containerPath); err != nil {
+			return err
+		}
 	}
 
-	// Validate filesystem
-	if c.filesystem != "ext4" && c.filesystem != "xfs" && c.filesystem != "btrfs" {
-		return fmt.Errorf("unsupported filesystem: %s (use ext4, xfs, or btrfs)", c.filesystem)
+	var sizeBytes uint64
+	if target.blockDevice {
+		// Block devices are formatted whole, their size is fixed
+		sizeBytes, err = c.ctx.LoopManager.GetDeviceSize(ctx, containerPath)
+		if err != nil {
+			return err
+		}
+		if c.size != "" {
+			c.ctx.Logger.Warning("Ignoring --size for block device %s", containerPath)
+		}
+	} else {
+		// Get size if not provided
+		if c.size == "" {
+			if c.dryRun {
+				return fmt.Errorf("--dry-run needs --size")
+			}
+			c.size = ui.PromptString("Container size (e.g., 1G, 10G, 50%)")
+		}
+
+		// Parse size, percentages are of the free space next to the container
+		available, availErr := system.GetAvailableSpace(containerPath)
+		if availErr != nil {
+			c.ctx.Logger.Debug("Failed to check available disk space: %v", availErr)
+		}
+		sizeBytes, err = system.ParseSizeWithContext(c.size, available)
+		if err != nil {
+			return err
+		}
+
+		if c.minFree != "" {
+			minFreeBytes, err := system.ParseSize(c.minFree)
+			if err != nil {
+				return fmt.Errorf("invalid --min-free: %w", err)
+			}
+			if availErr != nil {
+				return fmt.Errorf("cannot enforce --min-free: %w", availErr)
+			}
+			if err := checkMinFree(available, sizeBytes, minFreeBytes); err != nil {
+				return err
+			}
+		}
+	}
+
+	// --format-only may leave the device without a filesystem
+	if c.formatOnly && c.filesystem == noFilesystem {
+		c.filesystem = ""
+		c.skipFilesystem = true
+	}
+
+	// Get filesystem: offer the installed ones interactively, else ext4
+	if c.filesystem == "" && !c.skipFilesystem {
+		c.filesystem = defaultFilesystem
+		if ui.IsInteractive() && !c.passwordStdin && !c.dryRun {
+			options := filesystemOptions(c.ctx.Executor.CommandExists)
+			if len(options) > 0 {
+				c.filesystem, err = ui.SelectOption("Filesystem type", options)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if !c.skipFilesystem {
+		// Validate filesystem
+		if c.filesystem != "ext4" && c.filesystem != "xfs" && c.filesystem != "btrfs" {
+			return fmt.Errorf("unsupported filesystem: %s (use ext4, xfs, or btrfs)", c.filesystem)
+		}
+
+		if err := checkMinSize(sizeBytes, c.filesystem); err != nil {
+			return err
+		}
+
+		// Check if mkfs tool exists
+		mkfsTool := "mkfs." + c.filesystem
+		if !c.ctx.Executor.CommandExists(mkfsTool) {
+			return fmt.Errorf("filesystem tool not found: %s (please install it)", mkfsTool)
+		}
 	}
 
-	// Check if mkfs tool exists
-	mkfsTool := "mkfs." + c.filesystem
-	if !c.ctx.Executor.CommandExists(mkfsTool) {
-		return fmt.Errorf("filesystem tool not found: %s (please install it)", mkfsTool)
+	// The label goes into the LUKS header even without a filesystem
+	if err := container.ValidateLabel(c.filesystem, c.label); err != nil {
+		return err
 	}
 
-	// Get authentication method
-	auth, err := GetAuthMethod(c.keyfile, true, c.passwordStdin, "", "") // true = require password confirmation
+	// Get authentication method. A dry run only prints how the credential
+	// would be passed, so it doesn't ask for a password.
+	var auth container.AuthMethod
+	if c.dryRun {
+		auth, err = dryRunAuth(c.keyfile, c.keyDescription)
+	} else if c.passwordFile != "" {
+		auth, err = GetPasswordFileAuth(c.passwordFile, c.keyfile, c.keyDescription, c.passwordStdin)
+	} else {
+		auth, err = GetAuthMethod(c.keyfile, c.keyDescription, true, c.passwordStdin, "", "") // true = require password confirmation
+	}
 	if err != nil {
 		return err
 	}
@@ -102,87 +295,483 @@ func (c *CreateCommand) Run(cmd *cobra.Command, args []string) error {
 		defer pwAuth.Password.Zeroize()
 	}
 
+	// The checks above looked at the real system, the rest only prints
+	if c.dryRun {
+		c.ctx = c.ctx.withDryRun(os.Stdout)
+		c.ctx.Logger.Info("Dry run: printing commands instead of running them")
+		return c.execute(ctx, containerPath, sizeBytes, auth, target)
+	}
+
 	// Execute creation
 	c.ctx.Logger.Info("Creating %s encrypted container: %s", system.FormatSize(sizeBytes), containerPath)
-	return c.execute(containerPath, sizeBytes, auth)
+	err = c.execute(ctx, containerPath, sizeBytes, auth, target)
+	c.ctx.writeReport(ctx, c.report, c.printJSON, system.ReportRecord{
+		Operation:  "create",
+		Path:       containerPath,
+		Size:       sizeBytes,
+		Filesystem: c.filesystem,
+	}, err)
+	return err
 }
 
-func (c *CreateCommand) execute(path string, sizeBytes uint64, auth container.AuthMethod) error {
+// dryRunAuth returns the authentication a dry run prints commands with:
+// the keyfile or keyring entry if one was given, else a password, which
+// cryptsetup would read from stdin
+func dryRunAuth(keyfile, keyDescription string) (container.AuthMethod, error) {
+	if keyfile != "" || keyDescription != "" {
+		return GetAuthMethod(keyfile, keyDescription, false, false, "", "")
+	}
+	return &container.PasswordAuth{Password: system.NewSecureBytes(nil)}, nil
+}
+
+// printEstimate prints how long formatting should take, refined with a
+// cryptsetup benchmark when one can be run
+func (c *CreateCommand) printEstimate(ctx context.Context, sizeBytes uint64) {
+	throughput, err := c.ctx.LUKSManager.Benchmark(ctx)
+	if err != nil {
+		c.ctx.Logger.Debug("Cipher benchmark unavailable, using default throughput: %v", err)
+		throughput = 0
+	}
+	estimate := system.EstimateCreateDuration(sizeBytes, c.filesystem, throughput)
+	c.ctx.Logger.Info("This may take %s", system.FormatDuration(estimate))
+}
+
+// minIterTime is the shortest --iter-time create accepts without
+// --i-know-what-im-doing. Less makes passwords cheap to brute-force.
+const minIterTime = 1000
+
+// checkIterTime rejects an --iter-time below minIterTime unless the
+// user has explicitly accepted a weak key derivation
+func checkIterTime(iterTime int, allowWeak bool) error {
+	if iterTime < 0 {
+		return fmt.Errorf("invalid --iter-time %d (must be positive)", iterTime)
+	}
+	if iterTime > 0 && iterTime < minIterTime && !allowWeak {
+		return fmt.Errorf("--iter-time %d is below the minimum of %dms and would make the password easier to brute-force\n"+
+			"Pass --i-know-what-im-doing to use it anyway", iterTime, minIterTime)
+	}
+	return nil
+}
+
+// defaultFilesystem is used when --filesystem is not given and there is
+// no one to ask
+const defaultFilesystem = "ext4"
+
+// filesystemChoices are the filesystems create offers, default first
+var filesystemChoices = []ui.Option{
+	{Value: "ext4", Description: "Reliable general-purpose default, grows online"},
+	{Value: "xfs", Description: "Fast with large files, grows online but can never shrink"},
+	{Value: "btrfs", Description: "Snapshots, checksums, and compression; more overhead"},
+}
+
+// filesystemOptions returns the filesystem choices whose mkfs tool is
+// installed
+func filesystemOptions(commandExists func(name string) bool) []ui.Option {
+	var options []ui.Option
+	for _, opt := range filesystemChoices {
+		if commandExists("mkfs." + opt.Value) {
+			options = append(options, opt)
+		}
+	}
+	return options
+}
+
+// usableSize estimates the space left for the filesystem in a container of
+// sizeBytes with a default LUKS2 header
+func usableSize(sizeBytes uint64) uint64 {
+	if sizeBytes < luks2HeaderSize {
+		return 0
+	}
+	return sizeBytes - luks2HeaderSize
+}
+
+// checkMinSize rejects containers too small to hold the LUKS2 header and
+// the smallest filesystem of the chosen type, which would otherwise fail
+// deep inside luksFormat or mkfs
+func checkMinSize(sizeBytes uint64, fsType string) error {
+	minSize := luks2HeaderSize + container.MinFilesystemSize(fsType)
+	if sizeBytes < minSize {
+		return fmt.Errorf("container size %s is too small for LUKS2 and %s (minimum %s: %s header plus %s filesystem)",
+			system.FormatSize(sizeBytes), fsType, system.FormatSize(minSize),
+			system.FormatSize(luks2HeaderSize), system.FormatSize(container.MinFilesystemSize(fsType)))
+	}
+	return nil
+}
+
+// checkMinFree ensures that a sparse container of sizeBytes, once fully
+// written, would still leave at least minFree bytes on the host filesystem
+func checkMinFree(available, sizeBytes, minFree uint64) error {
+	if sizeBytes > available || available-sizeBytes < minFree {
+		remaining := uint64(0)
+		if available > sizeBytes {
+			remaining = available - sizeBytes
+		}
+		return fmt.Errorf("container would leave only %s free on the host when full (--min-free %s, available %s)",
+			system.FormatSize(remaining), system.FormatSize(minFree), system.FormatSize(available))
+	}
+	return nil
+}
+
+// enrollKeyfiles adds each --add-keyfile to its own keyslot, using the
+// credential the container was formatted with. A failure is returned only
+// with --add-keyfile-failure=rollback; otherwise the container is kept with
+// the keys that were added.
+func (c *CreateCommand) enrollKeyfiles(ctx context.Context, path string, auth container.AuthMethod) error {
+	for _, keyfile := range c.addKeyfiles {
+		c.ctx.Logger.Info("Adding keyfile %s...", keyfile)
+		err := c.ctx.LUKSManager.AddKey(ctx, path, auth, keyfile)
+		if err == nil {
+			continue
+		}
+		if c.addKeyFailure == "rollback" {
+			return fmt.Errorf("failed to add keyfile %s: %w", keyfile, err)
+		}
+		c.ctx.Logger.Warning("Failed to add keyfile %s, the container was created without it: %v", keyfile, err)
+	}
+	return nil
+}
+
+// applyTemplate fills in the LUKS parameters and filesystem type of the
+// --template container, except where they were given as flags
+func (c *CreateCommand) applyTemplate(ctx context.Context, cmd *cobra.Command, containerPath string) error {
+	templatePath, err := filepath.Abs(c.template)
+	if err != nil {
+		return fmt.Errorf("invalid template path: %w", err)
+	}
+	if templatePath == containerPath {
+		return fmt.Errorf("--template cannot be the container being created")
+	}
+
+	isLuks, err := c.ctx.LUKSManager.IsLUKS(ctx, templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to check template LUKS format: %w", err)
+	}
+	if !isLuks {
+		return fmt.Errorf("template is not a LUKS container: %s", templatePath)
+	}
+
+	opts, err := c.ctx.LUKSManager.FormatOptionsOf(ctx, templatePath)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	if !flags.Changed("cipher") {
+		c.cipher = opts.Cipher
+	}
+	if !flags.Changed("key-size") {
+		c.keySize = opts.KeySize
+	}
+	if !flags.Changed("pbkdf") {
+		c.pbkdf = opts.PBKDF
+	}
+	if !flags.Changed("filesystem") {
+		fsType, err := c.templateFilesystem(ctx, templatePath)
+		if err != nil {
+			c.ctx.Logger.Warning("Not copying the filesystem type: %v", err)
+		} else if fsType != "" {
+			c.filesystem = fsType
+		}
+	}
+
+	fsType := c.filesystem
+	if fsType == "" {
+		fsType = "not copied"
+	}
+	c.ctx.Logger.Info("Using settings from %s: cipher %s, key size %d, PBKDF %s, filesystem %s",
+		templatePath, c.cipher, c.keySize, c.pbkdf, fsType)
+	return nil
+}
+
+// templateFilesystem returns the filesystem type inside the template.
+// The template must be open, since the filesystem is encrypted.
+func (c *CreateCommand) templateFilesystem(ctx context.Context, templatePath string) (string, error) {
+	active, err := c.ctx.Discovery.FindByPath(ctx, templatePath)
+	if err != nil {
+		return "", err
+	}
+	if active == nil {
+		return "", fmt.Errorf("template is not open")
+	}
+	if active.Filesystem != "" {
+		return active.Filesystem, nil
+	}
+	return c.ctx.MountMgr.DetectFilesystem(ctx, "/dev/mapper/"+active.MapperName)
+}
+
+// checkTarget inspects an existing path and decides whether create may
+// overwrite it. Overwriting a file requires --force and confirmation, and
+// files that are open as a container or attached to a loop device are
+// never touched. Block devices go through checkBlockDevice.
+func (c *CreateCommand) checkTarget(ctx context.Context, path string) (createTarget, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if c.formatOnly {
+				return createTarget{}, fmt.Errorf("--format-only needs an existing block device: %s", path)
+			}
+			return createTarget{}, nil
+		}
+		return createTarget{}, fmt.Errorf("failed to access %s: %w", path, err)
+	}
+
+	switch {
+	case isBlockDevice(info):
+		if !c.formatOnly && !(c.force && c.forceDevice) {
+			return createTarget{}, fmt.Errorf("%s is a block device (use --format-only to format it)", path)
+		}
+		return c.checkBlockDevice(ctx, path)
+	case c.formatOnly:
+		return createTarget{}, fmt.Errorf("--format-only needs a block device: %s", path)
+	case !info.Mode().IsRegular():
+		return createTarget{}, fmt.Errorf("container must be a regular file or block device: %s", path)
+	case !c.force:
+		return createTarget{}, fmt.Errorf("file already exists: %s (use --force to overwrite)", path)
+	}
+
+	// Never overwrite a container that is currently open
+	existing, err := c.ctx.Discovery.FindByPath(ctx, path)
+	if err != nil {
+		return createTarget{}, err
+	}
+	if existing != nil {
+		if existing.MountPoint != "" {
+			return createTarget{}, fmt.Errorf("refusing to overwrite mounted container (mounted at: %s)", existing.MountPoint)
+		}
+		return createTarget{}, fmt.Errorf("refusing to overwrite open container (mapper: %s)", existing.MapperName)
+	}
+
+	// The file may also be attached to a loop device without a brezno mapper,
+	// e.g. opened manually under a different name
+	loopDev, err := c.ctx.LoopManager.FindByFile(ctx, path)
+	if err != nil {
+		return createTarget{}, err
+	}
+	if loopDev != "" {
+		return createTarget{}, fmt.Errorf("refusing to overwrite %s: it is in use by loop device %s\n"+
+			"Close it first (e.g., 'brezno unmount' or 'losetup -d %s')", path, loopDev, loopDev)
+	}
+
+	c.ctx.Logger.Warning("%s already exists and ALL data on it will be destroyed", path)
+	if !c.yes && !c.dryRun {
+		if !ui.PromptConfirm("Overwrite existing file?") {
+			return createTarget{}, fmt.Errorf("create cancelled by user")
+		}
+	}
+
+	return createTarget{exists: true}, nil
+}
+
+// isBlockDevice reports whether info describes a block device
+func isBlockDevice(info os.FileInfo) bool {
+	return info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0
+}
+
+// noFilesystem is the --filesystem value that skips mkfs with --format-only
+const noFilesystem = "none"
+
+// checkBlockDevice verifies that a block device may be formatted in place:
+// neither it nor its partitions are mounted, used as swap, or held by
+// another device (e.g. LVM), and it is not already LUKS unless --force is
+// given. The user must confirm.
+func (c *CreateCommand) checkBlockDevice(ctx context.Context, path string) (createTarget, error) {
+	if err := c.ctx.Discovery.CheckDeviceUnused(path); err != nil {
+		return createTarget{}, err
+	}
+
+	isLuks, err := c.ctx.LUKSManager.IsLUKS(ctx, path)
+	if err != nil {
+		return createTarget{}, fmt.Errorf("failed to check LUKS format: %w", err)
+	}
+	if isLuks && !c.force {
+		return createTarget{}, fmt.Errorf("%s is already a LUKS container (use --force to reformat it)", path)
+	}
+
+	c.ctx.Logger.Warning("ALL data on block device %s will be destroyed", path)
+	if !c.yes && !c.dryRun {
+		if !ui.PromptConfirm(fmt.Sprintf("Format %s?", path)) {
+			return createTarget{}, fmt.Errorf("create cancelled by user")
+		}
+	}
+
+	return createTarget{exists: true, blockDevice: true}, nil
+}
+
+func (c *CreateCommand) execute(ctx context.Context, path string, sizeBytes uint64, auth container.AuthMethod, target createTarget) error {
+	// Cleanup must still run after ctx is cancelled (e.g., by Ctrl-C)
+	cleanupCtx := context.WithoutCancel(ctx)
 	cleanup := system.NewCleanupStack()
+	cleanup.SetLogger(c.ctx.Logger)
 	defer func() {
+		// Failed steps are logged individually by the stack
 		if err := cleanup.Execute(); err != nil {
-			c.ctx.Logger.Warning("Cleanup errors occurred: %v", err)
+			c.ctx.Logger.Warning("Some resources may need to be removed manually")
 		}
 	}()
 
 	// Step 1: Create sparse file with secure permissions
-	c.ctx.Logger.Info("Creating sparse file...")
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
-	if err != nil {
-		if os.IsExist(err) {
-			return fmt.Errorf("file already exists: %s", path)
+	switch {
+	case target.blockDevice:
+		c.ctx.Logger.Info("Using block device %s...", path)
+	case c.dryRun:
+		c.ctx.Logger.Info("Would create a %s sparse file at %s", system.FormatSize(sizeBytes), path)
+	case target.exists:
+		c.ctx.Logger.Info("Overwriting existing file...")
+		if err := overwriteFile(path, sizeBytes); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to create file: %w", err)
+	default:
+		c.ctx.Logger.Info("Creating sparse file...")
+		if err := createFile(path, sizeBytes); err != nil {
+			return err
+		}
+		cleanup.Add("remove "+path, func() error {
+			return os.Remove(path)
+		})
 	}
 
-	// Truncate to desired size
-	if err := file.Truncate(int64(sizeBytes)); err != nil {
-		file.Close()
-		os.Remove(path)
-		return fmt.Errorf("failed to set file size: %w", err)
+	// The estimate is advisory, skip the benchmark when it won't be shown
+	if c.estimateTime && !c.ctx.Logger.Quiet {
+		c.printEstimate(ctx, sizeBytes)
 	}
-	file.Close()
-
-	cleanup.Add(func() error {
-		return os.Remove(path)
-	})
 
 	// Step 2: Format as LUKS
 	c.ctx.Logger.Info("Formatting as LUKS2 encrypted container...")
-	if err := c.ctx.LUKSManager.Format(path, auth); err != nil {
+	formatOpts := container.FormatOptions{
+		Label:    c.label,
+		Cipher:   c.cipher,
+		KeySize:  c.keySize,
+		PBKDF:    c.pbkdf,
+		IterTime: c.iterTime,
+	}
+	if err := c.ctx.LUKSManager.Format(ctx, path, auth, formatOpts); err != nil {
 		return err
 	}
 
-	// Step 3: Attach loop device
-	c.ctx.Logger.Info("Setting up loop device...")
-	loopDev, err := c.ctx.LoopManager.Attach(path)
-	if err != nil {
+	// Tag the header so discovery can tell brezno's containers apart
+	if err := c.ctx.LUKSManager.SetToken(ctx, path, container.NewBreznoToken()); err != nil {
+		c.ctx.Logger.Warning("Failed to mark container as created by brezno: %v", err)
+	}
+
+	if err := c.enrollKeyfiles(ctx, path, auth); err != nil {
 		return err
 	}
-	cleanup.Add(func() error {
-		return c.ctx.LoopManager.Detach(loopDev)
-	})
 
-	// Step 4: Open LUKS container
+	// Step 3: Attach loop device, unless formatting a block device in place
 	mapperName := container.GenerateMapperName(path)
+	device := path
+	var loopDev string
+	if !target.blockDevice {
+		c.ctx.Logger.Info("Setting up loop device...")
+		// A dry run created no file, and its losetup doesn't need one
+		var file *os.File
+		if !c.dryRun {
+			f, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("failed to open container: %w", err)
+			}
+			defer f.Close()
+			file = f
+		}
+		var err error
+		loopDev, err = c.ctx.LoopManager.AttachFd(ctx, file, container.AttachOptions{})
+		if err != nil {
+			return err
+		}
+		cleanup.Add("detach "+loopDev, func() error {
+			return c.ctx.detachLoop(cleanupCtx, loopDev, mapperName)
+		})
+		device = loopDev
+	}
+
+	// Step 4: Open LUKS container
 	c.ctx.Logger.Info("Opening LUKS container...")
-	if err := c.ctx.LUKSManager.Open(loopDev, mapperName, auth); err != nil {
+	if err := c.ctx.LUKSManager.Open(ctx, device, mapperName, auth, false); err != nil {
 		return err
 	}
-	cleanup.Add(func() error {
-		return c.ctx.LUKSManager.Close(mapperName)
+	cleanup.Add("close "+mapperName, func() error {
+		return c.ctx.closeMapper(cleanupCtx, mapperName)
 	})
 
+	// The filesystem gets what the LUKS header leaves. If the open mapping
+	// can't be measured, the default header size is a close estimate.
+	usableBytes, err := c.ctx.LUKSManager.GetLUKSSize(ctx, mapperName)
+	if err != nil {
+		c.ctx.Logger.Debug("Failed to measure LUKS mapping: %v", err)
+		usableBytes = usableSize(sizeBytes)
+	}
+
 	// Step 5: Create filesystem
 	mapperDevice := "/dev/mapper/" + mapperName
-	c.ctx.Logger.Info("Creating %s filesystem...", c.filesystem)
-	if err := c.ctx.MountMgr.MakeFilesystem(mapperDevice, c.filesystem); err != nil {
-		return err
+	if !c.skipFilesystem {
+		c.ctx.Logger.Info("Creating %s filesystem...", c.filesystem)
+		if err := c.ctx.MountMgr.MakeFilesystem(ctx, mapperDevice, c.filesystem, c.label); err != nil {
+			return err
+		}
 	}
 
 	// Success! Clear cleanup to prevent removal
 	cleanup.Clear()
 
-	// Clean up resources manually (close LUKS, detach loop)
-	if err := c.ctx.LUKSManager.Close(mapperName); err != nil {
+	// Clean up resources manually (close LUKS, detach loop). Nothing else
+	// would release them after an interrupt now, so ignore cancellation.
+	if err := c.ctx.closeMapper(cleanupCtx, mapperName); err != nil {
 		c.ctx.Logger.Warning("Failed to close LUKS container: %v", err)
 	}
-	if err := c.ctx.LoopManager.Detach(loopDev); err != nil {
-		c.ctx.Logger.Warning("Failed to detach loop device: %v", err)
+	if loopDev != "" {
+		if err := c.ctx.detachLoop(cleanupCtx, loopDev, mapperName); err != nil {
+			c.ctx.Logger.Warning("Failed to detach loop device: %v", err)
+		}
 	}
 
+	if c.dryRun {
+		c.ctx.Logger.Success("Dry run complete, nothing was changed")
+		return nil
+	}
 	c.ctx.Logger.Success("Container created successfully: %s", path)
-	c.ctx.Logger.Info("Size: %s, Filesystem: %s", system.FormatSize(sizeBytes), c.filesystem)
+	fsType := c.filesystem
+	if c.skipFilesystem {
+		fsType = "none (open it with 'brezno mount --open-only' to set one up)"
+	}
+	c.ctx.Logger.Info("Size: %s (%s usable after the LUKS header), Filesystem: %s",
+		system.FormatSize(sizeBytes), system.FormatSize(usableBytes), fsType)
 
 	return nil
 }
+
+// createFile creates a new sparse file with secure permissions
+func createFile(path string, sizeBytes uint64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("file already exists: %s", path)
+		}
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	// Truncate to desired size
+	if err := file.Truncate(int64(sizeBytes)); err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to set file size: %w", err)
+	}
+	return file.Close()
+}
+
+// overwriteFile discards the contents of an existing file and resizes it as
+// a sparse file, tightening its permissions to 0600
+func overwriteFile(path string, sizeBytes uint64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := file.Truncate(int64(sizeBytes)); err != nil {
+		return fmt.Errorf("failed to set file size: %w", err)
+	}
+	return file.Close()
+}
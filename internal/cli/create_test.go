@@ -0,0 +1,638 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+	"github.com/spf13/cobra"
+)
+
+func TestCreateExecute(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := newFakeCryptSetup()
+	c := &CreateCommand{ctx: newTestContext(exec, luks), filesystem: "ext4"}
+
+	path := filepath.Join(t.TempDir(), "new.img")
+	if err := c.execute(context.Background(), path, 1<<20, &container.KeyfileAuth{KeyfilePath: "/k"}, createTarget{}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != 1<<20 {
+		t.Fatalf("container file: %v, %v", info, err)
+	}
+	if !luks.called("Format "+path) || !luks.called("Open "+testLoopDevice) {
+		t.Errorf("LUKS calls = %v", luks.Calls())
+	}
+	mapperDevice := "/dev/mapper/" + container.GenerateMapperName(path)
+	if _, ok := exec.Ran("mkfs.ext4 -q " + mapperDevice); !ok {
+		t.Errorf("no filesystem created: %v", exec.Lines())
+	}
+}
+
+func TestCreateExecuteDryRun(t *testing.T) {
+	var out bytes.Buffer
+	exec := testutil.NewFakeExecutor()
+	ctx := newTestContext(exec, newFakeCryptSetup()).withDryRun(&out)
+	c := &CreateCommand{ctx: ctx, filesystem: "xfs", label: "secrets", dryRun: true}
+
+	path := filepath.Join(t.TempDir(), "new.img")
+	if err := c.execute(context.Background(), path, 1<<30, &container.KeyfileAuth{KeyfilePath: "/k"}, createTarget{}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	mapperName := container.GenerateMapperName(path)
+	for _, want := range []string{
+		"[DRY RUN] cryptsetup luksFormat --type luks2 --label secrets " + path + " --key-file [REDACTED]",
+		"[DRY RUN] losetup -f --show /proc/self/fd/3",
+		"[DRY RUN] cryptsetup luksOpen " + system.DryRunLoopDevice + " " + mapperName + " --key-file [REDACTED]",
+		"[DRY RUN] mkfs.xfs -L secrets /dev/mapper/" + mapperName,
+		"[DRY RUN] cryptsetup luksClose " + mapperName,
+		"[DRY RUN] losetup -d " + system.DryRunLoopDevice,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("dry run output lacks %q:\n%s", want, out.String())
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s", path)
+	}
+	if lines := exec.Lines(); len(lines) != 0 {
+		t.Errorf("dry run ran commands: %v", lines)
+	}
+}
+
+func TestCreateDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "existing.img")
+	if err := os.WriteFile(path, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Neither the lock held by another operation nor the prompts for
+	// overwriting and for a password may stop a dry run
+	lock, err := lockContainer(path)
+	if err != nil {
+		t.Skipf("cannot take container locks: %v", err)
+	}
+	defer lock.Unlock()
+	withStdin(t, "")
+
+	exec := testutil.NewFakeExecutor()
+	c := &CreateCommand{ctx: newTestContext(exec, newFakeCryptSetup()), size: "1G", filesystem: "ext4", force: true, addKeyFailure: "warn", dryRun: true}
+	out := captureStdout(t, func() { err = c.create(context.Background(), &cobra.Command{}, []string{path}) })
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+
+	mapperName := container.GenerateMapperName(path)
+	mapperDevice := "/dev/mapper/" + mapperName
+	want := strings.Join([]string{
+		"cryptsetup luksFormat --type luks2 " + path + " < [STDIN]",
+		"cryptsetup token import --json-file - " + path + " < [STDIN]",
+		"losetup -f --show /proc/self/fd/3",
+		"cryptsetup luksOpen " + system.DryRunLoopDevice + " " + mapperName + " < [STDIN]",
+		"blockdev --getsize64 " + mapperDevice,
+		"mkfs.ext4 -q " + mapperDevice,
+		"cryptsetup luksClose " + mapperName,
+		"losetup -d " + system.DryRunLoopDevice,
+	}, "\n")
+	if got := strings.TrimSpace(strings.ReplaceAll(out, "[DRY RUN] ", "")); got != want {
+		t.Errorf("dry run printed:\n%s\nwant:\n%s", got, want)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep" {
+		t.Errorf("dry run changed %s: %q", path, data)
+	}
+	// Discovery and the overwrite checks still look at the real system
+	for _, line := range exec.Lines() {
+		if !strings.HasPrefix(line, "dmsetup ") && !strings.HasPrefix(line, "losetup -l") && !strings.HasPrefix(line, "losetup -j") {
+			t.Errorf("dry run ran %q", line)
+		}
+	}
+}
+
+func TestCreateDryRunNeedsArguments(t *testing.T) {
+	c := &CreateCommand{ctx: newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()), addKeyFailure: "warn", dryRun: true}
+	err := c.create(context.Background(), &cobra.Command{}, nil)
+	if err == nil || err.Error() != "--dry-run needs the container path as an argument" {
+		t.Errorf("dry run without a path: %v", err)
+	}
+	err = c.create(context.Background(), &cobra.Command{}, []string{filepath.Join(t.TempDir(), "new.img")})
+	if err == nil || err.Error() != "--dry-run needs --size" {
+		t.Errorf("dry run without --size: %v", err)
+	}
+}
+
+func TestCreateExecuteRemovesFileOnFailure(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := newFakeCryptSetup()
+	luks.errs["Open"] = errors.New("open failed")
+	c := &CreateCommand{ctx: newTestContext(exec, luks), filesystem: "ext4"}
+
+	path := filepath.Join(t.TempDir(), "new.img")
+	if err := c.execute(context.Background(), path, 1<<20, &container.KeyfileAuth{KeyfilePath: "/k"}, createTarget{}); err == nil {
+		t.Fatal("execute succeeded despite the open failure")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("container file left behind: %v", err)
+	}
+	if _, ok := exec.Ran("mkfs"); ok {
+		t.Errorf("filesystem created despite the open failure")
+	}
+}
+
+// interruptingExecutor cancels the operation when a command starting with
+// prefix runs, as Ctrl-C would while it was running
+type interruptingExecutor struct {
+	*testutil.FakeExecutor
+	prefix string
+	cancel context.CancelFunc
+}
+
+func (e *interruptingExecutor) Run(ctx context.Context, name string, args ...string) error {
+	if strings.HasPrefix(strings.Join(append([]string{name}, args...), " "), e.prefix) {
+		e.cancel()
+		return ctx.Err()
+	}
+	return e.FakeExecutor.Run(ctx, name, args...)
+}
+
+// withDevices makes cleanup see the mapping until luks closes it and the
+// loop device as attached
+func withDevices(t *testing.T, luks *fakeCryptSetup) {
+	t.Helper()
+	oldMapper, oldLoop := mapperExists, loopAttached
+	t.Cleanup(func() { mapperExists, loopAttached = oldMapper, oldLoop })
+	mapperExists = func(name string) bool { return !luks.called("Close " + name) }
+	loopAttached = func(string) bool { return true }
+}
+
+func TestCreateExecuteInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	exec := &interruptingExecutor{FakeExecutor: fake, prefix: "mkfs", cancel: cancel}
+	luks := newFakeCryptSetup()
+	withDevices(t, luks)
+	gctx := newTestContext(fake, luks)
+	gctx.Executor = exec
+	gctx.LoopManager = container.NewLoopManager(exec)
+	gctx.MountMgr = container.NewMountManager(exec)
+	c := &CreateCommand{ctx: gctx, filesystem: "ext4"}
+
+	path := filepath.Join(t.TempDir(), "new.img")
+	if err := c.execute(ctx, path, 1<<20, &container.KeyfileAuth{KeyfilePath: "/k"}, createTarget{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("execute = %v, want the interruption", err)
+	}
+
+	// Cleanup ignores the cancellation, so nothing is left behind
+	mapperName := container.GenerateMapperName(path)
+	if !luks.called("Close " + mapperName) {
+		t.Errorf("mapping left open: %v", luks.Calls())
+	}
+	if _, ok := fake.Ran("losetup -d " + testLoopDevice); !ok {
+		t.Errorf("loop device left attached: %v", fake.Lines())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("container file left behind: %v", err)
+	}
+	if warnings := gctx.Warnings.List(); len(warnings) != 0 {
+		t.Errorf("cleanup warnings: %v", warnings)
+	}
+}
+
+// fixtureMounts is a MountSource serving a fixed mount table and swaps
+type fixtureMounts struct {
+	mountinfo string
+	swaps     string
+}
+
+func (f fixtureMounts) ReadMountinfo() ([]byte, error) { return []byte(f.mountinfo), nil }
+func (f fixtureMounts) ReadSwaps() ([]byte, error)     { return []byte(f.swaps), nil }
+
+// findBlockDevice returns a block device node to test against, skipping
+// the test if there is none
+func findBlockDevice(t *testing.T) string {
+	t.Helper()
+	candidates, _ := filepath.Glob("/dev/loop[0-9]*")
+	for _, dev := range candidates {
+		if info, err := os.Stat(dev); err == nil && isBlockDevice(info) {
+			return dev
+		}
+	}
+	t.Skip("no block device available")
+	return ""
+}
+
+// newBlockDeviceTest returns a create command whose discovery sees the
+// given mount table
+func newBlockDeviceTest(mounts fixtureMounts) (*CreateCommand, *fakeCryptSetup) {
+	luks := newFakeCryptSetup()
+	ctx := newTestContext(testutil.NewFakeExecutor(), luks)
+	ctx.Discovery.SetMountSource(mounts)
+	return &CreateCommand{ctx: ctx, yes: true}, luks
+}
+
+func TestCheckTargetFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.img")
+	if err := os.WriteFile(existing, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.img")
+
+	tests := []struct {
+		name       string
+		path       string
+		formatOnly bool
+		wantErr    string
+	}{
+		{name: "new file", path: missing},
+		{name: "existing file without --force", path: existing, wantErr: "already exists"},
+		{name: "format-only on a file", path: existing, formatOnly: true, wantErr: "needs a block device"},
+		{name: "format-only on a missing path", path: missing, formatOnly: true, wantErr: "needs an existing block device"},
+		{name: "character device", path: "/dev/null", wantErr: "regular file or block device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newBlockDeviceTest(fixtureMounts{})
+			c.formatOnly = tt.formatOnly
+			target, err := c.checkTarget(context.Background(), tt.path)
+			if tt.wantErr == "" {
+				if err != nil || target.exists {
+					t.Errorf("checkTarget = %+v, %v; want a new file", target, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkTarget error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckTargetBlockDevice(t *testing.T) {
+	dev := findBlockDevice(t)
+	mounted := fixtureMounts{mountinfo: "40 1 7:0 / /data rw - ext4 " + dev + " rw\n"}
+	swap := fixtureMounts{swaps: "Filename Type Size Used Priority\n" + dev + " partition 1024 0 -2\n"}
+
+	tests := []struct {
+		name        string
+		mounts      fixtureMounts
+		formatOnly  bool
+		force       bool
+		forceDevice bool
+		luks        bool
+		wantErr     string
+	}{
+		{name: "no flags", wantErr: "use --format-only"},
+		{name: "--force alone", force: true, wantErr: "use --format-only"},
+		{name: "format-only", formatOnly: true},
+		{name: "force-device", force: true, forceDevice: true},
+		{name: "format-only mounted", mounts: mounted, formatOnly: true, wantErr: "mounted at /data"},
+		{name: "force-device mounted", mounts: mounted, force: true, forceDevice: true, wantErr: "mounted at /data"},
+		{name: "format-only swap", mounts: swap, formatOnly: true, wantErr: "in use as swap"},
+		{name: "format-only LUKS", formatOnly: true, luks: true, wantErr: "already a LUKS container"},
+		{name: "format-only LUKS with --force", formatOnly: true, force: true, luks: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, luks := newBlockDeviceTest(tt.mounts)
+			c.formatOnly, c.force, c.forceDevice = tt.formatOnly, tt.force, tt.forceDevice
+			luks.luks[dev] = tt.luks
+
+			target, err := c.checkTarget(context.Background(), dev)
+			if tt.wantErr == "" {
+				if err != nil || !target.blockDevice {
+					t.Errorf("checkTarget = %+v, %v; want a block device target", target, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkTarget error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateExecuteBlockDevice(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	luks := newFakeCryptSetup()
+	c := &CreateCommand{ctx: newTestContext(exec, luks), formatOnly: true, skipFilesystem: true}
+
+	if err := c.execute(context.Background(), "/dev/sdz", 1<<30, &container.KeyfileAuth{KeyfilePath: "/k"}, createTarget{exists: true, blockDevice: true}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if _, ok := exec.Ran("losetup"); ok {
+		t.Errorf("block device was attached to a loop device: %v", exec.Lines())
+	}
+	if !luks.called("Format /dev/sdz") || !luks.called("Open /dev/sdz") {
+		t.Errorf("LUKS calls = %v", luks.Calls())
+	}
+	if _, ok := exec.Ran("mkfs"); ok {
+		t.Errorf("filesystem created despite --filesystem none: %v", exec.Lines())
+	}
+}
+
+func TestFilesystemOptions(t *testing.T) {
+	tests := []struct {
+		installed []string
+		want      []string
+	}{
+		{[]string{"mkfs.ext4", "mkfs.xfs", "mkfs.btrfs"}, []string{"ext4", "xfs", "btrfs"}},
+		{[]string{"mkfs.btrfs", "mkfs.ext4"}, []string{"ext4", "btrfs"}},
+		{[]string{"mkfs.xfs", "mkfs.vfat"}, []string{"xfs"}},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		installed := make(map[string]bool)
+		for _, name := range tt.installed {
+			installed[name] = true
+		}
+		var got []string
+		for _, opt := range filesystemOptions(func(name string) bool { return installed[name] }) {
+			if opt.Description == "" {
+				t.Errorf("%s has no description", opt.Value)
+			}
+			got = append(got, opt.Value)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("installed %v: options %v, want %v", tt.installed, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIterTime(t *testing.T) {
+	tests := []struct {
+		iterTime  int
+		allowWeak bool
+		wantErr   string
+	}{
+		{0, false, ""}, // cryptsetup's default
+		{0, true, ""},
+		{999, false, "below the minimum of 1000ms"},
+		{999, true, ""},
+		{1000, false, ""},
+		{1000, true, ""},
+		{1, false, "--i-know-what-im-doing"},
+		{-1, false, "must be positive"},
+		{-1, true, "must be positive"},
+	}
+	for _, tt := range tests {
+		err := checkIterTime(tt.iterTime, tt.allowWeak)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkIterTime(%d, %v) = %v", tt.iterTime, tt.allowWeak, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("checkIterTime(%d, %v) = %v, want an error containing %q", tt.iterTime, tt.allowWeak, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckMinSize(t *testing.T) {
+	for _, fsType := range []string{"ext4", "xfs", "btrfs"} {
+		floor := luks2HeaderSize + container.MinFilesystemSize(fsType)
+		if container.MinFilesystemSize(fsType) == 0 {
+			t.Fatalf("no minimum size for %s", fsType)
+		}
+		if err := checkMinSize(floor-1, fsType); err == nil || !strings.Contains(err.Error(), "too small for LUKS2 and "+fsType) {
+			t.Errorf("%s: checkMinSize(floor-1) = %v, want too small", fsType, err)
+		}
+		if err := checkMinSize(floor, fsType); err != nil {
+			t.Errorf("%s: checkMinSize(floor) = %v", fsType, err)
+		}
+		if err := checkMinSize(floor+1, fsType); err != nil {
+			t.Errorf("%s: checkMinSize(floor+1) = %v", fsType, err)
+		}
+	}
+
+	// xfs needs far more than ext4
+	if err := checkMinSize(100<<20, "xfs"); err == nil {
+		t.Error("100 MiB accepted for xfs")
+	}
+	if err := checkMinSize(100<<20, "ext4"); err != nil {
+		t.Errorf("100 MiB rejected for ext4: %v", err)
+	}
+}
+
+func TestUsableSize(t *testing.T) {
+	tests := []struct {
+		size, want uint64
+	}{
+		{0, 0},
+		{luks2HeaderSize - 1, 0},
+		{luks2HeaderSize, 0},
+		{luks2HeaderSize + 1, 1},
+		{1 << 30, 1<<30 - 16<<20},
+	}
+	for _, tt := range tests {
+		if got := usableSize(tt.size); got != tt.want {
+			t.Errorf("usableSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestCheckMinFree(t *testing.T) {
+	const available, reserve = 10 << 30, 2 << 30
+	tests := []struct {
+		size    uint64
+		wantErr bool
+	}{
+		{available - reserve, false},     // Leaves exactly the reserve
+		{available - reserve + 1, true},  // One byte below it
+		{available - reserve - 1, false}, // One byte to spare
+		{available, true},                // Leaves nothing
+		{available + 1, true},            // Does not fit at all
+		{0, false},
+	}
+	for _, tt := range tests {
+		err := checkMinFree(available, tt.size, reserve)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkMinFree(%d, %d, %d) = %v, want error %v", uint64(available), tt.size, uint64(reserve), err, tt.wantErr)
+		}
+	}
+
+	// Without a reserve, the container may use all the space
+	if err := checkMinFree(available, available, 0); err != nil {
+		t.Errorf("checkMinFree with no reserve = %v", err)
+	}
+	err := checkMinFree(available, available+1, 0)
+	if err == nil || !strings.Contains(err.Error(), "would leave only 0 B free") {
+		t.Errorf("checkMinFree over the available space = %v", err)
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	const templatePath = "/data/old.img"
+	type settings struct {
+		cipher     string
+		keySize    int
+		pbkdf      string
+		filesystem string
+	}
+	tests := []struct {
+		name    string
+		flags   map[string]string // Given explicitly
+		open    bool              // Template open, its filesystem readable
+		want    settings
+		warning string
+	}{
+		{
+			name:    "template closed",
+			want:    settings{"aes-xts-plain64", 512, "argon2id", ""},
+			warning: "Not copying the filesystem type: template is not open",
+		},
+		{
+			name: "template open",
+			open: true,
+			want: settings{"aes-xts-plain64", 512, "argon2id", "xfs"},
+		},
+		{
+			name:  "flags win",
+			flags: map[string]string{"cipher": "serpent-xts-plain64", "pbkdf": "pbkdf2", "filesystem": "btrfs"},
+			open:  true,
+			want:  settings{"serpent-xts-plain64", 512, "pbkdf2", "btrfs"},
+		},
+		{
+			name:    "key size flag",
+			flags:   map[string]string{"key-size": "256"},
+			want:    settings{"aes-xts-plain64", 256, "argon2id", ""},
+			warning: "Not copying the filesystem type: template is not open",
+		},
+	}
+	for _, tt := range tests {
+		if tt.open && !system.IsRoot() {
+			t.Logf("%s: skipped, discovery only goes through the executor as root", tt.name)
+			continue
+		}
+		exec := testutil.NewFakeExecutor()
+		if tt.open {
+			exec.On("dmsetup ls --target crypt", "old_img\t(253:0)\n", nil).
+				On("dmsetup table old_img", "0 2064384 crypt aes-xts-plain64 :64:logon:cryptsetup:x 0 7:0 32768\n", nil).
+				On("losetup -l -J", `{"loopdevices": [{"name": "/dev/loop0", "back-file": "/data/old.img"}]}`, nil).
+				On("blkid -o value -s TYPE /dev/mapper/old_img", "xfs\n", nil)
+		}
+		luks := newFakeCryptSetup()
+		luks.luks[templatePath] = true
+		luks.options = container.FormatOptions{Cipher: "aes-xts-plain64", KeySize: 512, PBKDF: "argon2id"}
+		ctx := newTestContext(exec, luks)
+		ctx.Discovery.SetMountSource(fixtureMounts{})
+
+		// The fields hold what the flags set, Changed tells them apart
+		// from defaults
+		c := &CreateCommand{ctx: ctx, template: templatePath}
+		cmd := &cobra.Command{}
+		cmd.Flags().StringVar(&c.cipher, "cipher", "", "")
+		cmd.Flags().IntVar(&c.keySize, "key-size", 0, "")
+		cmd.Flags().StringVar(&c.pbkdf, "pbkdf", "", "")
+		cmd.Flags().StringVar(&c.filesystem, "filesystem", "", "")
+		for name, value := range tt.flags {
+			if err := cmd.Flags().Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := c.applyTemplate(context.Background(), cmd, "/data/new.img"); err != nil {
+			t.Errorf("%s: applyTemplate: %v", tt.name, err)
+			continue
+		}
+		got := settings{c.cipher, c.keySize, c.pbkdf, c.filesystem}
+		if got != tt.want {
+			t.Errorf("%s: settings %+v, want %+v", tt.name, got, tt.want)
+		}
+		warnings := strings.Join(ctx.Warnings.List(), "\n")
+		if tt.warning == "" && warnings != "" || !strings.Contains(warnings, tt.warning) {
+			t.Errorf("%s: warnings %q, want %q", tt.name, warnings, tt.warning)
+		}
+	}
+}
+
+func TestApplyTemplateRefuses(t *testing.T) {
+	luks := newFakeCryptSetup()
+	c := &CreateCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks)}
+
+	c.template = "/data/new.img"
+	err := c.applyTemplate(context.Background(), &cobra.Command{}, "/data/new.img")
+	if err == nil || !strings.Contains(err.Error(), "cannot be the container being created") {
+		t.Errorf("template of itself: %v", err)
+	}
+
+	c.template = "/data/plain.img"
+	err = c.applyTemplate(context.Background(), &cobra.Command{}, "/data/new.img")
+	if err == nil || !strings.Contains(err.Error(), "not a LUKS container") {
+		t.Errorf("non-LUKS template: %v", err)
+	}
+	if luks.called("FormatOptionsOf") {
+		t.Errorf("read the settings of a non-LUKS template: %v", luks.Calls())
+	}
+}
+
+func TestEnrollKeyfiles(t *testing.T) {
+	keyfiles := []string{"/keys/a", "/keys/b", "/keys/c"}
+	tests := []struct {
+		name    string
+		failure string // --add-keyfile-failure
+		fails   int    // Leading AddKey calls that fail
+		wantErr bool
+		added   []string // Keyfiles AddKey was called with
+		warned  int
+	}{
+		{name: "all added", failure: "warn", added: keyfiles},
+		{name: "warn", failure: "warn", fails: 1, added: keyfiles, warned: 1},
+		{name: "warn on each", failure: "warn", fails: 2, added: keyfiles, warned: 2},
+		{name: "rollback", failure: "rollback", fails: 1, wantErr: true, added: keyfiles[:1]},
+		{name: "rollback unneeded", failure: "rollback", added: keyfiles},
+	}
+	for _, tt := range tests {
+		luks := newFakeCryptSetup()
+		luks.errs["AddKey"] = errors.New("no free keyslot")
+		luks.failTimes["AddKey"] = tt.fails
+		ctx := newTestContext(testutil.NewFakeExecutor(), luks)
+		c := &CreateCommand{ctx: ctx, addKeyfiles: keyfiles, addKeyFailure: tt.failure}
+
+		err := c.enrollKeyfiles(context.Background(), "/data/new.img", &container.KeyfileAuth{KeyfilePath: "/k"})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: enrollKeyfiles = %v", tt.name, err)
+		}
+		var added []string
+		for _, call := range luks.Calls() {
+			if rest, ok := strings.CutPrefix(call, "AddKey /data/new.img "); ok {
+				added = append(added, rest)
+			}
+		}
+		if strings.Join(added, " ") != strings.Join(tt.added, " ") {
+			t.Errorf("%s: AddKey called with %q, want %q", tt.name, added, tt.added)
+		}
+		if n := len(ctx.Warnings.List()); n != tt.warned {
+			t.Errorf("%s: %d warnings, want %d: %q", tt.name, n, tt.warned, ctx.Warnings.List())
+		}
+	}
+}
+
+func TestCreateExecuteAddKeyfileFailure(t *testing.T) {
+	for _, failure := range []string{"warn", "rollback"} {
+		exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+		luks := newFakeCryptSetup()
+		luks.errs["AddKey"] = errors.New("no free keyslot")
+		c := &CreateCommand{ctx: newTestContext(exec, luks), filesystem: "ext4", addKeyfiles: []string{"/keys/a"}, addKeyFailure: failure}
+
+		path := filepath.Join(t.TempDir(), "new.img")
+		err := c.execute(context.Background(), path, 1<<20, &container.KeyfileAuth{KeyfilePath: "/k"}, createTarget{})
+		_, statErr := os.Stat(path)
+		if failure == "warn" && (err != nil || statErr != nil) {
+			t.Errorf("warn: execute = %v, container %v; want it kept", err, statErr)
+		}
+		// A rollback removes the container before it is ever opened
+		if failure == "rollback" && (err == nil || !os.IsNotExist(statErr) || luks.called("Open")) {
+			t.Errorf("rollback: execute = %v, container %v, LUKS calls %v", err, statErr, luks.Calls())
+		}
+	}
+}
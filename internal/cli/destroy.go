@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nace/brezno/internal/system"
+	"github.com/spf13/cobra"
+)
+
+// luks2HeaderSize is the space a default LUKS2 header and its keyslots
+// occupy at the start of a container. Without it the volume key, and so
+// the data, cannot be recovered.
+const luks2HeaderSize = 16 * 1024 * 1024
+
+// DestroyCommand handles permanently deleting a container
+type DestroyCommand struct {
+	ctx  *GlobalContext
+	yes  bool
+	wipe bool
+}
+
+// NewDestroyCommand creates the destroy command
+func NewDestroyCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &DestroyCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:     "destroy <container-path>",
+		Aliases: []string{"wipe"},
+		Short:   "Permanently delete an encrypted container",
+		Long: `Delete a container file. The container must not be open or mounted.
+
+All LUKS keyslots are erased first (cryptsetup erase), so the volume key is
+gone and the contents are unrecoverable even if the deleted file can be
+restored. With --wipe, the whole LUKS header is also overwritten with random
+data.
+
+This cannot be undone, so --yes is required.`,
+		Args: cobra.ExactArgs(1),
+		RunE: cmd.Run,
+	}
+
+	cobraCmd.Flags().BoolVarP(&cmd.yes, "yes", "y", false, "Confirm the deletion (required)")
+	cobraCmd.Flags().BoolVar(&cmd.wipe, "wipe", false, "Overwrite the LUKS header with random data before deleting")
+
+	return cobraCmd
+}
+
+// Run executes the destroy command
+func (c *DestroyCommand) Run(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	if !c.yes {
+		return fmt.Errorf("destroying a container cannot be undone; re-run with --yes to confirm")
+	}
+
+	absPath, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+	containerPath := absPath
+
+	// Hold the container lock for the whole operation
+	lock, err := lockContainer(containerPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	info, err := os.Stat(containerPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("container file not found: %s", containerPath)
+		}
+		return fmt.Errorf("failed to access container: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("container must be a regular file: %s", containerPath)
+	}
+
+	// Only ever destroy LUKS containers, never an arbitrary file
+	isLuks, err := c.ctx.LUKSManager.IsLUKS(ctx, containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to check LUKS format: %w", err)
+	}
+	if !isLuks {
+		return fmt.Errorf("not a LUKS container: %s", containerPath)
+	}
+
+	if err := c.checkUnused(ctx, containerPath); err != nil {
+		return err
+	}
+
+	return c.execute(ctx, containerPath, uint64(info.Size()))
+}
+
+// checkUnused refuses containers that are open, mounted, or attached
+func (c *DestroyCommand) checkUnused(ctx context.Context, path string) error {
+	existing, err := c.ctx.Discovery.FindByPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("container is open as /dev/mapper/%s\n"+
+			"Run 'brezno unmount %s' first", existing.MapperName, path)
+	}
+
+	loopDev, err := c.ctx.LoopManager.FindByFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	if loopDev != "" {
+		return fmt.Errorf("container is attached to loop device %s\n"+
+			"Detach it first: losetup -d %s", loopDev, loopDev)
+	}
+	return nil
+}
+
+// execute erases the keyslots, wipes the header if requested, and
+// removes the file
+func (c *DestroyCommand) execute(ctx context.Context, path string, size uint64) error {
+	c.ctx.Logger.Info("Erasing LUKS keyslots...")
+	if err := c.ctx.LUKSManager.Erase(ctx, path); err != nil {
+		return err
+	}
+
+	if c.wipe {
+		extent := headerWipeExtent(size)
+		c.ctx.Logger.Info("Overwriting LUKS header (%s) with random data...", system.FormatSize(extent))
+		if err := system.OverwriteRandom(path, extent); err != nil {
+			return err
+		}
+	}
+
+	c.ctx.Logger.Info("Removing %s...", path)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+
+	c.ctx.Logger.Success("Container destroyed: %s", path)
+	return nil
+}
+
+// headerWipeExtent returns how many bytes to overwrite to destroy the
+// header: the LUKS2 header area, or the whole file if it is smaller
+func headerWipeExtent(fileSize uint64) uint64 {
+	if fileSize < luks2HeaderSize {
+		return fileSize
+	}
+	return luks2HeaderSize
+}
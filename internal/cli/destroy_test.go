@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestHeaderWipeExtent(t *testing.T) {
+	tests := []struct {
+		size, want uint64
+	}{
+		{0, 0},
+		{1 << 20, 1 << 20},
+		{luks2HeaderSize - 1, luks2HeaderSize - 1},
+		{luks2HeaderSize, luks2HeaderSize},
+		{luks2HeaderSize + 1, luks2HeaderSize},
+		{1 << 40, luks2HeaderSize},
+	}
+	for _, tt := range tests {
+		if got := headerWipeExtent(tt.size); got != tt.want {
+			t.Errorf("headerWipeExtent(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+// zeroBlocks counts the 4 KiB blocks of data that are all zero. Random
+// data practically never has one.
+func zeroBlocks(data []byte) int {
+	zero := make([]byte, 4096)
+	n := 0
+	for off := 0; off < len(data); off += len(zero) {
+		end := min(off+len(zero), len(data))
+		if bytes.Equal(data[off:end], zero[:end-off]) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHeaderWipe(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+	}{
+		{"smaller than the header", 3<<20 + 100},
+		{"larger than the header", luks2HeaderSize + 4<<20 + 100},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "old.img")
+		if err := os.WriteFile(path, make([]byte, tt.size), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		extent := headerWipeExtent(uint64(tt.size))
+		if err := system.OverwriteRandom(path, extent); err != nil {
+			t.Fatalf("%s: OverwriteRandom: %v", tt.name, err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != tt.size {
+			t.Errorf("%s: size changed from %d to %d", tt.name, tt.size, len(data))
+		}
+		if n := zeroBlocks(data[:extent]); n != 0 {
+			t.Errorf("%s: %d blocks of the header left as they were", tt.name, n)
+		}
+		if rest := data[extent:]; !bytes.Equal(rest, make([]byte, len(rest))) {
+			t.Errorf("%s: data after the header changed", tt.name)
+		}
+	}
+}
+
+func TestDestroyCheckUnused(t *testing.T) {
+	if !system.IsRoot() {
+		t.Skip("discovery only goes through the executor as root")
+	}
+	const path = "/data/old.img"
+	open := func(exec *testutil.FakeExecutor) {
+		exec.On("dmsetup ls --target crypt", "old_img\t(253:0)\n", nil).
+			On("dmsetup table old_img", "0 2064384 crypt aes-xts-plain64 :64:logon:cryptsetup:x 0 7:0 32768\n", nil).
+			On("losetup -l -J", `{"loopdevices": [{"name": "/dev/loop0", "back-file": "/data/old.img"}]}`, nil)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(exec *testutil.FakeExecutor)
+		want  string
+	}{
+		{"unused", func(*testutil.FakeExecutor) {}, ""},
+		{"open", open, "container is open as /dev/mapper/old_img"},
+		{"attached", func(exec *testutil.FakeExecutor) {
+			exec.On("losetup -j "+path, "/dev/loop4: []: (/data/old.img)\n", nil)
+		}, "container is attached to loop device /dev/loop4"},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor()
+		tt.setup(exec)
+		ctx := newTestContext(exec, newFakeCryptSetup())
+		ctx.Discovery.SetMountSource(fixtureMounts{})
+		c := &DestroyCommand{ctx: ctx, yes: true}
+
+		err := c.checkUnused(context.Background(), path)
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: checkUnused = %v", tt.name, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: checkUnused = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// eraseWitness is a CryptSetup noting whether the container file still
+// existed when its keyslots were erased
+type eraseWitness struct {
+	*fakeCryptSetup
+	fileExisted bool
+}
+
+func (w *eraseWitness) Erase(ctx context.Context, path string) error {
+	_, err := os.Stat(path)
+	w.fileExisted = err == nil
+	return w.fakeCryptSetup.Erase(ctx, path)
+}
+
+func TestDestroyExecute(t *testing.T) {
+	for _, wipe := range []bool{false, true} {
+		luks := &eraseWitness{fakeCryptSetup: newFakeCryptSetup()}
+		c := &DestroyCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), yes: true, wipe: wipe}
+		path := filepath.Join(t.TempDir(), "old.img")
+		if err := os.WriteFile(path, make([]byte, 1<<20), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.execute(context.Background(), path, 1<<20); err != nil {
+			t.Fatalf("wipe %v: execute: %v", wipe, err)
+		}
+		if !luks.called("Erase "+path) || !luks.fileExisted {
+			t.Errorf("wipe %v: keyslots not erased before removal: %v", wipe, luks.Calls())
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("wipe %v: container not removed: %v", wipe, err)
+		}
+	}
+}
+
+func TestDestroyExecuteEraseFailure(t *testing.T) {
+	luks := newFakeCryptSetup()
+	luks.errs["Erase"] = errors.New("failed to erase LUKS keyslots")
+	c := &DestroyCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), yes: true, wipe: true}
+	path := filepath.Join(t.TempDir(), "old.img")
+	if err := os.WriteFile(path, make([]byte, 1<<20), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.execute(context.Background(), path, 1<<20); err == nil {
+		t.Fatal("execute succeeded despite the erase failure")
+	}
+	// Nothing else is touched, so the user can retry
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, make([]byte, 1<<20)) {
+		t.Errorf("container changed after the failed erase: %v", err)
+	}
+}
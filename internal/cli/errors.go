@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nace/brezno/internal/ui"
+)
+
+// Exit codes returned by brezno
+const (
+	ExitFailure         = 1 // Any other error
+	ExitWrongPassphrase = 2 // No keyslot matched the passphrase (as in cryptsetup)
+)
+
+// ExitCodeError is an error that carries the process exit code
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code for err: the code of the first
+// ExitCodeError in its chain, or ExitFailure
+func ExitCode(err error) int {
+	var codeErr *ExitCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.Code
+	}
+	return ExitFailure
+}
+
+// jsonError is the shape of an error written by WriteJSONError
+type jsonError struct {
+	SchemaVersion int    `json:"schema_version,omitempty"` // Left out for legacy output
+	Error         string `json:"error"`
+	Code          int    `json:"code"`
+	Stage         string `json:"stage,omitempty"` // Last completed step of a failed resize
+}
+
+// WriteJSONError writes err as a single-line JSON object,
+// e.g. {"schema_version":1,"error":"...","code":1}. legacy leaves out
+// schema_version, as before it was added.
+func WriteJSONError(w io.Writer, err error, legacy bool) error {
+	out := jsonError{Error: err.Error(), Code: ExitCode(err)}
+	if !legacy {
+		out.SchemaVersion = ui.JSONSchemaVersion
+	}
+	var resizeErr *ResizeError
+	if errors.As(err, &resizeErr) {
+		out.Stage = string(resizeErr.Stage)
+	}
+
+	data, jsonErr := json.Marshal(out)
+	if jsonErr != nil {
+		return fmt.Errorf("failed to encode error: %w", jsonErr)
+	}
+	_, writeErr := fmt.Fprintf(w, "%s\n", data)
+	return writeErr
+}
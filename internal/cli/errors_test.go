@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWriteJSONError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		legacy bool
+		want   map[string]interface{}
+	}{
+		{
+			name: "plain error",
+			err:  errors.New("container not found: /data/x.img"),
+			want: map[string]interface{}{"schema_version": 1.0, "error": "container not found: /data/x.img", "code": 1.0},
+		},
+		{
+			name: "exit code",
+			err:  fmt.Errorf("mount: %w", &ExitCodeError{Code: ExitWrongPassphrase, Err: errors.New("wrong passphrase")}),
+			want: map[string]interface{}{"schema_version": 1.0, "error": "mount: wrong passphrase", "code": 2.0},
+		},
+		{
+			name: "resize stage",
+			err:  &ResizeError{Stage: ResizeStageFileExpanded, Err: errors.New("losetup failed")},
+			want: map[string]interface{}{"schema_version": 1.0, "error": "losetup failed", "code": 1.0, "stage": "file-expanded"},
+		},
+		{
+			name:   "legacy",
+			err:    &ExitCodeError{Code: ExitWrongPassphrase, Err: errors.New("wrong passphrase")},
+			legacy: true,
+			want:   map[string]interface{}{"error": "wrong passphrase", "code": 2.0},
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteJSONError(&buf, tt.err, tt.legacy); err != nil {
+			t.Fatalf("%s: WriteJSONError: %v", tt.name, err)
+		}
+		if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 1 || !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			t.Errorf("%s: not a single line: %q", tt.name, buf.String())
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("%s: invalid JSON %q: %v", tt.name, buf.String(), err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/testutil"
+	"github.com/nace/brezno/internal/ui"
+)
+
+// Ensure fakeCryptSetup implements container.CryptSetup
+var _ container.CryptSetup = (*fakeCryptSetup)(nil)
+
+// fakeCryptSetup is a container.CryptSetup that records calls and fails
+// the ones given an error in errs, keyed by method name. If failTimes has
+// a count for the method, only that many calls fail.
+type fakeCryptSetup struct {
+	mu        sync.Mutex
+	calls     []string
+	errs      map[string]error
+	failTimes map[string]int
+
+	luks    map[string]bool   // Paths IsLUKS reports as LUKS
+	labels  map[string]string // LUKS2 label of each path
+	uuid    string            // UUID result
+	slot    int               // Keyslot TestPassphrase reports
+	size    uint64            // GetLUKSSize result
+	options container.FormatOptions
+}
+
+func newFakeCryptSetup() *fakeCryptSetup {
+	return &fakeCryptSetup{errs: make(map[string]error), failTimes: make(map[string]int), luks: make(map[string]bool), labels: make(map[string]string)}
+}
+
+// record notes a call and returns the error configured for method
+func (f *fakeCryptSetup) record(method string, args ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, strings.Join(append([]string{method}, args...), " "))
+	if n, limited := f.failTimes[method]; limited {
+		if n == 0 {
+			return nil
+		}
+		f.failTimes[method] = n - 1
+	}
+	return f.errs[method]
+}
+
+// Calls returns the recorded calls, e.g. "Open /dev/loop0 brezno_x"
+func (f *fakeCryptSetup) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// called reports whether a call starting with prefix was made
+func (f *fakeCryptSetup) called(prefix string) bool {
+	for _, c := range f.Calls() {
+		if strings.HasPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeCryptSetup) Format(ctx context.Context, path string, auth container.AuthMethod, opts container.FormatOptions) error {
+	return f.record("Format", path)
+}
+
+func (f *fakeCryptSetup) FormatOptionsOf(ctx context.Context, path string) (container.FormatOptions, error) {
+	return f.options, f.record("FormatOptionsOf", path)
+}
+
+func (f *fakeCryptSetup) IsLUKS(ctx context.Context, path string) (bool, error) {
+	err := f.record("IsLUKS", path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.luks[path], err
+}
+
+func (f *fakeCryptSetup) IsLUKSFd(ctx context.Context, file *os.File) (bool, error) {
+	err := f.record("IsLUKSFd", file.Name())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.luks[file.Name()], err
+}
+
+func (f *fakeCryptSetup) UUID(ctx context.Context, path string) (string, error) {
+	return f.uuid, f.record("UUID", path)
+}
+
+func (f *fakeCryptSetup) Label(ctx context.Context, path string) (string, error) {
+	err := f.record("Label", path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.labels[path], err
+}
+
+func (f *fakeCryptSetup) SetToken(ctx context.Context, device string, token container.BreznoToken) error {
+	return f.record("SetToken", device)
+}
+
+func (f *fakeCryptSetup) GetToken(ctx context.Context, device string) (*container.BreznoToken, error) {
+	return nil, f.record("GetToken", device)
+}
+
+func (f *fakeCryptSetup) Open(ctx context.Context, device, mapperName string, auth container.AuthMethod, readonly bool) error {
+	return f.record("Open", device, mapperName, fmt.Sprint(readonly))
+}
+
+// Close fails once ctx is done, as cryptsetup would not be started
+func (f *fakeCryptSetup) Close(ctx context.Context, mapperName string) error {
+	if err := f.record("Close", mapperName); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (f *fakeCryptSetup) TestPassphrase(ctx context.Context, device string, auth container.AuthMethod) (int, error) {
+	if err := f.record("TestPassphrase", device); err != nil {
+		return -1, err
+	}
+	return f.slot, nil
+}
+
+func (f *fakeCryptSetup) Resize(ctx context.Context, mapperName string, auth container.AuthMethod) error {
+	return f.record("Resize", mapperName)
+}
+
+func (f *fakeCryptSetup) GetLUKSSize(ctx context.Context, mapperName string) (uint64, error) {
+	return f.size, f.record("GetLUKSSize", mapperName)
+}
+
+func (f *fakeCryptSetup) ChangeKey(ctx context.Context, device string, currentAuth, newAuth container.AuthMethod) error {
+	return f.record("ChangeKey", device)
+}
+
+func (f *fakeCryptSetup) AddKey(ctx context.Context, device string, auth container.AuthMethod, newKeyfile string) error {
+	return f.record("AddKey", device, newKeyfile)
+}
+
+func (f *fakeCryptSetup) Reencrypt(ctx context.Context, device string, auth container.AuthMethod, opts container.ReencryptOptions) error {
+	return f.record("Reencrypt", device)
+}
+
+func (f *fakeCryptSetup) ReencryptInProgress(ctx context.Context, device string) (bool, error) {
+	return false, f.record("ReencryptInProgress", device)
+}
+
+func (f *fakeCryptSetup) BackupHeader(ctx context.Context, device, backupFile string) error {
+	return f.record("BackupHeader", device, backupFile)
+}
+
+func (f *fakeCryptSetup) Erase(ctx context.Context, path string) error {
+	return f.record("Erase", path)
+}
+
+func (f *fakeCryptSetup) Benchmark(ctx context.Context) (uint64, error) {
+	return 0, f.record("Benchmark")
+}
+
+func (f *fakeCryptSetup) CheckTPM() error {
+	return f.record("CheckTPM")
+}
+
+func (f *fakeCryptSetup) CheckFIDO2() error {
+	return f.record("CheckFIDO2")
+}
+
+func (f *fakeCryptSetup) EnrollTPM(ctx context.Context, device string, auth container.AuthMethod, pcrs string) error {
+	return f.record("EnrollTPM", device)
+}
+
+func (f *fakeCryptSetup) EnrollFIDO2(ctx context.Context, device string, auth container.AuthMethod, fido2Device string) error {
+	return f.record("EnrollFIDO2", device)
+}
+
+// newTestContext returns a GlobalContext whose commands go to exec and
+// whose LUKS operations go to luks, logging quietly
+func newTestContext(exec *testutil.FakeExecutor, luks container.CryptSetup) *GlobalContext {
+	logger := ui.NewLogger(false, true, true)
+	warnings := &ui.WarningCollector{}
+	logger.Collector = warnings
+
+	discovery := container.NewDiscovery(exec)
+	discovery.SetLogger(logger)
+
+	return &GlobalContext{
+		Executor:    exec,
+		Logger:      logger,
+		LoopManager: container.NewLoopManager(exec),
+		LUKSManager: luks,
+		MountMgr:    container.NewMountManager(exec),
+		Discovery:   discovery,
+		Warnings:    warnings,
+	}
+}
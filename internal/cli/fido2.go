@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/spf13/cobra"
+)
+
+// FIDO2Command manages FIDO2 security token unlocking
+type FIDO2Command struct {
+	ctx           *GlobalContext
+	keyfile       string
+	fido2Device   string
+	passwordStdin bool
+}
+
+// NewFIDO2Command creates the fido2 command with its enroll subcommand
+func NewFIDO2Command(ctx *GlobalContext) *cobra.Command {
+	cmd := &FIDO2Command{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "fido2",
+		Short: "Manage FIDO2 security token unlocking",
+		Long: `Enroll a FIDO2 security token (e.g., a YubiKey) so a container can be
+mounted with 'brezno mount --fido2' by touching the token.
+
+Enrollment uses systemd-cryptenroll and adds a new key slot; existing
+passwords and keyfiles keep working.`,
+	}
+
+	enrollCmd := &cobra.Command{
+		Use:   "enroll <container-path>",
+		Short: "Enroll a FIDO2 security token as an unlock method for a container",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  cmd.RunEnroll,
+	}
+	enrollCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Current keyfile path (if not set, will prompt for password)")
+	enrollCmd.Flags().StringVar(&cmd.fido2Device, "fido2-device", "auto", "FIDO2 hidraw device path, or auto to use the only token plugged in")
+	enrollCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+
+	cobraCmd.AddCommand(enrollCmd)
+	return cobraCmd
+}
+
+// RunEnroll enrolls a FIDO2 token for a container
+func (c *FIDO2Command) RunEnroll(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	if err := c.ctx.LUKSManager.CheckFIDO2(); err != nil {
+		return err
+	}
+
+	containerPath, err := c.ctx.resolveExistingLUKS(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	// An existing credential authorizes the new key slot
+	auth, err := GetAuthMethod(c.keyfile, "", false, c.passwordStdin, "Enter current password", "")
+	if err != nil {
+		return err
+	}
+	// Ensure password is zeroized when done
+	if pwAuth, ok := auth.(*container.PasswordAuth); ok {
+		defer pwAuth.Password.Zeroize()
+	}
+
+	c.ctx.Logger.Info("Enrolling FIDO2 token for %s...", containerPath)
+	c.ctx.Logger.Info("Touch your security token when it blinks")
+	if err := c.ctx.LUKSManager.EnrollFIDO2(ctx, containerPath, auth, c.fido2Device); err != nil {
+		return err
+	}
+
+	c.ctx.Logger.Success("FIDO2 token enrolled successfully")
+	c.ctx.Logger.Info("Mount with: sudo brezno mount --fido2 %s <mount-point>", containerPath)
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// HistoryCommand shows past operations from a --report file
+type HistoryCommand struct {
+	ctx        *GlobalContext
+	report     string
+	json       bool
+	legacyJSON bool
+	limit      int
+}
+
+// NewHistoryCommand creates the history command
+func NewHistoryCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &HistoryCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "history [container-path]",
+		Short: "Show past operations recorded with --report",
+		Long: `Show the operations recorded in a report file written by the --report
+option of create, mount, and resize, oldest first. Pass a container path to
+show only its operations.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: cmd.Run,
+	}
+
+	cobraCmd.Flags().StringVar(&cmd.report, "report", "", "Report file to read (required)")
+	cobraCmd.Flags().BoolVarP(&cmd.json, "json", "j", false, "JSON output")
+	cobraCmd.Flags().BoolVar(&cmd.legacyJSON, "legacy-json", false, "With --json, print the bare record array without the schema_version envelope")
+	cobraCmd.Flags().IntVar(&cmd.limit, "limit", 0, "Show only the last N operations (0 for all)")
+	cobraCmd.MarkFlagRequired("report")
+
+	return cobraCmd
+}
+
+// Run executes the history command
+func (c *HistoryCommand) Run(cmd *cobra.Command, args []string) error {
+	if c.limit < 0 {
+		return fmt.Errorf("--limit must not be negative")
+	}
+	if c.legacyJSON && !c.json {
+		return fmt.Errorf("--legacy-json requires --json")
+	}
+
+	records, warnings, err := system.ReadReports(c.report)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		c.ctx.Logger.Warning("%s", warning)
+	}
+
+	// Filter to one container; reports record absolute, symlink-free paths
+	if len(args) > 0 {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+
+		filtered := records[:0]
+		for _, record := range records {
+			if record.Path == path {
+				filtered = append(filtered, record)
+			}
+		}
+		records = filtered
+	}
+
+	if c.limit > 0 && len(records) > c.limit {
+		records = records[len(records)-c.limit:]
+	}
+
+	if c.json {
+		if records == nil {
+			records = []system.ReportRecord{}
+		}
+		return ui.PrintJSON(ui.JSONOutput("records", records, c.legacyJSON))
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No operations recorded")
+		return nil
+	}
+
+	table := ui.NewTable("TIME", "OPERATION", "CONTAINER", "RESULT")
+	table.NoColor = c.ctx.Logger.NoColor
+	for _, record := range records {
+		when := record.Timestamp.Local().Format(time.DateTime)
+		if record.Success {
+			table.AddRow(when, record.Operation, record.Path, "ok")
+		} else {
+			table.AddWarningRow(when, record.Operation, record.Path, "failed")
+		}
+	}
+	table.Print()
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+// writeTestReport appends one record for each operation to a fresh
+// report file and returns its path
+func writeTestReport(t *testing.T, operations ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "report.jsonl")
+	for _, op := range operations {
+		record := system.ReportRecord{
+			Operation: op,
+			Path:      "/data/x.img",
+			Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			Success:   true,
+		}
+		if err := system.AppendReport(path, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestHistoryJSON(t *testing.T) {
+	record := `{"operation":"mount","path":"/data/x.img","timestamp":"2026-01-02T03:04:05Z","success":true}`
+	tests := []struct {
+		legacy bool
+		want   string
+	}{
+		{false, `{"schema_version":1,"records":[` + record + `]}`},
+		{true, `[` + record + `]`},
+	}
+	for _, tt := range tests {
+		c := &HistoryCommand{
+			ctx:        newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()),
+			report:     writeTestReport(t, "create", "mount"),
+			json:       true,
+			legacyJSON: tt.legacy,
+			limit:      1,
+		}
+		var err error
+		out := captureStdout(t, func() { err = c.Run(nil, nil) })
+		if err != nil {
+			t.Fatalf("legacy %v: %v", tt.legacy, err)
+		}
+		if got := strings.Join(strings.Fields(out), ""); got != tt.want {
+			t.Errorf("legacy %v: output %s, want %s", tt.legacy, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryJSONEmpty(t *testing.T) {
+	// No record matches, an empty array rather than null
+	c := &HistoryCommand{
+		ctx:    newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()),
+		report: writeTestReport(t, "mount"),
+		json:   true,
+	}
+	var err error
+	out := captureStdout(t, func() { err = c.Run(nil, []string{"/data/other.img"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(out), ""); got != `{"schema_version":1,"records":[]}` {
+		t.Errorf("output %s", got)
+	}
+}
+
+func TestHistoryLegacyJSONRequiresJSON(t *testing.T) {
+	c := &HistoryCommand{
+		ctx:        newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()),
+		report:     writeTestReport(t, "mount"),
+		legacyJSON: true,
+	}
+	if err := c.Run(nil, nil); err == nil || err.Error() != "--legacy-json requires --json" {
+		t.Errorf("Run() = %v", err)
+	}
+}
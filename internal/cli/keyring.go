@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/spf13/cobra"
+)
+
+// KeyringCommand manages passphrases in the kernel keyring
+type KeyringCommand struct {
+	ctx           *GlobalContext
+	keyring       string
+	passwordStdin bool
+}
+
+// NewKeyringCommand creates the keyring command with its add and clear subcommands
+func NewKeyringCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &KeyringCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "keyring",
+		Short: "Manage container passphrases in the kernel keyring",
+		Long: `Load passphrases into the kernel keyring so containers can be unlocked
+with --key-description instead of a prompt or a keyfile on disk.
+
+Keys are stored as "user" keys in the session keyring by default.`,
+	}
+
+	cobraCmd.PersistentFlags().StringVar(&cmd.keyring, "keyring", "@s", "Target keyring (@s session, @u user)")
+
+	addCmd := &cobra.Command{
+		Use:   "add <key-description>",
+		Short: "Load a passphrase into the kernel keyring",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmd.RunAdd,
+	}
+	addCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+
+	clearCmd := &cobra.Command{
+		Use:   "clear <key-description>",
+		Short: "Remove a passphrase from the kernel keyring",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmd.RunClear,
+	}
+
+	cobraCmd.AddCommand(addCmd, clearCmd)
+	return cobraCmd
+}
+
+// RunAdd loads a passphrase into the keyring
+func (c *KeyringCommand) RunAdd(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.Executor.CheckDependencies([]string{"keyctl"}); err != nil {
+		return fmt.Errorf("%w (install keyutils)", err)
+	}
+
+	description := args[0]
+
+	// Reuse the password flow, confirming the passphrase like create does
+	auth, err := GetAuthMethod("", "", true, c.passwordStdin, "Enter passphrase", "Confirm passphrase")
+	if err != nil {
+		return err
+	}
+	pwAuth, ok := auth.(*container.PasswordAuth)
+	if !ok {
+		return fmt.Errorf("expected a passphrase, got %T", auth)
+	}
+	password := pwAuth.Password
+	defer password.Zeroize()
+
+	// keyctl padd reads the key payload from stdin, avoiding the command line
+	keyctl := exec.CommandContext(ctx, "keyctl", "padd", "user", description, c.keyring)
+	keyctl.Stdin = bytes.NewReader(password.Bytes())
+	if _, err := c.ctx.Executor.RunCmd(keyctl); err != nil {
+		return fmt.Errorf("failed to add key to keyring: %w", err)
+	}
+
+	c.ctx.Logger.Success("Passphrase loaded into keyring %s as %q", c.keyring, description)
+	c.ctx.Logger.Info("Use it with: --key-description %s", description)
+
+	return nil
+}
+
+// RunClear removes a passphrase from the keyring
+func (c *KeyringCommand) RunClear(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.Executor.CheckDependencies([]string{"keyctl"}); err != nil {
+		return fmt.Errorf("%w (install keyutils)", err)
+	}
+
+	description := args[0]
+
+	output, err := c.ctx.Executor.RunOutput(ctx, "keyctl", "search", c.keyring, "user", description)
+	if err != nil {
+		return fmt.Errorf("no key %q found in keyring %s", description, c.keyring)
+	}
+	keyID := strings.TrimSpace(output)
+
+	if err := c.ctx.Executor.Run(ctx, "keyctl", "unlink", keyID, c.keyring); err != nil {
+		return fmt.Errorf("failed to remove key from keyring: %w", err)
+	}
+
+	c.ctx.Logger.Success("Passphrase %q removed from keyring %s", description, c.keyring)
+
+	return nil
+}
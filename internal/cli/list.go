@@ -1,7 +1,15 @@
 package cli
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/nace/brezno/internal/container"
 	"github.com/nace/brezno/internal/system"
@@ -11,8 +19,24 @@ import (
 
 // ListCommand handles listing containers
 type ListCommand struct {
-	ctx  *GlobalContext
-	json bool
+	ctx         *GlobalContext
+	json        bool
+	warnAbove   string
+	outputFile  string
+	scan        string
+	maxDepth    int
+	exclude     []string
+	format      string
+	legacyJSON  bool
+	managedOnly bool
+	watch       bool
+	interval    time.Duration
+}
+
+// listEntry is a container as reported by list, with an optional usage warning
+type listEntry struct {
+	container.Container
+	Warning string `json:"warning,omitempty"`
 }
 
 // NewListCommand creates the list command
@@ -22,68 +46,405 @@ func NewListCommand(ctx *GlobalContext) *cobra.Command {
 	cobraCmd := &cobra.Command{
 		Use:   "list",
 		Short: "List active encrypted containers",
-		Long:  `List all currently mounted LUKS encrypted containers.`,
-		RunE:  cmd.Run,
+		Long: `List all currently mounted LUKS encrypted containers.
+
+Listing works without root, with reduced detail: fields that could not be
+read are marked "?" (or listed under "unreadable" in JSON).
+
+With --scan, search a directory for LUKS container files instead, including
+ones that are not mounted.
+
+With --json --watch, print one JSON document per line every --interval
+until interrupted, for dashboards that tail the stream.`,
+		Example: `  # Stream the container list to a dashboard every 5 seconds
+  brezno list --json --watch --interval 5s | dashboard-ingest`,
+		RunE: cmd.Run,
 	}
 
 	cobraCmd.Flags().BoolVarP(&cmd.json, "json", "j", false, "JSON output")
+	cobraCmd.Flags().BoolVar(&cmd.legacyJSON, "legacy-json", false, "With --json, print the bare container array without the schema_version envelope")
+	cobraCmd.Flags().StringVarP(&cmd.outputFile, "output-file", "o", "", "Write JSON output to a file atomically instead of stdout (requires --json)")
+	cobraCmd.Flags().StringVar(&cmd.scan, "scan", "", "Scan a directory for LUKS container files, mounted or not")
+	cobraCmd.Flags().IntVar(&cmd.maxDepth, "max-depth", 3, "Maximum directory depth for --scan")
+	cobraCmd.Flags().StringVar(&cmd.format, "format", "", "Print each container with a Go template (e.g., '{{.MapperName}} {{.MountPoint}}')")
+	cobraCmd.Flags().BoolVar(&cmd.managedOnly, "managed-only", false, "Only show containers opened by brezno, hiding other crypt devices")
+	cobraCmd.Flags().StringArrayVar(&cmd.exclude, "exclude", nil, "Hide a container by path, mapper name, or mount point (repeatable)")
+	cobraCmd.Flags().BoolVar(&cmd.watch, "watch", false, "With --json, keep listing every --interval as newline-delimited JSON")
+	cobraCmd.Flags().DurationVar(&cmd.interval, "interval", 5*time.Second, "Time between listings with --watch")
+	cobraCmd.Flags().StringVar(&cmd.warnAbove, "warn-above", "", "Flag containers whose usage exceeds this percentage (e.g., 90%)")
 
 	return cobraCmd
 }
 
 // Run executes the list command
 func (c *ListCommand) Run(cmd *cobra.Command, args []string) error {
-	if err := system.RequireRoot(); err != nil {
-		return err
+	// Listing is read-only, so it degrades instead of requiring root
+	if !system.IsRoot() {
+		c.ctx.Logger.Warning("Not running as root, some details may be unavailable")
 	}
 
+	ctx := cmd.Context()
+
 	if err := c.ctx.CheckDependencies(); err != nil {
 		return err
 	}
 
-	// Discover active containers
-	containers, err := c.ctx.Discovery.DiscoverActive()
+	if c.outputFile != "" && !c.json {
+		return fmt.Errorf("--output-file requires --json")
+	}
+	if c.legacyJSON && !c.json {
+		return fmt.Errorf("--legacy-json requires --json")
+	}
+	if c.watch {
+		if !c.json {
+			return fmt.Errorf("--watch requires --json")
+		}
+		if c.outputFile != "" || c.scan != "" {
+			return fmt.Errorf("--watch cannot be used with --output-file or --scan")
+		}
+		if c.interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+	}
+
+	// Parse the template up front so bad syntax fails before discovery
+	var tmpl *template.Template
+	if c.format != "" {
+		if c.json {
+			return fmt.Errorf("--format cannot be used with --json")
+		}
+		var err error
+		if tmpl, err = parseFormat(c.format); err != nil {
+			return err
+		}
+	}
+
+	if c.scan != "" {
+		return c.runScan(ctx, tmpl)
+	}
+
+	threshold, err := parseThreshold(c.warnAbove)
+	if err != nil {
+		return err
+	}
+
+	if c.watch {
+		return c.runWatch(ctx, threshold)
+	}
+
+	entries, err := c.listEntries(ctx, threshold)
 	if err != nil {
-		return fmt.Errorf("failed to discover containers: %w", err)
+		return err
 	}
 
-	if len(containers) == 0 {
+	// In JSON and --format modes stdout carries only the requested output,
+	// so an empty result is an empty array (or nothing) rather than a message
+	if len(entries) == 0 && !c.json && tmpl == nil {
 		fmt.Println("No active containers found")
 		return nil
 	}
 
 	// Output based on format
+	if tmpl != nil {
+		for i := range entries {
+			if err := executeFormat(tmpl, &entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	if c.json {
-		return ui.PrintJSON(containers)
+		return c.printJSON(entries)
 	}
 
 	if c.ctx.Logger.Verbose {
-		c.printVerbose(containers)
+		c.printVerbose(entries)
 	} else {
-		c.printTable(containers)
+		c.printTable(entries)
 	}
 
 	return nil
 }
 
-func (c *ListCommand) printTable(containers []container.Container) {
-	table := ui.NewTable("CONTAINER", "MAPPER", "MOUNT POINT", "SIZE", "USED")
+// listEntries discovers the active containers to list, applying the
+// filters and the usage threshold
+func (c *ListCommand) listEntries(ctx context.Context, threshold float64) ([]listEntry, error) {
+	containers, err := c.ctx.Discovery.DiscoverActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover containers: %w", err)
+	}
+	containers = excludeContainers(containers, c.exclude)
+	c.ctx.Discovery.ReadTokens(ctx, containers)
+	if c.managedOnly {
+		containers = managedContainers(containers)
+	}
 
+	entries := make([]listEntry, len(containers))
+	for i, cont := range containers {
+		entries[i] = listEntry{Container: cont}
+		if exceedsThreshold(cont, threshold) {
+			entries[i].Warning = fmt.Sprintf("usage %.1f%% exceeds %.1f%%", cont.UsagePercent(), threshold)
+		}
+	}
+	return entries, nil
+}
+
+// runWatch prints the container list as one line of JSON per interval
+// until ctx is cancelled (e.g., by Ctrl-C), which ends it successfully
+func (c *ListCommand) runWatch(ctx context.Context, threshold float64) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := c.listEntries(ctx, threshold)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := ui.PrintJSONLine(ui.JSONOutput("containers", entries, c.legacyJSON)); err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runScan lists LUKS container files found under the scan directory
+func (c *ListCommand) runScan(ctx context.Context, tmpl *template.Template) error {
+	if c.maxDepth < 0 {
+		return fmt.Errorf("--max-depth must not be negative")
+	}
+
+	c.ctx.Logger.Debug("Scanning %s (max depth %d)", c.scan, c.maxDepth)
+	found, err := container.ScanDirectory(ctx, c.ctx.LUKSManager, c.scan, c.maxDepth)
+	if err != nil {
+		return err
+	}
+
+	// Mark containers that are currently open
+	active, err := c.ctx.Discovery.DiscoverActive(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover containers: %w", err)
+	}
+	for i := range found {
+		for _, cont := range active {
+			if cont.Path == found[i].Path {
+				found[i].Active = true
+			}
+		}
+	}
+
+	if len(c.exclude) > 0 {
+		kept := found[:0]
+		for _, f := range found {
+			if !isExcluded(container.Container{Path: f.Path}, c.exclude) {
+				kept = append(kept, f)
+			}
+		}
+		found = kept
+	}
+
+	if tmpl != nil {
+		for i := range found {
+			if err := executeFormat(tmpl, &found[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if c.json {
+		if found == nil {
+			found = []container.ScannedContainer{}
+		}
+		return c.printJSON(found)
+	}
+
+	if len(found) == 0 {
+		fmt.Printf("No LUKS containers found in %s\n", c.scan)
+		return nil
+	}
+
+	table := ui.NewTable("CONTAINER", "UUID", "SIZE", "STATUS")
+	for _, cont := range found {
+		status := "inactive"
+		if cont.Active {
+			status = "active"
+		}
+		uuid := cont.UUID
+		if uuid == "" {
+			uuid = "-"
+		}
+		table.AddRow(cont.Path, uuid, system.FormatSize(cont.Size), status)
+	}
+	table.Print()
+
+	return nil
+}
+
+// parseFormat parses a --format template
+func parseFormat(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// executeFormat prints one item with the --format template, followed by a
+// newline. Pass a pointer, so the template can call methods such as
+// {{.UsagePercent}}.
+func executeFormat(tmpl *template.Template, item interface{}) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, item); err != nil {
+		return fmt.Errorf("failed to apply --format template: %w", err)
+	}
+	buf.WriteByte('\n')
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// printJSON writes the containers as JSON to stdout or --output-file,
+// wrapped in the schema version envelope unless --legacy-json is set
+func (c *ListCommand) printJSON(containers interface{}) error {
+	v := ui.JSONOutput("containers", containers, c.legacyJSON)
+	if c.outputFile != "" {
+		return c.writeJSONFile(v)
+	}
+	return ui.PrintJSON(v)
+}
+
+// writeJSONFile encodes v before touching the output file, so a failure
+// leaves any previous contents intact
+func (c *ListCommand) writeJSONFile(v interface{}) error {
+	data, err := ui.FormatJSON(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	if err := system.WriteFileAtomic(c.outputFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	c.ctx.Logger.Debug("Wrote JSON output to %s", c.outputFile)
+	return nil
+}
+
+// managedContainers keeps only the containers brezno opened
+func managedContainers(containers []container.Container) []container.Container {
+	var kept []container.Container
 	for _, cont := range containers {
+		if cont.Managed {
+			kept = append(kept, cont)
+		}
+	}
+	return kept
+}
+
+// excludeContainers drops containers matching any of the excludes
+func excludeContainers(containers []container.Container, excludes []string) []container.Container {
+	if len(excludes) == 0 {
+		return containers
+	}
+
+	var kept []container.Container
+	for _, cont := range containers {
+		if !isExcluded(cont, excludes) {
+			kept = append(kept, cont)
+		}
+	}
+	return kept
+}
+
+// isExcluded reports whether a container's path, mapper name, or mount
+// point matches one of the excludes. Paths are compared in absolute form.
+func isExcluded(cont container.Container, excludes []string) bool {
+	for _, exclude := range excludes {
+		if exclude == "" {
+			continue
+		}
+		if exclude == cont.MapperName {
+			return true
+		}
+		abs, err := filepath.Abs(exclude)
+		if err != nil {
+			continue
+		}
+		if (cont.Path != "" && abs == cont.Path) || (cont.MountPoint != "" && abs == cont.MountPoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseThreshold parses a usage percentage such as "90%" or "90".
+// An empty string disables the check and returns 0.
+func parseThreshold(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	// Written so that NaN, which compares false with everything, fails too
+	value, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || !(value > 0 && value <= 100) {
+		return 0, fmt.Errorf("invalid usage threshold: %s (use a percentage between 0 and 100, e.g., 90%%)", s)
+	}
+
+	return value, nil
+}
+
+// exceedsThreshold reports whether a container's usage is strictly above
+// the threshold. A zero threshold or unknown size never exceeds.
+func exceedsThreshold(cont container.Container, threshold float64) bool {
+	if threshold == 0 || cont.Size == 0 {
+		return false
+	}
+	return cont.UsagePercent() > threshold
+}
+
+func (c *ListCommand) printTable(entries []listEntry) {
+	table := ui.NewTable("CONTAINER", "MAPPER", "MOUNT POINT", "SIZE", "USED")
+	table.NoColor = c.ctx.Logger.NoColor
+
+	for _, entry := range entries {
+		cont := entry.Container
 		size := "-"
 		used := "-"
 		if cont.Size > 0 {
 			size = system.FormatSize(cont.Size)
 			used = system.FormatSize(cont.Used)
+		} else if isUnreadable(cont, "size") {
+			size = "?"
+			used = "?"
 		}
 
 		mountPoint := cont.MountPoint
 		if mountPoint == "" {
 			mountPoint = "-"
+			if isUnreadable(cont, "mount point") {
+				mountPoint = "?"
+			}
+		}
+
+		path := cont.Path
+		if path == "" && isUnreadable(cont, "path") {
+			path = "?"
+		}
+
+		if entry.Warning != "" {
+			table.AddWarningRow(path, cont.MapperName, mountPoint, size, used+" !")
+			continue
 		}
 
 		table.AddRow(
-			cont.Path,
+			path,
 			cont.MapperName,
 			mountPoint,
 			size,
@@ -94,19 +455,34 @@ func (c *ListCommand) printTable(containers []container.Container) {
 	table.Print()
 }
 
-func (c *ListCommand) printVerbose(containers []container.Container) {
-	for i, cont := range containers {
+func (c *ListCommand) printVerbose(entries []listEntry) {
+	for i, entry := range entries {
+		cont := entry.Container
 		if i > 0 {
 			fmt.Println()
 		}
 
 		fmt.Printf("Container: %s\n", cont.Path)
 		fmt.Printf("  Mapper: %s\n", cont.MapperName)
+		if !cont.Managed {
+			fmt.Printf("  Managed: no (not opened by brezno)\n")
+		}
+		if cont.Created != nil {
+			fmt.Printf("  Created: %s\n", cont.Created.Local().Format(time.RFC3339))
+		}
 
 		if cont.MountPoint != "" {
 			fmt.Printf("  Mount Point: %s\n", cont.MountPoint)
 		}
 
+		if len(cont.MountOptions) > 0 {
+			fmt.Printf("  Mount Options: %s\n", strings.Join(cont.MountOptions, ","))
+		}
+
+		if cont.ReadOnly {
+			fmt.Printf("  Read-Only: yes\n")
+		}
+
 		if cont.LoopDevice != "" {
 			fmt.Printf("  Loop Device: %s\n", cont.LoopDevice)
 		}
@@ -115,20 +491,37 @@ func (c *ListCommand) printVerbose(containers []container.Container) {
 			fmt.Printf("  Filesystem: %s\n", cont.Filesystem)
 		}
 
+		if cont.OpenedSince != nil {
+			age := time.Since(*cont.OpenedSince).Truncate(time.Second)
+			fmt.Printf("  Opened Since: about %s (%s ago)\n", cont.OpenedSince.Format(time.RFC3339), age)
+		}
+
 		if cont.Size > 0 {
 			fmt.Printf("  Size: %s\n", system.FormatSize(cont.Size))
-			fmt.Printf("  Used: %s", system.FormatSize(cont.Used))
-			if cont.Size > 0 {
-				percentage := float64(cont.Used) / float64(cont.Size) * 100
-				fmt.Printf(" (%.1f%%)\n", percentage)
-			} else {
-				fmt.Println()
-			}
+			fmt.Printf("  Used: %s (%.1f%%)\n", system.FormatSize(cont.Used), cont.UsagePercent())
 
 			if cont.Size > cont.Used {
 				available := cont.Size - cont.Used
 				fmt.Printf("  Available: %s\n", system.FormatSize(available))
 			}
 		}
+
+		if len(cont.Unreadable) > 0 {
+			fmt.Printf("  Unreadable: %s\n", strings.Join(cont.Unreadable, ", "))
+		}
+
+		if entry.Warning != "" {
+			fmt.Printf("  Warning: %s\n", entry.Warning)
+		}
+	}
+}
+
+// isUnreadable reports whether discovery could not read the given field
+func isUnreadable(cont container.Container, field string) bool {
+	for _, f := range cont.Unreadable {
+		if f == field {
+			return true
+		}
 	}
+	return false
 }
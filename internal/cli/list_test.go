@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestListRunWatch(t *testing.T) {
+	if !system.IsRoot() {
+		t.Skip("discovery only goes through the executor as root")
+	}
+
+	exec := testutil.NewFakeExecutor().
+		On("dmsetup ls --target crypt", "brezno_x\t(253:0)\n", nil).
+		On("dmsetup table brezno_x", "0 2064384 crypt aes-xts-plain64 :64:logon:cryptsetup:x 0 7:0 32768\n", nil).
+		On("losetup -l -J", `{"loopdevices": [{"name": "/dev/loop0", "back-file": "/data/x.img"}]}`, nil)
+	ctx := newTestContext(exec, newFakeCryptSetup())
+
+	// An empty mount table, so nothing is mounted
+	proc := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(proc, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"self/mountinfo", "swaps"} {
+		if err := os.WriteFile(filepath.Join(proc, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx.Discovery.SetMountSource(container.ProcMountSource{Root: proc})
+
+	c := &ListCommand{ctx: ctx, json: true, watch: true, interval: 10 * time.Millisecond}
+	runCtx, cancel := context.WithTimeout(context.Background(), 45*time.Millisecond)
+	defer cancel()
+
+	var err error
+	out := captureStdout(t, func() { err = c.runWatch(runCtx, 0) })
+	if err != nil {
+		t.Fatalf("runWatch() = %v, want nil once cancelled", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("got %d documents, want at least 2: %q", len(lines), out)
+	}
+	for _, line := range lines {
+		var doc struct {
+			SchemaVersion int `json:"schema_version"`
+			Containers    []struct {
+				Path       string
+				MapperName string
+			} `json:"containers"`
+		}
+		if err := json.Unmarshal([]byte(line), &doc); err != nil {
+			t.Fatalf("line %q is not one JSON document: %v", line, err)
+		}
+		if doc.SchemaVersion != 1 || len(doc.Containers) != 1 ||
+			doc.Containers[0].Path != "/data/x.img" || doc.Containers[0].MapperName != "brezno_x" {
+			t.Errorf("document = %s", line)
+		}
+	}
+}
+
+func TestListRunWatchCancelled(t *testing.T) {
+	// Cancelled before the first tick, it prints one listing and stops
+	c := &ListCommand{
+		ctx:      newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()),
+		json:     true,
+		watch:    true,
+		interval: time.Hour,
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var err error
+	out := captureStdout(t, func() { err = c.runWatch(runCtx, 0) })
+	if err != nil {
+		t.Fatalf("runWatch() = %v", err)
+	}
+	if n := strings.Count(out, "\n"); n > 1 {
+		t.Errorf("printed %d documents after cancel: %q", n, out)
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"90%", 90, false},
+		{" 90 ", 90, false},
+		{"99.5%", 99.5, false},
+		{"100%", 100, false},
+		{"0.1", 0.1, false},
+		{"0%", 0, true},
+		{"-10%", 0, true},
+		{"100.1%", 0, true},
+		{"NaN%", 0, true},
+		{"nan", 0, true},
+		{"Inf%", 0, true},
+		{"-Inf", 0, true},
+		{"ninety%", 0, true},
+		{"%", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseThreshold(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseThreshold(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExceedsThreshold(t *testing.T) {
+	tests := []struct {
+		size, used uint64
+		threshold  float64
+		want       bool
+	}{
+		{1000, 901, 90, true},
+		{1000, 900, 90, false}, // Exactly at the threshold
+		{1000, 899, 90, false},
+		{1000, 1000, 100, false},
+		{1000, 1000, 99.9, true},
+		{1000, 1000, 0, false}, // No threshold
+		{0, 0, 90, false},      // Size unknown
+	}
+	for _, tt := range tests {
+		cont := container.Container{Size: tt.size, Used: tt.used}
+		if got := exceedsThreshold(cont, tt.threshold); got != tt.want {
+			t.Errorf("exceedsThreshold(%d of %d, %v) = %v, want %v", tt.used, tt.size, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestListWriteJSONFileEncodeFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, []byte("previous"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &ListCommand{ctx: newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()), json: true, outputFile: path}
+	// A channel cannot be encoded as JSON
+	if err := c.printJSON(make(chan int)); err == nil || !strings.Contains(err.Error(), "failed to encode JSON") {
+		t.Fatalf("printJSON = %v, want an encoding error", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("output file = %q after a failed encode", data)
+	}
+	if left, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*.tmp-*")); len(left) != 0 {
+		t.Errorf("temporary files left behind: %v", left)
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	// Relative excludes are taken from the working directory
+	dir := t.TempDir()
+	t.Chdir(dir)
+	secrets := filepath.Join(dir, "secrets.img")
+
+	mounted := container.Container{Path: secrets, MapperName: "secrets_img", MountPoint: "/mnt/secrets"}
+	open := container.Container{Path: filepath.Join(dir, "open.img"), MapperName: "open_img"}
+	unknown := container.Container{MapperName: "luks-1234"} // Backing file unreadable
+
+	tests := []struct {
+		name     string
+		cont     container.Container
+		excludes []string
+		want     bool
+	}{
+		{"no excludes", mounted, nil, false},
+		{"by path", mounted, []string{secrets}, true},
+		{"by relative path", mounted, []string{"secrets.img"}, true},
+		{"by unclean path", mounted, []string{dir + "//./secrets.img"}, true},
+		{"by mapper name", mounted, []string{"secrets_img"}, true},
+		{"by mount point", mounted, []string{"/mnt/secrets/"}, true},
+		{"by one of several", open, []string{secrets, "open_img"}, true},
+		{"other container", open, []string{secrets, "secrets_img", "/mnt/secrets"}, false},
+		{"unmounted is not at the cwd", open, []string{"."}, false},
+		{"path prefix", mounted, []string{filepath.Join(dir, "secrets")}, false},
+		{"unknown path", unknown, []string{secrets}, false},
+		{"unknown path by mapper", unknown, []string{"luks-1234"}, true},
+		{"empty exclude", unknown, []string{""}, false},
+		{"empty exclude of a scanned file", container.Container{Path: secrets}, []string{""}, false},
+	}
+	for _, tt := range tests {
+		if got := isExcluded(tt.cont, tt.excludes); got != tt.want {
+			t.Errorf("%s: isExcluded(%q) = %v, want %v", tt.name, tt.excludes, got, tt.want)
+		}
+	}
+}
+
+func TestExcludeContainers(t *testing.T) {
+	containers := []container.Container{
+		{Path: "/data/a.img", MapperName: "a_img", MountPoint: "/mnt/a"},
+		{Path: "/data/b.img", MapperName: "b_img"},
+		{Path: "/data/c.img", MapperName: "c_img", MountPoint: "/mnt/c"},
+	}
+	kept := excludeContainers(containers, []string{"/mnt/a", "c_img"})
+	if len(kept) != 1 || kept[0].Path != "/data/b.img" {
+		t.Errorf("kept %+v, want only b.img", kept)
+	}
+	if kept := excludeContainers(containers, nil); len(kept) != 3 {
+		t.Errorf("kept %d containers without excludes", len(kept))
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range []string{"{{.MapperName", "{{if .MountPoint}}mounted", "{{end}}", "{{nosuchfunc .Path}}"} {
+		if _, err := parseFormat(format); err == nil || !strings.Contains(err.Error(), "invalid --format template") {
+			t.Errorf("parseFormat(%q) = %v, want a syntax error", format, err)
+		}
+	}
+}
+
+func TestExecuteFormat(t *testing.T) {
+	entry := listEntry{Container: container.Container{
+		Path:       "/data/secrets.img",
+		MapperName: "secrets_img",
+		MountPoint: "/mnt/secrets",
+		Size:       1000,
+		Used:       250,
+	}}
+	scanned := container.ScannedContainer{Path: "/data/old.img", UUID: "1234", Active: false}
+
+	tests := []struct {
+		format string
+		item   interface{}
+		want   string
+	}{
+		{"{{.MapperName}} {{.MountPoint}}", &entry, "secrets_img /mnt/secrets\n"},
+		{`{{.Path}}{{if .Warning}} ({{.Warning}}){{end}}`, &entry, "/data/secrets.img\n"},
+		{`{{printf "%.0f%%" .UsagePercent}}`, &entry, "25%\n"},
+		{"{{.UUID}} active={{.Active}}", &scanned, "1234 active=false\n"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseFormat(tt.format)
+		if err != nil {
+			t.Fatalf("parseFormat(%q): %v", tt.format, err)
+		}
+		var execErr error
+		out := captureStdout(t, func() { execErr = executeFormat(tmpl, tt.item) })
+		if execErr != nil || out != tt.want {
+			t.Errorf("%q: printed %q, err %v; want %q", tt.format, out, execErr, tt.want)
+		}
+	}
+
+	// A missing field parses, but fails on the first container without
+	// printing part of a line
+	tmpl, err := parseFormat("{{.MapperName}} {{.Mountpoint}}")
+	if err != nil {
+		t.Fatalf("parseFormat: %v", err)
+	}
+	var execErr error
+	out := captureStdout(t, func() { execErr = executeFormat(tmpl, &entry) })
+	if execErr == nil || !strings.Contains(execErr.Error(), "Mountpoint") || out != "" {
+		t.Errorf("missing field: printed %q, err %v", out, execErr)
+	}
+}
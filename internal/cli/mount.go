@@ -1,8 +1,12 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/nace/brezno/internal/container"
 	"github.com/nace/brezno/internal/system"
@@ -12,27 +16,93 @@ import (
 
 // MountCommand handles container mounting
 type MountCommand struct {
-	ctx           *GlobalContext
-	keyfile       string
-	readonly      bool
-	passwordStdin bool
+	ctx            *GlobalContext
+	keyfile        string
+	keyDescription string
+	readonly       bool
+	passwordStdin  bool
+	keyfileStdin   bool
+	passwordFile   string
+	forceSystem    bool
+	allowContents  bool
+	createMount    bool
+	printDevice    bool
+	printMount     bool
+	loadModules    bool
+	openOnly       bool
+	attempts       int
+	uuid           string
+	label          string
+	searchDir      string
+	fsType         string
+	offset         string
+	report         string
+	printJSON      bool
+	tpm            bool
+	fido2          bool
+
+	// promptRetry asks for the passphrase again after a wrong one
+	promptRetry func() (container.AuthMethod, error)
 }
 
 // NewMountCommand creates the mount command
 func NewMountCommand(ctx *GlobalContext) *cobra.Command {
-	cmd := &MountCommand{ctx: ctx}
+	cmd := &MountCommand{ctx: ctx, promptRetry: promptPassword}
 
 	cobraCmd := &cobra.Command{
 		Use:   "mount <container-path> <mount-point>",
 		Short: "Mount an encrypted container",
-		Long:  `Open a LUKS encrypted container and mount its filesystem.`,
-		Args:  cobra.MaximumNArgs(2),
-		RunE:  cmd.Run,
+		Long: `Open a LUKS encrypted container and mount its filesystem.
+
+With --open-only, the container is opened but not mounted and the decrypted
+device (/dev/mapper/...) is printed, e.g. for fsck or LVM. Close it again
+with 'brezno unmount'.
+
+If the container is already open but not mounted, e.g. with --open-only,
+its decrypted device is mounted as is: no loop device is attached and no
+passphrase is asked for. A container that is already mounted is refused.
+
+With --uuid or --label, the container file is found by scanning --search-dir
+for a LUKS header with that UUID or label, and the only argument is the
+mount point.`,
+		Example: `  # Mount, prompting for the password
+  brezno mount /data/secrets.img /mnt/secrets
+
+  # Read-only, with a keyfile
+  brezno mount /data/secrets.img /mnt/secrets --readonly --keyfile ~/.keys/secret.key
+
+  # Automation: password from stdin
+  echo "$PW" | brezno mount /data/secrets.img /mnt/secrets --password-stdin
+
+  # Automation: key material piped from a secrets manager
+  vault kv get -field=key secret/disk | brezno mount /data/secrets.img /mnt/secrets --keyfile-stdin`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: cmd.Run,
 	}
 
 	cobraCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Keyfile path (if not set, will prompt for password)")
-	cobraCmd.Flags().BoolVarP(&cmd.readonly, "readonly", "r", false, "Mount as read-only")
+	cobraCmd.Flags().StringVar(&cmd.keyDescription, "key-description", "", "Read the passphrase from the kernel keyring entry with this description")
+	cobraCmd.Flags().BoolVarP(&cmd.readonly, "readonly", "r", false, "Mount as read-only (loop device, LUKS mapping, and filesystem)")
 	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+	cobraCmd.Flags().StringVar(&cmd.passwordFile, "password-file", "", "Read the password from the first line of this file")
+	cobraCmd.Flags().BoolVar(&cmd.keyfileStdin, "keyfile-stdin", false, "Read keyfile contents from stdin (e.g., piped from a secrets manager)")
+	cobraCmd.Flags().IntVar(&cmd.attempts, "password-attempts", 3, "Number of passphrase attempts when prompting interactively")
+	cobraCmd.Flags().BoolVar(&cmd.tpm, "tpm", false, "Unlock with the TPM2 (see 'brezno tpm enroll')")
+	cobraCmd.Flags().BoolVar(&cmd.fido2, "fido2", false, "Unlock with a FIDO2 security token (see 'brezno fido2 enroll')")
+	cobraCmd.Flags().StringVar(&cmd.uuid, "uuid", "", "Find the container by LUKS UUID instead of path")
+	cobraCmd.Flags().StringVar(&cmd.label, "label", "", "Find the container by LUKS label instead of path")
+	cobraCmd.Flags().StringVar(&cmd.searchDir, "search-dir", ".", "Directory to scan for --uuid/--label (up to 3 levels deep)")
+	cobraCmd.Flags().StringVarP(&cmd.fsType, "type", "t", "", "Filesystem type to mount as (default: detect)")
+	cobraCmd.Flags().StringVar(&cmd.offset, "offset", "", "Byte offset of the LUKS header within the file (e.g., 1M)")
+	cobraCmd.Flags().BoolVar(&cmd.forceSystem, "force-system-path", false, "Allow mounting over system directories such as /etc or /usr")
+	cobraCmd.Flags().BoolVar(&cmd.createMount, "create-mountpoint", true, "Create the mount point if it doesn't exist (=false requires an existing directory)")
+	cobraCmd.Flags().BoolVar(&cmd.allowContents, "allow-existing-mountpoint-contents", false, "Mount over a non-empty directory, hiding its contents until unmounted")
+	cobraCmd.Flags().BoolVar(&cmd.printDevice, "print-device", false, "Print the mapper device on stdout once mounted, even with --quiet")
+	cobraCmd.Flags().BoolVar(&cmd.printMount, "print-mountpoint", false, "Print the mount point on stdout once mounted, even with --quiet")
+	cobraCmd.Flags().BoolVar(&cmd.openOnly, "open-only", false, "Open the container without mounting it and print the mapper device")
+	cobraCmd.Flags().BoolVar(&cmd.loadModules, "load-modules", false, "Load missing kernel modules (dm_crypt, loop) with modprobe")
+	cobraCmd.Flags().BoolVar(&cmd.printJSON, "print-json", false, "On success, print a JSON object describing the result on stdout")
+	cobraCmd.Flags().StringVar(&cmd.report, "report", "", "Append a JSON record of the outcome to this file (JSONL)")
 
 	return cobraCmd
 }
@@ -43,62 +113,173 @@ func (c *MountCommand) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	ctx := cmd.Context()
+
 	if err := c.ctx.CheckDependencies(); err != nil {
 		return err
 	}
 
-	// Get container path
+	if err := c.ctx.CheckKernelModules(ctx, c.loadModules); err != nil {
+		return err
+	}
+
+	if c.printJSON && (c.printDevice || c.printMount) {
+		return fmt.Errorf("--print-json cannot be combined with --print-device or --print-mountpoint")
+	}
+
+	if c.attempts < 1 {
+		return fmt.Errorf("--password-attempts must be at least 1")
+	}
+
+	// With --uuid or --label the container path comes from a scan,
+	// leaving only the mount point as an argument
 	var containerPath string
-	if len(args) > 0 {
-		containerPath = args[0]
-	} else {
-		containerPath = ui.PromptString("Container file path")
+	if c.uuid != "" || c.label != "" {
+		if c.uuid != "" && c.label != "" {
+			return fmt.Errorf("--uuid and --label cannot be used together")
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("--uuid and --label replace the container path, pass only the mount point")
+		}
+		resolved, err := c.resolveByID(ctx)
+		if err != nil {
+			return err
+		}
+		containerPath = resolved
+		// Shift so args[1] is the mount point below
+		args = append([]string{containerPath}, args...)
+	}
+
+	if c.fsType != "" && !container.IsSupportedFilesystem(c.fsType) {
+		c.ctx.Logger.Warning("Filesystem type %s is not one brezno creates (ext4, xfs, btrfs), passing it to mount anyway", c.fsType)
 	}
 
-	// Convert to absolute path
+	if c.openOnly {
+		if len(args) > 1 {
+			return fmt.Errorf("--open-only does not take a mount point")
+		}
+		if c.readonly {
+			return fmt.Errorf("--readonly cannot be used with --open-only")
+		}
+		if c.fsType != "" {
+			return fmt.Errorf("--type cannot be used with --open-only")
+		}
+		if c.printMount || c.printJSON {
+			return fmt.Errorf("--print-mountpoint and --print-json cannot be used with --open-only")
+		}
+	}
+
+	// Get container path, unless already resolved from --uuid/--label
+	if containerPath == "" {
+		if len(args) > 0 {
+			containerPath = args[0]
+		} else {
+			containerPath = ui.PromptString("Container file path")
+		}
+	}
+
+	// Convert to absolute path and resolve symlinks
 	absPath, err := filepath.Abs(containerPath)
 	if err != nil {
 		return fmt.Errorf("invalid path: %w", err)
 	}
-	containerPath = absPath
+
+	// Resolve symlinks so the file checked is the file opened (security:
+	// prevent a symlink being swapped between the LUKS check and the open)
+	canonicalPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("container not found: %s", absPath)
+		}
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	containerPath = canonicalPath
+
+	// Hold the container lock for the whole operation
+	lock, err := lockContainer(containerPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
 	c.ctx.Logger.Debug("Resolved container path: %s", containerPath)
 
-	// Verify it's a LUKS container (will fail if file doesn't exist)
-	c.ctx.Logger.Debug("Checking if %s is a LUKS container", containerPath)
-	isLuks, err := c.ctx.LUKSManager.IsLUKS(containerPath)
+	// Open the container once and probe and attach it through the
+	// descriptor from here on, so the file cannot be swapped underneath
+	containerFile, err := os.Open(containerPath)
 	if err != nil {
-		return fmt.Errorf("failed to check LUKS format: %w", err)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("container not found: %s", containerPath)
+		}
+		return fmt.Errorf("failed to open container: %w", err)
 	}
-	if !isLuks {
-		return fmt.Errorf("not a LUKS container: %s", containerPath)
+	defer containerFile.Close()
+	if info, err := containerFile.Stat(); err != nil {
+		return fmt.Errorf("failed to stat container: %w", err)
+	} else if !info.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file: %s", containerPath)
+	}
+	// Verify it's a LUKS container
+	if err := c.checkLUKS(ctx, containerFile, containerPath); err != nil {
+		return err
 	}
 
-	// Check if already mounted
-	existing, err := c.ctx.Discovery.FindByPath(containerPath)
+	// Check if already open. An open but unmounted container is mounted
+	// from its mapper, but not opened a second time.
+	existing, err := c.ctx.Discovery.FindByPath(ctx, containerPath)
 	if err != nil {
 		return err
 	}
-	if existing != nil {
-		return fmt.Errorf("container already mounted at: %s", existing.MountPoint)
+	if existing != nil && c.openOnly {
+		return fmt.Errorf("container already open as /dev/mapper/%s", existing.MapperName)
 	}
 
-	// Get mount point
+	// Get mount point (not needed when only opening)
 	var mountPoint string
-	if len(args) > 1 {
-		mountPoint = args[1]
-	} else {
-		mountPoint = ui.PromptString("Mount point")
+	if !c.openOnly {
+		if len(args) > 1 {
+			mountPoint = args[1]
+		} else {
+			mountPoint = ui.PromptString("Mount point")
+		}
+
+		// Convert to absolute path and resolve symlinks, so the mount
+		// lands where the checks below looked
+		absMount, err := filepath.Abs(mountPoint)
+		if err != nil {
+			return fmt.Errorf("invalid mount point: %w", err)
+		}
+		mountPoint = system.ResolveExistingPath(absMount)
+		c.ctx.Logger.Debug("Resolved mount point: %s", mountPoint)
+
+		// A mistyped mount point must not hide a directory the system needs
+		if system.IsSystemPath(mountPoint) && !c.forceSystem {
+			return fmt.Errorf("refusing to mount over system directory %s (use --force-system-path if you really mean it)", mountPoint)
+		}
+
+		// Fail before asking for a passphrase, Mount checks again
+		if !c.createMount {
+			if err := container.CheckMountPoint(mountPoint); err != nil {
+				return fmt.Errorf("%w (--create-mountpoint=false)", err)
+			}
+		}
+
+		if err := c.checkMountPointContents(mountPoint); err != nil {
+			return err
+		}
 	}
 
-	// Convert to absolute path
-	absMount, err := filepath.Abs(mountPoint)
-	if err != nil {
-		return fmt.Errorf("invalid mount point: %w", err)
+	if existing != nil {
+		err := c.mountExisting(ctx, existing, mountPoint)
+		c.ctx.writeReport(ctx, c.report, c.printJSON, system.ReportRecord{
+			Operation:  "mount",
+			Path:       containerPath,
+			MountPoint: mountPoint,
+		}, err)
+		return err
 	}
-	mountPoint = absMount
 
 	// Get authentication method
-	auth, err := GetAuthMethod(c.keyfile, false, c.passwordStdin, "", "") // false = no password confirmation
+	auth, err := c.getAuth()
 	if err != nil {
 		return err
 	}
@@ -106,43 +287,249 @@ func (c *MountCommand) Run(cmd *cobra.Command, args []string) error {
 	if pwAuth, ok := auth.(*container.PasswordAuth); ok {
 		defer pwAuth.Password.Zeroize()
 	}
+	if keyAuth, ok := auth.(*container.StdinKeyAuth); ok {
+		defer keyAuth.Key.Zeroize()
+	}
 
 	// Execute mount
-	return c.execute(containerPath, mountPoint, auth)
+	err = c.execute(ctx, containerPath, containerFile, mountPoint, auth)
+	c.ctx.writeReport(ctx, c.report, c.printJSON, system.ReportRecord{
+		Operation:  "mount",
+		Path:       containerPath,
+		MountPoint: mountPoint,
+	}, err)
+	return err
+}
+
+// mountExisting mounts the mapper of a container that is open but not
+// mounted, e.g. one opened with --open-only. No loop device is attached and
+// no passphrase is needed. A mounted container is refused: discovery and
+// unmount only know one mount point per mapper, so a second mount would
+// keep unmount from closing the container.
+func (c *MountCommand) mountExisting(ctx context.Context, existing *container.Container, mountPoint string) error {
+	if existing.MountPoint != "" {
+		return fmt.Errorf("container already mounted at: %s", existing.MountPoint)
+	}
+
+	mapperDevice := "/dev/mapper/" + existing.MapperName
+	c.ctx.Logger.Info("Container is already open as %s, mounting it", mapperDevice)
+
+	if err := c.ctx.MountMgr.Mount(ctx, mapperDevice, mountPoint, c.mountOptions()); err != nil {
+		return err
+	}
+	c.ctx.Logger.Success("Container mounted at: %s", mountPoint)
+	c.printResult(mapperDevice, mountPoint)
+	return nil
+}
+
+// mountOptions returns how the container's filesystem is mounted
+func (c *MountCommand) mountOptions() container.MountOptions {
+	return container.MountOptions{
+		ReadOnly:          c.readonly,
+		FSType:            c.fsType,
+		RequireMountPoint: !c.createMount,
+	}
+}
+
+// checkMountPointContents refuses a non-empty mount point, whose files the
+// mount would hide, unless --allow-existing-mountpoint-contents is given
+func (c *MountCommand) checkMountPointContents(mountPoint string) error {
+	if !c.allowContents {
+		// One entry is enough to know
+		n, err := system.CountDirEntries(mountPoint, 1)
+		if err != nil {
+			return fmt.Errorf("failed to read mount point: %w", err)
+		}
+		if n > 0 {
+			return fmt.Errorf("mount point %s is not empty, mounting would hide its contents\n"+
+				"Use --allow-existing-mountpoint-contents to mount over it anyway", mountPoint)
+		}
+		return nil
+	}
+
+	n, err := system.CountDirEntries(mountPoint, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read mount point: %w", err)
+	}
+	if n > 0 {
+		c.ctx.Logger.Warning("Mounting over %s hides its %d existing entries until the container is unmounted", mountPoint, n)
+	}
+	return nil
 }
 
-func (c *MountCommand) execute(path, mountPoint string, auth container.AuthMethod) error {
+// checkLUKS verifies there is a LUKS header where the container will be
+// opened: at the start of the file, or at --offset. The open file is
+// probed, path is used in messages.
+func (c *MountCommand) checkLUKS(ctx context.Context, file *os.File, path string) error {
+	offset, err := c.offsetBytes()
+	if err != nil {
+		return err
+	}
+
+	if offset > 0 {
+		c.ctx.Logger.Debug("Checking for a LUKS header at offset %d of %s", offset, path)
+		isLuks, err := container.IsLUKSAt(file, offset)
+		if err != nil {
+			return fmt.Errorf("failed to check LUKS format: %w", err)
+		}
+		if !isLuks {
+			return fmt.Errorf("no LUKS header at offset %s in %s", c.offset, path)
+		}
+		return nil
+	}
+
+	c.ctx.Logger.Debug("Checking if %s is a LUKS container", path)
+	isLuks, err := c.ctx.LUKSManager.IsLUKSFd(ctx, file)
+	if err != nil {
+		return fmt.Errorf("failed to check LUKS format: %w", err)
+	}
+	if !isLuks {
+		return fmt.Errorf("not a LUKS container: %s\n"+
+			"If the LUKS header is not at the start of the file, pass its position with --offset", path)
+	}
+	return nil
+}
+
+// offsetBytes parses --offset, 0 if unset
+func (c *MountCommand) offsetBytes() (uint64, error) {
+	if c.offset == "" {
+		return 0, nil
+	}
+	offset, err := system.ParseSize(c.offset)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --offset: %w", err)
+	}
+	return offset, nil
+}
+
+// resolveByID finds the container file whose LUKS header has the
+// requested UUID or label by scanning the search directory
+func (c *MountCommand) resolveByID(ctx context.Context) (string, error) {
+	// No header can hold a longer label, fail before scanning
+	if err := container.ValidateLabel("", c.label); err != nil {
+		return "", err
+	}
+
+	c.ctx.Logger.Debug("Scanning %s for uuid=%q label=%q", c.searchDir, c.uuid, c.label)
+	found, err := container.ScanDirectory(ctx, c.ctx.LUKSManager, c.searchDir, 3)
+	if err != nil {
+		return "", err
+	}
+
+	id := "UUID " + c.uuid
+	if c.label != "" {
+		id = "label " + c.label
+	}
+
+	matches := container.MatchScanned(found, c.uuid, c.label)
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no container with %s found in %s (use --search-dir)", id, c.searchDir)
+	case 1:
+		c.ctx.Logger.Info("Found container with %s: %s", id, matches[0].Path)
+		return matches[0].Path, nil
+	default:
+		paths := make([]string, len(matches))
+		for i, m := range matches {
+			paths[i] = m.Path
+		}
+		return "", fmt.Errorf("multiple containers with %s found:\n  %s", id, strings.Join(paths, "\n  "))
+	}
+}
+
+// getAuth returns the authentication method selected by the flags
+func (c *MountCommand) getAuth() (container.AuthMethod, error) {
+	if c.tpm || c.fido2 {
+		if c.keyfile != "" || c.keyDescription != "" || c.passwordStdin || c.keyfileStdin || c.passwordFile != "" {
+			return nil, fmt.Errorf("--tpm and --fido2 cannot be combined with other authentication flags")
+		}
+		return c.ctx.GetTokenAuth(c.tpm, c.fido2)
+	}
+
+	if c.keyfileStdin {
+		if c.passwordFile != "" {
+			return nil, fmt.Errorf("--keyfile-stdin and --password-file cannot be used together")
+		}
+		return GetKeyfileStdinAuth(c.keyfile, c.keyDescription, c.passwordStdin)
+	}
+
+	if c.passwordFile != "" {
+		return GetPasswordFileAuth(c.passwordFile, c.keyfile, c.keyDescription, c.passwordStdin)
+	}
+
+	return GetAuthMethod(c.keyfile, c.keyDescription, false, c.passwordStdin, "", "") // false = no password confirmation
+}
+
+// execute attaches the already open container file and mounts it. path
+// names it for mapper naming and messages.
+func (c *MountCommand) execute(ctx context.Context, path string, file *os.File, mountPoint string, auth container.AuthMethod) error {
+	// Cleanup must still run after ctx is cancelled (e.g., by Ctrl-C)
+	cleanupCtx := context.WithoutCancel(ctx)
 	cleanup := system.NewCleanupStack()
+	cleanup.SetLogger(c.ctx.Logger)
 	defer func() {
+		// Failed steps are logged individually by the stack
 		if err := cleanup.Execute(); err != nil {
-			c.ctx.Logger.Warning("Cleanup errors occurred: %v", err)
+			c.ctx.Logger.Warning("Some resources may need to be removed manually")
 		}
 	}()
 
-	// Step 1: Attach loop device
+	// Step 1: Attach loop device. This happens once: passphrase retries in
+	// open reuse the device, and cleanup detaches it only if all attempts fail.
+	mapperName := container.GenerateMapperName(path)
 	c.ctx.Logger.Info("Setting up loop device...")
-	loopDev, err := c.ctx.LoopManager.Attach(path)
+	offset, err := c.offsetBytes()
+	if err != nil {
+		return err
+	}
+	loopDev, err := c.ctx.LoopManager.AttachFd(ctx, file, container.AttachOptions{
+		ReadOnly: c.readonly,
+		Offset:   offset,
+	})
 	if err != nil {
 		return err
 	}
-	cleanup.Add(func() error {
-		return c.ctx.LoopManager.Detach(loopDev)
+	cleanup.Add("detach "+loopDev, func() error {
+		return c.ctx.detachLoop(cleanupCtx, loopDev, mapperName)
 	})
 
+	// A read-only mount is read-only at every layer: make sure the
+	// kernel honoured losetup -r before going further
+	if c.readonly {
+		ro, err := container.IsReadOnly(loopDev)
+		if err != nil {
+			return err
+		}
+		if !ro {
+			return fmt.Errorf("loop device %s was not attached read-only", loopDev)
+		}
+	}
+
 	// Step 2: Open LUKS container
-	mapperName := container.GenerateMapperName(path)
 	c.ctx.Logger.Info("Opening LUKS container...")
-	if err := c.ctx.LUKSManager.Open(loopDev, mapperName, auth); err != nil {
+	if _, ok := auth.(*container.FIDO2Auth); ok {
+		c.ctx.Logger.Info("Touch your security token to unlock")
+	}
+	if err := c.open(ctx, loopDev, mapperName, auth); err != nil {
 		return err
 	}
-	cleanup.Add(func() error {
-		return c.ctx.LUKSManager.Close(mapperName)
+	cleanup.Add("close "+mapperName, func() error {
+		return c.ctx.closeMapper(cleanupCtx, mapperName)
 	})
 
-	// Step 3: Mount filesystem
 	mapperDevice := "/dev/mapper/" + mapperName
+	if c.openOnly {
+		cleanup.Clear()
+		c.ctx.Logger.Success("Container opened without mounting")
+		c.ctx.Logger.Info("Close it with: brezno unmount %s", path)
+		// Print the device on stdout so scripts can capture it
+		fmt.Println(mapperDevice)
+		return nil
+	}
+
+	// Step 3: Mount filesystem
 	c.ctx.Logger.Info("Mounting filesystem...")
-	if err := c.ctx.MountMgr.Mount(mapperDevice, mountPoint, c.readonly); err != nil {
+	if err := c.ctx.MountMgr.Mount(ctx, mapperDevice, mountPoint, c.mountOptions()); err != nil {
 		return err
 	}
 
@@ -150,6 +537,74 @@ func (c *MountCommand) execute(path, mountPoint string, auth container.AuthMetho
 	cleanup.Clear()
 
 	c.ctx.Logger.Success("Container mounted at: %s", mountPoint)
+	c.printResult(mapperDevice, mountPoint)
 
 	return nil
 }
+
+// printResult prints what --print-device and --print-mountpoint ask for
+// on stdout, one value per line. Unlike log messages these are meant for
+// scripts, so --quiet does not suppress them.
+func (c *MountCommand) printResult(mapperDevice, mountPoint string) {
+	if c.printDevice {
+		fmt.Println(mapperDevice)
+	}
+	if c.printMount {
+		fmt.Println(mountPoint)
+	}
+}
+
+// open opens the LUKS container on loopDev. A mistyped interactive
+// passphrase is prompted for again, up to --password-attempts times.
+// Other failures (or other authentication methods) are not retried.
+func (c *MountCommand) open(ctx context.Context, loopDev, mapperName string, auth container.AuthMethod) error {
+	attempts := 1
+	if _, ok := auth.(*container.PasswordAuth); ok && !c.passwordStdin && c.passwordFile == "" {
+		attempts = c.attempts
+	}
+
+	// The caller zeroizes the first passphrase. Retried ones are zeroized
+	// here as soon as they are rejected.
+	var retried *container.PasswordAuth
+	defer func() {
+		if retried != nil {
+			retried.Password.Zeroize()
+		}
+	}()
+
+	for attempt := 1; ; attempt++ {
+		err := c.ctx.LUKSManager.Open(ctx, loopDev, mapperName, auth, c.readonly)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, container.ErrWrongPassphrase) {
+			return err
+		}
+		if attempt >= attempts {
+			if attempts > 1 {
+				err = fmt.Errorf("wrong passphrase, giving up after %d attempts: %w", attempts, container.ErrWrongPassphrase)
+			}
+			return &ExitCodeError{Code: ExitWrongPassphrase, Err: err}
+		}
+
+		c.ctx.Logger.Warning("Wrong passphrase, %d attempt(s) left", attempts-attempt)
+		if retried != nil {
+			retried.Password.Zeroize()
+		}
+		next, err := c.promptRetry()
+		if err != nil {
+			return err
+		}
+		pwAuth, ok := next.(*container.PasswordAuth)
+		if !ok {
+			return fmt.Errorf("expected a passphrase, got %T", next)
+		}
+		retried, auth = pwAuth, pwAuth
+		c.ctx.Logger.Debug("Retrying luksOpen on %s (attempt %d of %d)", loopDev, attempt+1, attempts)
+	}
+}
+
+// promptPassword prompts for the container passphrase
+func promptPassword() (container.AuthMethod, error) {
+	return GetAuthMethod("", "", false, false, "", "")
+}
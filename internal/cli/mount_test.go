@@ -0,0 +1,679 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+	"github.com/spf13/cobra"
+)
+
+// testLoopDevice is what the fake losetup attaches. No such device exists,
+// so cleanup never finds it attached.
+const testLoopDevice = "/dev/loopfake0"
+
+// openTestContainer creates an empty container file and opens it
+func openTestContainer(t *testing.T) (string, *os.File) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secrets.img")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return path, file
+}
+
+func TestMountExecute(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := newFakeCryptSetup()
+	c := &MountCommand{ctx: newTestContext(exec, luks), attempts: 1, createMount: true}
+
+	path, file := openTestContainer(t)
+	mountPoint := t.TempDir()
+	if err := c.execute(context.Background(), path, file, mountPoint, &container.KeyfileAuth{KeyfilePath: "/k"}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	attach, ok := exec.Ran("losetup -f --show")
+	if !ok || attach.ExtraFiles != 1 {
+		t.Errorf("losetup was not given the open container: %v", exec.Lines())
+	}
+	mapperName := container.GenerateMapperName(path)
+	if !luks.called(fmt.Sprintf("Open %s %s false", testLoopDevice, mapperName)) {
+		t.Errorf("LUKS calls = %v", luks.Calls())
+	}
+	if _, ok := exec.Ran(fmt.Sprintf("mount /dev/mapper/%s %s", mapperName, mountPoint)); !ok {
+		t.Errorf("filesystem not mounted: %v", exec.Lines())
+	}
+}
+
+func TestMountExecuteWrongPassphrase(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := newFakeCryptSetup()
+	luks.errs["Open"] = fmt.Errorf("failed to open LUKS container: %w", container.ErrWrongPassphrase)
+	c := &MountCommand{ctx: newTestContext(exec, luks), attempts: 1, createMount: true}
+
+	path, file := openTestContainer(t)
+	err := c.execute(context.Background(), path, file, t.TempDir(), &container.KeyfileAuth{KeyfilePath: "/k"})
+	if !errors.Is(err, container.ErrWrongPassphrase) || ExitCode(err) != ExitWrongPassphrase {
+		t.Fatalf("execute = %v (exit code %d), want a wrong passphrase", err, ExitCode(err))
+	}
+	if _, ok := exec.Ran("mount "); ok {
+		t.Errorf("mounted despite the wrong passphrase: %v", exec.Lines())
+	}
+}
+
+// passwordAuth returns password authentication with the given passphrase
+func passwordAuth(s string) *container.PasswordAuth {
+	return &container.PasswordAuth{Password: system.NewSecureBytes([]byte(s))}
+}
+
+// retryPrompts makes c answer retry prompts with fresh passphrases, and
+// returns the ones handed out
+func retryPrompts(c *MountCommand) *[]*container.PasswordAuth {
+	var prompted []*container.PasswordAuth
+	c.promptRetry = func() (container.AuthMethod, error) {
+		auth := passwordAuth("retry")
+		prompted = append(prompted, auth)
+		return auth, nil
+	}
+	return &prompted
+}
+
+func TestMountOpenExhaustsAttempts(t *testing.T) {
+	luks := newFakeCryptSetup()
+	luks.errs["Open"] = fmt.Errorf("failed to open LUKS container: %w", container.ErrWrongPassphrase)
+	c := &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), attempts: 3}
+	prompted := retryPrompts(c)
+
+	err := c.open(context.Background(), testLoopDevice, "m", passwordAuth("first"))
+	if err == nil || !strings.Contains(err.Error(), "giving up after 3 attempts") {
+		t.Fatalf("open = %v, want giving up after 3 attempts", err)
+	}
+	if !errors.Is(err, container.ErrWrongPassphrase) || ExitCode(err) != ExitWrongPassphrase {
+		t.Errorf("open = %v (exit code %d), want a wrong passphrase", err, ExitCode(err))
+	}
+	if opens := len(luks.Calls()); opens != 3 {
+		t.Errorf("Open called %d times, want 3", opens)
+	}
+	if len(*prompted) != 2 {
+		t.Fatalf("prompted %d times, want 2", len(*prompted))
+	}
+	for i, auth := range *prompted {
+		if auth.Password.Len() != 0 {
+			t.Errorf("rejected passphrase %d was not zeroized", i+1)
+		}
+	}
+}
+
+func TestMountOpenDoesNotRetryOtherErrors(t *testing.T) {
+	luks := newFakeCryptSetup()
+	luks.errs["Open"] = errors.New("device not found")
+	c := &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), attempts: 3}
+	prompted := retryPrompts(c)
+
+	if err := c.open(context.Background(), testLoopDevice, "m", passwordAuth("first")); err == nil {
+		t.Fatal("open succeeded")
+	}
+	if len(*prompted) != 0 || len(luks.Calls()) != 1 {
+		t.Errorf("retried a non-passphrase failure: %v", luks.Calls())
+	}
+}
+
+func TestMountExecuteAttachesOnceAcrossRetries(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := newFakeCryptSetup()
+	luks.errs["Open"] = fmt.Errorf("failed to open LUKS container: %w", container.ErrWrongPassphrase)
+	luks.failTimes["Open"] = 2
+	c := &MountCommand{ctx: newTestContext(exec, luks), attempts: 3, createMount: true}
+	retryPrompts(c)
+
+	path, file := openTestContainer(t)
+	if err := c.execute(context.Background(), path, file, t.TempDir(), passwordAuth("first")); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	attaches := 0
+	for _, line := range exec.Lines() {
+		if strings.HasPrefix(line, "losetup -f") {
+			attaches++
+		}
+	}
+	if attaches != 1 {
+		t.Errorf("loop device attached %d times, want once: %v", attaches, exec.Lines())
+	}
+	if opens := len(luks.Calls()); opens != 3 {
+		t.Errorf("Open called %d times, want 3", opens)
+	}
+	if _, ok := exec.Ran("mount /dev/mapper/"); !ok {
+		t.Errorf("not mounted after the third attempt: %v", exec.Lines())
+	}
+}
+
+func TestMountExistingOpenContainer(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	luks := newFakeCryptSetup()
+	c := &MountCommand{ctx: newTestContext(exec, luks), createMount: true}
+
+	// As discovery reports a container opened with --open-only
+	existing := &container.Container{
+		Path:       "/data/secrets.img",
+		MapperName: "brezno_secrets",
+		LoopDevice: "/dev/loop0",
+		IsActive:   true,
+	}
+	mountPoint := t.TempDir()
+	if err := c.mountExisting(context.Background(), existing, mountPoint); err != nil {
+		t.Fatalf("mountExisting: %v", err)
+	}
+
+	if _, ok := exec.Ran("mount /dev/mapper/brezno_secrets " + mountPoint); !ok {
+		t.Errorf("existing mapper not mounted: %v", exec.Lines())
+	}
+	if _, ok := exec.Ran("losetup"); ok {
+		t.Errorf("attached a loop device for an open container: %v", exec.Lines())
+	}
+	if calls := luks.Calls(); len(calls) != 0 {
+		t.Errorf("LUKS calls for an open container: %v", calls)
+	}
+}
+
+func TestMountExistingRefusesMountedContainer(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	c := &MountCommand{ctx: newTestContext(exec, newFakeCryptSetup()), createMount: true}
+
+	existing := &container.Container{
+		Path:       "/data/secrets.img",
+		MapperName: "brezno_secrets",
+		MountPoint: "/mnt/secrets",
+		IsActive:   true,
+	}
+	err := c.mountExisting(context.Background(), existing, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "already mounted at: /mnt/secrets") {
+		t.Fatalf("mountExisting = %v, want already mounted", err)
+	}
+	if lines := exec.Lines(); len(lines) != 0 {
+		t.Errorf("ran commands for a mounted container: %v", lines)
+	}
+}
+
+// scanTestContainers creates sparse container files in a new directory,
+// labelled as given, and reports them as LUKS through luks
+func scanTestContainers(t *testing.T, luks *fakeCryptSetup, labels map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, label := range labels {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, 2<<20); err != nil {
+			t.Fatal(err)
+		}
+		luks.luks[path] = true
+		luks.labels[path] = label
+	}
+	return dir
+}
+
+func TestMountResolveByLabel(t *testing.T) {
+	luks := newFakeCryptSetup()
+	dir := scanTestContainers(t, luks, map[string]string{
+		"a.img": "secrets",
+		"b.img": "backup",
+		"c.img": "backup",
+	})
+	c := &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), searchDir: dir}
+
+	c.label = "secrets"
+	path, err := c.resolveByID(context.Background())
+	if err != nil || path != filepath.Join(dir, "a.img") {
+		t.Errorf("resolveByID(secrets) = %q, %v", path, err)
+	}
+
+	c.label = "backup"
+	if _, err := c.resolveByID(context.Background()); err == nil || !strings.Contains(err.Error(), "multiple containers") {
+		t.Errorf("resolveByID(backup) = %v, want multiple containers", err)
+	}
+
+	c.label = "missing"
+	if _, err := c.resolveByID(context.Background()); err == nil || !strings.Contains(err.Error(), "no container with label missing") {
+		t.Errorf("resolveByID(missing) = %v, want none found", err)
+	}
+}
+
+func TestMountResolveByLabelTooLong(t *testing.T) {
+	luks := newFakeCryptSetup()
+	dir := scanTestContainers(t, luks, map[string]string{"a.img": "secrets"})
+	c := &MountCommand{
+		ctx:       newTestContext(testutil.NewFakeExecutor(), luks),
+		searchDir: dir,
+		label:     strings.Repeat("x", container.MaxLUKSLabelLength+1),
+	}
+
+	if _, err := c.resolveByID(context.Background()); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Fatalf("resolveByID = %v, want the label refused", err)
+	}
+	if calls := luks.Calls(); len(calls) != 0 {
+		t.Errorf("scanned for a label no header can hold: %v", calls)
+	}
+}
+
+// luksHeader is the start of a LUKS2 header, enough for the magic checks
+var luksHeader = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe, 0, 2}
+
+// swapAfterOpen writes a container with a LUKS header at offset, opens it,
+// and then replaces the path with a file without one, as an attacker
+// racing mount would
+func swapAfterOpen(t *testing.T, offset int64) (string, *os.File) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets.img")
+	data := make([]byte, offset+4096)
+	copy(data[offset:], luksHeader)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	decoy := filepath.Join(dir, "decoy.img")
+	if err := os.WriteFile(decoy, make([]byte, len(data)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(decoy, path); err != nil {
+		t.Fatal(err)
+	}
+	return path, file
+}
+
+// TestMountProbesOpenFile documents mount's fd-based flow: the container
+// is opened once and everything after that goes through the descriptor,
+// so replacing the path afterwards changes nothing
+func TestMountProbesOpenFile(t *testing.T) {
+	// With --offset, the header magic is read from the open file
+	path, file := swapAfterOpen(t, 8192)
+	c := &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()), offset: "8K"}
+	if err := c.checkLUKS(context.Background(), file, path); err != nil {
+		t.Errorf("checkLUKS at an offset read the replaced file: %v", err)
+	}
+
+	// Without, cryptsetup is handed the open file, never the path
+	path, file = swapAfterOpen(t, 0)
+	luks := newFakeCryptSetup()
+	luks.luks[file.Name()] = true
+	c = &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks)}
+	if err := c.checkLUKS(context.Background(), file, path); err != nil {
+		t.Errorf("checkLUKS: %v", err)
+	}
+	if calls := luks.Calls(); len(calls) != 1 || !strings.HasPrefix(calls[0], "IsLUKSFd ") {
+		t.Errorf("LUKS probe went by path: %v", calls)
+	}
+
+	// The loop device is attached through the same descriptor
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	c = &MountCommand{ctx: newTestContext(exec, newFakeCryptSetup()), attempts: 1, createMount: true}
+	if err := c.execute(context.Background(), path, file, t.TempDir(), &container.KeyfileAuth{KeyfilePath: "/k"}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	attach, ok := exec.Ran("losetup -f --show")
+	if !ok || attach.ExtraFiles != 1 || attach.Args[len(attach.Args)-1] != "/proc/self/fd/3" {
+		t.Errorf("losetup was not given the open container: %+v", attach)
+	}
+}
+
+func TestCheckMountPointContents(t *testing.T) {
+	empty := t.TempDir()
+	full := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(full, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := filepath.Join(empty, "new")
+
+	tests := []struct {
+		name          string
+		mountPoint    string
+		allowContents bool
+		wantErr       bool
+		wantWarning   string
+	}{
+		{name: "empty", mountPoint: empty},
+		{name: "missing", mountPoint: missing},
+		{name: "not empty", mountPoint: full, wantErr: true},
+		{name: "empty, allowed", mountPoint: empty, allowContents: true},
+		{name: "not empty, allowed", mountPoint: full, allowContents: true, wantWarning: "hides its 3 existing entries"},
+	}
+	for _, tt := range tests {
+		ctx := newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup())
+		c := &MountCommand{ctx: ctx, allowContents: tt.allowContents}
+
+		err := c.checkMountPointContents(tt.mountPoint)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "--allow-existing-mountpoint-contents") {
+				t.Errorf("%s: checkMountPointContents() = %v, want a refusal", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: checkMountPointContents() = %v", tt.name, err)
+		}
+
+		warnings := ctx.Warnings.List()
+		if tt.wantWarning == "" && len(warnings) > 0 {
+			t.Errorf("%s: warned %q", tt.name, warnings)
+		}
+		if tt.wantWarning != "" && (len(warnings) != 1 || !strings.Contains(warnings[0], tt.wantWarning)) {
+			t.Errorf("%s: warnings %q, want %q", tt.name, warnings, tt.wantWarning)
+		}
+	}
+}
+
+func TestMountPrintResult(t *testing.T) {
+	tests := []struct {
+		printDevice, printMount bool
+		want                    string
+	}{
+		{false, false, ""},
+		{true, false, "/dev/mapper/brezno_x\n"},
+		{false, true, "/mnt/x\n"},
+		{true, true, "/dev/mapper/brezno_x\n/mnt/x\n"},
+	}
+	for _, tt := range tests {
+		c := &MountCommand{printDevice: tt.printDevice, printMount: tt.printMount}
+		if got := captureStdout(t, func() { c.printResult("/dev/mapper/brezno_x", "/mnt/x") }); got != tt.want {
+			t.Errorf("device %v, mount point %v: printed %q, want %q", tt.printDevice, tt.printMount, got, tt.want)
+		}
+	}
+}
+
+func TestMountExecuteQuietStdout(t *testing.T) {
+	// The test context logs quietly, so stdout holds only the results
+	tests := []struct {
+		name string
+		c    MountCommand
+		want func(device, mountPoint string) string
+	}{
+		{"plain", MountCommand{}, func(string, string) string { return "" }},
+		{"print both", MountCommand{printDevice: true, printMount: true}, func(d, m string) string { return d + "\n" + m + "\n" }},
+		{"open only", MountCommand{openOnly: true}, func(d, _ string) string { return d + "\n" }},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+		c := tt.c
+		c.ctx = newTestContext(exec, newFakeCryptSetup())
+		c.attempts = 1
+		c.createMount = true
+
+		path, file := openTestContainer(t)
+		mountPoint := t.TempDir()
+		var err error
+		out := captureStdout(t, func() {
+			err = c.execute(context.Background(), path, file, mountPoint, &container.KeyfileAuth{KeyfilePath: "/k"})
+		})
+		if err != nil {
+			t.Fatalf("%s: execute: %v", tt.name, err)
+		}
+		device := "/dev/mapper/" + container.GenerateMapperName(path)
+		if want := tt.want(device, mountPoint); out != want {
+			t.Errorf("%s: stdout %q, want %q", tt.name, out, want)
+		}
+	}
+}
+
+func TestMountExecuteOpenOnly(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := newFakeCryptSetup()
+	withDevices(t, luks)
+	c := &MountCommand{ctx: newTestContext(exec, luks), attempts: 1, openOnly: true}
+
+	path, file := openTestContainer(t)
+	mapperName := container.GenerateMapperName(path)
+	var err error
+	out := captureStdout(t, func() {
+		err = c.execute(context.Background(), path, file, "", &container.KeyfileAuth{KeyfilePath: "/k"})
+	})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	if out != "/dev/mapper/"+mapperName+"\n" {
+		t.Errorf("stdout %q, want the mapper device", out)
+	}
+	if !reflect.DeepEqual(luks.Calls(), []string{"Open " + testLoopDevice + " " + mapperName + " false"}) {
+		t.Errorf("LUKS calls = %v, want only the open", luks.Calls())
+	}
+	// The loop device stays attached and nothing is mounted
+	if lines := exec.Lines(); len(lines) != 1 || !strings.HasPrefix(lines[0], "losetup -f --show") {
+		t.Errorf("ran %v, want only the loop attach", lines)
+	}
+}
+
+func TestMountExecuteType(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	c := &MountCommand{ctx: newTestContext(exec, newFakeCryptSetup()), attempts: 1, createMount: true, fsType: "btrfs"}
+
+	path, file := openTestContainer(t)
+	mountPoint := t.TempDir()
+	if err := c.execute(context.Background(), path, file, mountPoint, &container.KeyfileAuth{KeyfilePath: "/k"}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	want := "mount -t btrfs /dev/mapper/" + container.GenerateMapperName(path) + " " + mountPoint
+	if mount, ok := exec.Ran("mount "); !ok || mount.Line() != want {
+		t.Errorf("ran %v, want %q", exec.Lines(), want)
+	}
+}
+
+func TestMountOffset(t *testing.T) {
+	// The header is 1 MiB in, so only --offset 1M finds it
+	path, file := swapAfterOpen(t, 1<<20)
+	for _, tt := range []struct {
+		offset string
+		want   string
+	}{
+		{"1M", ""},
+		{"1048576", ""},
+		{"512K", "no LUKS header at offset 512K"},
+		{"2M", "no LUKS header at offset 2M"},
+		{"lots", "invalid --offset"},
+	} {
+		c := &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()), offset: tt.offset}
+		err := c.checkLUKS(context.Background(), file, path)
+		if (tt.want == "" && err != nil) || (tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want))) {
+			t.Errorf("--offset %s: checkLUKS = %v, want %q", tt.offset, err, tt.want)
+		}
+	}
+
+	// Without --offset, cryptsetup probes the start and the error says
+	// where to look
+	luks := newFakeCryptSetup()
+	c := &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks)}
+	if err := c.checkLUKS(context.Background(), file, path); err == nil || !strings.Contains(err.Error(), "pass its position with --offset") {
+		t.Errorf("checkLUKS without --offset = %v", err)
+	}
+
+	// The loop device starts at the header
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	c = &MountCommand{ctx: newTestContext(exec, newFakeCryptSetup()), attempts: 1, createMount: true, offset: "1M"}
+	if err := c.execute(context.Background(), path, file, t.TempDir(), &container.KeyfileAuth{KeyfilePath: "/k"}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if attach, ok := exec.Ran("losetup -f --show"); !ok || attach.Line() != "losetup -f --show -o 1048576 /proc/self/fd/3" {
+		t.Errorf("ran %v, want the loop device attached at the offset", exec.Lines())
+	}
+}
+
+func TestMountExecuteReadOnlyLoop(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := newFakeCryptSetup()
+	c := &MountCommand{ctx: newTestContext(exec, luks), attempts: 1, createMount: true, readonly: true}
+
+	path, file := openTestContainer(t)
+	err := c.execute(context.Background(), path, file, t.TempDir(), &container.KeyfileAuth{KeyfilePath: "/k"})
+
+	attach, ok := exec.Ran("losetup -f --show")
+	if !ok || attach.Line() != "losetup -f --show -r /proc/self/fd/3" {
+		t.Errorf("losetup ran as %q, want it given -r", attach.Line())
+	}
+
+	// The fake loop device has no sysfs entry, so its read-only flag can't
+	// be confirmed and the mount must go no further
+	if err == nil || !strings.Contains(err.Error(), "read-only flag") {
+		t.Errorf("execute() = %v, want the read-only check to fail", err)
+	}
+	if luks.called("Open") {
+		t.Errorf("opened LUKS on an unconfirmed loop device: %v", luks.Calls())
+	}
+}
+
+func TestMountGetAuthTPM(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       MountCommand
+		tpmErr  error
+		wantErr string
+	}{
+		{name: "tpm", c: MountCommand{tpm: true}},
+		{name: "tooling missing", c: MountCommand{tpm: true}, tpmErr: errors.New("no TPM2 device found"), wantErr: "no TPM2 device found"},
+		{name: "with a keyfile", c: MountCommand{tpm: true, keyfile: "/k"}, wantErr: "cannot be combined"},
+		{name: "with --password-stdin", c: MountCommand{tpm: true, passwordStdin: true}, wantErr: "cannot be combined"},
+		{name: "with --fido2", c: MountCommand{tpm: true, fido2: true}, wantErr: "cannot be used together"},
+	}
+	for _, tt := range tests {
+		luks := newFakeCryptSetup()
+		if tt.tpmErr != nil {
+			luks.errs["CheckTPM"] = tt.tpmErr
+		}
+		c := tt.c
+		c.ctx = newTestContext(testutil.NewFakeExecutor(), luks)
+		auth, err := c.getAuth()
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: getAuth() = %T, %v; want %q", tt.name, auth, err, tt.wantErr)
+			}
+			continue
+		}
+		if _, ok := auth.(*container.TPMAuth); !ok || err != nil {
+			t.Errorf("%s: getAuth() = %T, %v; want TPM authentication", tt.name, auth, err)
+		}
+	}
+}
+
+func TestMountGetAuthFIDO2(t *testing.T) {
+	c := &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()), fido2: true}
+	if auth, err := c.getAuth(); err != nil {
+		t.Errorf("getAuth() = %v", err)
+	} else if _, ok := auth.(*container.FIDO2Auth); !ok {
+		t.Errorf("getAuth() = %T, want FIDO2 authentication", auth)
+	}
+
+	luks := newFakeCryptSetup()
+	luks.errs["CheckFIDO2"] = errors.New("cryptsetup systemd-fido2 token plugin not found")
+	c = &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), fido2: true}
+	if _, err := c.getAuth(); err == nil || !strings.Contains(err.Error(), "plugin not found") {
+		t.Errorf("getAuth() without the plugin = %v", err)
+	}
+
+	c = &MountCommand{ctx: newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()), fido2: true, keyDescription: "brezno:x"}
+	if _, err := c.getAuth(); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Errorf("getAuth() with --key-description = %v", err)
+	}
+}
+
+// authRecorder is a CryptSetup keeping the auth each Open was given
+type authRecorder struct {
+	*fakeCryptSetup
+	auth []container.AuthMethod
+}
+
+func (r *authRecorder) Open(ctx context.Context, device, mapperName string, auth container.AuthMethod, readonly bool) error {
+	r.auth = append(r.auth, auth)
+	return r.fakeCryptSetup.Open(ctx, device, mapperName, auth, readonly)
+}
+
+func TestMountRunResolvesSymlinks(t *testing.T) {
+	if !system.IsRoot() {
+		t.Skip("mount requires root")
+	}
+	dir := t.TempDir()
+	link := func(target, name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	containerPath := write("secrets.img", append(luksHeader, make([]byte, 4096)...))
+	keyfile := write("secret.key", []byte("secret"))
+	mountPoint := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(mountPoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(dir, "report.jsonl")
+
+	exec := testutil.NewFakeExecutor().On("losetup -f --show", testLoopDevice+"\n", nil)
+	luks := &authRecorder{fakeCryptSetup: newFakeCryptSetup()}
+	// Only the resolved path is LUKS: probing the link would fail
+	luks.luks[containerPath] = true
+	ctx := newTestContext(exec, luks)
+	ctx.LUKSManager = luks
+	ctx.Discovery.SetMountSource(fixtureMounts{})
+	c := &MountCommand{
+		ctx:         ctx,
+		attempts:    1,
+		createMount: true,
+		loadModules: true,
+		keyfile:     link("secret.key", "key-link"),
+		report:      report,
+	}
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	if err := c.Run(cmd, []string{link("secrets.img", "link.img"), link("mnt", "mnt-link")}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mapperName := container.GenerateMapperName(containerPath)
+	if !luks.called(fmt.Sprintf("Open %s %s false", testLoopDevice, mapperName)) {
+		t.Errorf("not opened under the resolved name %s: %v", mapperName, luks.Calls())
+	}
+	if len(luks.auth) != 1 {
+		t.Fatalf("opened %d times", len(luks.auth))
+	}
+	if auth, ok := luks.auth[0].(*container.KeyfileAuth); !ok || auth.KeyfilePath != keyfile {
+		t.Errorf("auth = %#v, want keyfile %s", luks.auth[0], keyfile)
+	}
+	if _, ok := exec.Ran(fmt.Sprintf("mount /dev/mapper/%s %s", mapperName, mountPoint)); !ok {
+		t.Errorf("not mounted on the resolved mount point: %v", exec.Lines())
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record system.ReportRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Path != containerPath || record.MountPoint != mountPoint {
+		t.Errorf("reported %s on %s, want %s on %s", record.Path, record.MountPoint, containerPath, mountPoint)
+	}
+}
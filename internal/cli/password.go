@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,10 +15,14 @@ import (
 
 // PasswordCommand handles changing container authentication
 type PasswordCommand struct {
-	ctx           *GlobalContext
-	keyfile       string
-	newKeyfile    string
-	passwordStdin bool
+	ctx                *GlobalContext
+	keyfile            string
+	keyDescription     string
+	newKeyfile         string
+	passwordStdin      bool
+	passwordFile       string
+	newPasswordFile    string
+	backupHeaderBefore string
 }
 
 // NewPasswordCommand creates a new password command
@@ -36,16 +41,32 @@ Supports all transitions:
   - Keyfile to keyfile (--keyfile and --new-keyfile)
 
 The container must be unmounted before changing credentials.`,
+		Example: `  # Change the password
+  brezno password /data/secrets.img
+
+  # Switch from a password to a keyfile
+  brezno password /data/secrets.img --new-keyfile ~/.keys/new.key
+
+  # Rotate keyfiles
+  brezno password /data/secrets.img --keyfile ~/.keys/old.key --new-keyfile ~/.keys/new.key`,
 		Args: cobra.MaximumNArgs(1),
 		RunE: cmd.Run,
 	}
 
 	cobraCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "",
 		"Current keyfile path (if not set, will prompt for current password)")
+	cobraCmd.Flags().StringVar(&cmd.keyDescription, "key-description", "",
+		"Read the current passphrase from the kernel keyring entry with this description")
 	cobraCmd.Flags().StringVar(&cmd.newKeyfile, "new-keyfile", "",
 		"New keyfile path (if not set, will prompt for new password)")
 	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false,
 		"Read passwords from stdin (for automation)")
+	cobraCmd.Flags().StringVar(&cmd.passwordFile, "password-file", "",
+		"Read the current password from the first line of this file")
+	cobraCmd.Flags().StringVar(&cmd.newPasswordFile, "new-password-file", "",
+		"Read the new password from the first line of this file")
+	cobraCmd.Flags().StringVar(&cmd.backupHeaderBefore, "backup-header-before", "",
+		"Back up the LUKS header into this directory first (timestamped)")
 
 	return cobraCmd
 }
@@ -57,6 +78,8 @@ func (c *PasswordCommand) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	ctx := cmd.Context()
+
 	// Check dependencies
 	if err := c.ctx.CheckDependencies(); err != nil {
 		return err
@@ -77,6 +100,17 @@ func (c *PasswordCommand) Run(cmd *cobra.Command, args []string) error {
 	}
 	containerPath = absPath
 
+	// Hold the container lock for the whole operation
+	lock, err := lockContainer(containerPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := validateKeyfileChange(c.keyfile, c.newKeyfile); err != nil {
+		return err
+	}
+
 	// Verify container file exists
 	if _, err := os.Stat(containerPath); err != nil {
 		if os.IsNotExist(err) {
@@ -86,7 +120,7 @@ func (c *PasswordCommand) Run(cmd *cobra.Command, args []string) error {
 	}
 
 	// Verify it's a LUKS container
-	isLuks, err := c.ctx.LUKSManager.IsLUKS(containerPath)
+	isLuks, err := c.ctx.LUKSManager.IsLUKS(ctx, containerPath)
 	if err != nil {
 		return fmt.Errorf("failed to check LUKS format: %w", err)
 	}
@@ -95,7 +129,7 @@ func (c *PasswordCommand) Run(cmd *cobra.Command, args []string) error {
 	}
 
 	// Verify container is NOT mounted
-	existing, err := c.ctx.Discovery.FindByPath(containerPath)
+	existing, err := c.ctx.Discovery.FindByPath(ctx, containerPath)
 	if err != nil {
 		return err
 	}
@@ -107,7 +141,12 @@ func (c *PasswordCommand) Run(cmd *cobra.Command, args []string) error {
 
 	// Get current authentication method
 	c.ctx.Logger.Info("Enter current authentication credentials:")
-	currentAuth, err := GetAuthMethod(c.keyfile, false, c.passwordStdin, "", "")
+	var currentAuth container.AuthMethod
+	if c.passwordFile != "" {
+		currentAuth, err = GetPasswordFileAuth(c.passwordFile, c.keyfile, c.keyDescription, c.passwordStdin)
+	} else {
+		currentAuth, err = GetAuthMethod(c.keyfile, c.keyDescription, false, c.passwordStdin, "", "")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get current authentication: %w", err)
 	}
@@ -118,7 +157,15 @@ func (c *PasswordCommand) Run(cmd *cobra.Command, args []string) error {
 
 	// Get new authentication method
 	c.ctx.Logger.Info("Enter new authentication credentials:")
-	newAuth, err := GetAuthMethod(c.newKeyfile, true, c.passwordStdin, "Enter new password", "Confirm new password")
+	var newAuth container.AuthMethod
+	if c.newPasswordFile != "" {
+		if c.newKeyfile != "" {
+			return fmt.Errorf("--new-password-file and --new-keyfile cannot be used together")
+		}
+		newAuth, err = GetPasswordFileAuth(c.newPasswordFile, "", "", false)
+	} else {
+		newAuth, err = GetAuthMethod(c.newKeyfile, "", true, c.passwordStdin, "Enter new password", "Confirm new password")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get new authentication: %w", err)
 	}
@@ -127,15 +174,33 @@ func (c *PasswordCommand) Run(cmd *cobra.Command, args []string) error {
 		defer pwAuth.Password.Zeroize()
 	}
 
+	if err := c.ctx.backupHeaderBefore(ctx, c.backupHeaderBefore, containerPath); err != nil {
+		return err
+	}
+
 	// Execute password change
-	return c.execute(containerPath, currentAuth, newAuth)
+	return c.execute(ctx, containerPath, currentAuth, newAuth)
+}
+
+// validateKeyfileChange rejects replacing a keyfile with itself, which
+// would leave the container's credentials unchanged
+func validateKeyfileChange(currentKeyfile, newKeyfile string) error {
+	if currentKeyfile == "" || newKeyfile == "" {
+		return nil
+	}
+	currentAbs, err1 := filepath.Abs(currentKeyfile)
+	newAbs, err2 := filepath.Abs(newKeyfile)
+	if err1 == nil && err2 == nil && currentAbs == newAbs {
+		return fmt.Errorf("--keyfile and --new-keyfile are the same file: %s", currentAbs)
+	}
+	return nil
 }
 
 // execute performs the credential change operation
-func (c *PasswordCommand) execute(path string, currentAuth, newAuth container.AuthMethod) error {
+func (c *PasswordCommand) execute(ctx context.Context, path string, currentAuth, newAuth container.AuthMethod) error {
 	c.ctx.Logger.Info("Changing container credentials...")
 
-	if err := c.ctx.LUKSManager.ChangeKey(path, currentAuth, newAuth); err != nil {
+	if err := c.ctx.LUKSManager.ChangeKey(ctx, path, currentAuth, newAuth); err != nil {
 		// Provide helpful error messages for common failures
 		if strings.Contains(err.Error(), "No key available") {
 			return fmt.Errorf("incorrect current password or keyfile")
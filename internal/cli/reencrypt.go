@@ -0,0 +1,256 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// ReencryptCommand handles re-encrypting a container with a new volume key
+type ReencryptCommand struct {
+	ctx                *GlobalContext
+	keyfile            string
+	yes                bool
+	resume             bool
+	backupHeader       string
+	newCipher          string
+	newKeySize         int
+	passwordStdin      bool
+	keyfileStdin       bool
+	backupHeaderBefore string
+}
+
+// NewReencryptCommand creates the reencrypt command
+func NewReencryptCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &ReencryptCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "reencrypt <container-path>",
+		Short: "Re-encrypt a container with a new volume key",
+		Long: `Re-encrypt a LUKS2 container with a new volume key using cryptsetup reencrypt.
+
+This rewrites every block of the container and can take a long time. If the
+container is mounted, it is re-encrypted online and stays usable. An
+interrupted reencryption can be continued with --resume.
+
+Use --new-cipher and --new-key-size to change the cipher in place, e.g.:
+  brezno reencrypt data.img --yes --new-cipher aes-xts-plain64 --new-key-size 512
+
+Because of the risk involved, --yes is required. Backing up the LUKS header
+first (--backup-header) is strongly recommended.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: cmd.Run,
+	}
+
+	cobraCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Keyfile path (if not set, will prompt for password)")
+	cobraCmd.Flags().BoolVarP(&cmd.yes, "yes", "y", false, "Confirm the reencryption (required)")
+	cobraCmd.Flags().BoolVar(&cmd.resume, "resume", false, "Resume an interrupted reencryption")
+	cobraCmd.Flags().StringVar(&cmd.newCipher, "new-cipher", "", "New cipher (e.g., aes-xts-plain64), default keeps the current cipher")
+	cobraCmd.Flags().IntVar(&cmd.newKeySize, "new-key-size", 0, "New key size in bits (e.g., 512), default keeps the current key size")
+	cobraCmd.Flags().StringVar(&cmd.backupHeader, "backup-header", "", "Back up the LUKS header to this file first")
+	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+	cobraCmd.Flags().StringVar(&cmd.backupHeaderBefore, "backup-header-before", "", "Back up the LUKS header into this directory first (timestamped)")
+	cobraCmd.Flags().BoolVar(&cmd.keyfileStdin, "keyfile-stdin", false, "Read keyfile contents from stdin")
+
+	return cobraCmd
+}
+
+// Run executes the reencrypt command
+func (c *ReencryptCommand) Run(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	if !c.yes {
+		return fmt.Errorf("reencryption rewrites the whole container; re-run with --yes to confirm")
+	}
+
+	// Validate cipher options before touching the container
+	if c.resume && (c.newCipher != "" || c.newKeySize != 0) {
+		return fmt.Errorf("--new-cipher and --new-key-size cannot be used with --resume (the header already records them)")
+	}
+	if c.newCipher != "" {
+		if err := container.ValidateCipher(c.newCipher); err != nil {
+			return err
+		}
+	}
+	if c.newKeySize != 0 {
+		if err := container.ValidateKeySize(c.newKeySize); err != nil {
+			return err
+		}
+	}
+
+	// Get container path
+	var containerPath string
+	if len(args) > 0 {
+		containerPath = args[0]
+	} else {
+		containerPath = ui.PromptString("Container file path")
+	}
+
+	// Convert to absolute path
+	absPath, err := filepath.Abs(containerPath)
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+	containerPath = absPath
+
+	// Hold the container lock for the whole operation: a resize or key
+	// change during reencryption would corrupt the container
+	lock, err := lockContainer(containerPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// Verify container file exists
+	if _, err := os.Stat(containerPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("container file not found: %s", containerPath)
+		}
+		return fmt.Errorf("failed to access container: %w", err)
+	}
+
+	// Verify it's a LUKS container
+	isLuks, err := c.ctx.LUKSManager.IsLUKS(ctx, containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to check LUKS format: %w", err)
+	}
+	if !isLuks {
+		return fmt.Errorf("not a LUKS container: %s", containerPath)
+	}
+
+	// An interrupted reencryption must be resumed, not restarted
+	inProgress, err := c.ctx.LUKSManager.ReencryptInProgress(ctx, containerPath)
+	if err != nil {
+		return err
+	}
+	if inProgress && !c.resume {
+		return fmt.Errorf("an interrupted reencryption was found in %s\n"+
+			"Run 'brezno reencrypt --resume --yes %s' to finish it", containerPath, containerPath)
+	}
+	if !inProgress && c.resume {
+		return fmt.Errorf("no interrupted reencryption found in %s", containerPath)
+	}
+
+	// Reencrypt online through the loop device if the container is open
+	device := containerPath
+	existing, err := c.ctx.Discovery.FindByPath(ctx, containerPath)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		device = existing.LoopDevice
+		c.ctx.Logger.Info("Container is open, reencrypting online via %s", device)
+	}
+
+	// Offer a header backup before touching the container
+	if err := c.backup(ctx, containerPath); err != nil {
+		return err
+	}
+	if err := c.ctx.backupHeaderBefore(ctx, c.backupHeaderBefore, containerPath); err != nil {
+		return err
+	}
+
+	// Get authentication method
+	var auth container.AuthMethod
+	if c.keyfileStdin {
+		auth, err = GetKeyfileStdinAuth(c.keyfile, "", c.passwordStdin)
+	} else {
+		auth, err = GetAuthMethod(c.keyfile, "", false, c.passwordStdin, "", "")
+	}
+	if err != nil {
+		return err
+	}
+	// Ensure password is zeroized when done
+	if pwAuth, ok := auth.(*container.PasswordAuth); ok {
+		defer pwAuth.Password.Zeroize()
+	}
+	if keyAuth, ok := auth.(*container.StdinKeyAuth); ok {
+		defer keyAuth.Key.Zeroize()
+	}
+
+	return c.execute(ctx, containerPath, device, auth)
+}
+
+// backup saves the LUKS header if requested, or offers to when interactive
+func (c *ReencryptCommand) backup(ctx context.Context, containerPath string) error {
+	backupFile := c.backupHeader
+	if backupFile == "" && c.backupHeaderBefore != "" {
+		return nil
+	}
+	if backupFile == "" {
+		c.ctx.Logger.Warning("No LUKS header backup requested. A failed reencryption may make the container unrecoverable.")
+		if c.passwordStdin || c.keyfileStdin || !ui.PromptConfirm("Back up the LUKS header first?") {
+			return nil
+		}
+		backupFile = ui.PromptStringWithDefault("Header backup file", containerPath+".header")
+	}
+
+	absBackup, err := filepath.Abs(backupFile)
+	if err != nil {
+		return fmt.Errorf("invalid backup path: %w", err)
+	}
+
+	c.ctx.Logger.Info("Backing up LUKS header to %s...", absBackup)
+	if err := c.ctx.LUKSManager.BackupHeader(ctx, containerPath, absBackup); err != nil {
+		return err
+	}
+	return nil
+}
+
+// execute performs the reencryption
+func (c *ReencryptCommand) execute(ctx context.Context, containerPath, device string, auth container.AuthMethod) error {
+	if c.resume {
+		c.ctx.Logger.Info("Resuming reencryption of %s...", containerPath)
+	} else {
+		c.ctx.Logger.Info("Reencrypting %s (this may take a long time)...", containerPath)
+	}
+
+	opts := container.ReencryptOptions{
+		Resume:  c.resume,
+		Cipher:  c.newCipher,
+		KeySize: c.newKeySize,
+	}
+	if opts.Cipher != "" || opts.KeySize != 0 {
+		c.ctx.Logger.Info("New cipher: %s, key size: %s", valueOrCurrent(opts.Cipher), valueOrCurrent(keySizeString(opts.KeySize)))
+	}
+	if err := c.ctx.LUKSManager.Reencrypt(ctx, device, auth, opts); err != nil {
+		return fmt.Errorf("%w\n"+
+			"If the reencryption was interrupted, resume it with:\n"+
+			"  sudo brezno reencrypt --resume --yes %s", err, containerPath)
+	}
+
+	c.ctx.Logger.Success("Container reencrypted successfully")
+	c.ctx.Logger.Info("Container: %s", containerPath)
+
+	return nil
+}
+
+// valueOrCurrent returns s, or a placeholder when the setting is unchanged
+func valueOrCurrent(s string) string {
+	if s == "" {
+		return "(unchanged)"
+	}
+	return s
+}
+
+// keySizeString formats a key size in bits, empty if unset
+func keySizeString(bits int) string {
+	if bits == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d bits", bits)
+}
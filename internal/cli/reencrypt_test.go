@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+	"github.com/spf13/cobra"
+)
+
+func TestReencryptRunLocked(t *testing.T) {
+	if !system.IsRoot() {
+		t.Skip("reencrypt requires root")
+	}
+	path := filepath.Join(t.TempDir(), "secrets.img")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := lockContainer(path)
+	if err != nil {
+		t.Skipf("cannot take container locks: %v", err)
+	}
+	defer lock.Unlock()
+
+	luks := newFakeCryptSetup()
+	c := &ReencryptCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), yes: true}
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	err = c.Run(cmd, []string{path})
+	if err == nil || !strings.Contains(err.Error(), "another brezno operation is in progress") {
+		t.Fatalf("Run with the container locked: %v", err)
+	}
+	if calls := luks.Calls(); len(calls) != 0 {
+		t.Errorf("container touched while locked: %v", calls)
+	}
+}
+
+func TestReencryptRunResumeWithNewCipher(t *testing.T) {
+	if !system.IsRoot() {
+		t.Skip("reencrypt requires root")
+	}
+	for _, c := range []*ReencryptCommand{
+		{resume: true, newCipher: "aes-xts-plain64"},
+		{resume: true, newKeySize: 512},
+	} {
+		luks := newFakeCryptSetup()
+		c.ctx = newTestContext(testutil.NewFakeExecutor(), luks)
+		c.yes = true
+		cmd := &cobra.Command{}
+		cmd.SetContext(context.Background())
+		err := c.Run(cmd, []string{"/data/secrets.img"})
+		if err == nil || !strings.Contains(err.Error(), "cannot be used with --resume") {
+			t.Errorf("--resume with --new-cipher %q --new-key-size %d: %v", c.newCipher, c.newKeySize, err)
+		}
+		if calls := luks.Calls(); len(calls) != 0 {
+			t.Errorf("container touched: %v", calls)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,11 +15,20 @@ import (
 
 // ResizeCommand handles container resizing
 type ResizeCommand struct {
-	ctx           *GlobalContext
-	size          string
-	keyfile       string
-	yes           bool
-	passwordStdin bool
+	ctx                *GlobalContext
+	size               string
+	keyfile            string
+	yes                bool
+	passwordStdin      bool
+	keyfileStdin       bool
+	noFSResize         bool
+	noRefreshLoop      bool
+	checkOnly          bool
+	fsSize             string
+	fsSizeBytes        uint64 // Parsed --filesystem-size, 0 to fill the container
+	backupHeaderBefore string
+	report             string
+	printJSON          bool
 }
 
 // NewResizeCommand creates the resize command
@@ -27,15 +38,50 @@ func NewResizeCommand(ctx *GlobalContext) *cobra.Command {
 	cobraCmd := &cobra.Command{
 		Use:   "resize <container-path> [new-size]",
 		Short: "Expand an encrypted container",
-		Long:  `Expand a mounted LUKS2 encrypted container to a new size. The container must be mounted before resizing.`,
-		Args:  cobra.RangeArgs(1, 2),
-		RunE:  cmd.Run,
+		Long: `Expand an open LUKS2 encrypted container to a new size.
+
+If the container is mounted, its filesystem is grown as well. Containers
+that are open but not mounted (no filesystem, or e.g. LVM inside) are
+resized raw: only the file, loop device, and LUKS mapping are grown, and
+the inner layout is left for you to resize. --no-filesystem-resize does
+the same for a mounted container.
+
+A percentage size is of the space the container could occupy: its current
+size plus the free space on its filesystem. (For create, it is of the free
+space alone.)`,
+		Example: `  # Grow a mounted container to 20G
+  brezno resize /data/secrets.img 20G
+
+  # Grow to 80% of its current size plus the free space, without prompting
+  brezno resize /data/secrets.img --size 80% --yes
+
+  # Grow the container to 50G but the btrfs filesystem only to 40G
+  brezno resize /data/secrets.img 50G --filesystem-size 40G
+
+  # Check that a resize would work, without changing anything
+  brezno resize /data/secrets.img 20G --check-only
+
+  # Grow only the encrypted device of a container opened with --open-only
+  brezno resize /data/lvm.img 50G --no-filesystem-resize
+
+  # Automation: keyfile authentication
+  brezno resize /data/secrets.img 20G --yes --keyfile ~/.keys/secret.key`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: cmd.Run,
 	}
 
-	cobraCmd.Flags().StringVarP(&cmd.size, "size", "s", "", "New container size (e.g., 20G, 500M)")
+	cobraCmd.Flags().StringVarP(&cmd.size, "size", "s", "", "New container size (e.g., 20G, 500M, 80% of current plus free space)")
 	cobraCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Keyfile path for authentication")
 	cobraCmd.Flags().BoolVarP(&cmd.yes, "yes", "y", false, "Skip confirmation prompt")
 	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+	cobraCmd.Flags().BoolVar(&cmd.keyfileStdin, "keyfile-stdin", false, "Read keyfile contents from stdin (requires --yes)")
+	cobraCmd.Flags().BoolVar(&cmd.noFSResize, "no-filesystem-resize", false, "Only grow the file, loop device, and LUKS mapping")
+	cobraCmd.Flags().BoolVar(&cmd.checkOnly, "check-only", false, "Run the checks and show what would happen, without resizing")
+	cobraCmd.Flags().BoolVar(&cmd.noRefreshLoop, "no-refresh-loop", false, "Skip 'losetup -c', for kernels that update loop devices on their own")
+	cobraCmd.Flags().StringVar(&cmd.fsSize, "filesystem-size", "", "Grow the filesystem to this size instead of filling the container (ext4, btrfs)")
+	cobraCmd.Flags().StringVar(&cmd.backupHeaderBefore, "backup-header-before", "", "Back up the LUKS header into this directory first (timestamped)")
+	cobraCmd.Flags().BoolVar(&cmd.printJSON, "print-json", false, "On success, print a JSON object describing the result on stdout")
+	cobraCmd.Flags().StringVar(&cmd.report, "report", "", "Append a JSON record of the outcome to this file (JSONL)")
 
 	return cobraCmd
 }
@@ -46,6 +92,13 @@ func (c *ResizeCommand) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	ctx := cmd.Context()
+
+	// The confirmation prompt would read from the same stdin as the key
+	if c.keyfileStdin && !c.yes {
+		return fmt.Errorf("--keyfile-stdin requires --yes")
+	}
+
 	// Get container path
 	containerPath := args[0]
 
@@ -65,6 +118,13 @@ func (c *ResizeCommand) Run(cmd *cobra.Command, args []string) error {
 	}
 	containerPath = canonicalPath
 
+	// Hold the container lock for the whole operation
+	lock, err := lockContainer(containerPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	// Get size (from positional arg or flag)
 	newSize := c.size
 	if len(args) > 1 {
@@ -73,26 +133,75 @@ func (c *ResizeCommand) Run(cmd *cobra.Command, args []string) error {
 
 	// Prompt for size if not provided
 	if newSize == "" {
-		newSize = ui.PromptString("New container size (e.g., 20G, 500M)")
+		newSize = ui.PromptString("New container size (e.g., 20G, 500M, 50%)")
 	}
 
-	// Parse size
-	newSizeBytes, err := system.ParseSize(newSize)
+	// Parse size. A percentage is of the space the container could occupy:
+	// its current size plus the free space on its filesystem.
+	var available uint64
+	if free, err := system.GetAvailableSpace(containerPath); err == nil {
+		available = free
+		if current, err := system.GetFileSize(containerPath); err == nil {
+			available += current
+		}
+	}
+	newSizeBytes, err := system.ParseSizeWithContext(newSize, available)
 	if err != nil {
 		return err
 	}
 
+	if c.fsSize != "" {
+		if c.noFSResize {
+			return fmt.Errorf("--filesystem-size cannot be used with --no-filesystem-resize")
+		}
+		c.fsSizeBytes, err = system.ParseSize(c.fsSize)
+		if err != nil {
+			return fmt.Errorf("invalid --filesystem-size: %w", err)
+		}
+		if c.fsSizeBytes > usableSize(newSizeBytes) {
+			return fmt.Errorf("--filesystem-size %s does not fit in a %s container (at most %s after the LUKS header)",
+				system.FormatSize(c.fsSizeBytes), system.FormatSize(newSizeBytes), system.FormatSize(usableSize(newSizeBytes)))
+		}
+	}
+
+	// Reject shrinking before asking for credentials or touching anything.
+	// --check-only leaves it to plan, to report it with any other blockers.
+	if current, err := system.GetFileSize(containerPath); err == nil && !c.checkOnly {
+		if err := validateNewSize(newSizeBytes, current); err != nil {
+			if newSizeBytes < current {
+				return c.shrinkError(ctx, err, containerPath, newSize)
+			}
+			return err
+		}
+	}
+
 	// Execute resize
-	c.ctx.Logger.Info("Resizing encrypted container: %s", containerPath)
-	return c.execute(containerPath, newSizeBytes)
+	operation := "resize"
+	if c.checkOnly {
+		operation = "resize-check"
+	} else {
+		c.ctx.Logger.Info("Resizing encrypted container: %s", containerPath)
+	}
+	err = c.execute(ctx, containerPath, newSizeBytes)
+	c.ctx.writeReport(ctx, c.report, c.printJSON, system.ReportRecord{
+		Operation: operation,
+		Path:      containerPath,
+		Size:      newSizeBytes,
+	}, err)
+	return err
 }
 
-func (c *ResizeCommand) execute(containerPath string, newSizeBytes uint64) error {
+func (c *ResizeCommand) execute(ctx context.Context, containerPath string, newSizeBytes uint64) error {
 	// Step 1: Open container file early to prevent TOCTOU race conditions
-	// Open with O_WRONLY (we need write access for truncate)
+	// Open with O_WRONLY (we need write access for truncate), or read-only
+	// for --check-only, which changes nothing
 	// We don't use O_EXCL here because the file already exists
 	// Instead, we'll validate the file hasn't changed using file descriptor operations
-	containerFile, err := os.OpenFile(containerPath, os.O_WRONLY, 0600)
+	flag := os.O_WRONLY
+	if c.checkOnly {
+		flag = os.O_RDONLY
+	}
+	containerFile, err := os.OpenFile(containerPath, flag, 0600)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return fmt.Errorf("container not found: %s", containerPath)
@@ -112,84 +221,19 @@ func (c *ResizeCommand) execute(containerPath string, newSizeBytes uint64) error
 		return fmt.Errorf("container must be a regular file, not a directory or device: %s", containerPath)
 	}
 
-	// Step 2: Find the active container using Discovery
-	c.ctx.Logger.Info("Checking container status...")
-	activeContainer, err := c.ctx.Discovery.FindByPath(containerPath)
+	// Steps 2-7: Read-only checks, nothing is changed until the prompt
+	plan, err := c.plan(ctx, containerPath, uint64(containerInfo.Size()), newSizeBytes)
 	if err != nil {
-		return fmt.Errorf("failed to discover container: %w", err)
-	}
-
-	// Step 3: Validate container is mounted
-	if activeContainer == nil {
-		return fmt.Errorf("container must be mounted for resize. Use 'brezno mount' first")
-	}
-
-	if activeContainer.MountPoint == "" {
-		return fmt.Errorf("container is open but not mounted. Please mount it first")
-	}
-
-	if activeContainer.Filesystem == "" {
-		return fmt.Errorf("cannot detect filesystem type")
-	}
-
-	// Step 4: Check filesystem-specific resize tool exists
-	if !c.ctx.Executor.CommandExists("blockdev") {
-		return fmt.Errorf("blockdev not found (install util-linux)")
-	}
-
-	switch activeContainer.Filesystem {
-	case "ext4":
-		if !c.ctx.Executor.CommandExists("resize2fs") {
-			return fmt.Errorf("resize2fs not found (install e2fsprogs)")
-		}
-	case "xfs":
-		if !c.ctx.Executor.CommandExists("xfs_growfs") {
-			return fmt.Errorf("xfs_growfs not found (install xfsprogs)")
-		}
-	case "btrfs":
-		if !c.ctx.Executor.CommandExists("btrfs") {
-			return fmt.Errorf("btrfs not found (install btrfs-progs)")
-		}
-	default:
-		return fmt.Errorf("filesystem %s does not support online resize", activeContainer.Filesystem)
-	}
-
-	// Step 5: Get current sizes
-	// Use file descriptor to get size (already have containerInfo from Stat above)
-	currentFileSize := uint64(containerInfo.Size())
-
-	currentFSSize, currentFSUsed, err := c.ctx.MountMgr.GetFilesystemSize(activeContainer.MountPoint)
-	if err != nil {
-		return fmt.Errorf("failed to get filesystem size: %w", err)
-	}
-
-	// Step 6: Validate new size is larger
-	if newSizeBytes <= currentFileSize {
-		return fmt.Errorf("new size (%s) must be larger than current size (%s)",
-			system.FormatSize(newSizeBytes), system.FormatSize(currentFileSize))
+		return err
 	}
+	// Step 8: Show preview and get confirmation
+	c.preview(containerPath, plan, newSizeBytes)
 
-	// Step 7: Check available disk space
-	expansionBytes := newSizeBytes - currentFileSize
-	availableSpace, err := system.GetAvailableSpace(containerPath)
-	if err != nil {
-		c.ctx.Logger.Warning("Failed to check available disk space: %v", err)
-	} else if expansionBytes > availableSpace {
-		return fmt.Errorf("insufficient disk space: need %s, available %s",
-			system.FormatSize(expansionBytes), system.FormatSize(availableSpace))
+	if c.checkOnly {
+		c.ctx.Logger.Success("Resize is possible, nothing was changed (--check-only)")
+		return nil
 	}
 
-	// Step 8: Show preview and get confirmation
-	c.ctx.Logger.Info("Container: %s", containerPath)
-	c.ctx.Logger.Info("Mount point: %s", activeContainer.MountPoint)
-	c.ctx.Logger.Info("Filesystem: %s", activeContainer.Filesystem)
-	c.ctx.Logger.Info("")
-	c.ctx.Logger.Info("Current size: %s", system.FormatSize(currentFileSize))
-	c.ctx.Logger.Info("New size:     %s", system.FormatSize(newSizeBytes))
-	c.ctx.Logger.Info("Expansion:    %s", system.FormatSize(expansionBytes))
-	c.ctx.Logger.Info("")
-	c.ctx.Logger.Info("Filesystem used: %s of %s", system.FormatSize(currentFSUsed), system.FormatSize(currentFSSize))
-
 	if !c.yes {
 		if !ui.PromptConfirm("Proceed with resize?") {
 			return fmt.Errorf("resize cancelled by user")
@@ -197,7 +241,12 @@ func (c *ResizeCommand) execute(containerPath string, newSizeBytes uint64) error
 	}
 
 	// Step 9: Get authentication
-	auth, err := GetAuthMethod(c.keyfile, false, c.passwordStdin, "", "") // false = no confirmation needed
+	var auth container.AuthMethod
+	if c.keyfileStdin {
+		auth, err = GetKeyfileStdinAuth(c.keyfile, "", c.passwordStdin)
+	} else {
+		auth, err = GetAuthMethod(c.keyfile, "", false, c.passwordStdin, "", "") // false = no confirmation needed
+	}
 	if err != nil {
 		return err
 	}
@@ -205,58 +254,395 @@ func (c *ResizeCommand) execute(containerPath string, newSizeBytes uint64) error
 	if pwAuth, ok := auth.(*container.PasswordAuth); ok {
 		defer pwAuth.Password.Zeroize()
 	}
+	if keyAuth, ok := auth.(*container.StdinKeyAuth); ok {
+		defer keyAuth.Key.Zeroize()
+	}
+
+	return c.apply(ctx, containerFile, containerPath, plan, newSizeBytes, auth)
+}
+
+// apply performs the resize plan describes on the open container file. A
+// failure is a ResizeError recording the last step that completed.
+func (c *ResizeCommand) apply(ctx context.Context, containerFile *os.File, containerPath string, plan *resizePlan, newSizeBytes uint64, auth container.AuthMethod) error {
+	activeContainer := plan.container
+	currentFileSize := plan.currentFileSize
 
 	// Step 10: Perform resize operations
 	// Note: No CleanupStack needed - resize operations are monotonic (safe if interrupted)
+	if err := c.ctx.backupHeaderBefore(ctx, c.backupHeaderBefore, containerPath); err != nil {
+		return &ResizeError{Stage: ResizeStageNone, Err: err}
+	}
 
 	// Step 10a: Expand container file using the already-open file descriptor
 	// This prevents TOCTOU race conditions
 	c.ctx.Logger.Info("Expanding container file...")
 	if err := containerFile.Truncate(int64(newSizeBytes)); err != nil {
-		return fmt.Errorf("failed to expand container file: %w", err)
+		return &ResizeError{Stage: ResizeStageNone, Err: fmt.Errorf("failed to expand container file: %w", err)}
 	}
+	stage := ResizeStageFileExpanded
 	// Sync to ensure changes are written to disk before proceeding
 	if err := containerFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync container file: %w", err)
+		return &ResizeError{Stage: stage, Err: fmt.Errorf("failed to sync container file: %w", err)}
 	}
 
 	// Step 10b: Refresh loop device size
-	c.ctx.Logger.Info("Refreshing loop device size...")
-	if err := c.ctx.LoopManager.RefreshSize(activeContainer.LoopDevice); err != nil {
-		c.ctx.Logger.Warning("Failed to refresh loop device (may auto-update): %v", err)
-		// Continue anyway - many kernels auto-update the loop device
+	if err := c.refreshLoop(ctx, activeContainer.LoopDevice, newSizeBytes); err != nil {
+		return &ResizeError{Stage: stage, Err: err}
 	}
+	stage = ResizeStageLoopRefreshed
 
 	// Step 10c: Resize LUKS container
+	// Record the size first so the resize can be verified
+	luksBefore, luksBeforeErr := c.ctx.LUKSManager.GetLUKSSize(ctx, activeContainer.MapperName)
+	if luksBeforeErr != nil {
+		c.ctx.Logger.Warning("Failed to read LUKS size, the resize will not be verified: %v", luksBeforeErr)
+	}
+
 	c.ctx.Logger.Info("Resizing LUKS container...")
-	if err := c.ctx.LUKSManager.Resize(activeContainer.MapperName, auth); err != nil {
-		return fmt.Errorf("failed to resize LUKS container: %w\n"+
+	if err := c.ctx.LUKSManager.Resize(ctx, activeContainer.MapperName, auth); err != nil {
+		return &ResizeError{Stage: stage, Err: fmt.Errorf("failed to resize LUKS container: %w\n"+
 			"The container file has been expanded but LUKS has not.\n"+
-			"You can retry: sudo brezno resize %s %s", err, containerPath, system.FormatSize(newSizeBytes))
+			"You can retry: sudo brezno resize %s %s", err, containerPath, system.FormatSize(newSizeBytes))}
 	}
 
-	// Step 10d: Resize filesystem
+	// cryptsetup resize succeeds without growing anything if the loop
+	// device still reports the old size, so check the mapper really grew
+	if luksBeforeErr == nil {
+		luksAfter, err := c.ctx.LUKSManager.GetLUKSSize(ctx, activeContainer.MapperName)
+		if err != nil {
+			c.ctx.Logger.Warning("Failed to verify LUKS size: %v", err)
+		} else {
+			// The header takes the same space before and after
+			expected := newSizeBytes - (currentFileSize - luksBefore)
+			if err := checkLUKSGrowth(luksBefore, luksAfter, expected); err != nil {
+				return &ResizeError{Stage: stage, Err: fmt.Errorf("%w\n"+
+					"Refresh the loop device and retry:\n"+
+					"  sudo losetup -c %s\n"+
+					"  sudo brezno resize %s %s",
+					err, activeContainer.LoopDevice, containerPath, system.FormatSize(newSizeBytes))}
+			}
+			c.ctx.Logger.Debug("LUKS size: %s -> %s", system.FormatSize(luksBefore), system.FormatSize(luksAfter))
+		}
+	}
+	stage = ResizeStageLUKSResized
+
 	mapperDevice := "/dev/mapper/" + activeContainer.MapperName
+	if !plan.resizeFS {
+		c.ctx.Logger.Success("Encrypted device resized successfully!")
+		c.ctx.Logger.Info("Container is now %s. Resize the contents of %s manually.", system.FormatSize(newSizeBytes), mapperDevice)
+		return nil
+	}
+
+	// Step 10d: Resize filesystem
 	c.ctx.Logger.Info("Resizing %s filesystem...", activeContainer.Filesystem)
-	if err := c.ctx.MountMgr.ResizeFilesystem(mapperDevice, activeContainer.Filesystem, activeContainer.MountPoint); err != nil {
-		return fmt.Errorf("failed to resize filesystem: %w\n"+
+	if err := c.ctx.MountMgr.ResizeFilesystem(ctx, mapperDevice, activeContainer.Filesystem, activeContainer.MountPoint, c.fsSizeBytes); err != nil {
+		return &ResizeError{Stage: stage, Err: fmt.Errorf("failed to resize filesystem: %w\n"+
 			"The LUKS container has been expanded but the filesystem has not.\n"+
 			"You may need to resize manually:\n"+
 			"  ext4:  sudo resize2fs %s\n"+
 			"  xfs:   sudo xfs_growfs %s\n"+
 			"  btrfs: sudo btrfs filesystem resize max %s",
-			err, mapperDevice, activeContainer.MountPoint, activeContainer.MountPoint)
+			err, mapperDevice, activeContainer.MountPoint, activeContainer.MountPoint)}
 	}
 
 	// Step 11: Verify success
-	newFSSize, newFSUsed, err := c.ctx.MountMgr.GetFilesystemSize(activeContainer.MountPoint)
+	newFSSize, newFSUsed, err := c.ctx.MountMgr.GetFilesystemSize(ctx, activeContainer.MountPoint, activeContainer.Filesystem)
 	if err != nil {
 		c.ctx.Logger.Warning("Failed to verify new filesystem size: %v", err)
 	}
 
 	c.ctx.Logger.Success("Container resized successfully!")
-	c.ctx.Logger.Info("Old size: %s → New size: %s", system.FormatSize(currentFSSize), system.FormatSize(newFSSize))
+	c.ctx.Logger.Info("Old size: %s → New size: %s", system.FormatSize(plan.currentFSSize), system.FormatSize(newFSSize))
 	c.ctx.Logger.Info("Used: %s, Available: %s", system.FormatSize(newFSUsed), system.FormatSize(newFSSize-newFSUsed))
 
 	return nil
 }
+
+// resizePlan is what the read-only checks found out about a resize
+type resizePlan struct {
+	container       *container.Container
+	resizeFS        bool // Grow the filesystem too, not just the device
+	currentFileSize uint64
+	currentFSSize   uint64
+	currentFSUsed   uint64
+	expansionBytes  uint64
+}
+
+// plan runs every check that needs no changes and no credentials: the
+// container is open, the tools are installed, the size grows, and the
+// space is there.
+func (c *ResizeCommand) plan(ctx context.Context, containerPath string, currentFileSize, newSizeBytes uint64) (*resizePlan, error) {
+	// Step 2: Find the active container using Discovery
+	c.ctx.Logger.Info("Checking container status...")
+	activeContainer, err := c.ctx.Discovery.FindByPath(ctx, containerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover container: %w", err)
+	}
+	return c.check(ctx, containerPath, activeContainer, currentFileSize, newSizeBytes)
+}
+
+// check runs plan's checks against activeContainer, nil if it is not
+// open. It carries on past blockers so --check-only can report them all;
+// if there are several, the error joins them.
+func (c *ResizeCommand) check(ctx context.Context, containerPath string, activeContainer *container.Container, currentFileSize, newSizeBytes uint64) (*resizePlan, error) {
+	var blockers []error
+	var resizeFS bool
+	var currentFSSize, currentFSUsed uint64
+
+	// Step 3: Validate container is open, and decide whether the
+	// filesystem is resized too
+	switch {
+	case activeContainer == nil:
+		blockers = append(blockers, fmt.Errorf("container must be open for resize. Use 'brezno mount' first"))
+	case activeContainer.MountPoint == "" && isUnreadable(*activeContainer, "mount point"):
+		blockers = append(blockers, fmt.Errorf("cannot determine where the container is mounted (mount table unreadable)"))
+	default:
+		resizeFS = !c.noFSResize
+		if resizeFS && activeContainer.MountPoint == "" {
+			c.ctx.Logger.Warning("Container is open but not mounted, only the encrypted device will be resized")
+			resizeFS = false
+		} else if resizeFS && activeContainer.Filesystem == "" {
+			c.ctx.Logger.Warning("Cannot detect filesystem type, only the encrypted device will be resized")
+			resizeFS = false
+		}
+
+		// Step 4: Check filesystem-specific resize tool exists
+		if resizeFS {
+			if err := c.checkResizeTool(activeContainer.Filesystem); err != nil {
+				blockers = append(blockers, err)
+			}
+		} else if c.fsSizeBytes > 0 {
+			blockers = append(blockers, fmt.Errorf("--filesystem-size needs a mounted filesystem to resize"))
+		}
+		if c.fsSizeBytes > 0 && activeContainer.Filesystem == "xfs" {
+			blockers = append(blockers, fmt.Errorf("--filesystem-size is not supported for xfs, which can only grow to fill the device"))
+		}
+
+		// Step 5: Get current sizes
+		if resizeFS {
+			var err error
+			currentFSSize, currentFSUsed, err = c.ctx.MountMgr.GetFilesystemSize(ctx, activeContainer.MountPoint, activeContainer.Filesystem)
+			if err != nil {
+				blockers = append(blockers, fmt.Errorf("failed to get filesystem size: %w", err))
+			} else if c.fsSizeBytes > 0 && c.fsSizeBytes <= currentFSSize {
+				blockers = append(blockers, fmt.Errorf("--filesystem-size %s is not larger than the filesystem (%s)",
+					system.FormatSize(c.fsSizeBytes), system.FormatSize(currentFSSize)))
+			}
+		}
+	}
+
+	if !c.ctx.Executor.CommandExists("blockdev") {
+		blockers = append(blockers, fmt.Errorf("blockdev not found (install util-linux)"))
+	}
+
+	// Step 6: Validate new size is larger (the file may have changed since Run checked)
+	var expansionBytes uint64
+	if err := validateNewSize(newSizeBytes, currentFileSize); err != nil {
+		blockers = append(blockers, err)
+	} else {
+		// Step 7: Check available disk space
+		expansionBytes = newSizeBytes - currentFileSize
+		availableSpace, err := system.GetAvailableSpace(containerPath)
+		if err != nil {
+			c.ctx.Logger.Warning("Failed to check available disk space: %v", err)
+		} else if expansionBytes > availableSpace {
+			blockers = append(blockers, fmt.Errorf("insufficient disk space: need %s, available %s",
+				system.FormatSize(expansionBytes), system.FormatSize(availableSpace)))
+		}
+	}
+
+	switch len(blockers) {
+	case 0:
+	case 1:
+		return nil, blockers[0]
+	default:
+		return nil, fmt.Errorf("resize is not possible:\n%w", errors.Join(blockers...))
+	}
+
+	return &resizePlan{
+		container:       activeContainer,
+		resizeFS:        resizeFS,
+		currentFileSize: currentFileSize,
+		currentFSSize:   currentFSSize,
+		currentFSUsed:   currentFSUsed,
+		expansionBytes:  expansionBytes,
+	}, nil
+}
+
+// preview shows what the resize will do
+func (c *ResizeCommand) preview(containerPath string, plan *resizePlan, newSizeBytes uint64) {
+	c.ctx.Logger.Info("Container: %s", containerPath)
+	if plan.resizeFS {
+		c.ctx.Logger.Info("Mount point: %s", plan.container.MountPoint)
+		c.ctx.Logger.Info("Filesystem: %s", plan.container.Filesystem)
+	} else {
+		c.ctx.Logger.Info("Device: /dev/mapper/%s (filesystem not resized)", plan.container.MapperName)
+	}
+	c.ctx.Logger.Info("")
+	c.ctx.Logger.Info("Current size: %s", system.FormatSize(plan.currentFileSize))
+	c.ctx.Logger.Info("New size:     %s", system.FormatSize(newSizeBytes))
+	c.ctx.Logger.Info("Expansion:    %s", system.FormatSize(plan.expansionBytes))
+	if plan.resizeFS {
+		c.ctx.Logger.Info("")
+		c.ctx.Logger.Info("Filesystem used: %s of %s", system.FormatSize(plan.currentFSUsed), system.FormatSize(plan.currentFSSize))
+	}
+}
+
+// refreshLoop makes the loop device pick up the expanded file, unless
+// --no-refresh-loop leaves it to the kernel, and checks that it did
+func (c *ResizeCommand) refreshLoop(ctx context.Context, loopDevice string, newSizeBytes uint64) error {
+	if c.noRefreshLoop {
+		c.ctx.Logger.Debug("Skipping loop device refresh (--no-refresh-loop)")
+	} else {
+		c.ctx.Logger.Info("Refreshing loop device size...")
+		if err := c.ctx.LoopManager.RefreshSize(ctx, loopDevice); err != nil {
+			c.ctx.Logger.Warning("Failed to refresh loop device (may auto-update): %v", err)
+			// Continue anyway - many kernels auto-update the loop device
+		}
+	}
+
+	// Whether refreshed or updated by the kernel, the loop device must see
+	// the new size before LUKS can grow into it
+	if err := c.ctx.LoopManager.VerifySize(ctx, loopDevice, newSizeBytes); err != nil {
+		hint := "try 'losetup -c " + loopDevice + "' and run resize again"
+		if c.noRefreshLoop {
+			hint = "run resize again without --no-refresh-loop"
+		}
+		return fmt.Errorf("loop device did not pick up the new file size: %w\n%s", err, hint)
+	}
+	return nil
+}
+
+// checkResizeTool checks that the tool to grow fsType online is installed
+func (c *ResizeCommand) checkResizeTool(fsType string) error {
+	switch fsType {
+	case "ext4":
+		if !c.ctx.Executor.CommandExists("resize2fs") {
+			return fmt.Errorf("resize2fs not found (install e2fsprogs)")
+		}
+	case "xfs":
+		if !c.ctx.Executor.CommandExists("xfs_growfs") {
+			return fmt.Errorf("xfs_growfs not found (install xfsprogs)")
+		}
+	case "btrfs":
+		if !c.ctx.Executor.CommandExists("btrfs") {
+			return fmt.Errorf("btrfs not found (install btrfs-progs)")
+		}
+	default:
+		return fmt.Errorf("filesystem %s does not support online resize (use --no-filesystem-resize to grow only the encrypted device)", fsType)
+	}
+	return nil
+}
+
+// validateNewSize checks that a resize grows the container. Shrinking is
+// not supported, since none of the filesystems can shrink online.
+func validateNewSize(newSize, currentSize uint64) error {
+	if newSize == currentSize {
+		return fmt.Errorf("container is already %s", system.FormatSize(currentSize))
+	}
+	if newSize < currentSize {
+		return fmt.Errorf("new size (%s) is smaller than the current size (%s); containers can only grow",
+			system.FormatSize(newSize), system.FormatSize(currentSize))
+	}
+	if growth := newSize - currentSize; growth < minResizeGrowth {
+		return fmt.Errorf("growing by %s would not enlarge the filesystem, since LUKS and filesystems grow in aligned steps; grow by at least %s",
+			system.FormatSize(growth), system.FormatSize(minResizeGrowth))
+	}
+	return nil
+}
+
+// minResizeGrowth is the smallest growth resize accepts. Less can vanish
+// in LUKS sector alignment and filesystem block rounding, leaving the
+// filesystem the same size.
+const minResizeGrowth = luksResizeTolerance
+
+// shrinkError looks up the filesystem of an open container for
+// shrinkHint. Only an open container's filesystem can be read.
+func (c *ResizeCommand) shrinkError(ctx context.Context, err error, path, newSize string) error {
+	cont, findErr := c.ctx.Discovery.FindByPath(ctx, path)
+	if findErr != nil || cont == nil {
+		return shrinkHint(err, path, newSize, nil, "")
+	}
+	fsType := cont.Filesystem
+	if fsType == "" {
+		// Open with --open-only, ask the device
+		fsType, _ = c.ctx.MountMgr.DetectFilesystem(ctx, "/dev/mapper/"+cont.MapperName)
+	}
+	return shrinkHint(err, path, newSize, cont, fsType)
+}
+
+// shrinkHint adds a way forward to err, the refusal to shrink a container.
+// XFS users get the commands to move to a smaller container, since XFS
+// cannot shrink at all. cont is nil if the container is not open, in which
+// case its filesystem is unknown.
+func shrinkHint(err error, path, newSize string, cont *container.Container, fsType string) error {
+	if cont == nil {
+		return fmt.Errorf("%w\nFor XFS, which cannot shrink at all, open the container and run this again to see how to move to a smaller one", err)
+	}
+	if fsType == "xfs" {
+		return xfsShrinkError(path, newSize, cont.MountPoint)
+	}
+	return err
+}
+
+// xfsShrinkError explains how to end up with a smaller XFS container, as
+// XFS cannot be shrunk at all
+func xfsShrinkError(path, newSize, mountPoint string) error {
+	dir, base := filepath.Split(path)
+	smaller := filepath.Join(dir, "smaller-"+base)
+	if mountPoint == "" {
+		// Open with --open-only, it needs mounting for the copy
+		mountPoint = "<mount point>"
+	}
+	return fmt.Errorf("XFS filesystems cannot be shrunk\n"+
+		"To move the data into a smaller container, create one and copy it over:\n"+
+		"  brezno create %s --size %s --filesystem xfs\n"+
+		"  brezno mount %s /mnt/smaller\n"+
+		"  rsync -aHAX %s/ /mnt/smaller/",
+		smaller, newSize, smaller, mountPoint)
+}
+
+// luksResizeTolerance is how far below the expected size a resized LUKS
+// mapping may end up, allowing for sector alignment
+const luksResizeTolerance = 1 << 20 // 1 MiB
+
+// checkLUKSGrowth verifies that a LUKS mapping grew from before to roughly
+// the expected size after cryptsetup resize reported success
+func checkLUKSGrowth(before, after, expected uint64) error {
+	if after <= before {
+		return fmt.Errorf("LUKS container did not grow (still %s); the loop device may not have picked up the new file size",
+			system.FormatSize(after))
+	}
+	if after+luksResizeTolerance < expected {
+		return fmt.Errorf("LUKS container only grew to %s, expected about %s",
+			system.FormatSize(after), system.FormatSize(expected))
+	}
+	return nil
+}
+
+// ResizeStage is the last resize step that completed
+type ResizeStage string
+
+// Resize stages, in the order they complete
+const (
+	ResizeStageNone          ResizeStage = "none"           // Nothing was changed
+	ResizeStageFileExpanded  ResizeStage = "file-expanded"  // Container file grown
+	ResizeStageLoopRefreshed ResizeStage = "loop-refreshed" // Loop device sees the new size
+	ResizeStageLUKSResized   ResizeStage = "luks-resized"   // LUKS mapping grown, filesystem not
+)
+
+// ResizeError is returned when a resize fails partway through.
+// Stage records the last step that completed, so callers can tell
+// which steps remain.
+type ResizeError struct {
+	Stage ResizeStage
+	Err   error
+}
+
+func (e *ResizeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResizeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,428 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestShrinkHint(t *testing.T) {
+	refusal := errors.New("new size (1.0G) is smaller than the current size (2.0G); containers can only grow")
+	path := "/data/secrets.img"
+
+	// Closed: the filesystem is unknown
+	err := shrinkHint(refusal, path, "1G", nil, "")
+	if !errors.Is(err, refusal) || !strings.Contains(err.Error(), "open the container and run this again") {
+		t.Errorf("closed container: %v", err)
+	}
+
+	// Mounted XFS: the exact commands, with the current mount point
+	mounted := &container.Container{MapperName: "secrets_img", MountPoint: "/mnt/secrets"}
+	err = shrinkHint(refusal, path, "1G", mounted, "xfs")
+	for _, want := range []string{
+		"XFS filesystems cannot be shrunk",
+		"brezno create /data/smaller-secrets.img --size 1G --filesystem xfs",
+		"brezno mount /data/smaller-secrets.img /mnt/smaller",
+		"rsync -aHAX /mnt/secrets/ /mnt/smaller/",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("mounted XFS: %v lacks %q", err, want)
+		}
+	}
+
+	// Open with --open-only: it has to be mounted for the copy
+	err = shrinkHint(refusal, path, "1G", &container.Container{MapperName: "secrets_img"}, "xfs")
+	if err == nil || !strings.Contains(err.Error(), "rsync -aHAX <mount point>/ /mnt/smaller/") {
+		t.Errorf("open XFS: %v", err)
+	}
+
+	// Other filesystems: just the refusal
+	if err := shrinkHint(refusal, path, "1G", mounted, "ext4"); err != refusal {
+		t.Errorf("ext4: %v", err)
+	}
+}
+
+func TestResizeRefreshLoop(t *testing.T) {
+	tests := []struct {
+		name      string
+		noRefresh bool
+		size      string
+		wantErr   string
+	}{
+		{name: "refreshed", size: "2097152"},
+		{name: "skipped", noRefresh: true, size: "2097152"},
+		{name: "stale", size: "1048576", wantErr: "try 'losetup -c " + testLoopDevice + "'"},
+		{name: "stale skipped", noRefresh: true, size: "1048576", wantErr: "without --no-refresh-loop"},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().On("blockdev --getsize64 "+testLoopDevice, tt.size+"\n", nil)
+		c := &ResizeCommand{ctx: newTestContext(exec, newFakeCryptSetup()), noRefreshLoop: tt.noRefresh}
+
+		err := c.refreshLoop(context.Background(), testLoopDevice, 2<<20)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: refreshLoop() = %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: refreshLoop() = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+
+		_, refreshed := exec.Ran("losetup -c " + testLoopDevice)
+		if refreshed == tt.noRefresh {
+			t.Errorf("%s: ran losetup -c: %v, with --no-refresh-loop %v", tt.name, refreshed, tt.noRefresh)
+		}
+		if _, ok := exec.Ran("blockdev --getsize64 " + testLoopDevice); !ok {
+			t.Errorf("%s: loop device size not verified", tt.name)
+		}
+	}
+}
+
+func TestResizeCheck(t *testing.T) {
+	const (
+		gib = uint64(1 << 30)
+		df  = "Filesystem 1B-blocks Used Available Use% Mounted on\n/dev/mapper/brezno_x 1000000000 250000000 750000000 25% /mnt/x\n"
+	)
+	mounted := func(fsType string) *container.Container {
+		return &container.Container{MapperName: "brezno_x", LoopDevice: testLoopDevice, MountPoint: "/mnt/x", Filesystem: fsType}
+	}
+	tests := []struct {
+		name    string
+		cont    *container.Container
+		missing []string
+		dfErr   error
+		fsSize  uint64
+		newSize uint64
+		want    []string // Every blocker expected, none if empty
+	}{
+		{name: "ok", cont: mounted("ext4"), newSize: 2 * gib},
+		{name: "open only", cont: &container.Container{MapperName: "brezno_x"}, newSize: 2 * gib},
+		{name: "not open", newSize: 2 * gib, want: []string{"container must be open"}},
+		{name: "mount table unreadable", cont: &container.Container{MapperName: "brezno_x", Unreadable: []string{"mount point"}}, newSize: 2 * gib,
+			want: []string{"cannot determine where the container is mounted"}},
+		{name: "no resize tool", cont: mounted("ext4"), missing: []string{"resize2fs"}, newSize: 2 * gib, want: []string{"resize2fs not found"}},
+		{name: "unsupported filesystem", cont: mounted("vfat"), newSize: 2 * gib, want: []string{"does not support online resize"}},
+		{name: "no blockdev", cont: mounted("ext4"), missing: []string{"blockdev"}, newSize: 2 * gib, want: []string{"blockdev not found"}},
+		{name: "filesystem size unmounted", cont: &container.Container{MapperName: "brezno_x"}, fsSize: gib, newSize: 2 * gib,
+			want: []string{"--filesystem-size needs a mounted filesystem"}},
+		{name: "filesystem size xfs", cont: mounted("xfs"), fsSize: gib, newSize: 2 * gib, want: []string{"not supported for xfs"}},
+		{name: "filesystem size not larger", cont: mounted("ext4"), fsSize: 1000000000, newSize: 2 * gib, want: []string{"is not larger than the filesystem"}},
+		{name: "df fails", cont: mounted("ext4"), dfErr: errors.New("df failed"), newSize: 2 * gib, want: []string{"failed to get filesystem size"}},
+		{name: "not growing", cont: mounted("ext4"), newSize: gib, want: []string{"container is already"}},
+		{name: "too little growth", cont: mounted("ext4"), newSize: gib + 1, want: []string{"would not enlarge the filesystem"}},
+		{name: "no space", cont: mounted("ext4"), newSize: 1 << 62, want: []string{"insufficient disk space"}},
+		{name: "several", missing: []string{"blockdev"}, newSize: gib / 2,
+			want: []string{"resize is not possible", "container must be open", "blockdev not found", "smaller than the current size"}},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().On("df --block-size=1 /mnt/x", df, tt.dfErr).Missing(tt.missing...)
+		c := &ResizeCommand{ctx: newTestContext(exec, newFakeCryptSetup()), fsSizeBytes: tt.fsSize}
+		path, _ := openTestContainer(t)
+
+		plan, err := c.check(context.Background(), path, tt.cont, gib, tt.newSize)
+		if len(tt.want) == 0 {
+			if err != nil || plan == nil {
+				t.Errorf("%s: check() = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || plan != nil {
+			t.Errorf("%s: check() passed, want %q", tt.name, tt.want)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not mention %q", tt.name, err, want)
+			}
+		}
+		if len(tt.want) == 1 && strings.Contains(err.Error(), "\n") {
+			t.Errorf("%s: one blocker reported as several: %q", tt.name, err)
+		}
+	}
+}
+
+func TestResizeCheckPlan(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("df --block-size=1 /mnt/x",
+		"Filesystem 1B-blocks Used Available Use% Mounted on\n/dev/mapper/brezno_x 1000000000 250000000 750000000 25% /mnt/x\n", nil)
+	c := &ResizeCommand{ctx: newTestContext(exec, newFakeCryptSetup())}
+	path, _ := openTestContainer(t)
+	cont := &container.Container{MapperName: "brezno_x", MountPoint: "/mnt/x", Filesystem: "ext4"}
+
+	plan, err := c.check(context.Background(), path, cont, 1<<30, 2<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.resizeFS || plan.currentFSSize != 1000000000 || plan.currentFSUsed != 250000000 || plan.expansionBytes != 1<<30 {
+		t.Errorf("plan = %+v", plan)
+	}
+
+	// --no-filesystem-resize grows only the device, without reading the filesystem
+	c = &ResizeCommand{ctx: newTestContext(testutil.NewFakeExecutor(), newFakeCryptSetup()), noFSResize: true}
+	plan, err = c.check(context.Background(), path, cont, 1<<30, 2<<30)
+	if err != nil || plan.resizeFS {
+		t.Errorf("check() = %+v, %v; want a device-only plan", plan, err)
+	}
+}
+
+func TestResizeExecuteCheckOnly(t *testing.T) {
+	// A read-only container file is enough for a user without root to
+	// check, since nothing is written
+	path, _ := openTestContainer(t)
+	if err := os.Chmod(path, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	exec := testutil.NewFakeExecutor()
+	c := &ResizeCommand{ctx: newTestContext(exec, newFakeCryptSetup()), checkOnly: true}
+
+	// Not open: discovery finds nothing, which is reported
+	err := c.execute(context.Background(), path, 2<<20)
+	if err == nil || !strings.Contains(err.Error(), "container must be open") {
+		t.Errorf("execute() = %v", err)
+	}
+	if info, statErr := os.Stat(path); statErr != nil || info.Size() != 1<<20 {
+		t.Errorf("container changed: %v, %v", info, statErr)
+	}
+}
+
+func TestValidateNewSize(t *testing.T) {
+	const current = uint64(1 << 30)
+	tests := []struct {
+		name    string
+		newSize uint64
+		wantErr string
+	}{
+		{"same size", current, "container is already"},
+		{"smaller", current - 1, "containers can only grow"},
+		{"one byte", current + 1, "would not enlarge the filesystem"},
+		{"one block", current + 4096, "would not enlarge the filesystem"},
+		{"just under the minimum", current + minResizeGrowth - 1, "grow by at least 1.0 MB"},
+		{"the minimum", current + minResizeGrowth, ""},
+		{"well above", 2 * current, ""},
+	}
+	for _, tt := range tests {
+		err := validateNewSize(tt.newSize, current)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: validateNewSize() = %v", tt.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: validateNewSize() = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResizeApplyStages(t *testing.T) {
+	const (
+		oldSize = uint64(32 << 20)
+		newSize = uint64(64 << 20)
+	)
+	tests := []struct {
+		name      string
+		readOnly  bool   // Open the container read-only, so it can't grow
+		loopSize  uint64 // What blockdev reports for the loop device
+		luksErrs  map[string]error
+		luksSize  uint64 // GetLUKSSize, the same before and after
+		fsErr     error
+		wantStage ResizeStage // "" for success
+	}{
+		{name: "file not expanded", readOnly: true, loopSize: newSize, wantStage: ResizeStageNone},
+		{name: "loop not refreshed", loopSize: oldSize, wantStage: ResizeStageFileExpanded},
+		{name: "LUKS resize fails", loopSize: newSize, luksErrs: map[string]error{"Resize": errors.New("cryptsetup resize failed")},
+			wantStage: ResizeStageLoopRefreshed},
+		{name: "LUKS does not grow", loopSize: newSize, luksSize: oldSize - 16<<20, wantStage: ResizeStageLoopRefreshed},
+		{name: "filesystem resize fails", loopSize: newSize, fsErr: errors.New("resize2fs failed"), wantStage: ResizeStageLUKSResized},
+		{name: "success", loopSize: newSize},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().
+			On("blockdev --getsize64 "+testLoopDevice, fmt.Sprint(tt.loopSize), nil).
+			On("resize2fs", "", tt.fsErr)
+		luks := newFakeCryptSetup()
+		luks.size = tt.luksSize
+		if tt.luksSize == 0 {
+			// Skip verifying the LUKS growth
+			luks.errs["GetLUKSSize"] = errors.New("no size")
+		}
+		for method, err := range tt.luksErrs {
+			luks.errs[method] = err
+		}
+		c := &ResizeCommand{ctx: newTestContext(exec, luks)}
+
+		path, file := openTestContainer(t)
+		if err := file.Truncate(int64(oldSize)); err != nil {
+			t.Fatal(err)
+		}
+		if tt.readOnly {
+			var err error
+			if file, err = os.Open(path); err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+		}
+		plan := &resizePlan{
+			container:       &container.Container{MapperName: "brezno_x", LoopDevice: testLoopDevice, MountPoint: "/mnt/x", Filesystem: "ext4"},
+			resizeFS:        true,
+			currentFileSize: oldSize,
+			expansionBytes:  newSize - oldSize,
+		}
+
+		err := c.apply(context.Background(), file, path, plan, newSize, &container.KeyfileAuth{KeyfilePath: "/k"})
+		if tt.wantStage == "" {
+			if err != nil {
+				t.Errorf("%s: apply() = %v", tt.name, err)
+			}
+			if !luks.called("Resize brezno_x") {
+				t.Errorf("%s: LUKS not resized: %v", tt.name, luks.Calls())
+			}
+			if _, ok := exec.Ran("resize2fs /dev/mapper/brezno_x"); !ok {
+				t.Errorf("%s: filesystem not resized: %v", tt.name, exec.Lines())
+			}
+			continue
+		}
+		var resizeErr *ResizeError
+		if !errors.As(err, &resizeErr) || resizeErr.Stage != tt.wantStage {
+			t.Errorf("%s: apply() = %v, want a ResizeError at stage %s", tt.name, err, tt.wantStage)
+		}
+	}
+}
+
+func TestResizeRaw(t *testing.T) {
+	const (
+		oldSize = uint64(32 << 20)
+		newSize = uint64(64 << 20)
+	)
+	tests := []struct {
+		name       string
+		cont       *container.Container
+		noFSResize bool
+		warning    string
+	}{
+		{"open only", &container.Container{MapperName: "brezno_x", LoopDevice: testLoopDevice}, false, "open but not mounted"},
+		{"no filesystem detected", &container.Container{MapperName: "brezno_x", LoopDevice: testLoopDevice, MountPoint: "/mnt/x"}, false, "Cannot detect filesystem type"},
+		{"--no-filesystem-resize", &container.Container{MapperName: "brezno_x", LoopDevice: testLoopDevice, MountPoint: "/mnt/x", Filesystem: "ext4"}, true, ""},
+	}
+	for _, tt := range tests {
+		// No filesystem tool is needed, nor asked about the filesystem
+		exec := testutil.NewFakeExecutor().
+			On("blockdev --getsize64 "+testLoopDevice, fmt.Sprint(newSize), nil).
+			Missing("resize2fs", "xfs_growfs", "btrfs")
+		luks := newFakeCryptSetup()
+		luks.errs["GetLUKSSize"] = errors.New("no size")
+		ctx := newTestContext(exec, luks)
+		c := &ResizeCommand{ctx: ctx, noFSResize: tt.noFSResize}
+
+		path, file := openTestContainer(t)
+		if err := file.Truncate(int64(oldSize)); err != nil {
+			t.Fatal(err)
+		}
+		plan, err := c.check(context.Background(), path, tt.cont, oldSize, newSize)
+		if err != nil {
+			t.Fatalf("%s: check() = %v", tt.name, err)
+		}
+		if plan.resizeFS {
+			t.Errorf("%s: plan resizes the filesystem", tt.name)
+		}
+		warnings := strings.Join(ctx.Warnings.List(), "\n")
+		if (tt.warning == "" && warnings != "") || !strings.Contains(warnings, tt.warning) {
+			t.Errorf("%s: warnings = %q, want %q", tt.name, warnings, tt.warning)
+		}
+
+		if err := c.apply(context.Background(), file, path, plan, newSize, &container.KeyfileAuth{KeyfilePath: "/k"}); err != nil {
+			t.Fatalf("%s: apply() = %v", tt.name, err)
+		}
+		if info, _ := file.Stat(); uint64(info.Size()) != newSize || !luks.called("Resize brezno_x") {
+			t.Errorf("%s: device not grown: size %d, LUKS calls %v", tt.name, info.Size(), luks.Calls())
+		}
+		for _, line := range exec.Lines() {
+			if !strings.HasPrefix(line, "losetup -c ") && !strings.HasPrefix(line, "blockdev ") {
+				t.Errorf("%s: ran %q on a raw container", tt.name, line)
+			}
+		}
+	}
+}
+
+func TestResizeApplyBacksUpHeader(t *testing.T) {
+	const (
+		oldSize = uint64(32 << 20)
+		newSize = uint64(64 << 20)
+	)
+	for _, backupErr := range []error{nil, errors.New("failed to back up LUKS header: disk full")} {
+		exec := testutil.NewFakeExecutor().On("blockdev --getsize64 "+testLoopDevice, fmt.Sprint(newSize), nil)
+		luks := newFakeCryptSetup()
+		luks.errs["GetLUKSSize"] = errors.New("no size")
+		if backupErr != nil {
+			luks.errs["BackupHeader"] = backupErr
+		}
+		dir := t.TempDir()
+		c := &ResizeCommand{ctx: newTestContext(exec, luks), backupHeaderBefore: dir}
+
+		path, file := openTestContainer(t)
+		if err := file.Truncate(int64(oldSize)); err != nil {
+			t.Fatal(err)
+		}
+		plan := &resizePlan{
+			container:       &container.Container{MapperName: "brezno_x", LoopDevice: testLoopDevice},
+			currentFileSize: oldSize,
+			expansionBytes:  newSize - oldSize,
+		}
+		err := c.apply(context.Background(), file, path, plan, newSize, &container.KeyfileAuth{KeyfilePath: "/k"})
+
+		calls := luks.Calls()
+		if len(calls) == 0 || !strings.HasPrefix(calls[0], "BackupHeader "+path+" "+dir+"/") {
+			t.Errorf("backup error %v: LUKS calls %v, want the backup first", backupErr, calls)
+		}
+		if backupErr == nil {
+			if err != nil || !luks.called("Resize brezno_x") {
+				t.Errorf("apply() = %v, calls %v", err, calls)
+			}
+			continue
+		}
+
+		// Nothing was touched
+		var resizeErr *ResizeError
+		if !errors.As(err, &resizeErr) || resizeErr.Stage != ResizeStageNone {
+			t.Errorf("apply() = %v, want a ResizeError before any stage", err)
+		}
+		if info, _ := file.Stat(); uint64(info.Size()) != oldSize || len(calls) != 1 || len(exec.Lines()) != 0 {
+			t.Errorf("changed after the failed backup: size %d, calls %v, ran %v", info.Size(), calls, exec.Lines())
+		}
+	}
+}
+
+func TestCheckLUKSGrowth(t *testing.T) {
+	const (
+		before   = uint64(1 << 30)
+		expected = uint64(2 << 30)
+	)
+	tests := []struct {
+		name    string
+		after   uint64
+		wantErr string
+	}{
+		{"exact", expected, ""},
+		{"more", expected + 4096, ""},
+		{"within tolerance", expected - luksResizeTolerance, ""},
+		{"one sector short", expected - 512, ""},
+		{"beyond tolerance", expected - luksResizeTolerance - 1, "only grew to"},
+		{"barely grew", before + 512, "only grew to"},
+		{"unchanged", before, "did not grow"},
+		{"shrank", before - 512, "did not grow"},
+	}
+	for _, tt := range tests {
+		err := checkLUKSGrowth(before, tt.after, expected)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: checkLUKSGrowth() = %v", tt.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: checkLUKSGrowth() = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// StatsCommand handles aggregate usage reporting
+type StatsCommand struct {
+	ctx        *GlobalContext
+	json       bool
+	legacyJSON bool
+}
+
+// NewStatsCommand creates the stats command
+func NewStatsCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &StatsCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show aggregate usage across active containers",
+		Long: `Show totals across all active containers: how many are open, their
+combined size and usage, the average utilization, and the fullest container.
+
+Use 'brezno list' for per-container details.`,
+		Args: cobra.NoArgs,
+		RunE: cmd.Run,
+	}
+
+	cobraCmd.Flags().BoolVarP(&cmd.json, "json", "j", false, "JSON output")
+	cobraCmd.Flags().BoolVar(&cmd.legacyJSON, "legacy-json", false, "With --json, print the bare stats object without the schema_version envelope")
+
+	return cobraCmd
+}
+
+// Run executes the stats command
+func (c *StatsCommand) Run(cmd *cobra.Command, args []string) error {
+	if c.legacyJSON && !c.json {
+		return fmt.Errorf("--legacy-json requires --json")
+	}
+
+	// Like list, stats is read-only and degrades instead of requiring root
+	if !system.IsRoot() {
+		c.ctx.Logger.Warning("Not running as root, some details may be unavailable")
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	containers, err := c.ctx.Discovery.DiscoverActive(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover containers: %w", err)
+	}
+
+	stats := container.Aggregate(containers)
+
+	if c.json {
+		return ui.PrintJSON(ui.JSONOutput("stats", stats, c.legacyJSON))
+	}
+
+	fmt.Printf("Active containers:   %d\n", stats.Count)
+	fmt.Printf("Total size:          %s\n", system.FormatSize(stats.Allocated))
+	fmt.Printf("Total used:          %s\n", system.FormatSize(stats.Used))
+	fmt.Printf("Average utilization: %.1f%%\n", stats.AverageUtilization)
+	if stats.Fullest != nil {
+		fmt.Printf("Fullest container:   %s (%.1f%%)\n", stats.Fullest.Path, stats.Fullest.UsagePercent())
+	}
+
+	return nil
+}
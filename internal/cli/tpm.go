@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/spf13/cobra"
+)
+
+// TPMCommand manages TPM2-backed unlocking
+type TPMCommand struct {
+	ctx           *GlobalContext
+	keyfile       string
+	pcrs          string
+	passwordStdin bool
+}
+
+// NewTPMCommand creates the tpm command with its enroll subcommand
+func NewTPMCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &TPMCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "tpm",
+		Short: "Manage TPM2-backed unlocking",
+		Long: `Seal a container key to this machine's TPM2 so it can be mounted with
+'brezno mount --tpm' without typing a passphrase.
+
+Enrollment uses systemd-cryptenroll and adds a new key slot; existing
+passwords and keyfiles keep working.`,
+	}
+
+	enrollCmd := &cobra.Command{
+		Use:   "enroll <container-path>",
+		Short: "Enroll the TPM2 as an unlock method for a container",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  cmd.RunEnroll,
+	}
+	enrollCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Current keyfile path (if not set, will prompt for password)")
+	enrollCmd.Flags().StringVar(&cmd.pcrs, "pcrs", "", "PCRs to bind the key to (e.g., 7 or 0+7), default is systemd's")
+	enrollCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+
+	cobraCmd.AddCommand(enrollCmd)
+	return cobraCmd
+}
+
+// RunEnroll enrolls the TPM2 for a container
+func (c *TPMCommand) RunEnroll(cmd *cobra.Command, args []string) error {
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	if err := c.ctx.LUKSManager.CheckTPM(); err != nil {
+		return err
+	}
+
+	containerPath, err := c.ctx.resolveExistingLUKS(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	// An existing credential authorizes the new key slot
+	auth, err := GetAuthMethod(c.keyfile, "", false, c.passwordStdin, "Enter current password", "")
+	if err != nil {
+		return err
+	}
+	// Ensure password is zeroized when done
+	if pwAuth, ok := auth.(*container.PasswordAuth); ok {
+		defer pwAuth.Password.Zeroize()
+	}
+
+	c.ctx.Logger.Info("Enrolling TPM2 for %s...", containerPath)
+	if err := c.ctx.LUKSManager.EnrollTPM(ctx, containerPath, auth, c.pcrs); err != nil {
+		return err
+	}
+
+	c.ctx.Logger.Success("TPM2 enrolled successfully")
+	c.ctx.Logger.Info("Mount with: sudo brezno mount --tpm %s <mount-point>", containerPath)
+
+	return nil
+}
@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/nace/brezno/internal/container"
 	"github.com/nace/brezno/internal/system"
@@ -12,10 +15,19 @@ import (
 
 // UnmountCommand handles container unmounting
 type UnmountCommand struct {
-	ctx   *GlobalContext
-	force bool
+	ctx      *GlobalContext
+	force    bool
+	wipeFree bool
+	keepOpen bool
+
+	forceDetach bool
+	yes         bool
 }
 
+// holderGracePeriod is how long processes get to exit after SIGTERM
+// before --force-detach sends SIGKILL
+const holderGracePeriod = 5 * time.Second
+
 // NewUnmountCommand creates the unmount command
 func NewUnmountCommand(ctx *GlobalContext) *cobra.Command {
 	cmd := &UnmountCommand{ctx: ctx}
@@ -23,12 +35,29 @@ func NewUnmountCommand(ctx *GlobalContext) *cobra.Command {
 	cobraCmd := &cobra.Command{
 		Use:   "unmount <container-path|mount-point|mapper-name>",
 		Short: "Unmount an encrypted container",
-		Long:  `Unmount a LUKS encrypted container and close all associated resources.`,
-		Args:  cobra.MaximumNArgs(1),
-		RunE:  cmd.Run,
+		Long: `Unmount a LUKS encrypted container and close all associated resources.
+
+With --keep-open, only the filesystem is unmounted and the decrypted device
+(/dev/mapper/...) stays available, e.g. for fsck. Run unmount again without
+--keep-open to close it.`,
+		Example: `  # By container path, mount point, or mapper name
+  brezno unmount /data/secrets.img
+  brezno unmount /mnt/secrets
+
+  # Force unmount a busy filesystem
+  brezno unmount /mnt/secrets --force
+
+  # Stop the processes keeping it busy (SIGTERM, then SIGKILL), then unmount
+  brezno unmount /mnt/secrets --force-detach --yes`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: cmd.Run,
 	}
 
 	cobraCmd.Flags().BoolVarP(&cmd.force, "force", "f", false, "Force unmount (try umount -f, then umount -l)")
+	cobraCmd.Flags().BoolVar(&cmd.wipeFree, "wipe-free", false, "Discard freed blocks with fstrim before unmounting")
+	cobraCmd.Flags().BoolVar(&cmd.forceDetach, "force-detach", false, "If the filesystem is busy, kill the processes using it and retry (requires --yes)")
+	cobraCmd.Flags().BoolVarP(&cmd.yes, "yes", "y", false, "Confirm --force-detach")
+	cobraCmd.Flags().BoolVar(&cmd.keepOpen, "keep-open", false, "Unmount the filesystem but keep the LUKS container open")
 
 	return cobraCmd
 }
@@ -39,10 +68,16 @@ func (c *UnmountCommand) Run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	ctx := cmd.Context()
+
 	if err := c.ctx.CheckDependencies(); err != nil {
 		return err
 	}
 
+	if c.forceDetach && !c.yes {
+		return fmt.Errorf("--force-detach kills processes and requires --yes")
+	}
+
 	// Get identifier (path, mount point, or mapper name)
 	var identifier string
 	if len(args) > 0 {
@@ -60,14 +95,14 @@ func (c *UnmountCommand) Run(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to resolve path: %w", err)
 	}
-	cont, err = c.ctx.Discovery.FindByPath(absPath)
+	cont, err = c.ctx.Discovery.FindByPath(ctx, absPath)
 	if err != nil {
 		return err
 	}
 
 	// Try as mount point
 	if cont == nil {
-		cont, err = c.ctx.Discovery.FindByMount(identifier)
+		cont, err = c.ctx.Discovery.FindByMount(ctx, identifier)
 		if err != nil {
 			return err
 		}
@@ -75,7 +110,7 @@ func (c *UnmountCommand) Run(cmd *cobra.Command, args []string) error {
 
 	// Try as mapper name
 	if cont == nil {
-		cont, err = c.ctx.Discovery.FindByMapper(identifier)
+		cont, err = c.ctx.Discovery.FindByMapper(ctx, identifier)
 		if err != nil {
 			return err
 		}
@@ -85,31 +120,67 @@ func (c *UnmountCommand) Run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("no mounted container found matching: %s", identifier)
 	}
 
+	// Hold the container lock like the other commands, and look again
+	// once it is held, in case another operation just changed the state
+	if cont.Path != "" {
+		lock, err := lockContainer(strings.TrimSuffix(cont.Path, deletedSuffix))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		cont, err = c.ctx.Discovery.FindByMapper(ctx, cont.MapperName)
+		if err != nil {
+			return err
+		}
+		if cont == nil {
+			return fmt.Errorf("no mounted container found matching: %s", identifier)
+		}
+	}
+
+	if c.keepOpen && cont.MountPoint == "" {
+		return fmt.Errorf("container is not mounted (open as /dev/mapper/%s)", cont.MapperName)
+	}
+
 	// Execute unmount
-	return c.execute(cont)
+	return c.execute(ctx, cont)
 }
 
-func (c *UnmountCommand) execute(cont *container.Container) error {
-	// Step 1: Unmount filesystem (if mounted)
+func (c *UnmountCommand) execute(ctx context.Context, cont *container.Container) error {
+	// Step 1: Discard freed blocks (if requested)
+	if c.wipeFree && cont.MountPoint != "" {
+		c.trim(ctx, cont.MountPoint)
+	}
+
+	// Step 2: Unmount filesystem (if mounted)
 	if cont.MountPoint != "" {
 		c.ctx.Logger.Info("Unmounting filesystem from %s...", cont.MountPoint)
-		if err := c.ctx.MountMgr.Unmount(cont.MountPoint, c.force); err != nil {
+		err := c.ctx.MountMgr.Unmount(ctx, cont.MountPoint, c.force)
+		if err != nil && c.forceDetach {
+			err = c.detachHolders(ctx, cont.MountPoint, err)
+		}
+		if err != nil {
 			return fmt.Errorf("failed to unmount: %w", err)
 		}
 	}
 
-	// Step 2: Close LUKS container
+	if c.keepOpen {
+		c.ctx.Logger.Success("Filesystem unmounted, container still open as: /dev/mapper/%s", cont.MapperName)
+		return nil
+	}
+
+	// Step 3: Close LUKS container
 	if cont.MapperName != "" {
 		c.ctx.Logger.Info("Closing LUKS container...")
-		if err := c.ctx.LUKSManager.Close(cont.MapperName); err != nil {
+		if err := c.ctx.LUKSManager.Close(ctx, cont.MapperName); err != nil {
 			return fmt.Errorf("failed to close LUKS container: %w", err)
 		}
 	}
 
-	// Step 3: Detach loop device
+	// Step 4: Detach loop device
 	if cont.LoopDevice != "" {
 		c.ctx.Logger.Info("Detaching loop device...")
-		if err := c.ctx.LoopManager.Detach(cont.LoopDevice); err != nil {
+		if err := c.ctx.LoopManager.Detach(ctx, cont.LoopDevice); err != nil {
 			// Log warning but don't fail - loop device might auto-detach
 			c.ctx.Logger.Warning("Failed to detach loop device: %v", err)
 		}
@@ -122,3 +193,44 @@ func (c *UnmountCommand) execute(cont *container.Container) error {
 
 	return nil
 }
+
+// detachHolders stops the processes using mountPoint and retries the
+// unmount that failed with unmountErr. Every PID is listed before it is
+// signalled.
+func (c *UnmountCommand) detachHolders(ctx context.Context, mountPoint string, unmountErr error) error {
+	holders, err := system.FindHolders(mountPoint)
+	if err != nil {
+		return err
+	}
+	if len(holders) == 0 {
+		return fmt.Errorf("%w (no processes found using %s)", unmountErr, mountPoint)
+	}
+
+	c.ctx.Logger.Warning("Stopping %d process(es) using %s:", len(holders), mountPoint)
+	for _, h := range holders {
+		c.ctx.Logger.Warning("  PID %d (%s)", h.PID, h.Command)
+	}
+	if err := system.TerminateHolders(ctx, holders, holderGracePeriod); err != nil {
+		c.ctx.Logger.Warning("%v", err)
+	}
+
+	c.ctx.Logger.Info("Retrying unmount of %s...", mountPoint)
+	return system.RetryBusy(ctx, func() error {
+		return c.ctx.MountMgr.Unmount(ctx, mountPoint, c.force)
+	})
+}
+
+// trim discards freed blocks on the mounted filesystem. Failures are not
+// fatal: the unmount proceeds and the user is told why trimming was skipped.
+func (c *UnmountCommand) trim(ctx context.Context, mountPoint string) {
+	if !c.ctx.Executor.CommandExists("fstrim") {
+		c.ctx.Logger.Warning("fstrim not found (install util-linux), skipping --wipe-free")
+		return
+	}
+
+	c.ctx.Logger.Info("Discarding freed blocks on %s...", mountPoint)
+	if err := c.ctx.MountMgr.Trim(ctx, mountPoint); err != nil {
+		c.ctx.Logger.Warning("Skipping --wipe-free: %v", err)
+		c.ctx.Logger.Warning("Discards require a filesystem and LUKS mapping that allow them")
+	}
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+// mountedTestContainer is an open container mounted at /mnt/secrets
+func mountedTestContainer() *container.Container {
+	return &container.Container{
+		Path:       "/data/secrets.img",
+		MapperName: "secrets_img",
+		LoopDevice: testLoopDevice,
+		MountPoint: "/mnt/secrets",
+	}
+}
+
+func TestUnmountExecuteWipeFree(t *testing.T) {
+	tests := []struct {
+		name     string
+		wipeFree bool
+		exec     *testutil.FakeExecutor
+		want     []string
+		warning  string
+	}{
+		{
+			name:     "trimmed before unmounting",
+			wipeFree: true,
+			exec:     testutil.NewFakeExecutor(),
+			want:     []string{"fstrim /mnt/secrets", "umount /mnt/secrets", "losetup -d " + testLoopDevice},
+		},
+		{
+			name: "not requested",
+			exec: testutil.NewFakeExecutor(),
+			want: []string{"umount /mnt/secrets", "losetup -d " + testLoopDevice},
+		},
+		{
+			name:     "fstrim not installed",
+			wipeFree: true,
+			exec:     testutil.NewFakeExecutor().Missing("fstrim"),
+			want:     []string{"umount /mnt/secrets", "losetup -d " + testLoopDevice},
+			warning:  "fstrim not found",
+		},
+		{
+			name:     "discards not supported",
+			wipeFree: true,
+			exec:     testutil.NewFakeExecutor().On("fstrim", "", errors.New("the discard operation is not supported")),
+			want:     []string{"fstrim /mnt/secrets", "umount /mnt/secrets", "losetup -d " + testLoopDevice},
+			warning:  "Skipping --wipe-free",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestContext(tt.exec, newFakeCryptSetup())
+			c := &UnmountCommand{ctx: ctx, wipeFree: tt.wipeFree}
+			if err := c.execute(context.Background(), mountedTestContainer()); err != nil {
+				t.Fatalf("execute: %v", err)
+			}
+			if got := strings.Join(tt.exec.Lines(), "; "); got != strings.Join(tt.want, "; ") {
+				t.Errorf("ran %q, want %q", got, strings.Join(tt.want, "; "))
+			}
+			warnings := strings.Join(ctx.Warnings.List(), "\n")
+			if (tt.warning == "" && warnings != "") || !strings.Contains(warnings, tt.warning) {
+				t.Errorf("warnings = %q, want %q", warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestUnmountExecuteKeepOpen(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	luks := newFakeCryptSetup()
+	c := &UnmountCommand{ctx: newTestContext(exec, luks), keepOpen: true}
+	if err := c.execute(context.Background(), mountedTestContainer()); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if lines := exec.Lines(); len(lines) != 1 || lines[0] != "umount /mnt/secrets" {
+		t.Errorf("ran %q, want only the unmount", lines)
+	}
+	if calls := luks.Calls(); len(calls) != 0 {
+		t.Errorf("LUKS calls = %v, want the mapping kept open", calls)
+	}
+
+	// A failed unmount leaves everything as it was
+	exec = testutil.NewFakeExecutor().On("umount", "", testutil.ExitError("umount", 32, "target is busy."))
+	luks = newFakeCryptSetup()
+	c = &UnmountCommand{ctx: newTestContext(exec, luks), keepOpen: true}
+	if err := c.execute(context.Background(), mountedTestContainer()); err == nil || !strings.Contains(err.Error(), "failed to unmount") {
+		t.Errorf("execute with a busy filesystem = %v", err)
+	}
+	if lines := exec.Lines(); len(lines) != 1 || len(luks.Calls()) != 0 {
+		t.Errorf("ran %q and LUKS calls %v after the failed unmount", lines, luks.Calls())
+	}
+}
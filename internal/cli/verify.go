@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// VerifyCommand checks a passphrase or keyfile against a container
+type VerifyCommand struct {
+	ctx            *GlobalContext
+	keyfile        string
+	keyDescription string
+	passwordStdin  bool
+	passwordFile   string
+	json           bool
+}
+
+// verifyResult is the --json output of verify. Slot is left out when
+// the credentials didn't match or cryptsetup didn't report it.
+type verifyResult struct {
+	Valid bool `json:"valid"`
+	Slot  *int `json:"slot,omitempty"`
+}
+
+// NewVerifyCommand creates the verify command
+func NewVerifyCommand(ctx *GlobalContext) *cobra.Command {
+	cmd := &VerifyCommand{ctx: ctx}
+
+	cobraCmd := &cobra.Command{
+		Use:   "verify <container-path>",
+		Short: "Check a password or keyfile without opening the container",
+		Long: `Check whether a password or keyfile unlocks a LUKS container, without
+opening or mounting it.
+
+The exit code is 0 if it does and 2 if no keyslot matched. With --json,
+the result and the matching keyslot are also printed.`,
+		Example: `  # Check a password
+  brezno verify /data/secrets.img
+
+  # Check a keyfile, for scripts
+  brezno verify /data/secrets.img --keyfile ~/.keys/secret.key --json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: cmd.Run,
+	}
+
+	cobraCmd.Flags().StringVarP(&cmd.keyfile, "keyfile", "k", "", "Keyfile path (if not set, will prompt for password)")
+	cobraCmd.Flags().StringVar(&cmd.keyDescription, "key-description", "",
+		"Read the passphrase from the kernel keyring entry with this description")
+	cobraCmd.Flags().BoolVar(&cmd.passwordStdin, "password-stdin", false, "Read password from stdin (for automation)")
+	cobraCmd.Flags().StringVar(&cmd.passwordFile, "password-file", "", "Read the password from the first line of this file")
+	cobraCmd.Flags().BoolVarP(&cmd.json, "json", "j", false, "JSON output")
+
+	return cobraCmd
+}
+
+// Run executes the verify command
+func (c *VerifyCommand) Run(cmd *cobra.Command, args []string) error {
+	// Check root permissions
+	if err := system.RequireRoot(); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	// Check dependencies
+	if err := c.ctx.CheckDependencies(); err != nil {
+		return err
+	}
+
+	// Get container path (from args or prompt)
+	var containerPath string
+	if len(args) > 0 {
+		containerPath = args[0]
+	} else {
+		containerPath = ui.PromptString("Container file path")
+	}
+
+	// Convert to absolute path
+	absPath, err := filepath.Abs(containerPath)
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+	containerPath = absPath
+
+	// Verify container file exists
+	if _, err := os.Stat(containerPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("container file not found: %s", containerPath)
+		}
+		return fmt.Errorf("failed to access container: %w", err)
+	}
+
+	// Verify it's a LUKS container
+	isLuks, err := c.ctx.LUKSManager.IsLUKS(ctx, containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to check LUKS format: %w", err)
+	}
+	if !isLuks {
+		return fmt.Errorf("not a LUKS container: %s", containerPath)
+	}
+
+	var auth container.AuthMethod
+	if c.passwordFile != "" {
+		auth, err = GetPasswordFileAuth(c.passwordFile, c.keyfile, c.keyDescription, c.passwordStdin)
+	} else {
+		auth, err = GetAuthMethod(c.keyfile, c.keyDescription, false, c.passwordStdin, "", "")
+	}
+	if err != nil {
+		return err
+	}
+	switch a := auth.(type) {
+	case *container.PasswordAuth:
+		defer a.Password.Zeroize()
+	case *container.StdinKeyAuth:
+		defer a.Key.Zeroize()
+	}
+
+	return c.execute(ctx, containerPath, auth)
+}
+
+// execute tests auth against the container at containerPath and reports
+// the result. A mismatch returns an ExitCodeError with ExitWrongPassphrase.
+func (c *VerifyCommand) execute(ctx context.Context, containerPath string, auth container.AuthMethod) error {
+	slot, err := c.ctx.LUKSManager.TestPassphrase(ctx, containerPath, auth)
+	if err != nil && !errors.Is(err, container.ErrWrongPassphrase) {
+		return err
+	}
+
+	result := verifyResult{Valid: err == nil}
+	if result.Valid && slot >= 0 {
+		result.Slot = &slot
+	}
+	if c.json {
+		if printErr := ui.PrintJSON(ui.Envelope{Key: "result", Value: result}); printErr != nil {
+			return printErr
+		}
+	}
+
+	if !result.Valid {
+		return &ExitCodeError{Code: ExitWrongPassphrase, Err: err}
+	}
+	if result.Slot != nil {
+		c.ctx.Logger.Success("Credentials are valid for %s (keyslot %d)", containerPath, slot)
+	} else {
+		c.ctx.Logger.Success("Credentials are valid for %s", containerPath)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/container"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestVerifyExecuteJSON(t *testing.T) {
+	luks := newFakeCryptSetup()
+	luks.slot = 1
+	c := &VerifyCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), json: true}
+
+	var err error
+	out := captureStdout(t, func() { err = c.execute(context.Background(), "/data/x.img", passwordAuth("secret")) })
+	if err != nil {
+		t.Fatalf("execute() = %v", err)
+	}
+	if got := strings.Join(strings.Fields(out), ""); got != `{"schema_version":1,"result":{"valid":true,"slot":1}}` {
+		t.Errorf("output %s", got)
+	}
+}
+
+func TestVerifyExecuteWrongPassphrase(t *testing.T) {
+	luks := newFakeCryptSetup()
+	luks.errs["TestPassphrase"] = fmt.Errorf("failed to verify /data/x.img: %w", container.ErrWrongPassphrase)
+	c := &VerifyCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), json: true}
+
+	var err error
+	out := captureStdout(t, func() { err = c.execute(context.Background(), "/data/x.img", passwordAuth("wrong")) })
+	if code := ExitCode(err); code != ExitWrongPassphrase {
+		t.Errorf("exit code %d (%v), want %d", code, err, ExitWrongPassphrase)
+	}
+	if got := strings.Join(strings.Fields(out), ""); got != `{"schema_version":1,"result":{"valid":false}}` {
+		t.Errorf("output %s", got)
+	}
+}
+
+func TestVerifyExecuteFailure(t *testing.T) {
+	// Errors other than a wrong passphrase fail without a result
+	luks := newFakeCryptSetup()
+	luks.errs["TestPassphrase"] = fmt.Errorf("failed to verify /data/x.img: device busy")
+	c := &VerifyCommand{ctx: newTestContext(testutil.NewFakeExecutor(), luks), json: true}
+
+	var err error
+	out := captureStdout(t, func() { err = c.execute(context.Background(), "/data/x.img", passwordAuth("secret")) })
+	if err == nil || ExitCode(err) != ExitFailure {
+		t.Errorf("execute() = %v, want a plain failure", err)
+	}
+	if out != "" {
+		t.Errorf("printed %q", out)
+	}
+}
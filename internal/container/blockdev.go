@@ -0,0 +1,99 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nace/brezno/internal/system"
+)
+
+// CheckDeviceUnused returns an error if a block device, or any partition
+// on it, is mounted, used as swap, or held by another device such as a
+// dm-crypt mapping, LVM, or RAID. Formatting it would destroy data in use.
+func (d *Discovery) CheckDeviceUnused(device string) error {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", device, err)
+	}
+
+	mountinfo, err := d.mountSource.ReadMountinfo()
+	if err != nil {
+		return fmt.Errorf("failed to read mount table: %w", err)
+	}
+	swaps, err := d.mountSource.ReadSwaps()
+	if err != nil {
+		return fmt.Errorf("failed to read swap areas: %w", err)
+	}
+
+	use := deviceUse(d.sysRoot, resolved, system.ParseMountinfo(string(mountinfo)), parseSwaps(string(swaps)))
+	if use != "" {
+		return fmt.Errorf("refusing to format %s: %s", device, use)
+	}
+	return nil
+}
+
+// deviceUse explains how device (a /dev path) or one of its partitions is
+// in use, or returns "" if it isn't. Partitions and holders are read from
+// sysfs under sysRoot.
+func deviceUse(sysRoot, device string, mounts []system.MountinfoEntry, swaps []string) string {
+	for _, dev := range append([]string{device}, partitions(sysRoot, device)...) {
+		for _, m := range mounts {
+			source := m.Source
+			if resolved, err := filepath.EvalSymlinks(source); err == nil {
+				source = resolved
+			}
+			if source == dev {
+				return fmt.Sprintf("%s is mounted at %s", dev, m.MountPoint)
+			}
+		}
+		for _, swap := range swaps {
+			if swap == dev {
+				return fmt.Sprintf("%s is in use as swap", dev)
+			}
+		}
+		holders, _ := os.ReadDir(filepath.Join(sysRoot, "class", "block", filepath.Base(dev), "holders"))
+		if len(holders) > 0 {
+			return fmt.Sprintf("%s is held by %s", dev, holders[0].Name())
+		}
+	}
+	return ""
+}
+
+// partitions lists the /dev paths of the partitions on a whole disk,
+// which sysfs shows as subdirectories with a "partition" file
+func partitions(sysRoot, device string) []string {
+	name := filepath.Base(device)
+	entries, err := os.ReadDir(filepath.Join(sysRoot, "class", "block", name))
+	if err != nil {
+		return nil
+	}
+
+	var parts []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), name) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(sysRoot, "class", "block", name, e.Name(), "partition")); err == nil {
+			parts = append(parts, "/dev/"+e.Name())
+		}
+	}
+	return parts
+}
+
+// parseSwaps returns the swap areas listed in /proc/swaps:
+//
+//	Filename    Type        Size     Used  Priority
+//	/dev/sdb2   partition   1048572  0     -2
+func parseSwaps(data string) []string {
+	var swaps []string
+	for i, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) == 0 {
+			continue
+		}
+		swaps = append(swaps, system.UnescapeMountField(fields[0]))
+	}
+	return swaps
+}
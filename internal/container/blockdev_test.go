@@ -0,0 +1,86 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/system"
+)
+
+// fakeSysfs builds /sys/class/block for a disk sdb with partitions sdb1
+// and sdb2, and returns its root
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, dir := range []string{"sdb/sdb1", "sdb/sdb2", "sdb/holders", "sdb/queue", "sdb1/holders", "sdb2/holders"} {
+		if err := os.MkdirAll(filepath.Join(root, "class", "block", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, part := range []string{"sdb/sdb1", "sdb/sdb2"} {
+		if err := os.WriteFile(filepath.Join(root, "class", "block", part, "partition"), []byte("1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestDeviceUse(t *testing.T) {
+	mountedPart := system.ParseMountinfo("40 1 8:17 / /data rw - ext4 /dev/sdb1 rw\n")
+	mountedDisk := system.ParseMountinfo("40 1 8:16 / /data rw - ext4 /dev/sdb rw\n")
+	other := system.ParseMountinfo("40 1 8:1 / / rw - ext4 /dev/sda1 rw\n")
+
+	tests := []struct {
+		name    string
+		device  string
+		mounts  []system.MountinfoEntry
+		swaps   []string
+		holder  string // Holder entry to create, as "<dev>/<holder>"
+		wantUse string // Substring of the explanation, "" if unused
+	}{
+		{name: "unused disk", device: "/dev/sdb", mounts: other},
+		{name: "mounted disk", device: "/dev/sdb", mounts: mountedDisk, wantUse: "/dev/sdb is mounted at /data"},
+		{name: "mounted partition of disk", device: "/dev/sdb", mounts: mountedPart, wantUse: "/dev/sdb1 is mounted at /data"},
+		{name: "swap partition of disk", device: "/dev/sdb", swaps: []string{"/dev/sdb2"}, wantUse: "/dev/sdb2 is in use as swap"},
+		{name: "held disk", device: "/dev/sdb", holder: "sdb/dm-0", wantUse: "/dev/sdb is held by dm-0"},
+		{name: "held partition of disk", device: "/dev/sdb", holder: "sdb1/md0", wantUse: "/dev/sdb1 is held by md0"},
+		{name: "unused partition next to mounted one", device: "/dev/sdb2", mounts: mountedPart},
+		{name: "mounted partition itself", device: "/dev/sdb1", mounts: mountedPart, wantUse: "mounted at /data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t)
+			if tt.holder != "" {
+				dev, holder, _ := strings.Cut(tt.holder, "/")
+				if err := os.WriteFile(filepath.Join(root, "class", "block", dev, "holders", holder), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			use := deviceUse(root, tt.device, tt.mounts, tt.swaps)
+			if tt.wantUse == "" && use != "" {
+				t.Errorf("deviceUse = %q, want unused", use)
+			}
+			if tt.wantUse != "" && !strings.Contains(use, tt.wantUse) {
+				t.Errorf("deviceUse = %q, want %q", use, tt.wantUse)
+			}
+		})
+	}
+}
+
+func TestParseSwaps(t *testing.T) {
+	data := `Filename				Type		Size		Used		Priority
+/dev/sdb2                               partition	1048572		0		-2
+/swap\040file                           file		524284		0		-3
+`
+	want := []string{"/dev/sdb2", "/swap file"}
+	if got := parseSwaps(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSwaps = %v, want %v", got, want)
+	}
+	if got := parseSwaps("Filename Type Size Used Priority\n"); len(got) != 0 {
+		t.Errorf("no swap: got %v", got)
+	}
+}
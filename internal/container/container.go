@@ -1,5 +1,7 @@
 package container
 
+import "time"
+
 // Container represents a dm-crypt LUKS container
 type Container struct {
 	Path       string // Absolute path to container file
@@ -10,4 +12,37 @@ type Container struct {
 	Size       uint64 // Size in bytes
 	Used       uint64 // Used space in bytes
 	IsActive   bool   // Currently opened/mounted
+
+	// Managed is set for containers brezno created or opened, as opposed
+	// to other crypt devices on the system (e.g., LVM-on-LUKS or a LUKS
+	// root disk). Discovery only checks whether brezno opened it; the
+	// created by brezno part needs Discovery.ReadTokens.
+	Managed bool
+
+	// Created is when brezno created the container, from the marker token
+	// in its LUKS2 header. Nil for containers without one, or until
+	// Discovery.ReadTokens is called.
+	Created *time.Time `json:",omitempty"`
+
+	MountOptions []string // Per-mount options (e.g., rw, noatime)
+	ReadOnly     bool     // Mounted read-only
+
+	// OpenedSince approximates when the mapper device was opened: it is the
+	// change time of the device node, which a later chmod or chown (e.g. by
+	// udev) moves forward. It is not when the container was mounted. Nil if
+	// it could not be determined.
+	OpenedSince *time.Time `json:",omitempty"`
+
+	// Unreadable lists fields that could not be read, e.g. "size" when
+	// discovery ran without root. Such fields are left empty.
+	Unreadable []string `json:",omitempty"`
+}
+
+// UsagePercent returns the percentage of filesystem space in use,
+// or 0 if the size is unknown
+func (c *Container) UsagePercent() float64 {
+	if c.Size == 0 {
+		return 0
+	}
+	return float64(c.Used) / float64(c.Size) * 100
 }
@@ -2,47 +2,109 @@ package container
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/nace/brezno/internal/system"
 )
 
 // Discovery handles container discovery by querying system state
 type Discovery struct {
-	executor    *system.Executor
+	executor    system.Executor
 	loopManager *LoopManager
+	luks        *LUKSManager
+	mountSource MountSource
+	logger      system.WarningLogger
+	sysRoot     string // Where sysfs is mounted, normally /sys
+}
+
+// MountSource provides the mount table in /proc/<pid>/mountinfo format and
+// the active swap areas in /proc/swaps format
+type MountSource interface {
+	ReadMountinfo() ([]byte, error)
+	ReadSwaps() ([]byte, error)
+}
+
+// ProcMountSource reads the mount table from a proc filesystem
+type ProcMountSource struct {
+	Root string // Where proc is mounted, normally /proc
+}
+
+// ReadMountinfo reads <Root>/self/mountinfo
+func (s ProcMountSource) ReadMountinfo() ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.Root, "self", "mountinfo"))
+}
+
+// ReadSwaps reads <Root>/swaps
+func (s ProcMountSource) ReadSwaps() ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.Root, "swaps"))
 }
 
 // NewDiscovery creates a new discovery instance
-func NewDiscovery(executor *system.Executor) *Discovery {
+func NewDiscovery(executor system.Executor) *Discovery {
 	return &Discovery{
 		executor:    executor,
 		loopManager: NewLoopManager(executor),
+		luks:        NewLUKSManager(executor),
+		mountSource: ProcMountSource{Root: "/proc"},
+		sysRoot:     "/sys",
+	}
+}
+
+// SetMountSource replaces where discovery reads the mount table from,
+// e.g. a proc filesystem mounted elsewhere or a fixture
+func (d *Discovery) SetMountSource(source MountSource) {
+	d.mountSource = source
+}
+
+// SetLogger makes discovery report degraded results (e.g., an unreadable
+// mount table) as warnings
+func (d *Discovery) SetLogger(logger system.WarningLogger) {
+	d.logger = logger
+}
+
+// warn logs a warning if a logger is set
+func (d *Discovery) warn(format string, args ...interface{}) {
+	if d.logger != nil {
+		d.logger.Warning(format, args...)
+	}
+}
+
+// DiscoverActive discovers all active LUKS containers.
+// Without root, dmsetup cannot be used and discovery falls back to sysfs;
+// fields it cannot read are listed in Container.Unreadable.
+func (d *Discovery) DiscoverActive(ctx context.Context) ([]Container, error) {
+	if !system.IsRoot() {
+		return d.discoverUnprivileged(ctx)
 	}
+	return d.discoverPrivileged(ctx)
 }
 
-// DiscoverActive discovers all active LUKS containers
-func (d *Discovery) DiscoverActive() ([]Container, error) {
+// discoverPrivileged discovers containers with dmsetup and losetup, which
+// need root
+func (d *Discovery) discoverPrivileged(ctx context.Context) ([]Container, error) {
 	// Step 1: Get all crypt-type mapper devices
-	mappers, err := d.getCryptMappers()
+	mappers, err := d.getCryptMappers(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	// Step 2: Get all loop devices and their backing files
-	loopDevices, err := d.loopManager.GetAll()
+	loopDevices, err := d.loopManager.GetAll(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Step 3: Parse /proc/mounts to find mount points
-	mounts, err := d.getMounts()
-	if err != nil {
-		return nil, err
+	// Step 3: Parse /proc/self/mountinfo to find mount points.
+	// Without it, containers are still reported, just without mount details.
+	mounts, mountsErr := d.getMounts(ctx)
+	if mountsErr != nil {
+		d.warn("Mount information unavailable: %v", mountsErr)
 	}
 
 	// Step 4: Correlate all information
@@ -54,7 +116,7 @@ func (d *Discovery) DiscoverActive() ([]Container, error) {
 		}
 
 		// Get backing loop device from dmsetup table
-		loopDev, err := d.getMapperLoopDevice(mapper)
+		loopDev, err := d.getMapperLoopDevice(ctx, mapper)
 		if err != nil {
 			continue
 		}
@@ -65,25 +127,65 @@ func (d *Discovery) DiscoverActive() ([]Container, error) {
 		if backFile, ok := loopDevices[loopDev]; ok {
 			container.Path = backFile
 		}
+		container.Managed = IsManaged(container)
 
-		// Get mount information
-		mapperDevice := "/dev/mapper/" + mapper
-		if mount, ok := mounts[mapperDevice]; ok {
-			container.MountPoint = mount.MountPoint
-			container.Filesystem = mount.Filesystem
-			container.Size = mount.Size
-			container.Used = mount.Used
+		// The open time is informational only, leave it unset if unavailable
+		if since, err := d.getDeviceChangeTime("/dev/mapper/" + mapper); err == nil {
+			container.OpenedSince = &since
 		}
 
+		if mountsErr != nil {
+			container.Unreadable = append(container.Unreadable, "mount point")
+		} else {
+			d.applyMount(&container, mounts)
+		}
 		containers = append(containers, container)
 	}
 
 	return containers, nil
 }
 
+// ReadTokens also marks containers as managed whose header carries the
+// brezno marker token, and records when they were created. It runs
+// cryptsetup for each container, so discovery leaves it to the commands
+// that show Managed or Created.
+func (d *Discovery) ReadTokens(ctx context.Context, containers []Container) {
+	for i := range containers {
+		if containers[i].Path == "" {
+			continue
+		}
+
+		// The marker is informational, so a header that can't be read is
+		// not an error
+		token, err := d.luks.GetToken(ctx, containers[i].Path)
+		if err != nil || token == nil {
+			continue
+		}
+		containers[i].Managed = true
+		created := token.Created
+		containers[i].Created = &created
+	}
+}
+
+// applyMount fills in mount information for a container's mapper device
+func (d *Discovery) applyMount(container *Container, mounts map[string]MountInfo) {
+	mapperDevice := "/dev/mapper/" + container.MapperName
+	mount, ok := mounts[mapperDevice]
+	if !ok {
+		return
+	}
+
+	container.MountPoint = mount.MountPoint
+	container.Filesystem = mount.Filesystem
+	container.MountOptions = mount.MountOptions
+	container.ReadOnly = mount.ReadOnly
+	container.Size = mount.Size
+	container.Used = mount.Used
+}
+
 // FindByPath finds a container by its file path
-func (d *Discovery) FindByPath(path string) (*Container, error) {
-	containers, err := d.DiscoverActive()
+func (d *Discovery) FindByPath(ctx context.Context, path string) (*Container, error) {
+	containers, err := d.DiscoverActive(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -103,8 +205,8 @@ func (d *Discovery) FindByPath(path string) (*Container, error) {
 }
 
 // FindByMapper finds a container by its mapper name
-func (d *Discovery) FindByMapper(mapper string) (*Container, error) {
-	containers, err := d.DiscoverActive()
+func (d *Discovery) FindByMapper(ctx context.Context, mapper string) (*Container, error) {
+	containers, err := d.DiscoverActive(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -119,8 +221,8 @@ func (d *Discovery) FindByMapper(mapper string) (*Container, error) {
 }
 
 // FindByMount finds a container by its mount point
-func (d *Discovery) FindByMount(mount string) (*Container, error) {
-	containers, err := d.DiscoverActive()
+func (d *Discovery) FindByMount(ctx context.Context, mount string) (*Container, error) {
+	containers, err := d.DiscoverActive(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -140,8 +242,8 @@ func (d *Discovery) FindByMount(mount string) (*Container, error) {
 }
 
 // getCryptMappers returns all crypt-type device mapper names
-func (d *Discovery) getCryptMappers() ([]string, error) {
-	output, err := d.executor.RunOutput("dmsetup", "ls", "--target", "crypt")
+func (d *Discovery) getCryptMappers(ctx context.Context) ([]string, error) {
+	output, err := d.executor.RunOutput(ctx, "dmsetup", "ls", "--target", "crypt")
 	if err != nil {
 		// dmsetup returns error if no devices found
 		return []string{}, nil
@@ -162,8 +264,8 @@ func (d *Discovery) getCryptMappers() ([]string, error) {
 }
 
 // getMapperLoopDevice gets the backing loop device for a mapper
-func (d *Discovery) getMapperLoopDevice(mapper string) (string, error) {
-	output, err := d.executor.RunOutput("dmsetup", "table", mapper)
+func (d *Discovery) getMapperLoopDevice(ctx context.Context, mapper string) (string, error) {
+	output, err := d.executor.RunOutput(ctx, "dmsetup", "table", mapper)
 	if err != nil {
 		return "", err
 	}
@@ -187,73 +289,78 @@ func (d *Discovery) getMapperLoopDevice(mapper string) (string, error) {
 	return device, nil
 }
 
+// getDeviceChangeTime returns the change time of a device node. devtmpfs
+// sets it when the device is created, so it roughly dates a mapper's open.
+func (d *Discovery) getDeviceChangeTime(device string) (time.Time, error) {
+	// Follow the /dev/mapper symlink to the underlying dm-N node
+	info, err := os.Stat(device)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime(), nil
+	}
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec)), nil
+}
+
 // MountInfo represents mount information
 type MountInfo struct {
-	Device     string
-	MountPoint string
-	Filesystem string
-	Size       uint64
-	Used       uint64
+	Device       string
+	MountPoint   string
+	Filesystem   string
+	MountOptions []string
+	ReadOnly     bool
+	Size         uint64
+	Used         uint64
 }
 
-// getMounts parses /proc/mounts to find mount points
-func (d *Discovery) getMounts() (map[string]MountInfo, error) {
-	data, err := os.ReadFile("/proc/mounts")
+// getMounts parses the mount table to find mount points
+func (d *Discovery) getMounts(ctx context.Context) (map[string]MountInfo, error) {
+	data, err := d.mountSource.ReadMountinfo()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
 	}
 
 	mounts := make(map[string]MountInfo)
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
-		if len(fields) >= 3 {
-			device := fields[0]
-			// Only track /dev/mapper/* devices
-			if strings.HasPrefix(device, "/dev/mapper/") {
-				info := MountInfo{
-					Device:     device,
-					MountPoint: fields[1],
-					Filesystem: fields[2],
-				}
-
-				// Try to get size information using df
-				if size, used, err := d.getDiskUsage(fields[1]); err == nil {
-					info.Size = size
-					info.Used = used
-				}
-
-				mounts[device] = info
-			}
+	for _, entry := range system.ParseMountinfo(string(data)) {
+		// Only track /dev/mapper/* devices
+		if !strings.HasPrefix(entry.Source, "/dev/mapper/") {
+			continue
 		}
+
+		info := MountInfo{
+			Device:       entry.Source,
+			MountPoint:   entry.MountPoint,
+			Filesystem:   entry.Filesystem,
+			MountOptions: entry.Options,
+			ReadOnly:     entry.ReadOnly(),
+		}
+
+		// Try to get size information using df (or btrfs)
+		if size, used, err := d.getDiskUsage(ctx, entry.MountPoint, entry.Filesystem); err == nil {
+			info.Size = size
+			info.Used = used
+		}
+
+		mounts[entry.Source] = info
 	}
 
 	return mounts, nil
 }
 
 // getDiskUsage gets disk usage for a mount point
-func (d *Discovery) getDiskUsage(mountPoint string) (size uint64, used uint64, err error) {
-	output, err := d.executor.RunOutput("df", "--block-size=1", mountPoint)
-	if err != nil {
-		return 0, 0, err
-	}
-
-	// Parse df output
-	// Header: Filesystem     1B-blocks      Used Available Use% Mounted on
-	// Data:   /dev/mapper/x  1234567890  123456  ...
-	lines := strings.Split(output, "\n")
-	if len(lines) < 2 {
-		return 0, 0, fmt.Errorf("invalid df output")
+func (d *Discovery) getDiskUsage(ctx context.Context, mountPoint, fsType string) (size uint64, used uint64, err error) {
+	if fsType == "btrfs" {
+		if size, used, err := btrfsUsage(ctx, d.executor, mountPoint); err == nil {
+			return size, used, nil
+		}
 	}
 
-	fields := strings.Fields(lines[1])
-	if len(fields) < 3 {
-		return 0, 0, fmt.Errorf("invalid df output format")
+	output, err := d.executor.RunOutput(ctx, "df", "--block-size=1", mountPoint)
+	if err != nil {
+		return 0, 0, err
 	}
-
-	// Parse size and used
-	fmt.Sscanf(fields[1], "%d", &size)
-	fmt.Sscanf(fields[2], "%d", &used)
-
-	return size, used, nil
+	return parseDf(output)
 }
@@ -0,0 +1,290 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nace/brezno/internal/testutil"
+)
+
+// fixtureMounts is a MountSource serving a fixed mount table and swaps
+type fixtureMounts struct {
+	data  string
+	swaps string
+	err   error
+}
+
+func (f fixtureMounts) ReadMountinfo() ([]byte, error) {
+	return []byte(f.data), f.err
+}
+
+func (f fixtureMounts) ReadSwaps() ([]byte, error) {
+	return []byte(f.swaps), f.err
+}
+
+const (
+	sampleLosetupJSON = `{
+   "loopdevices": [
+      {"name": "/dev/loop0", "back-file": "/data/secrets.img"},
+      {"name": "/dev/loop1", "back-file": "/data/open only.img"},
+      {"name": "/dev/loop2", "back-file": null}
+   ]
+}`
+	sampleMountinfo = `22 1 0:21 / /proc rw,nosuid - proc proc rw
+36 22 253:0 / /mnt/secrets rw,relatime shared:1 - ext4 /dev/mapper/brezno_secrets rw
+`
+	sampleDf = `Filesystem                1B-blocks      Used  Available Use% Mounted on
+/dev/mapper/brezno_secrets 1000000    250000     750000  25% /mnt/secrets
+`
+)
+
+// newTestDiscovery returns a discovery over two crypt devices on loop0
+// and loop1, of which only the first is mounted
+func newTestDiscovery(mounts MountSource) (*Discovery, *testutil.FakeExecutor) {
+	exec := testutil.NewFakeExecutor().
+		On("dmsetup ls --target crypt", "brezno_secrets\t(253:0)\nbrezno_open\t(253:1)\n", nil).
+		On("dmsetup table brezno_secrets", "0 2064384 crypt aes-xts-plain64 :64:logon:cryptsetup:x 0 7:0 32768\n", nil).
+		On("dmsetup table brezno_open", "0 2064384 crypt aes-xts-plain64 :64:logon:cryptsetup:x 0 7:1 32768\n", nil).
+		On("losetup -l -J", sampleLosetupJSON, nil).
+		On("df --block-size=1 /mnt/secrets", sampleDf, nil)
+	d := NewDiscovery(exec)
+	d.SetMountSource(mounts)
+	return d, exec
+}
+
+func TestDiscoverPrivileged(t *testing.T) {
+	d, exec := newTestDiscovery(fixtureMounts{data: sampleMountinfo})
+
+	containers, err := d.discoverPrivileged(context.Background())
+	if err != nil {
+		t.Fatalf("discoverPrivileged: %v", err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("got %d containers, want 2", len(containers))
+	}
+
+	mounted := containers[0]
+	if mounted.Path != "/data/secrets.img" || mounted.LoopDevice != "/dev/loop0" {
+		t.Errorf("mounted container = %s on %s", mounted.Path, mounted.LoopDevice)
+	}
+	if mounted.MountPoint != "/mnt/secrets" || mounted.Filesystem != "ext4" {
+		t.Errorf("mount = %s (%s), want /mnt/secrets (ext4)", mounted.MountPoint, mounted.Filesystem)
+	}
+	if mounted.Size != 1000000 || mounted.Used != 250000 {
+		t.Errorf("usage = %d/%d, want 250000/1000000", mounted.Used, mounted.Size)
+	}
+	if !reflect.DeepEqual(mounted.MountOptions, []string{"rw", "relatime"}) {
+		t.Errorf("mount options = %v", mounted.MountOptions)
+	}
+
+	open := containers[1]
+	if open.Path != "/data/open only.img" || !open.IsActive {
+		t.Errorf("open container = %+v", open)
+	}
+	if open.MountPoint != "" || len(open.Unreadable) != 0 {
+		t.Errorf("open-only container has mount point %q, unreadable %v", open.MountPoint, open.Unreadable)
+	}
+
+	// Headers are only read for the commands that show the marker
+	if _, ok := exec.Ran("cryptsetup luksDump"); ok {
+		t.Errorf("discovery read LUKS headers: %v", exec.Lines())
+	}
+}
+
+func TestDiscoverPrivilegedWithoutMountTable(t *testing.T) {
+	d, _ := newTestDiscovery(fixtureMounts{err: errors.New("permission denied")})
+
+	containers, err := d.discoverPrivileged(context.Background())
+	if err != nil {
+		t.Fatalf("discoverPrivileged: %v", err)
+	}
+	for _, c := range containers {
+		if !reflect.DeepEqual(c.Unreadable, []string{"mount point"}) {
+			t.Errorf("%s: unreadable = %v, want [mount point]", c.MapperName, c.Unreadable)
+		}
+	}
+}
+
+func TestGetCryptMappersNone(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("dmsetup ls", "", errors.New("No devices found"))
+	mappers, err := NewDiscovery(exec).getCryptMappers(context.Background())
+	if err != nil || len(mappers) != 0 {
+		t.Errorf("getCryptMappers = %v, %v; want none", mappers, err)
+	}
+}
+
+func TestGetMapperLoopDevice(t *testing.T) {
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{"0 2064384 crypt aes-xts-plain64 :64:logon:x 0 7:3 32768", "/dev/loop3"},
+		{"0 2064384 crypt aes-xts-plain64 :64:logon:x 0 8:17 32768", "8:17"},
+		{"0 2064384 crypt aes-xts-plain64 :64:logon:x 0 /dev/loop9 32768", "/dev/loop9"},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().On("dmsetup table", tt.table, nil)
+		got, err := NewDiscovery(exec).getMapperLoopDevice(context.Background(), "m")
+		if err != nil || got != tt.want {
+			t.Errorf("table %q: got %q, %v; want %q", tt.table, got, err, tt.want)
+		}
+	}
+
+	exec := testutil.NewFakeExecutor().On("dmsetup table", "0 100 linear", nil)
+	if _, err := NewDiscovery(exec).getMapperLoopDevice(context.Background(), "m"); err == nil {
+		t.Error("short table: expected an error")
+	}
+}
+
+func TestGetDiskUsage(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("df", sampleDf, nil)
+	size, used, err := NewDiscovery(exec).getDiskUsage(context.Background(), "/mnt/secrets", "ext4")
+	if err != nil || size != 1000000 || used != 250000 {
+		t.Errorf("getDiskUsage = %d, %d, %v", size, used, err)
+	}
+
+	for _, output := range []string{"", "Filesystem 1B-blocks Used\n", "Filesystem\n/dev/x abc 1\n"} {
+		exec := testutil.NewFakeExecutor().On("df", output, nil)
+		if _, _, err := NewDiscovery(exec).getDiskUsage(context.Background(), "/mnt", "ext4"); err == nil {
+			t.Errorf("df output %q: expected an error", output)
+		}
+	}
+}
+
+func TestGetDeviceChangeTime(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	path := filepath.Join(t.TempDir(), "dm-0")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscovery(testutil.NewFakeExecutor())
+	since, err := d.getDeviceChangeTime(path)
+	if err != nil {
+		t.Fatalf("getDeviceChangeTime: %v", err)
+	}
+	if since.Before(before) || since.After(time.Now()) {
+		t.Errorf("change time %v is not around now", since)
+	}
+
+	if _, err := d.getDeviceChangeTime(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing device: expected an error")
+	}
+}
+
+func TestReadTokens(t *testing.T) {
+	exec := testutil.NewFakeExecutor().
+		On("cryptsetup luksDump --dump-json-metadata /data/created.img", sampleJSONMetadata, nil).
+		On("cryptsetup luksDump --dump-json-metadata /data/plain.img", `{"tokens": {}}`, nil).
+		On("cryptsetup luksDump --dump-json-metadata /data/broken.img", "", errors.New("no header"))
+	d := NewDiscovery(exec)
+
+	containers := []Container{
+		{Path: "/data/created.img", MapperName: "luks-1"},
+		{Path: "/data/plain.img", MapperName: "plain_img", Managed: true},
+		{Path: "/data/broken.img", MapperName: "luks-2"},
+		{MapperName: "luks-3"},
+	}
+	d.ReadTokens(context.Background(), containers)
+
+	if c := containers[0]; !c.Managed || c.Created == nil || c.Created.Year() != 2026 {
+		t.Errorf("container with a marker: managed %v, created %v", c.Managed, c.Created)
+	}
+	if c := containers[1]; !c.Managed || c.Created != nil {
+		t.Errorf("container opened by brezno without a marker: managed %v, created %v", c.Managed, c.Created)
+	}
+	if c := containers[2]; c.Managed || c.Created != nil {
+		t.Errorf("unreadable header: managed %v, created %v", c.Managed, c.Created)
+	}
+	if lines := exec.Lines(); len(lines) != 3 {
+		t.Errorf("ran %v, want one luksDump per container with a path", lines)
+	}
+}
+
+// writeSysfsFixture creates the files under sysRoot, each holding its value
+// followed by a newline as sysfs attributes do
+func writeSysfsFixture(t *testing.T, sysRoot string, files map[string]string) {
+	t.Helper()
+	for name, value := range files {
+		path := filepath.Join(sysRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiscoverUnprivileged(t *testing.T) {
+	mountinfo := sampleMountinfo + "37 22 253:3 / /mnt/locked rw,relatime shared:2 - ext4 /dev/mapper/locked_img rw\n"
+	d, exec := newTestDiscovery(fixtureMounts{data: mountinfo})
+	exec.On("df --block-size=1 /mnt/locked", "", testutil.ExitError("df", 1, "df: /mnt/locked: Permission denied"))
+	d.sysRoot = t.TempDir()
+	writeSysfsFixture(t, d.sysRoot, map[string]string{
+		// Mounted, everything readable
+		"block/dm-0/dm/uuid":            "CRYPT-LUKS2-1234-brezno_secrets",
+		"block/dm-0/dm/name":            "brezno_secrets",
+		"block/dm-0/slaves/loop0":       "",
+		"block/loop0/loop/backing_file": "/data/secrets.img",
+		// Open only, its loop device's backing file hidden
+		"block/dm-1/dm/uuid":      "CRYPT-LUKS2-5678-brezno_open",
+		"block/dm-1/dm/name":      "brezno_open",
+		"block/dm-1/slaves/loop1": "",
+		// Not a crypt mapping
+		"block/dm-2/dm/uuid": "LVM-abcd",
+		"block/dm-2/dm/name": "vg-root",
+		// Mounted where df may not look
+		"block/dm-3/dm/uuid":            "CRYPT-LUKS2-9abc-locked_img",
+		"block/dm-3/dm/name":            "locked_img",
+		"block/dm-3/slaves/loop3":       "",
+		"block/loop3/loop/backing_file": "/data/locked.img",
+	})
+
+	containers, err := d.discoverUnprivileged(context.Background())
+	if err != nil {
+		t.Fatalf("discoverUnprivileged: %v", err)
+	}
+	want := []Container{
+		{MapperName: "brezno_secrets", LoopDevice: "/dev/loop0", Path: "/data/secrets.img", IsActive: true,
+			MountPoint: "/mnt/secrets", Filesystem: "ext4", MountOptions: []string{"rw", "relatime"}, Size: 1000000, Used: 250000},
+		{MapperName: "brezno_open", LoopDevice: "/dev/loop1", IsActive: true, Unreadable: []string{"path"}},
+		{MapperName: "locked_img", LoopDevice: "/dev/loop3", Path: "/data/locked.img", IsActive: true, Managed: true,
+			MountPoint: "/mnt/locked", Filesystem: "ext4", MountOptions: []string{"rw", "relatime"}, Unreadable: []string{"size"}},
+	}
+	if len(containers) != len(want) {
+		t.Fatalf("found %+v, want %d containers", containers, len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(containers[i], want[i]) {
+			t.Errorf("container %d = %+v, want %+v", i, containers[i], want[i])
+		}
+	}
+	if lines := exec.Lines(); strings.Contains(strings.Join(lines, "\n"), "dmsetup") {
+		t.Errorf("ran dmsetup without root: %v", lines)
+	}
+}
+
+func TestDiscoverUnprivilegedWithoutMountTable(t *testing.T) {
+	d, _ := newTestDiscovery(fixtureMounts{err: errors.New("permission denied")})
+	d.sysRoot = t.TempDir()
+	writeSysfsFixture(t, d.sysRoot, map[string]string{
+		"block/dm-1/dm/uuid":      "CRYPT-LUKS2-5678-brezno_open",
+		"block/dm-1/dm/name":      "brezno_open",
+		"block/dm-1/slaves/loop1": "",
+	})
+
+	containers, err := d.discoverUnprivileged(context.Background())
+	if err != nil {
+		t.Fatalf("discoverUnprivileged: %v", err)
+	}
+	if len(containers) != 1 || !reflect.DeepEqual(containers[0].Unreadable, []string{"path", "mount point"}) {
+		t.Errorf("found %+v, want one container missing its path and mount point", containers)
+	}
+}
@@ -0,0 +1,42 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// hashChunkSize is how much of a device is read between cancellation checks
+const hashChunkSize = 4 * 1024 * 1024
+
+// HashDevice returns the hex SHA-256 digest of the full contents of a
+// device, e.g. an open /dev/mapper device. It stops early if ctx is
+// cancelled.
+func HashDevice(ctx context.Context, device string) (string, error) {
+	file, err := os.Open(device)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	buf := make([]byte, hashChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := file.Read(buf)
+		hash.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", device, err)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,48 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashDevice(t *testing.T) {
+	// Larger than a chunk, so the digest spans several reads
+	data := make([]byte, hashChunkSize+12345)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), "device")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+
+	got, err := HashDevice(context.Background(), path)
+	if err != nil || got != hex.EncodeToString(sum[:]) {
+		t.Errorf("HashDevice = %s, %v; want %x", got, err, sum)
+	}
+
+	// One changed byte changes the digest
+	data[len(data)-1]++
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := HashDevice(context.Background(), path); err != nil || changed == got {
+		t.Errorf("HashDevice after a change = %s, %v", changed, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := HashDevice(ctx, path); !errors.Is(err, context.Canceled) {
+		t.Errorf("HashDevice cancelled = %v", err)
+	}
+
+	if _, err := HashDevice(context.Background(), filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("HashDevice of a missing device succeeded")
+	}
+}
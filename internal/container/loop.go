@@ -1,8 +1,14 @@
 package container
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/nace/brezno/internal/system"
@@ -10,37 +16,118 @@ import (
 
 // LoopManager handles loop device operations
 type LoopManager struct {
-	executor *system.Executor
+	executor system.Executor
+	sysRoot  string // Where sysfs is mounted, normally /sys
 }
 
 // NewLoopManager creates a new loop manager
-func NewLoopManager(executor *system.Executor) *LoopManager {
+func NewLoopManager(executor system.Executor) *LoopManager {
 	return &LoopManager{
 		executor: executor,
+		sysRoot:  "/sys",
 	}
 }
 
+// AttachOptions controls how a file is attached to a loop device
+type AttachOptions struct {
+	ReadOnly bool   // Create a read-only loop device (losetup -r)
+	Offset   uint64 // Start of the device within the file in bytes (losetup -o)
+}
+
 // Attach attaches a file to a loop device
-func (m *LoopManager) Attach(path string) (string, error) {
-	output, err := m.executor.RunOutput("losetup", "-f", "--show", path)
+func (m *LoopManager) Attach(ctx context.Context, path string, opts AttachOptions) (string, error) {
+	output, err := m.executor.RunOutput(ctx, "losetup", attachArgs(path, opts)...)
+	return m.attached(ctx, output, err)
+}
+
+// AttachFd attaches an already open file to a loop device. losetup gets
+// the descriptor itself and opens it through /proc/self/fd, so the path
+// the file was opened by is never resolved again.
+func (m *LoopManager) AttachFd(ctx context.Context, f *os.File, opts AttachOptions) (string, error) {
+	cmd := exec.CommandContext(ctx, "losetup", attachArgs(inheritedFdPath(0), opts)...)
+	cmd.ExtraFiles = []*os.File{f}
+	output, err := m.executor.RunCmd(cmd)
+	return m.attached(ctx, output, err)
+}
+
+// inheritedFdPath returns the path a child process opens the i-th entry of
+// exec.Cmd.ExtraFiles by; they follow stdin, stdout, and stderr
+func inheritedFdPath(i int) string {
+	return fmt.Sprintf("/proc/self/fd/%d", 3+i)
+}
+
+// attachArgs builds the losetup arguments to attach path
+func attachArgs(path string, opts AttachOptions) []string {
+	args := []string{"-f", "--show"}
+	if opts.ReadOnly {
+		args = append(args, "-r")
+	}
+	if opts.Offset > 0 {
+		args = append(args, "-o", strconv.FormatUint(opts.Offset, 10))
+	}
+	return append(args, path)
+}
+
+// attached turns the result of losetup --show into the new loop device
+func (m *LoopManager) attached(ctx context.Context, output string, err error) (string, error) {
 	if err != nil {
+		if isLoopExhausted(err) {
+			return "", m.exhaustedError(ctx)
+		}
 		return "", fmt.Errorf("failed to attach loop device: %w", err)
 	}
 	return strings.TrimSpace(output), nil
 }
 
+// ErrNoFreeLoopDevice is returned by Attach when every loop device is in
+// use and no more can be created
+var ErrNoFreeLoopDevice = errors.New("no free loop device")
+
+// isLoopExhausted reports whether losetup failed for lack of a free device
+func isLoopExhausted(err error) bool {
+	return strings.Contains(err.Error(), "could not find any free loop device")
+}
+
+// exhaustedError explains how to get a loop device back, including how
+// many are in use when that can be determined
+func (m *LoopManager) exhaustedError(ctx context.Context) error {
+	inUse := ""
+	if devices, listErr := m.GetAll(ctx); listErr == nil {
+		inUse = fmt.Sprintf(" (%d in use)", len(devices))
+	}
+	return fmt.Errorf("%w%s\n"+
+		"Detach loop devices that are no longer needed (list them with 'losetup -l', detach one with 'losetup -d /dev/loopN'),\n"+
+		"or raise the limit with the max_loop parameter of the loop module (e.g., max_loop=64 on the kernel command line)",
+		ErrNoFreeLoopDevice, inUse)
+}
+
 // Detach detaches a loop device
-func (m *LoopManager) Detach(device string) error {
-	err := m.executor.Run("losetup", "-d", device)
+func (m *LoopManager) Detach(ctx context.Context, device string) error {
+	err := m.executor.Run(ctx, "losetup", "-d", device)
 	if err != nil {
 		return fmt.Errorf("failed to detach loop device %s: %w", device, err)
 	}
 	return nil
 }
 
+// IsAttached reports whether a loop device still has a backing file
+func IsAttached(device string) bool {
+	_, err := os.Stat(filepath.Join("/sys/block", filepath.Base(device), "loop", "backing_file"))
+	return err == nil
+}
+
+// IsReadOnly reports whether the kernel treats a loop device as read-only
+func IsReadOnly(device string) (bool, error) {
+	ro, err := os.ReadFile(filepath.Join("/sys/block", filepath.Base(device), "ro"))
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s read-only flag: %w", device, err)
+	}
+	return strings.TrimSpace(string(ro)) == "1", nil
+}
+
 // FindByFile finds the loop device for a file
-func (m *LoopManager) FindByFile(path string) (string, error) {
-	output, err := m.executor.RunOutput("losetup", "-j", path)
+func (m *LoopManager) FindByFile(ctx context.Context, path string) (string, error) {
+	output, err := m.executor.RunOutput(ctx, "losetup", "-j", path)
 	if err != nil || output == "" {
 		return "", nil
 	}
@@ -63,15 +150,28 @@ type losetupOutput struct {
 	LoopDevices []losetupDevice `json:"loopdevices"`
 }
 
-// GetAll returns all loop devices with their backing files
-func (m *LoopManager) GetAll() (map[string]string, error) {
-	output, err := m.executor.RunOutput("losetup", "-l", "-J")
+// GetAll returns all loop devices with their backing files. losetup
+// before util-linux 2.27 has no JSON output, in which case the plain
+// listing is parsed instead.
+func (m *LoopManager) GetAll(ctx context.Context) (map[string]string, error) {
+	output, err := m.executor.RunOutput(ctx, "losetup", "-l", "-J")
 	if err != nil {
+		if isUnsupportedOption(err) {
+			return m.getAllPlain(ctx)
+		}
 		return nil, fmt.Errorf("failed to list loop devices: %w", err)
 	}
+	// With no loop devices in use, losetup prints nothing at all
+	if strings.TrimSpace(output) == "" {
+		return map[string]string{}, nil
+	}
 
 	var result losetupOutput
 	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		// Some versions accept -J but ignore it
+		if devices, plainErr := m.getAllPlain(ctx); plainErr == nil {
+			return devices, nil
+		}
 		return nil, fmt.Errorf("failed to parse losetup output: %w", err)
 	}
 
@@ -85,26 +185,105 @@ func (m *LoopManager) GetAll() (map[string]string, error) {
 	return devices, nil
 }
 
-// RefreshSize updates the loop device to recognize the new size of its backing file
-func (m *LoopManager) RefreshSize(device string) error {
-	err := m.executor.Run("losetup", "-c", device)
+// getAllPlain lists loop devices from losetup's table output, for
+// versions without -J
+func (m *LoopManager) getAllPlain(ctx context.Context) (map[string]string, error) {
+	output, err := m.executor.RunOutput(ctx, "losetup", "-l", "-n", "-O", "NAME,BACK-FILE")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list loop devices: %w", err)
+	}
+	return parseLosetupTable(output), nil
+}
+
+// parseLosetupTable parses "losetup -l -n -O NAME,BACK-FILE" output:
+//
+//	/dev/loop0 /data/secrets.img
+//	/dev/loop1 /data/file with spaces.img
+//
+// The backing file is the rest of the line, as it may contain spaces.
+// Devices without one are left out.
+func parseLosetupTable(output string) map[string]string {
+	devices := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, backFile, _ := strings.Cut(strings.TrimSpace(line), " ")
+		backFile = strings.TrimSpace(backFile)
+		if name != "" && backFile != "" {
+			devices[name] = backFile
+		}
+	}
+	return devices
+}
+
+// ErrRefreshUnsupported is returned by RefreshSize when losetup predates
+// -c (--set-capacity, util-linux 2.17)
+var ErrRefreshUnsupported = errors.New("losetup does not support -c (--set-capacity), upgrade util-linux")
+
+// RefreshSize updates the loop device to recognize the new size of its
+// backing file. Callers should check the result with VerifySize, since
+// some kernels accept the request without applying it.
+func (m *LoopManager) RefreshSize(ctx context.Context, device string) error {
+	err := m.executor.Run(ctx, "losetup", "-c", device)
 	if err != nil {
+		if isUnsupportedOption(err) {
+			return fmt.Errorf("failed to refresh loop device size %s: %w", device, ErrRefreshUnsupported)
+		}
 		return fmt.Errorf("failed to refresh loop device size %s: %w", device, err)
 	}
 	return nil
 }
 
+// isUnsupportedOption reports whether a util-linux tool rejected an option
+// it doesn't know
+func isUnsupportedOption(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "invalid option") || strings.Contains(msg, "unrecognized option")
+}
+
+// VerifySize checks that a loop device covers a backing file of fileSize
+// bytes, less the offset it was attached at
+func (m *LoopManager) VerifySize(ctx context.Context, device string, fileSize uint64) error {
+	offset, err := loopOffset(m.sysRoot, device)
+	if err != nil {
+		return err
+	}
+
+	size, err := m.GetDeviceSize(ctx, device)
+	if err != nil {
+		return err
+	}
+	if expected := fileSize - min(offset, fileSize); size < expected {
+		return fmt.Errorf("loop device %s is %d bytes, expected %d", device, size, expected)
+	}
+	return nil
+}
+
+// loopOffset returns where in its backing file a loop device starts, from
+// sysfs under sysRoot, or 0 if sysfs doesn't have the device
+func loopOffset(sysRoot, device string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(sysRoot, "block", filepath.Base(device), "loop", "offset"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read %s offset: %w", device, err)
+	}
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s offset %q: %w", device, strings.TrimSpace(string(data)), err)
+	}
+	return offset, nil
+}
+
 // GetDeviceSize gets the size of a loop device in bytes
-func (m *LoopManager) GetDeviceSize(device string) (uint64, error) {
-	output, err := m.executor.RunOutput("blockdev", "--getsize64", device)
+func (m *LoopManager) GetDeviceSize(ctx context.Context, device string) (uint64, error) {
+	output, err := m.executor.RunOutput(ctx, "blockdev", "--getsize64", device)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get device size: %w", err)
 	}
 
-	var size uint64
-	_, err = fmt.Sscanf(strings.TrimSpace(output), "%d", &size)
+	size, err := strconv.ParseUint(strings.TrimSpace(output), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse device size: %w", err)
+		return 0, fmt.Errorf("failed to parse device size from blockdev output %q: %w", output, err)
 	}
 
 	return size, nil
@@ -0,0 +1,260 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestInheritedFdPath(t *testing.T) {
+	// ExtraFiles follow stdin, stdout, and stderr
+	for i, want := range []string{"/proc/self/fd/3", "/proc/self/fd/4", "/proc/self/fd/5"} {
+		if got := inheritedFdPath(i); got != want {
+			t.Errorf("inheritedFdPath(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestAttachArgs(t *testing.T) {
+	tests := []struct {
+		opts AttachOptions
+		want []string
+	}{
+		{AttachOptions{}, []string{"-f", "--show", "/data/x.img"}},
+		{AttachOptions{ReadOnly: true}, []string{"-f", "--show", "-r", "/data/x.img"}},
+		{AttachOptions{Offset: 1048576}, []string{"-f", "--show", "-o", "1048576", "/data/x.img"}},
+		{AttachOptions{ReadOnly: true, Offset: 4096}, []string{"-f", "--show", "-r", "-o", "4096", "/data/x.img"}},
+	}
+	for _, tt := range tests {
+		if got := attachArgs("/data/x.img", tt.opts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("attachArgs(%+v) = %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestAttachFd(t *testing.T) {
+	file, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	exec := testutil.NewFakeExecutor().On("losetup", "/dev/loop7\n", nil)
+	device, err := NewLoopManager(exec).AttachFd(context.Background(), file, AttachOptions{ReadOnly: true})
+	if err != nil || device != "/dev/loop7" {
+		t.Fatalf("AttachFd = %q, %v", device, err)
+	}
+
+	cmds := exec.Commands()
+	if len(cmds) != 1 || cmds[0].Line() != "losetup -f --show -r /proc/self/fd/3" || cmds[0].ExtraFiles != 1 {
+		t.Errorf("ran %+v", cmds)
+	}
+}
+
+func TestAttachExhausted(t *testing.T) {
+	exhausted := testutil.ExitError("losetup", 1, "losetup: /data/new.img: failed to set up loop device: could not find any free loop device")
+	tests := []struct {
+		name   string
+		list   error  // losetup -l -J failure
+		inUse  string // Count reported, if any
+		attach func(*LoopManager) (string, error)
+	}{
+		{
+			name:  "path",
+			inUse: "(2 in use)",
+			attach: func(m *LoopManager) (string, error) {
+				return m.Attach(context.Background(), "/data/new.img", AttachOptions{})
+			},
+		},
+		{
+			name:  "fd",
+			inUse: "(2 in use)",
+			attach: func(m *LoopManager) (string, error) {
+				return m.AttachFd(context.Background(), nil, AttachOptions{})
+			},
+		},
+		{
+			name: "count unknown",
+			list: testutil.ExitError("losetup", 1, "permission denied"),
+			attach: func(m *LoopManager) (string, error) {
+				return m.Attach(context.Background(), "/data/new.img", AttachOptions{})
+			},
+		},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().
+			On("losetup -f", "", exhausted).
+			On("losetup -l -J", sampleLosetupJSON, tt.list)
+		_, err := tt.attach(NewLoopManager(exec))
+		if !errors.Is(err, ErrNoFreeLoopDevice) {
+			t.Fatalf("%s: Attach = %v, want ErrNoFreeLoopDevice", tt.name, err)
+		}
+		msg := err.Error()
+		for _, hint := range []string{"losetup -d", "max_loop"} {
+			if !strings.Contains(msg, hint) {
+				t.Errorf("%s: %q doesn't suggest %s", tt.name, msg, hint)
+			}
+		}
+		if tt.inUse != "" && !strings.Contains(msg, tt.inUse) {
+			t.Errorf("%s: %q doesn't say %s", tt.name, msg, tt.inUse)
+		}
+		if tt.inUse == "" && strings.Contains(msg, "in use") {
+			t.Errorf("%s: %q reports a count it couldn't know", tt.name, msg)
+		}
+	}
+
+	// Other failures are reported as they are
+	exec := testutil.NewFakeExecutor().On("losetup -f", "", testutil.ExitError("losetup", 1, "Permission denied"))
+	_, err := NewLoopManager(exec).Attach(context.Background(), "/data/new.img", AttachOptions{})
+	if err == nil || errors.Is(err, ErrNoFreeLoopDevice) || !strings.HasPrefix(err.Error(), "failed to attach loop device") {
+		t.Errorf("Attach = %v", err)
+	}
+	if _, ok := exec.Ran("losetup -l"); ok {
+		t.Error("listed loop devices for an unrelated failure")
+	}
+}
+
+func TestIsLUKSFd(t *testing.T) {
+	file, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	exec := testutil.NewFakeExecutor()
+	isLuks, err := NewLUKSManager(exec).IsLUKSFd(context.Background(), file)
+	if err != nil || !isLuks {
+		t.Errorf("IsLUKSFd = %v, %v", isLuks, err)
+	}
+	cmds := exec.Commands()
+	if len(cmds) != 1 || cmds[0].Line() != "cryptsetup isLuks /proc/self/fd/3" || cmds[0].ExtraFiles != 1 {
+		t.Errorf("ran %+v", cmds)
+	}
+}
+
+func TestGetAllJSON(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -l -J", sampleLosetupJSON, nil)
+	got, err := NewLoopManager(exec).GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"/dev/loop0": "/data/secrets.img", "/dev/loop1": "/data/open only.img"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll() = %v, want %v", got, want)
+	}
+	if _, ok := exec.Ran("losetup -l -n"); ok {
+		t.Error("fell back to the plain listing")
+	}
+}
+
+func TestGetAllEmpty(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("losetup -l -J", "", nil)
+	got, err := NewLoopManager(exec).GetAll(context.Background())
+	if err != nil || len(got) != 0 {
+		t.Errorf("GetAll() = %v, %v; want no devices", got, err)
+	}
+	if _, ok := exec.Ran("losetup -l -n"); ok {
+		t.Error("empty output fell back to the plain listing")
+	}
+}
+
+func TestGetAllPlain(t *testing.T) {
+	plain := "/dev/loop0 /data/secrets.img\n/dev/loop1 /data/file with spaces.img\n"
+	want := map[string]string{"/dev/loop0": "/data/secrets.img", "/dev/loop1": "/data/file with spaces.img"}
+	tests := []struct {
+		name string
+		out  string
+		err  error
+	}{
+		{"no -J", "", testutil.ExitError("losetup", 1, "losetup: invalid option -- 'J'")},
+		{"-J ignored", "NAME       BACK-FILE\n/dev/loop0 /data/secrets.img\n", nil},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().
+			On("losetup -l -J", tt.out, tt.err).
+			On("losetup -l -n -O NAME,BACK-FILE", plain, nil)
+		got, err := NewLoopManager(exec).GetAll(context.Background())
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: GetAll() = %v, %v; want %v", tt.name, got, err, want)
+		}
+	}
+}
+
+func TestParseLosetupTable(t *testing.T) {
+	got := parseLosetupTable("/dev/loop0 /data/secrets.img\n  /dev/loop1   /data/file with spaces.img  \n/dev/loop2\n\n")
+	want := map[string]string{"/dev/loop0": "/data/secrets.img", "/dev/loop1": "/data/file with spaces.img"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLosetupTable() = %v, want %v", got, want)
+	}
+}
+
+func TestRefreshSize(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	if err := NewLoopManager(exec).RefreshSize(context.Background(), "/dev/loop0"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := exec.Lines(); len(lines) != 1 || lines[0] != "losetup -c /dev/loop0" {
+		t.Errorf("ran %q", lines)
+	}
+}
+
+func TestRefreshSizeTooOld(t *testing.T) {
+	tests := []struct {
+		stderr      string
+		unsupported bool
+	}{
+		{"losetup: invalid option -- 'c'", true},
+		{"losetup: unrecognized option '--set-capacity'", true},
+		{"losetup: /dev/loop0: set capacity failed: No such device or address", false},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().On("losetup -c", "", testutil.ExitError("losetup", 1, tt.stderr))
+		err := NewLoopManager(exec).RefreshSize(context.Background(), "/dev/loop0")
+		if err == nil || errors.Is(err, ErrRefreshUnsupported) != tt.unsupported {
+			t.Errorf("%q: RefreshSize() = %v, want ErrRefreshUnsupported %v", tt.stderr, err, tt.unsupported)
+		}
+	}
+}
+
+// writeLoopOffset makes sysfs under sysRoot report offset for loop0
+func writeLoopOffset(t *testing.T, sysRoot, offset string) {
+	t.Helper()
+	dir := filepath.Join(sysRoot, "block", "loop0", "loop")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "offset"), []byte(offset), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySize(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  string // Empty for no sysfs entry
+		size    string
+		wantErr bool
+	}{
+		{name: "grown", size: "4096"},
+		{name: "stale", size: "2048", wantErr: true},
+		{name: "offset", offset: "1024\n", size: "3072"},
+		{name: "offset stale", offset: "1024\n", size: "2048", wantErr: true},
+		{name: "bad offset", offset: "garbage\n", size: "4096", wantErr: true},
+	}
+	for _, tt := range tests {
+		m := NewLoopManager(testutil.NewFakeExecutor().On("blockdev --getsize64 /dev/loop0", tt.size, nil))
+		m.sysRoot = t.TempDir()
+		if tt.offset != "" {
+			writeLoopOffset(t, m.sysRoot, tt.offset)
+		}
+		if err := m.VerifySize(context.Background(), "/dev/loop0", 4096); (err != nil) != tt.wantErr {
+			t.Errorf("%s: VerifySize() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
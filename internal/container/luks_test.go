@@ -0,0 +1,233 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestKeyringAuthApply(t *testing.T) {
+	cmd := exec.Command("cryptsetup", "luksOpen", "/dev/loop0", "m")
+	if err := (&KeyringAuth{KeyDescription: "brezno:data"}).Apply(cmd); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []string{"cryptsetup", "luksOpen", "/dev/loop0", "m", "--key-description", "brezno:data"}
+	if !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("args = %v, want %v", cmd.Args, want)
+	}
+	if cmd.Stdin != nil {
+		t.Error("keyring authentication should not use stdin")
+	}
+
+	if err := (&KeyringAuth{}).Apply(exec.Command("cryptsetup")); err == nil {
+		t.Error("empty description: expected an error")
+	}
+}
+
+func TestParseUnlockedSlot(t *testing.T) {
+	tests := []struct {
+		output string
+		want   int
+	}{
+		{"Key slot 1 unlocked.\nCommand successful.\n", 1},
+		{"No usable token is available.\nKey slot 12 unlocked.\nCommand successful.\n", 12},
+		{"Command successful.\n", -1},
+		{"", -1},
+		{"Key slot 99999999999999999999 unlocked.\n", -1},
+	}
+	for _, tt := range tests {
+		if got := parseUnlockedSlot(tt.output); got != tt.want {
+			t.Errorf("parseUnlockedSlot(%q) = %d, want %d", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestTestPassphrase(t *testing.T) {
+	exec := testutil.NewFakeExecutor().
+		On("cryptsetup luksOpen --test-passphrase", "Key slot 3 unlocked.\nCommand successful.\n", nil)
+	slot, err := NewLUKSManager(exec).TestPassphrase(context.Background(), "/data/x.img", &KeyringAuth{KeyDescription: "k"})
+	if err != nil || slot != 3 {
+		t.Errorf("TestPassphrase = %d, %v; want slot 3", slot, err)
+	}
+	want := "cryptsetup luksOpen --test-passphrase --verbose /data/x.img --key-description k"
+	if lines := exec.Lines(); len(lines) != 1 || lines[0] != want {
+		t.Errorf("ran %q, want %q", lines, want)
+	}
+}
+
+func TestTestPassphraseWrong(t *testing.T) {
+	exec := testutil.NewFakeExecutor().
+		On("cryptsetup luksOpen --test-passphrase", "", testutil.ExitError("cryptsetup", 2, "No key available with this passphrase."))
+	slot, err := NewLUKSManager(exec).TestPassphrase(context.Background(), "/data/x.img", &KeyringAuth{KeyDescription: "k"})
+	if !errors.Is(err, ErrWrongPassphrase) || slot != -1 {
+		t.Errorf("TestPassphrase = %d, %v; want ErrWrongPassphrase", slot, err)
+	}
+
+	// Other failures are not a wrong passphrase
+	exec = testutil.NewFakeExecutor().
+		On("cryptsetup luksOpen --test-passphrase", "", testutil.ExitError("cryptsetup", 1, "Device is busy."))
+	if _, err := NewLUKSManager(exec).TestPassphrase(context.Background(), "/data/x.img", &KeyringAuth{KeyDescription: "k"}); err == nil || errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("TestPassphrase = %v, want a plain error", err)
+	}
+}
+
+func TestIsKeyslotsFull(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"All key slots full.", true},                       // cryptsetup 2.x
+		{"Key slot 0 unlocked.\nAll key slots full.", true}, // with --verbose
+		{"No free key slot.", true},                         // older wording
+		{"No key available with this passphrase.", false},
+		{"Device /data/x.img is not a valid LUKS device.", false},
+	}
+	for _, tt := range tests {
+		if got := isKeyslotsFull(testutil.ExitError("cryptsetup", 1, tt.stderr)); got != tt.want {
+			t.Errorf("isKeyslotsFull(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestAddKeyErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      error // Sentinel the error must wrap, nil for neither
+		wantSlots bool  // Whether the slot freeing hint is given
+	}{
+		{"slots full", testutil.ExitError("cryptsetup", 1, "All key slots full."), ErrKeyslotsFull, true},
+		{"wrong passphrase", testutil.ExitError("cryptsetup", 2, "No key available with this passphrase."), ErrWrongPassphrase, false},
+		{"other", testutil.ExitError("cryptsetup", 1, "Device /data/x.img is not a valid LUKS device."), nil, false},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().On("cryptsetup luksAddKey", "", tt.err)
+		err := NewLUKSManager(exec).AddKey(context.Background(), "/data/x.img", &KeyringAuth{KeyDescription: "k"}, "/keys/new.key")
+		if err == nil {
+			t.Fatalf("%s: AddKey succeeded", tt.name)
+		}
+		for _, sentinel := range []error{ErrKeyslotsFull, ErrWrongPassphrase} {
+			if want := sentinel == tt.want; errors.Is(err, sentinel) != want {
+				t.Errorf("%s: errors.Is(%v, %v) = %v, want %v", tt.name, err, sentinel, !want, want)
+			}
+		}
+		if strings.Contains(err.Error(), "luksKillSlot") != tt.wantSlots {
+			t.Errorf("%s: AddKey() = %v, slot hint wanted %v", tt.name, err, tt.wantSlots)
+		}
+	}
+}
+
+func TestGetLUKSSize(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    uint64
+		wantErr bool
+	}{
+		{"1073741824", 1073741824, false},
+		{"1073741824\n", 1073741824, false},
+		{"  1073741824 \n", 1073741824, false},
+		{"", 0, true},
+		{"\n", 0, true},
+		{"blockdev: cannot open /dev/mapper/brezno_x", 0, true},
+		{"1073741824 bytes", 0, true},
+		{"-1", 0, true},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor().On("blockdev --getsize64 /dev/mapper/brezno_x", tt.output, nil)
+		got, err := NewLUKSManager(exec).GetLUKSSize(context.Background(), "brezno_x")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("output %q: GetLUKSSize() = %d, %v; want %d, error %v", tt.output, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	const prefix = "cryptsetup reencrypt --progress-frequency 5 "
+	tests := []struct {
+		name string
+		opts ReencryptOptions
+		want string // Command run, or "" if rejected
+	}{
+		{"new key only", ReencryptOptions{}, prefix + "/dev/loop0 --key-file /k"},
+		{"new cipher", ReencryptOptions{Cipher: "serpent-xts-plain64"}, prefix + "--cipher serpent-xts-plain64 /dev/loop0 --key-file /k"},
+		{"new key size", ReencryptOptions{KeySize: 256}, prefix + "--key-size 256 /dev/loop0 --key-file /k"},
+		{"both", ReencryptOptions{Cipher: "aes-xts-plain64", KeySize: 512}, prefix + "--cipher aes-xts-plain64 --key-size 512 /dev/loop0 --key-file /k"},
+		{"resume", ReencryptOptions{Resume: true}, prefix + "--resume-only /dev/loop0 --key-file /k"},
+		{"resume with a new cipher", ReencryptOptions{Resume: true, Cipher: "aes-xts-plain64"}, ""},
+		{"resume with a new key size", ReencryptOptions{Resume: true, KeySize: 512}, ""},
+		{"invalid cipher", ReencryptOptions{Cipher: "aes xts"}, ""},
+		{"invalid key size", ReencryptOptions{KeySize: 100}, ""},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor()
+		err := NewLUKSManager(exec).Reencrypt(context.Background(), "/dev/loop0", &KeyfileAuth{KeyfilePath: "/k"}, tt.opts)
+		lines := exec.Lines()
+		if tt.want == "" {
+			if err == nil || len(lines) != 0 {
+				t.Errorf("%s: ran %q, err %v; want a refusal", tt.name, lines, err)
+			}
+			continue
+		}
+		if err != nil || len(lines) != 1 || lines[0] != tt.want {
+			t.Errorf("%s: ran %q, err %v; want %q", tt.name, lines, err, tt.want)
+		}
+	}
+}
+
+func TestErase(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	if err := NewLUKSManager(exec).Erase(context.Background(), "/data/old image.img"); err != nil {
+		t.Fatalf("Erase: %v", err)
+	}
+	cmds := exec.Commands()
+	want := []string{"cryptsetup", "erase", "--batch-mode", "/data/old image.img"}
+	if len(cmds) != 1 || !reflect.DeepEqual(cmds[0].Args, want) || cmds[0].Stdin != "" {
+		t.Errorf("ran %q, want %q without input", exec.Lines(), want)
+	}
+
+	exec = testutil.NewFakeExecutor().On("cryptsetup erase", "", testutil.ExitError("cryptsetup", 1, "Device /data/old.img is not a valid LUKS device."))
+	if err := NewLUKSManager(exec).Erase(context.Background(), "/data/old.img"); err == nil || !strings.Contains(err.Error(), "failed to erase LUKS keyslots") {
+		t.Errorf("Erase = %v", err)
+	}
+}
+
+func TestIsLUKSAt(t *testing.T) {
+	// A file with a LUKS header 1 MiB in, after some other data
+	data := make([]byte, 1<<20+4096)
+	copy(data, "decoy data, not a header")
+	copy(data[1<<20:], append(append([]byte(nil), luksMagic...), 0, 2))
+	path := filepath.Join(t.TempDir(), "embedded.img")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tests := []struct {
+		offset uint64
+		want   bool
+	}{
+		{1 << 20, true},
+		{0, false},
+		{1<<20 - 512, false},
+		{1<<20 + 1, false},
+		{uint64(len(data)) - 3, false}, // Too little left for the magic
+		{1 << 30, false},               // Past the end
+	}
+	for _, tt := range tests {
+		got, err := IsLUKSAt(file, tt.offset)
+		if err != nil || got != tt.want {
+			t.Errorf("IsLUKSAt(%d) = %v, %v; want %v", tt.offset, got, err, tt.want)
+		}
+	}
+}
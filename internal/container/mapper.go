@@ -6,6 +6,26 @@ import (
 	"strings"
 )
 
+// managedMapperSuffixes are appended to GenerateMapperName by commands that
+// open a container temporarily under a different name (see compare)
+var managedMapperSuffixes = []string{"", "_compare"}
+
+// IsManaged reports whether a mapping looks like one brezno opened: it is
+// backed by a container file and named after that file the way
+// GenerateMapperName names it
+func IsManaged(c Container) bool {
+	if c.Path == "" {
+		return false
+	}
+	base := GenerateMapperName(c.Path)
+	for _, suffix := range managedMapperSuffixes {
+		if c.MapperName == base+suffix {
+			return true
+		}
+	}
+	return false
+}
+
 // GenerateMapperName converts a container path to a valid dm-crypt mapper name
 // Mirrors the bash implementation:
 // - /path/to/container.img → container_img
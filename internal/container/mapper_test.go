@@ -0,0 +1,23 @@
+package container
+
+import "testing"
+
+func TestIsManaged(t *testing.T) {
+	tests := []struct {
+		path, mapper string
+		want         bool
+	}{
+		{"/data/secrets.img", "secrets_img", true},
+		{"/data/secrets.img", "secrets_img_compare", true},
+		{"/data/2024-backup.img", "crypt_2024_backup_img", true},
+		{"/data/secrets.img", "secrets_img_other", false},
+		{"/data/secrets.img", "luks-1234", false},
+		{"", "secrets_img", false}, // Backing file unknown
+	}
+	for _, tt := range tests {
+		c := Container{Path: tt.path, MapperName: tt.mapper}
+		if got := IsManaged(c); got != tt.want {
+			t.Errorf("IsManaged(%s as %s) = %v, want %v", tt.path, tt.mapper, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nace/brezno/internal/testutil"
+)
+
+// sampleJSONMetadata is luksDump --dump-json-metadata output, trimmed,
+// with a keyring token next to the brezno marker
+const sampleJSONMetadata = `{
+  "keyslots": {"0": {"type": "luks2", "key_size": 64}},
+  "tokens": {
+    "0": {"type": "luks2-keyring", "keyslots": ["0"], "key_description": "brezno:secrets"},
+    "1": {"type": "systemd-tpm2", "keyslots": ["1"], "tpm2-pcrs": [7], "version": "two"},
+    "2": {"type": "brezno", "keyslots": [], "version": 1, "created": "2026-03-01T12:30:00Z"}
+  },
+  "segments": {},
+  "digests": {},
+  "config": {"json_size": "12288", "keyslots_size": "16744448"}
+}`
+
+func TestParseBreznoToken(t *testing.T) {
+	token, err := parseBreznoToken([]byte(sampleJSONMetadata))
+	if err != nil {
+		t.Fatalf("parseBreznoToken: %v", err)
+	}
+	want := BreznoToken{Version: 1, Created: time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)}
+	if token == nil || token.Version != want.Version || !token.Created.Equal(want.Created) {
+		t.Errorf("token = %+v, want %+v", token, want)
+	}
+
+	for _, metadata := range []string{
+		`{"keyslots": {}, "tokens": {}}`,
+		`{"keyslots": {}}`,
+		`{"tokens": {"0": {"type": "luks2-keyring", "keyslots": ["0"], "key_description": "x"}}}`,
+	} {
+		if token, err := parseBreznoToken([]byte(metadata)); err != nil || token != nil {
+			t.Errorf("metadata without a marker %s: got %+v, %v", metadata, token, err)
+		}
+	}
+
+	if _, err := parseBreznoToken([]byte("Device /dev/loop0 is not a valid LUKS device.")); err == nil {
+		t.Error("invalid metadata: expected an error")
+	}
+}
+
+func TestSetToken(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	m := NewLUKSManager(exec)
+	token := BreznoToken{Version: 1, Created: time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)}
+	if err := m.SetToken(context.Background(), "/data/secrets.img", token); err != nil {
+		t.Fatalf("SetToken: %v", err)
+	}
+
+	cmd, ok := exec.Ran("cryptsetup token import --json-file - /data/secrets.img")
+	if !ok {
+		t.Fatalf("no token import: %v", exec.Lines())
+	}
+	// What is written must read back as the same token
+	metadata, _ := json.Marshal(map[string]interface{}{"tokens": map[string]json.RawMessage{"3": json.RawMessage(cmd.Stdin)}})
+	got, err := parseBreznoToken(metadata)
+	if err != nil || got == nil || *got != token {
+		t.Errorf("imported token %s reads back as %+v, %v", cmd.Stdin, got, err)
+	}
+}
+
+func TestGetTokenFailure(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("cryptsetup luksDump --dump-json-metadata", "", errors.New("not LUKS2"))
+	if _, err := NewLUKSManager(exec).GetToken(context.Background(), "/data/secrets.img"); err == nil {
+		t.Error("GetToken succeeded despite the luksDump failure")
+	}
+}
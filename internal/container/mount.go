@@ -1,8 +1,12 @@
 package container
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/nace/brezno/internal/system"
@@ -10,30 +14,46 @@ import (
 
 // MountManager handles filesystem mount operations
 type MountManager struct {
-	executor *system.Executor
+	executor system.Executor
 }
 
 // NewMountManager creates a new mount manager
-func NewMountManager(executor *system.Executor) *MountManager {
+func NewMountManager(executor system.Executor) *MountManager {
 	return &MountManager{
 		executor: executor,
 	}
 }
 
+// MountOptions controls how a device is mounted
+type MountOptions struct {
+	ReadOnly bool   // Mount read-only (-o ro)
+	FSType   string // Passed to mount -t; empty lets mount detect the filesystem
+	// RequireMountPoint fails the mount if the mount point is not an
+	// existing directory, instead of creating it
+	RequireMountPoint bool
+}
+
 // Mount mounts a device to a mount point
-func (m *MountManager) Mount(device, mountPoint string, readonly bool) error {
+func (m *MountManager) Mount(ctx context.Context, device, mountPoint string, opts MountOptions) error {
 	// Ensure mount point exists
-	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+	if opts.RequireMountPoint {
+		if err := CheckMountPoint(mountPoint); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(mountPoint, 0755); err != nil {
 		return fmt.Errorf("failed to create mount point: %w", err)
 	}
 
 	args := []string{}
-	if readonly {
+	if opts.FSType != "" {
+		args = append(args, "-t", opts.FSType)
+	}
+	if opts.ReadOnly {
 		args = append(args, "-o", "ro")
 	}
 	args = append(args, device, mountPoint)
 
-	err := m.executor.Run("mount", args...)
+	err := m.executor.Run(ctx, "mount", args...)
 	if err != nil {
 		return fmt.Errorf("failed to mount %s to %s: %w", device, mountPoint, err)
 	}
@@ -41,60 +61,168 @@ func (m *MountManager) Mount(device, mountPoint string, readonly bool) error {
 	return nil
 }
 
+// CheckMountPoint returns an error unless mountPoint is an existing directory
+func CheckMountPoint(mountPoint string) error {
+	info, err := os.Stat(mountPoint)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("mount point does not exist: %s", mountPoint)
+		}
+		return fmt.Errorf("failed to access mount point: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("mount point is not a directory: %s", mountPoint)
+	}
+	return nil
+}
+
 // Unmount unmounts a mount point
-func (m *MountManager) Unmount(mountPoint string, force bool) error {
+func (m *MountManager) Unmount(ctx context.Context, mountPoint string, force bool) error {
 	if !force {
-		return m.executor.Run("umount", mountPoint)
+		return m.executor.Run(ctx, "umount", mountPoint)
 	}
 
 	// Try normal unmount first
-	if err := m.executor.Run("umount", mountPoint); err == nil {
+	if err := m.executor.Run(ctx, "umount", mountPoint); err == nil {
 		return nil
 	}
 
 	// Try force unmount
-	if err := m.executor.Run("umount", "-f", mountPoint); err == nil {
+	if err := m.executor.Run(ctx, "umount", "-f", mountPoint); err == nil {
 		return nil
 	}
 
 	// Try lazy unmount as last resort
-	return m.executor.Run("umount", "-l", mountPoint)
+	return m.executor.Run(ctx, "umount", "-l", mountPoint)
+}
+
+// Trim discards unused blocks on a mounted filesystem using fstrim.
+// Discards only reach the backing file if the LUKS mapping allows them.
+func (m *MountManager) Trim(ctx context.Context, mountPoint string) error {
+	err := m.executor.Run(ctx, "fstrim", mountPoint)
+	if err != nil {
+		return fmt.Errorf("failed to trim %s: %w", mountPoint, err)
+	}
+	return nil
+}
+
+// maxLabelLength is the longest filesystem label each mkfs accepts
+var maxLabelLength = map[string]int{
+	"ext4":  16,
+	"xfs":   12,
+	"btrfs": 255,
+}
+
+// minFilesystemSize is roughly the smallest device each mkfs accepts with
+// default options
+var minFilesystemSize = map[string]uint64{
+	"ext4":  1 << 20,   // 1 MiB
+	"xfs":   300 << 20, // 300 MiB since xfsprogs 5.19
+	"btrfs": 110 << 20, // 109 MiB, rounded up
+}
+
+// MinFilesystemSize returns the smallest device mkfs accepts for fsType,
+// or 0 if unknown
+func MinFilesystemSize(fsType string) uint64 {
+	return minFilesystemSize[fsType]
+}
+
+// IsSupportedFilesystem reports whether brezno can create and resize fsType
+func IsSupportedFilesystem(fsType string) bool {
+	_, ok := maxLabelLength[fsType]
+	return ok
+}
+
+// MaxLUKSLabelLength is the longest label a LUKS2 header holds: 48 bytes
+// including the terminating NUL
+const MaxLUKSLabelLength = 47
+
+// ValidateLabel checks that label fits the LUKS2 header, where create puts
+// it too, and the filesystem's label limit. fsType is "" when no
+// filesystem is created.
+func ValidateLabel(fsType, label string) error {
+	if fsType != "" {
+		max, ok := maxLabelLength[fsType]
+		if !ok {
+			return fmt.Errorf("unsupported filesystem: %s", fsType)
+		}
+		if len(label) > max {
+			return fmt.Errorf("label %q is too long for %s (max %d characters)", label, fsType, max)
+		}
+	}
+	if len(label) > MaxLUKSLabelLength {
+		return fmt.Errorf("label %q is too long for a LUKS2 header (max %d characters)", label, MaxLUKSLabelLength)
+	}
+	return nil
+}
+
+// DetectFilesystem returns the filesystem type on a device, as reported
+// by blkid, or "" if it has none
+func (m *MountManager) DetectFilesystem(ctx context.Context, device string) (string, error) {
+	output, err := m.executor.RunOutput(ctx, "blkid", "-o", "value", "-s", "TYPE", device)
+	if err != nil {
+		// blkid exits with status 2 when it finds nothing to report
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to detect filesystem on %s: %w", device, err)
+	}
+	return strings.TrimSpace(output), nil
 }
 
-// MakeFilesystem creates a filesystem on a device
-func (m *MountManager) MakeFilesystem(device, fsType string) error {
+// MakeFilesystem creates a filesystem on a device.
+// An empty label creates the filesystem without one, so containers don't
+// collide in /dev/disk/by-label.
+func (m *MountManager) MakeFilesystem(ctx context.Context, device, fsType, label string) error {
+	var args []string
 	switch fsType {
 	case "ext4":
-		return m.executor.Run("mkfs.ext4", "-q", "-L", "encrypted", device)
-	case "xfs":
-		return m.executor.Run("mkfs.xfs", "-L", "encrypted", device)
-	case "btrfs":
-		return m.executor.Run("mkfs.btrfs", "-L", "encrypted", device)
+		args = []string{"-q"}
+	case "xfs", "btrfs":
 	default:
 		return fmt.Errorf("unsupported filesystem: %s", fsType)
 	}
+	if label != "" {
+		args = append(args, "-L", label)
+	}
+	args = append(args, device)
+
+	return m.executor.Run(ctx, "mkfs."+fsType, args...)
 }
 
-// ResizeFilesystem expands a mounted filesystem to use all available space
-func (m *MountManager) ResizeFilesystem(mapperDevice, fsType, mountPoint string) error {
+// ResizeFilesystem expands a mounted filesystem to targetSize bytes, or to
+// use all available space if targetSize is 0. xfs can only fill the device.
+func (m *MountManager) ResizeFilesystem(ctx context.Context, mapperDevice, fsType, mountPoint string, targetSize uint64) error {
 	switch fsType {
 	case "ext4":
 		// ext4 can resize online, uses the device
-		err := m.executor.Run("resize2fs", mapperDevice)
+		args := []string{mapperDevice}
+		if targetSize > 0 {
+			args = append(args, strconv.FormatUint(targetSize/1024, 10)+"K")
+		}
+		err := m.executor.Run(ctx, "resize2fs", args...)
 		if err != nil {
 			return fmt.Errorf("failed to resize ext4 filesystem: %w", err)
 		}
 
 	case "xfs":
+		if targetSize > 0 {
+			return fmt.Errorf("xfs can only grow to fill the device, not to a given size")
+		}
 		// xfs requires the mount point for online resize
-		err := m.executor.Run("xfs_growfs", mountPoint)
+		err := m.executor.Run(ctx, "xfs_growfs", mountPoint)
 		if err != nil {
 			return fmt.Errorf("failed to resize xfs filesystem: %w", err)
 		}
 
 	case "btrfs":
 		// btrfs uses mount point, 'max' means use all available space
-		err := m.executor.Run("btrfs", "filesystem", "resize", "max", mountPoint)
+		size := "max"
+		if targetSize > 0 {
+			size = strconv.FormatUint(targetSize, 10)
+		}
+		err := m.executor.Run(ctx, "btrfs", "filesystem", "resize", size, mountPoint)
 		if err != nil {
 			return fmt.Errorf("failed to resize btrfs filesystem: %w", err)
 		}
@@ -106,16 +234,28 @@ func (m *MountManager) ResizeFilesystem(mapperDevice, fsType, mountPoint string)
 	return nil
 }
 
-// GetFilesystemSize gets the size and usage of a mounted filesystem
-func (m *MountManager) GetFilesystemSize(mountPoint string) (size uint64, used uint64, err error) {
-	output, err := m.executor.RunOutput("df", "--block-size=1", mountPoint)
+// GetFilesystemSize gets the size and usage of a mounted filesystem. For
+// btrfs, whose df numbers are pool-level estimates, they come from btrfs
+// itself when possible.
+func (m *MountManager) GetFilesystemSize(ctx context.Context, mountPoint, fsType string) (size uint64, used uint64, err error) {
+	if fsType == "btrfs" {
+		if size, used, err := btrfsUsage(ctx, m.executor, mountPoint); err == nil {
+			return size, used, nil
+		}
+	}
+
+	output, err := m.executor.RunOutput(ctx, "df", "--block-size=1", mountPoint)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to get filesystem size: %w", err)
 	}
+	return parseDf(output)
+}
 
-	// Parse df output
-	// Header: Filesystem     1B-blocks      Used Available Use% Mounted on
-	// Data:   /dev/mapper/x  1234567890  123456  ...
+// parseDf extracts the size and space used from df --block-size=1 output:
+//
+//	Filesystem     1B-blocks      Used Available Use% Mounted on
+//	/dev/mapper/x  1234567890  123456  ...
+func parseDf(output string) (size uint64, used uint64, err error) {
 	lines := strings.Split(output, "\n")
 	if len(lines) < 2 {
 		return 0, 0, fmt.Errorf("invalid df output")
@@ -126,9 +266,70 @@ func (m *MountManager) GetFilesystemSize(mountPoint string) (size uint64, used u
 		return 0, 0, fmt.Errorf("invalid df output format")
 	}
 
-	// Parse size and used
-	fmt.Sscanf(fields[1], "%d", &size)
-	fmt.Sscanf(fields[2], "%d", &used)
-
+	size, err = strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size in df output %q: %w", fields[1], err)
+	}
+	used, err = strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid used space in df output %q: %w", fields[2], err)
+	}
 	return size, used, nil
 }
+
+// btrfsUsage gets the device size and space used of a mounted btrfs
+// filesystem from btrfs filesystem usage
+func btrfsUsage(ctx context.Context, executor system.Executor, mountPoint string) (size uint64, used uint64, err error) {
+	output, err := executor.RunOutput(ctx, "btrfs", "filesystem", "usage", "--raw", mountPoint)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get btrfs usage: %w", err)
+	}
+	return parseBtrfsUsage(output)
+}
+
+// parseBtrfsUsage extracts the device size and the space used from the
+// "Overall" section of btrfs filesystem usage --raw:
+//
+//	Overall:
+//	    Device size:                1073741824
+//	    ...
+//	    Used:                        123731968
+//	    Free (estimated):            847904768      (min: 425328640)
+//
+// Its "Used" is raw and counts both copies of DUP or RAID1 chunks, so used
+// is instead what the free space estimate, which allows for the profiles,
+// leaves of the device.
+func parseBtrfsUsage(output string) (size uint64, used uint64, err error) {
+	var free uint64
+	var haveSize, haveFree bool
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "Device size":
+			size, err = strconv.ParseUint(fields[0], 10, 64)
+			haveSize = err == nil
+		case "Free (estimated)":
+			free, err = strconv.ParseUint(fields[0], 10, 64)
+			haveFree = err == nil
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid btrfs usage output %q: %w", line, err)
+		}
+	}
+
+	if !haveSize || !haveFree {
+		return 0, 0, fmt.Errorf("invalid btrfs usage output")
+	}
+	if free > size {
+		// Cannot happen on a consistent filesystem
+		return 0, 0, fmt.Errorf("invalid btrfs usage output: %d free of %d", free, size)
+	}
+	return size, size - free, nil
+}
@@ -0,0 +1,263 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestValidateLabel(t *testing.T) {
+	tests := []struct {
+		fsType  string
+		label   string
+		wantErr string
+	}{
+		{"ext4", "", ""},
+		{"ext4", strings.Repeat("a", 16), ""},
+		{"ext4", strings.Repeat("a", 17), "too long for ext4"},
+		{"xfs", strings.Repeat("a", 12), ""},
+		{"xfs", strings.Repeat("a", 13), "too long for xfs"},
+		// btrfs allows 255, but the LUKS2 header holds only 47
+		{"btrfs", strings.Repeat("a", 47), ""},
+		{"btrfs", strings.Repeat("a", 48), "too long for a LUKS2 header"},
+		// No filesystem, only the LUKS2 header
+		{"", strings.Repeat("a", 47), ""},
+		{"", strings.Repeat("a", 48), "too long for a LUKS2 header"},
+		{"vfat", "data", "unsupported filesystem"},
+	}
+	for _, tt := range tests {
+		err := ValidateLabel(tt.fsType, tt.label)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("ValidateLabel(%q, %d chars) = %v", tt.fsType, len(tt.label), err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ValidateLabel(%q, %d chars) = %v, want %q", tt.fsType, len(tt.label), err, tt.wantErr)
+		}
+	}
+}
+
+// sampleBtrfsUsage is btrfs filesystem usage --raw for a 1 GiB filesystem
+// with DUP metadata, as mkfs.btrfs creates on a single device
+const sampleBtrfsUsage = `Overall:
+    Device size:		  1073741824
+    Device allocated:		   228589568
+    Device unallocated:		   845152256
+    Device missing:		           0
+    Device slack:		           0
+    Used:			   123731968
+    Free (estimated):		   847904768	(min: 425328640)
+    Free (statfs, df):		   846856192
+    Data ratio:			        1.00
+    Metadata ratio:		        2.00
+    Global reserve:		     5767168	(min: 5767168)
+    Multiple profiles:		          no
+
+Data,single: Size:8388608, Used:2752512 (32.81%)
+   /dev/mapper/brezno_secrets	     8388608
+
+Metadata,DUP: Size:104857600, Used:114688 (0.11%)
+   /dev/mapper/brezno_secrets	   209715200
+
+System,DUP: Size:8388608, Used:16384 (0.20%)
+   /dev/mapper/brezno_secrets	    16777216
+
+Unallocated:
+   /dev/mapper/brezno_secrets	   845152256
+`
+
+func TestParseBtrfsUsage(t *testing.T) {
+	size, used, err := parseBtrfsUsage(sampleBtrfsUsage)
+	if err != nil {
+		t.Fatalf("parseBtrfsUsage: %v", err)
+	}
+	// Not the raw Used, which counts both copies of the DUP metadata
+	if size != 1073741824 || used != 1073741824-847904768 {
+		t.Errorf("parseBtrfsUsage = %d, %d; want 1073741824, %d", size, used, 1073741824-847904768)
+	}
+
+	for _, output := range []string{
+		"",
+		"Overall:\n    Device size:\t1073741824\n",
+		"Overall:\n    Free (estimated):\t847904768\n",
+		"Overall:\n    Device size:\t1G\n    Free (estimated):\t847904768\n",
+		"Overall:\n    Device size:\t100\n    Free (estimated):\t200\n",
+	} {
+		if _, _, err := parseBtrfsUsage(output); err == nil {
+			t.Errorf("parseBtrfsUsage(%q): expected an error", output)
+		}
+	}
+}
+
+func TestGetFilesystemSizeBtrfs(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("btrfs filesystem usage --raw /mnt/secrets", sampleBtrfsUsage, nil)
+	size, used, err := NewMountManager(exec).GetFilesystemSize(context.Background(), "/mnt/secrets", "btrfs")
+	if err != nil || size != 1073741824 || used != 225837056 {
+		t.Errorf("GetFilesystemSize = %d, %d, %v", size, used, err)
+	}
+	if _, ok := exec.Ran("df"); ok {
+		t.Errorf("fell back to df: %v", exec.Lines())
+	}
+
+	// Without usable btrfs output, df's numbers are the next best
+	exec = testutil.NewFakeExecutor().
+		On("btrfs", "", errors.New("btrfs: command not found")).
+		On("df", sampleDf, nil)
+	size, used, err = NewMountManager(exec).GetFilesystemSize(context.Background(), "/mnt/secrets", "btrfs")
+	if err != nil || size != 1000000 || used != 250000 {
+		t.Errorf("GetFilesystemSize with df fallback = %d, %d, %v", size, used, err)
+	}
+}
+
+func TestResizeFilesystem(t *testing.T) {
+	tests := []struct {
+		fsType     string
+		targetSize uint64
+		want       string // Command run, or "" if rejected
+	}{
+		{"ext4", 0, "resize2fs /dev/mapper/brezno_x"},
+		{"ext4", 40 << 30, "resize2fs /dev/mapper/brezno_x 41943040K"},
+		{"btrfs", 0, "btrfs filesystem resize max /mnt/x"},
+		{"btrfs", 40 << 30, "btrfs filesystem resize 42949672960 /mnt/x"},
+		{"xfs", 0, "xfs_growfs /mnt/x"},
+		{"xfs", 40 << 30, ""},
+		{"vfat", 0, ""},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor()
+		err := NewMountManager(exec).ResizeFilesystem(context.Background(), "/dev/mapper/brezno_x", tt.fsType, "/mnt/x", tt.targetSize)
+		lines := exec.Lines()
+		if tt.want == "" {
+			if err == nil || len(lines) != 0 {
+				t.Errorf("%s to %d: ran %q, err %v; want a refusal", tt.fsType, tt.targetSize, lines, err)
+			}
+			continue
+		}
+		if err != nil || len(lines) != 1 || lines[0] != tt.want {
+			t.Errorf("%s to %d: ran %q, err %v; want %q", tt.fsType, tt.targetSize, lines, err, tt.want)
+		}
+	}
+}
+
+func TestResizeFilesystemFailure(t *testing.T) {
+	exec := testutil.NewFakeExecutor().On("resize2fs", "", errors.New("resize2fs failed"))
+	err := NewMountManager(exec).ResizeFilesystem(context.Background(), "/dev/mapper/brezno_x", "ext4", "/mnt/x", 0)
+	if err == nil || !strings.Contains(err.Error(), "failed to resize ext4 filesystem") {
+		t.Errorf("ResizeFilesystem() = %v", err)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	if err := NewMountManager(exec).Trim(context.Background(), "/mnt/my secrets"); err != nil {
+		t.Fatalf("Trim: %v", err)
+	}
+	cmds := exec.Commands()
+	if len(cmds) != 1 || len(cmds[0].Args) != 2 || cmds[0].Args[0] != "fstrim" || cmds[0].Args[1] != "/mnt/my secrets" {
+		t.Errorf("ran %q, want fstrim with the mount point as one argument", exec.Lines())
+	}
+
+	exec = testutil.NewFakeExecutor().On("fstrim", "", errors.New("the discard operation is not supported"))
+	err := NewMountManager(exec).Trim(context.Background(), "/mnt/x")
+	if err == nil || !strings.Contains(err.Error(), "failed to trim /mnt/x") {
+		t.Errorf("Trim() = %v", err)
+	}
+}
+
+func TestCheckMountPoint(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{dir, ""},
+		{filepath.Join(dir, "missing"), "mount point does not exist"},
+		{file, "mount point is not a directory"},
+	}
+	for _, tt := range tests {
+		err := CheckMountPoint(tt.path)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("CheckMountPoint(%s) = %v", tt.path, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("CheckMountPoint(%s) = %v, want %q", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMountRequireMountPoint(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "new")
+	exec := testutil.NewFakeExecutor()
+	if err := NewMountManager(exec).Mount(context.Background(), "/dev/mapper/brezno_x", missing, MountOptions{RequireMountPoint: true}); err == nil {
+		t.Error("missing mount point accepted")
+	}
+	if _, ok := exec.Ran("mount"); ok {
+		t.Errorf("mounted anyway: %v", exec.Lines())
+	}
+
+	// Without it, a missing mount point is created
+	if err := NewMountManager(exec).Mount(context.Background(), "/dev/mapper/brezno_x", missing, MountOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckMountPoint(missing); err != nil {
+		t.Errorf("mount point not created: %v", err)
+	}
+}
+
+func TestMountArgs(t *testing.T) {
+	mountPoint := t.TempDir()
+	tests := []struct {
+		opts MountOptions
+		want string
+	}{
+		{MountOptions{}, "mount /dev/mapper/brezno_x " + mountPoint},
+		{MountOptions{FSType: "xfs"}, "mount -t xfs /dev/mapper/brezno_x " + mountPoint},
+		{MountOptions{ReadOnly: true}, "mount -o ro /dev/mapper/brezno_x " + mountPoint},
+		{MountOptions{FSType: "vfat", ReadOnly: true}, "mount -t vfat -o ro /dev/mapper/brezno_x " + mountPoint},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor()
+		if err := NewMountManager(exec).Mount(context.Background(), "/dev/mapper/brezno_x", mountPoint, tt.opts); err != nil {
+			t.Fatalf("Mount(%+v): %v", tt.opts, err)
+		}
+		if lines := exec.Lines(); len(lines) != 1 || lines[0] != tt.want {
+			t.Errorf("Mount(%+v) ran %q, want %q", tt.opts, lines, tt.want)
+		}
+	}
+}
+
+func TestParseDf(t *testing.T) {
+	const header = "Filesystem 1B-blocks Used Available Use% Mounted on\n"
+	tests := []struct {
+		name       string
+		output     string
+		size, used uint64
+		wantErr    bool
+	}{
+		{"plain", header + "/dev/mapper/x 1000000 250000 750000 25% /mnt/x\n", 1000000, 250000, false},
+		{"aligned columns", header + "/dev/mapper/x     1000000    250000     750000  25% /mnt/x", 1000000, 250000, false},
+		{"no data line", header, 0, 0, true},
+		{"empty", "", 0, 0, true},
+		{"short line", header + "/dev/mapper/x 1000000\n", 0, 0, true},
+		{"garbage size", header + "/dev/mapper/x 1.5G 250000 750000 25% /mnt/x\n", 0, 0, true},
+		{"garbage used", header + "/dev/mapper/x 1000000 - 750000 25% /mnt/x\n", 0, 0, true},
+		{"negative", header + "/dev/mapper/x -1000000 250000 750000 25% /mnt/x\n", 0, 0, true},
+	}
+	for _, tt := range tests {
+		size, used, err := parseDf(tt.output)
+		if (err != nil) != tt.wantErr || size != tt.size || used != tt.used {
+			t.Errorf("%s: parseDf() = %d, %d, %v; want %d, %d, error %v", tt.name, size, used, err, tt.size, tt.used, tt.wantErr)
+		}
+	}
+}
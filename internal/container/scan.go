@@ -0,0 +1,107 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// scanMinSize skips files too small to hold a LUKS header and any data
+const scanMinSize = 1024 * 1024
+
+// ScannedContainer is a LUKS container file found on disk
+type ScannedContainer struct {
+	Path   string // Absolute path to container file
+	UUID   string // LUKS UUID
+	Label  string `json:",omitempty"` // LUKS2 header label
+	Size   uint64 // File size in bytes
+	Active bool   // Currently opened/mounted
+}
+
+// ScanDirectory walks dir up to maxDepth levels deep and returns the regular
+// files that are LUKS containers. Symlinks are not followed and unreadable
+// subdirectories are skipped.
+func ScanDirectory(ctx context.Context, luks CryptSetup, dir string, maxDepth int) ([]ScannedContainer, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory: %w", err)
+	}
+
+	var found []ScannedContainer
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// Skip what we can't read rather than aborting the scan
+			return nil
+		}
+
+		if entry.IsDir() {
+			if path != root && scanDepth(root, path) > maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.Size() < scanMinSize {
+			return nil
+		}
+
+		isLuks, err := luks.IsLUKS(ctx, path)
+		if err != nil || !isLuks {
+			return nil
+		}
+
+		uuid, err := luks.UUID(ctx, path)
+		if err != nil {
+			uuid = ""
+		}
+
+		label, err := luks.Label(ctx, path)
+		if err != nil {
+			label = ""
+		}
+
+		found = append(found, ScannedContainer{
+			Path:  path,
+			UUID:  uuid,
+			Label: label,
+			Size:  uint64(info.Size()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+
+	return found, nil
+}
+
+// MatchScanned returns the scanned containers with the given UUID
+// (case-insensitive) or LUKS2 label. Empty criteria match nothing.
+func MatchScanned(found []ScannedContainer, uuid, label string) []ScannedContainer {
+	var matches []ScannedContainer
+	for _, c := range found {
+		if (uuid != "" && strings.EqualFold(c.UUID, uuid)) || (label != "" && c.Label == label) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// scanDepth returns how many directory levels path is below root
+func scanDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
@@ -0,0 +1,108 @@
+package container
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nace/brezno/internal/testutil"
+)
+
+// scanFixture lays out a directory mixing LUKS files, non-LUKS files,
+// undersized files, a symlink and nested subdirectories, and returns it with
+// an executor that recognises the LUKS ones
+func scanFixture(t *testing.T) (string, *testutil.FakeExecutor) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]int64{
+		"a.img":            2 * scanMinSize,
+		"notes.txt":        2 * scanMinSize,
+		"small.img":        scanMinSize - 1,
+		"sub/b.img":        3 * scanMinSize,
+		"sub/deep/c.img":   scanMinSize,
+		"sub/deep/old.bin": scanMinSize,
+	}
+	for name, size := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "a.img"), filepath.Join(dir, "link.img")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := testutil.NewFakeExecutor().
+		On("cryptsetup isLuks ", "", testutil.ExitError("cryptsetup", 1, ""))
+	for _, name := range []string{"a.img", "small.img", "sub/b.img", "sub/deep/c.img"} {
+		path := filepath.Join(dir, name)
+		exec.On("cryptsetup isLuks "+path, "", nil)
+		exec.On("cryptsetup luksUUID "+path, "uuid-"+filepath.Base(name)+"\n", nil)
+		label := "(no label)"
+		if name == "sub/b.img" {
+			label = "backups"
+		}
+		exec.On("cryptsetup luksDump "+path, "Label:          \t"+label+"\n", nil)
+	}
+	return dir, exec
+}
+
+func TestScanDirectory(t *testing.T) {
+	dir, exec := scanFixture(t)
+	a := ScannedContainer{Path: filepath.Join(dir, "a.img"), UUID: "uuid-a.img", Size: 2 * scanMinSize}
+	b := ScannedContainer{Path: filepath.Join(dir, "sub/b.img"), UUID: "uuid-b.img", Label: "backups", Size: 3 * scanMinSize}
+	c := ScannedContainer{Path: filepath.Join(dir, "sub/deep/c.img"), UUID: "uuid-c.img", Size: scanMinSize}
+
+	tests := []struct {
+		maxDepth int
+		want     []ScannedContainer
+	}{
+		{0, []ScannedContainer{a}},
+		{1, []ScannedContainer{a, b}},
+		{2, []ScannedContainer{a, b, c}},
+		{10, []ScannedContainer{a, b, c}},
+	}
+	for _, tt := range tests {
+		got, err := ScanDirectory(context.Background(), NewLUKSManager(exec), dir, tt.maxDepth)
+		if err != nil {
+			t.Fatalf("depth %d: %v", tt.maxDepth, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("depth %d: found %+v, want %+v", tt.maxDepth, got, tt.want)
+		}
+	}
+
+	// Undersized files and symlinks are never handed to cryptsetup
+	for _, name := range []string{"small.img", "link.img"} {
+		if _, ran := exec.Ran("cryptsetup isLuks " + filepath.Join(dir, name)); ran {
+			t.Errorf("%s was probed", name)
+		}
+	}
+	if _, ran := exec.Ran("cryptsetup isLuks " + filepath.Join(dir, "notes.txt")); !ran {
+		t.Error("notes.txt was not probed")
+	}
+}
+
+func TestScanDirectoryRelativeAndMissing(t *testing.T) {
+	dir, exec := scanFixture(t)
+	t.Chdir(dir)
+	got, err := ScanDirectory(context.Background(), NewLUKSManager(exec), ".", 0)
+	if err != nil || len(got) != 1 || got[0].Path != filepath.Join(dir, "a.img") {
+		t.Errorf("ScanDirectory(.) = %+v, %v; want the absolute path of a.img", got, err)
+	}
+
+	if _, err := ScanDirectory(context.Background(), NewLUKSManager(exec), filepath.Join(dir, "missing"), 1); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
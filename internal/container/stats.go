@@ -0,0 +1,46 @@
+package container
+
+// Stats aggregates usage across containers
+type Stats struct {
+	Count     int    // Active containers
+	Allocated uint64 // Total filesystem size in bytes
+	Used      uint64 // Total used space in bytes
+
+	// AverageUtilization is the mean usage percentage of the containers
+	// whose size is known
+	AverageUtilization float64
+
+	// Fullest is the container with the highest usage percentage,
+	// nil if no container size is known
+	Fullest *Container `json:",omitempty"`
+}
+
+// Aggregate computes usage statistics for a set of containers.
+// Containers with an unknown size (e.g., not mounted) count toward Count only.
+func Aggregate(containers []Container) Stats {
+	stats := Stats{Count: len(containers)}
+
+	var sized int
+	var totalPercent float64
+	for i := range containers {
+		c := &containers[i]
+		if c.Size == 0 {
+			continue
+		}
+
+		sized++
+		stats.Allocated += c.Size
+		stats.Used += c.Used
+		totalPercent += c.UsagePercent()
+
+		if stats.Fullest == nil || c.UsagePercent() > stats.Fullest.UsagePercent() {
+			stats.Fullest = c
+		}
+	}
+
+	if sized > 0 {
+		stats.AverageUtilization = totalPercent / float64(sized)
+	}
+
+	return stats
+}
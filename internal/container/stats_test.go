@@ -0,0 +1,40 @@
+package container
+
+import "testing"
+
+func TestAggregate(t *testing.T) {
+	half := Container{Path: "/data/half.img", Size: 1000, Used: 500}
+	full := Container{Path: "/data/full.img", Size: 4000, Used: 3600}
+	empty := Container{Path: "/data/empty.img", Size: 2000}
+	open := Container{Path: "/data/open.img"} // Open, not mounted
+
+	tests := []struct {
+		name       string
+		containers []Container
+		want       Stats
+		fullest    string
+	}{
+		{"none", nil, Stats{}, ""},
+		{"unmounted only", []Container{open, open}, Stats{Count: 2}, ""},
+		{"one", []Container{half}, Stats{Count: 1, Allocated: 1000, Used: 500, AverageUtilization: 50}, "/data/half.img"},
+		{
+			// The average is over containers, not bytes: (50 + 90 + 0) / 3
+			"mixed",
+			[]Container{half, open, full, empty},
+			Stats{Count: 4, Allocated: 7000, Used: 4100, AverageUtilization: 140.0 / 3},
+			"/data/full.img",
+		},
+		{"tie keeps the first", []Container{half, {Path: "/data/other.img", Size: 10, Used: 5}}, Stats{Count: 2, Allocated: 1010, Used: 505, AverageUtilization: 50}, "/data/half.img"},
+	}
+	for _, tt := range tests {
+		got := Aggregate(tt.containers)
+		fullest := ""
+		if got.Fullest != nil {
+			fullest = got.Fullest.Path
+		}
+		got.Fullest = nil
+		if got != tt.want || fullest != tt.fullest {
+			t.Errorf("%s: Aggregate = %+v with fullest %q, want %+v with fullest %q", tt.name, got, fullest, tt.want, tt.fullest)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FormatOptionsOf reads the cipher, key size, and key derivation function
+// of an existing LUKS2 container, e.g. to create another one like it.
+// The label is not included, since it identifies the container.
+func (m *LUKSManager) FormatOptionsOf(ctx context.Context, path string) (FormatOptions, error) {
+	output, err := m.executor.RunOutput(ctx, "cryptsetup", "luksDump", "--dump-json-metadata", path)
+	if err != nil {
+		return FormatOptions{}, fmt.Errorf("failed to read LUKS2 metadata (is it a LUKS2 container?): %w", err)
+	}
+	return parseFormatOptions([]byte(output))
+}
+
+// luks2Metadata is the part of the LUKS2 JSON metadata that describes how
+// a container was formatted
+type luks2Metadata struct {
+	Keyslots map[string]struct {
+		Type    string `json:"type"`
+		KeySize int    `json:"key_size"` // Volume key size in bytes
+		KDF     struct {
+			Type string `json:"type"`
+		} `json:"kdf"`
+	} `json:"keyslots"`
+	Segments map[string]struct {
+		Type       string `json:"type"`
+		Encryption string `json:"encryption"`
+	} `json:"segments"`
+}
+
+// parseFormatOptions extracts FormatOptions from LUKS2 JSON metadata, as
+// printed by luksDump --dump-json-metadata. The cipher comes from the
+// first data segment, the key size and KDF from the first keyslot.
+func parseFormatOptions(metadata []byte) (FormatOptions, error) {
+	var header luks2Metadata
+	if err := json.Unmarshal(metadata, &header); err != nil {
+		return FormatOptions{}, fmt.Errorf("failed to parse LUKS metadata: %w", err)
+	}
+
+	var opts FormatOptions
+	for _, id := range sortedIDs(header.Segments) {
+		if segment := header.Segments[id]; segment.Type == "crypt" {
+			opts.Cipher = segment.Encryption
+			break
+		}
+	}
+	for _, id := range sortedIDs(header.Keyslots) {
+		if keyslot := header.Keyslots[id]; keyslot.Type == "luks2" {
+			opts.KeySize = keyslot.KeySize * 8
+			opts.PBKDF = keyslot.KDF.Type
+			break
+		}
+	}
+
+	if opts.Cipher == "" {
+		return FormatOptions{}, fmt.Errorf("no encrypted segment in LUKS metadata")
+	}
+	return opts, nil
+}
+
+// sortedIDs returns the numeric keys of a LUKS2 metadata object in order
+func sortedIDs[V any](objects map[string]V) []string {
+	ids := make([]string, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, errA := strconv.Atoi(ids[i])
+		b, errB := strconv.Atoi(ids[j])
+		if errA != nil || errB != nil {
+			return ids[i] < ids[j]
+		}
+		return a < b
+	})
+	return ids
+}
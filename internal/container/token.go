@@ -0,0 +1,139 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// TPMAuth authenticates using a systemd-tpm2 LUKS2 token, letting the TPM
+// unseal the key instead of prompting for a passphrase
+type TPMAuth struct{}
+
+// Apply applies TPM token authentication to a command
+func (a *TPMAuth) Apply(cmd *exec.Cmd) error {
+	cmd.Args = append(cmd.Args, "--token-only", "--token-type", "systemd-tpm2")
+	return nil
+}
+
+// FIDO2Auth authenticates using a systemd-fido2 LUKS2 token. The user is
+// asked to touch their security token while the container is opened.
+type FIDO2Auth struct{}
+
+// Apply applies FIDO2 token authentication to a command
+func (a *FIDO2Auth) Apply(cmd *exec.Cmd) error {
+	cmd.Args = append(cmd.Args, "--token-only", "--token-type", "systemd-fido2")
+	return nil
+}
+
+// tokenPluginDirs lists where distributions install cryptsetup token plugins
+var tokenPluginDirs = []string{
+	"/usr/lib/cryptsetup",
+	"/usr/lib64/cryptsetup",
+	"/usr/lib/*/cryptsetup",
+}
+
+// tokenPluginAvailable reports whether cryptsetup can use a token type,
+// e.g. "systemd-tpm2", through its external token plugin
+func tokenPluginAvailable(tokenType string) bool {
+	for _, dir := range tokenPluginDirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "libcryptsetup-token-"+tokenType+".so"))
+		if len(matches) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTPM verifies a TPM2 device and the tooling to use it are present
+func (m *LUKSManager) CheckTPM() error {
+	if _, err := os.Stat("/dev/tpmrm0"); err != nil {
+		return fmt.Errorf("no TPM2 device found (/dev/tpmrm0)")
+	}
+	return m.checkTokenTooling("systemd-tpm2")
+}
+
+// CheckFIDO2 verifies the tooling to enroll and use FIDO2 tokens is present
+func (m *LUKSManager) CheckFIDO2() error {
+	return m.checkTokenTooling("systemd-fido2")
+}
+
+// checkTokenTooling verifies systemd-cryptenroll and the cryptsetup token
+// plugin for tokenType are installed
+func (m *LUKSManager) checkTokenTooling(tokenType string) error {
+	if !m.executor.CommandExists("systemd-cryptenroll") {
+		return fmt.Errorf("systemd-cryptenroll not found (install systemd)")
+	}
+	if !tokenPluginAvailable(tokenType) {
+		return fmt.Errorf("cryptsetup %s token plugin not found (install systemd's cryptsetup integration)", tokenType)
+	}
+	return nil
+}
+
+// EnrollTPM adds a key slot sealed to the TPM, with a systemd-tpm2 token
+// that lets the container be unlocked by TPMAuth. pcrs selects the PCRs the
+// key is bound to (e.g., "7"); empty uses the systemd-cryptenroll default.
+// An existing credential is needed to authorize the new key slot.
+func (m *LUKSManager) EnrollTPM(ctx context.Context, device string, auth AuthMethod, pcrs string) error {
+	args := []string{"--tpm2-device=auto"}
+	if pcrs != "" {
+		args = append(args, "--tpm2-pcrs="+pcrs)
+	}
+	return m.enroll(ctx, device, auth, args)
+}
+
+// EnrollFIDO2 adds a key slot unlocked by a FIDO2 security token, with a
+// systemd-fido2 token used by FIDO2Auth. fido2Device is the hidraw device
+// path, or "auto" to use the only token plugged in. The user is asked to
+// touch the token during enrollment.
+func (m *LUKSManager) EnrollFIDO2(ctx context.Context, device string, auth AuthMethod, fido2Device string) error {
+	if fido2Device == "" {
+		fido2Device = "auto"
+	}
+	return m.enroll(ctx, device, auth, []string{"--fido2-device=" + fido2Device})
+}
+
+// enroll runs systemd-cryptenroll with the given enrollment arguments,
+// authorizing with an existing credential
+func (m *LUKSManager) enroll(ctx context.Context, device string, auth AuthMethod, enrollArgs []string) error {
+	cmd := exec.CommandContext(ctx, "systemd-cryptenroll")
+	if err := applyUnlockKey(cmd, auth); err != nil {
+		return err
+	}
+	cmd.Args = append(cmd.Args, enrollArgs...)
+	cmd.Args = append(cmd.Args, device)
+
+	// Enrollment may wait for user interaction (e.g., a PIN or token touch)
+	if err := m.executor.RunCmdWithProgress(cmd); err != nil {
+		return fmt.Errorf("failed to enroll key: %w", err)
+	}
+	return nil
+}
+
+// applyUnlockKey passes an existing credential to systemd-cryptenroll.
+// Passwords are fed through stdin as an unlock key file, so they never
+// appear on the command line or in the environment.
+func applyUnlockKey(cmd *exec.Cmd, auth AuthMethod) error {
+	switch a := auth.(type) {
+	case *KeyfileAuth:
+		cmd.Args = append(cmd.Args, "--unlock-key-file="+a.KeyfilePath)
+	case *PasswordAuth:
+		if a.Password == nil {
+			return fmt.Errorf("password is nil")
+		}
+		cmd.Args = append(cmd.Args, "--unlock-key-file=/dev/stdin")
+		cmd.Stdin = bytes.NewReader(a.Password.Bytes())
+	case *StdinKeyAuth:
+		if a.Key == nil || a.Key.Len() == 0 {
+			return fmt.Errorf("key is empty")
+		}
+		cmd.Args = append(cmd.Args, "--unlock-key-file=/dev/stdin")
+		cmd.Stdin = bytes.NewReader(a.Key.Bytes())
+	default:
+		return fmt.Errorf("unsupported authentication type for enrollment: %T", auth)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package container
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nace/brezno/internal/system"
+	"github.com/nace/brezno/internal/testutil"
+)
+
+func TestTPMAuthApply(t *testing.T) {
+	cmd := exec.Command("cryptsetup", "luksOpen", "/dev/loop0", "m")
+	if err := (&TPMAuth{}).Apply(cmd); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []string{"cryptsetup", "luksOpen", "/dev/loop0", "m", "--token-only", "--token-type", "systemd-tpm2"}
+	if !reflect.DeepEqual(cmd.Args, want) || cmd.Stdin != nil {
+		t.Errorf("args = %v, stdin %v; want %v without stdin", cmd.Args, cmd.Stdin, want)
+	}
+}
+
+func TestOpenWithTPM(t *testing.T) {
+	exec := testutil.NewFakeExecutor()
+	if err := NewLUKSManager(exec).Open(context.Background(), "/dev/loop0", "secrets_img", &TPMAuth{}, false); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, ok := exec.Ran("cryptsetup luksOpen /dev/loop0 secrets_img --token-only --token-type systemd-tpm2"); !ok {
+		t.Errorf("ran %q", exec.Lines())
+	}
+}
+
+func TestEnrollTPM(t *testing.T) {
+	const device = "/data/secrets.img"
+	tests := []struct {
+		name      string
+		auth      AuthMethod
+		pcrs      string
+		want      string // Command run, or "" if rejected
+		wantStdin string
+	}{
+		{
+			name: "keyfile",
+			auth: &KeyfileAuth{KeyfilePath: "/keys/k"},
+			want: "systemd-cryptenroll --unlock-key-file=/keys/k --tpm2-device=auto " + device,
+		},
+		{
+			name: "keyfile and PCRs",
+			auth: &KeyfileAuth{KeyfilePath: "/keys/k"},
+			pcrs: "0+7",
+			want: "systemd-cryptenroll --unlock-key-file=/keys/k --tpm2-device=auto --tpm2-pcrs=0+7 " + device,
+		},
+		{
+			name:      "password on stdin, without a newline",
+			auth:      &PasswordAuth{Password: system.NewSecureBytes([]byte("hunter2"))},
+			want:      "systemd-cryptenroll --unlock-key-file=/dev/stdin --tpm2-device=auto " + device,
+			wantStdin: "hunter2",
+		},
+		{
+			name:      "key from stdin",
+			auth:      &StdinKeyAuth{Key: system.NewSecureBytes([]byte("raw\nkey"))},
+			want:      "systemd-cryptenroll --unlock-key-file=/dev/stdin --tpm2-device=auto " + device,
+			wantStdin: "raw\nkey",
+		},
+		{name: "nil password", auth: &PasswordAuth{}},
+		{name: "empty key", auth: &StdinKeyAuth{Key: system.NewSecureBytes(nil)}},
+		{name: "keyring", auth: &KeyringAuth{KeyDescription: "brezno:secrets"}},
+		{name: "another token", auth: &FIDO2Auth{}},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor()
+		err := NewLUKSManager(exec).EnrollTPM(context.Background(), device, tt.auth, tt.pcrs)
+		cmds := exec.Commands()
+		if tt.want == "" {
+			if err == nil || len(cmds) != 0 {
+				t.Errorf("%s: ran %q, err %v; want a refusal", tt.name, exec.Lines(), err)
+			}
+			continue
+		}
+		if err != nil || len(cmds) != 1 || cmds[0].Line() != tt.want || cmds[0].Stdin != tt.wantStdin {
+			t.Errorf("%s: ran %q, err %v; want %q with stdin %q", tt.name, exec.Lines(), err, tt.want, tt.wantStdin)
+		}
+	}
+}
+
+// withTokenPlugins points the token plugin lookup at a directory holding
+// plugins for the given token types
+func withTokenPlugins(t *testing.T, tokenTypes ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, tokenType := range tokenTypes {
+		if err := os.WriteFile(filepath.Join(dir, "libcryptsetup-token-"+tokenType+".so"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := tokenPluginDirs
+	tokenPluginDirs = []string{filepath.Join(t.TempDir(), "missing"), dir}
+	t.Cleanup(func() { tokenPluginDirs = saved })
+}
+
+func TestCheckTokenTooling(t *testing.T) {
+	withTokenPlugins(t, "systemd-tpm2")
+
+	m := NewLUKSManager(testutil.NewFakeExecutor())
+	if err := m.checkTokenTooling("systemd-tpm2"); err != nil {
+		t.Errorf("plugin and systemd-cryptenroll present: %v", err)
+	}
+	if err := m.checkTokenTooling("systemd-fido2"); err == nil {
+		t.Error("missing plugin: expected an error")
+	}
+
+	m = NewLUKSManager(testutil.NewFakeExecutor().Missing("systemd-cryptenroll"))
+	if err := m.checkTokenTooling("systemd-tpm2"); err == nil {
+		t.Error("missing systemd-cryptenroll: expected an error")
+	}
+}
+
+func TestFIDO2AuthApply(t *testing.T) {
+	cmd := exec.Command("cryptsetup", "luksOpen", "/dev/loop0", "m")
+	if err := (&FIDO2Auth{}).Apply(cmd); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []string{"cryptsetup", "luksOpen", "/dev/loop0", "m", "--token-only", "--token-type", "systemd-fido2"}
+	if !reflect.DeepEqual(cmd.Args, want) || cmd.Stdin != nil {
+		t.Errorf("args = %v, stdin %v; want %v without stdin", cmd.Args, cmd.Stdin, want)
+	}
+}
+
+func TestEnrollFIDO2(t *testing.T) {
+	const device = "/data/secrets.img"
+	tests := []struct {
+		fido2Device string
+		auth        AuthMethod
+		want        string
+		wantStdin   string
+	}{
+		{"", &KeyfileAuth{KeyfilePath: "/keys/k"}, "systemd-cryptenroll --unlock-key-file=/keys/k --fido2-device=auto " + device, ""},
+		{"auto", &KeyfileAuth{KeyfilePath: "/keys/k"}, "systemd-cryptenroll --unlock-key-file=/keys/k --fido2-device=auto " + device, ""},
+		{"/dev/hidraw3", &PasswordAuth{Password: system.NewSecureBytes([]byte("hunter2"))},
+			"systemd-cryptenroll --unlock-key-file=/dev/stdin --fido2-device=/dev/hidraw3 " + device, "hunter2"},
+	}
+	for _, tt := range tests {
+		exec := testutil.NewFakeExecutor()
+		err := NewLUKSManager(exec).EnrollFIDO2(context.Background(), device, tt.auth, tt.fido2Device)
+		cmds := exec.Commands()
+		if err != nil || len(cmds) != 1 || cmds[0].Line() != tt.want || cmds[0].Stdin != tt.wantStdin {
+			t.Errorf("device %q: ran %q, err %v; want %q with stdin %q", tt.fido2Device, exec.Lines(), err, tt.want, tt.wantStdin)
+		}
+	}
+
+	exec := testutil.NewFakeExecutor()
+	if err := NewLUKSManager(exec).EnrollFIDO2(context.Background(), device, &TPMAuth{}, ""); err == nil || len(exec.Lines()) != 0 {
+		t.Errorf("TPM as the existing credential: ran %q, err %v; want a refusal", exec.Lines(), err)
+	}
+}
+
+func TestCheckFIDO2(t *testing.T) {
+	withTokenPlugins(t, "systemd-fido2")
+	if err := NewLUKSManager(testutil.NewFakeExecutor()).CheckFIDO2(); err != nil {
+		t.Errorf("CheckFIDO2 with the plugin installed: %v", err)
+	}
+
+	withTokenPlugins(t, "systemd-tpm2")
+	if err := NewLUKSManager(testutil.NewFakeExecutor()).CheckFIDO2(); err == nil {
+		t.Error("CheckFIDO2 without the plugin: expected an error")
+	}
+}
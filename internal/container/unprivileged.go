@@ -0,0 +1,80 @@
+package container
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// discoverUnprivileged discovers active containers without root.
+// dm-crypt mappings are found through sysfs (dm/uuid starts with "CRYPT-"),
+// which is world-readable, instead of dmsetup, which needs root.
+func (d *Discovery) discoverUnprivileged(ctx context.Context) ([]Container, error) {
+	sysBlock := filepath.Join(d.sysRoot, "block")
+	dmDevices, err := filepath.Glob(filepath.Join(sysBlock, "dm-*"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Mount points are optional here: report the mappings even if
+	// mountinfo cannot be read
+	mounts, mountsErr := d.getMounts(ctx)
+	if mountsErr != nil {
+		d.warn("Mount information unavailable: %v", mountsErr)
+	}
+
+	var containers []Container
+	for _, dmDir := range dmDevices {
+		uuid, err := readSysfs(filepath.Join(dmDir, "dm", "uuid"))
+		if err != nil || !strings.HasPrefix(uuid, "CRYPT-") {
+			continue
+		}
+		name, err := readSysfs(filepath.Join(dmDir, "dm", "name"))
+		if err != nil {
+			continue
+		}
+
+		container := Container{
+			MapperName: name,
+			IsActive:   true,
+		}
+
+		// The backing device is the mapping's only slave
+		slaves, _ := os.ReadDir(filepath.Join(dmDir, "slaves"))
+		if len(slaves) == 1 {
+			slave := slaves[0].Name()
+			container.LoopDevice = "/dev/" + slave
+			if backFile, err := readSysfs(filepath.Join(sysBlock, slave, "loop", "backing_file")); err == nil {
+				container.Path = backFile
+			}
+		}
+		if container.Path == "" {
+			container.Unreadable = append(container.Unreadable, "path")
+		}
+		container.Managed = IsManaged(container)
+
+		if mountsErr != nil {
+			container.Unreadable = append(container.Unreadable, "mount point")
+		} else {
+			d.applyMount(&container, mounts)
+			// df needs search permission on the mount point
+			if container.MountPoint != "" && container.Size == 0 {
+				container.Unreadable = append(container.Unreadable, "size")
+			}
+		}
+
+		containers = append(containers, container)
+	}
+
+	return containers, nil
+}
+
+// readSysfs reads a single-value sysfs attribute
+func readSysfs(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
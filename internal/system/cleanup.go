@@ -1,53 +1,120 @@
 package system
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 )
 
+// WarningLogger receives warnings, such as cleanup failures as they happen
+type WarningLogger interface {
+	Warning(format string, args ...interface{})
+}
+
+// cleanupStep is a named cleanup operation
+type cleanupStep struct {
+	name string
+	fn   func() error
+}
+
 // CleanupStack manages cleanup operations in reverse order (LIFO)
 // This mimics bash trap cleanup behavior
 type CleanupStack struct {
-	cleanups []func() error
+	cleanups []cleanupStep
+	logger   WarningLogger
 	mu       sync.Mutex
 }
 
-// NewCleanupStack creates a new cleanup stack
+// activeStacks tracks cleanup stacks that have not yet been executed or
+// cleared, so a signal handler can run them if the process is interrupted
+var (
+	activeMu     sync.Mutex
+	activeStacks = make(map[*CleanupStack]struct{})
+)
+
+// NewCleanupStack creates a new cleanup stack.
+// The stack stays registered for ExecuteActiveCleanups until it is executed or cleared.
 func NewCleanupStack() *CleanupStack {
-	return &CleanupStack{
-		cleanups: make([]func() error, 0),
+	s := &CleanupStack{
+		cleanups: make([]cleanupStep, 0),
+	}
+
+	activeMu.Lock()
+	activeStacks[s] = struct{}{}
+	activeMu.Unlock()
+
+	return s
+}
+
+// ExecuteActiveCleanups executes every cleanup stack that is still pending.
+// It is meant for signal handlers: stacks already executed or cleared are skipped.
+func ExecuteActiveCleanups() error {
+	activeMu.Lock()
+	stacks := make([]*CleanupStack, 0, len(activeStacks))
+	for s := range activeStacks {
+		stacks = append(stacks, s)
 	}
+	activeMu.Unlock()
+
+	var errs []error
+	for _, s := range stacks {
+		errs = append(errs, s.Execute())
+	}
+	return errors.Join(errs...)
 }
 
-// Add adds a cleanup function to the stack
-func (s *CleanupStack) Add(cleanup func() error) {
+// unregister removes the stack from the active set
+func (s *CleanupStack) unregister() {
+	activeMu.Lock()
+	delete(activeStacks, s)
+	activeMu.Unlock()
+}
+
+// SetLogger makes Execute log each failed step as a warning as it happens
+func (s *CleanupStack) SetLogger(logger WarningLogger) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.cleanups = append(s.cleanups, cleanup)
+	s.logger = logger
 }
 
-// Execute runs all cleanup functions in reverse order (LIFO)
+// Add adds a cleanup function to the stack.
+// name describes the step (e.g., "detach /dev/loop3") and prefixes its error.
+func (s *CleanupStack) Add(name string, cleanup func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanups = append(s.cleanups, cleanupStep{name: name, fn: cleanup})
+}
+
+// Execute runs all cleanup functions in reverse order (LIFO).
+// Each function runs at most once, so calling Execute again is a no-op.
+// The returned error joins every failure (see errors.Join), each wrapped
+// with the name of its step.
 func (s *CleanupStack) Execute() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.unregister()
 
 	var errs []error
 	// Execute in reverse order
 	for i := len(s.cleanups) - 1; i >= 0; i-- {
-		if err := s.cleanups[i](); err != nil {
+		step := s.cleanups[i]
+		if err := step.fn(); err != nil {
+			err = fmt.Errorf("%s: %w", step.name, err)
+			if s.logger != nil {
+				s.logger.Warning("Cleanup failed: %v", err)
+			}
 			errs = append(errs, err)
 		}
 	}
+	s.cleanups = nil
 
-	if len(errs) > 0 {
-		return fmt.Errorf("cleanup errors: %v", errs)
-	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // Clear removes all cleanup functions (call on success to prevent cleanup)
 func (s *CleanupStack) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.unregister()
 	s.cleanups = nil
 }
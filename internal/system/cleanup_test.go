@@ -0,0 +1,119 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// step returns a cleanup function that appends name to ran
+func step(ran *[]string, name string) func() error {
+	return func() error {
+		*ran = append(*ran, name)
+		return nil
+	}
+}
+
+func TestCleanupStackOrder(t *testing.T) {
+	var ran []string
+	s := NewCleanupStack()
+	s.Add("remove file", step(&ran, "remove file"))
+	s.Add("detach loop", step(&ran, "detach loop"))
+	s.Add("close mapper", step(&ran, "close mapper"))
+
+	if err := s.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	want := []string{"close mapper", "detach loop", "remove file"}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+
+	// A second Execute, e.g. from the signal handler, repeats nothing
+	if err := s.Execute(); err != nil || len(ran) != len(want) {
+		t.Errorf("second Execute ran %v, err %v", ran, err)
+	}
+}
+
+func TestExecuteActiveCleanups(t *testing.T) {
+	var ran []string
+	pending := NewCleanupStack()
+	pending.Add("pending", step(&ran, "pending"))
+	cleared := NewCleanupStack()
+	cleared.Add("cleared", step(&ran, "cleared"))
+	cleared.Clear()
+	done := NewCleanupStack()
+	done.Add("done", step(&ran, "done"))
+	if err := done.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	ran = nil
+
+	if err := ExecuteActiveCleanups(); err != nil {
+		t.Fatalf("ExecuteActiveCleanups: %v", err)
+	}
+	if !reflect.DeepEqual(ran, []string{"pending"}) {
+		t.Errorf("ran %v, want only the pending stack", ran)
+	}
+
+	// The deferred Execute of the interrupted command then does nothing
+	if err := pending.Execute(); err != nil || len(ran) != 1 {
+		t.Errorf("deferred Execute ran %v, err %v", ran, err)
+	}
+}
+
+// warningRecorder is a WarningLogger keeping the warnings it receives
+type warningRecorder struct {
+	warnings []string
+}
+
+func (r *warningRecorder) Warning(format string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestCleanupStackErrors(t *testing.T) {
+	errDetach := errors.New("device busy")
+	errRemove := &os.PathError{Op: "remove", Path: "/data/new.img", Err: os.ErrPermission}
+
+	var ran []string
+	var logged warningRecorder
+	s := NewCleanupStack()
+	s.SetLogger(&logged)
+	s.Add("remove /data/new.img", func() error { return errRemove })
+	s.Add("detach /dev/loop3", func() error { return errDetach })
+	s.Add("close new_img", step(&ran, "close new_img"))
+
+	err := s.Execute()
+	if err == nil {
+		t.Fatal("Execute succeeded despite two failing steps")
+	}
+	// A failed step doesn't stop the ones below it
+	if !reflect.DeepEqual(ran, []string{"close new_img"}) {
+		t.Errorf("ran %v", ran)
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("Execute = %#v, want the two failures joined", err)
+	}
+	if got := joined.Unwrap()[0].Error(); got != "detach /dev/loop3: device busy" {
+		t.Errorf("first failure = %q", got)
+	}
+	if !errors.Is(err, errDetach) || !errors.Is(err, os.ErrPermission) {
+		t.Errorf("failures not retrievable from %v", err)
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "/data/new.img" {
+		t.Errorf("errors.As(%v) = %v", err, pathErr)
+	}
+
+	want := []string{
+		"Cleanup failed: detach /dev/loop3: device busy",
+		"Cleanup failed: remove /data/new.img: remove /data/new.img: permission denied",
+	}
+	if !reflect.DeepEqual(logged.warnings, want) {
+		t.Errorf("warnings = %q, want %q", logged.warnings, want)
+	}
+}
@@ -0,0 +1,56 @@
+package system
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultCipherThroughput is assumed when no cryptsetup benchmark is
+	// available (bytes/s, a modest AES-NI machine)
+	defaultCipherThroughput = 500 * 1024 * 1024
+
+	// keyDerivationTime covers the PBKDF run by luksFormat and luksOpen,
+	// which cryptsetup calibrates to about 2 seconds each
+	keyDerivationTime = 4 * time.Second
+)
+
+// mkfsWriteFraction approximates how much of the device each mkfs writes
+// (inode tables, metadata), as a fraction of its size
+var mkfsWriteFraction = map[string]float64{
+	"ext4":  0.02,
+	"xfs":   0.001,
+	"btrfs": 0.001,
+}
+
+// EstimateCreateDuration roughly estimates how long formatting and creating
+// a filesystem on a new container takes. cipherThroughput is the encryption
+// speed in bytes/s (e.g., from cryptsetup benchmark); 0 uses a default.
+func EstimateCreateDuration(sizeBytes uint64, fsType string, cipherThroughput uint64) time.Duration {
+	if cipherThroughput == 0 {
+		cipherThroughput = defaultCipherThroughput
+	}
+
+	fraction, ok := mkfsWriteFraction[fsType]
+	if !ok {
+		fraction = mkfsWriteFraction["ext4"]
+	}
+
+	written := float64(sizeBytes) * fraction
+	seconds := written / float64(cipherThroughput)
+	return keyDerivationTime + time.Duration(seconds*float64(time.Second))
+}
+
+// FormatDuration formats an estimate for humans, e.g. "about 3 minutes"
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < 2*time.Minute:
+		return "about a minute"
+	case d < time.Hour-30*time.Second: // Rounds to at most 59 minutes
+		return fmt.Sprintf("about %d minutes", int(d.Round(time.Minute).Minutes()))
+	default:
+		return fmt.Sprintf("about %.1f hours", d.Hours())
+	}
+}
@@ -0,0 +1,63 @@
+package system
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateCreateDuration(t *testing.T) {
+	const (
+		GiB = 1 << 30
+		MiB = 1 << 20
+	)
+	tests := []struct {
+		size       uint64
+		fsType     string
+		throughput uint64
+		want       time.Duration
+	}{
+		{0, "ext4", 0, keyDerivationTime},
+		// ext4 writes 2% of the device: 2 GiB at the default 500 MiB/s
+		{100 * GiB, "ext4", 0, keyDerivationTime + 4096*time.Millisecond},
+		{100 * GiB, "ext4", 2000 * MiB, keyDerivationTime + 1024*time.Millisecond},
+		// xfs and btrfs write far less
+		{1000 * GiB, "xfs", 1000 * MiB, keyDerivationTime + 1024*time.Millisecond},
+		{1000 * GiB, "btrfs", 1000 * MiB, keyDerivationTime + 1024*time.Millisecond},
+		// Unknown filesystems are estimated like ext4
+		{100 * GiB, "vfat", 0, keyDerivationTime + 4096*time.Millisecond},
+		{16 << 40, "ext4", 0, keyDerivationTime + 671088640*time.Microsecond},
+	}
+	for _, tt := range tests {
+		got := EstimateCreateDuration(tt.size, tt.fsType, tt.throughput)
+		if got.Round(time.Millisecond) != tt.want.Round(time.Millisecond) {
+			t.Errorf("EstimateCreateDuration(%d, %s, %d) = %v, want %v", tt.size, tt.fsType, tt.throughput, got, tt.want)
+		}
+	}
+
+	// A faster cipher never makes the estimate longer
+	if EstimateCreateDuration(1<<40, "ext4", 4000*MiB) >= EstimateCreateDuration(1<<40, "ext4", 100*MiB) {
+		t.Error("estimate does not shrink with throughput")
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "less than a minute"},
+		{59 * time.Second, "less than a minute"},
+		{time.Minute, "about a minute"},
+		{119 * time.Second, "about a minute"},
+		{2 * time.Minute, "about 2 minutes"},
+		{2*time.Minute + 30*time.Second, "about 3 minutes"},
+		{59*time.Minute + 29*time.Second, "about 59 minutes"},
+		{59*time.Minute + 30*time.Second, "about 1.0 hours"},
+		{90 * time.Minute, "about 1.5 hours"},
+	}
+	for _, tt := range tests {
+		if got := FormatDuration(tt.d); got != tt.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
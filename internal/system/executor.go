@@ -2,41 +2,105 @@ package system
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-// Executor handles execution of external commands
-type Executor struct {
+// Executor runs external commands. CommandExecutor is the real
+// implementation; tests can substitute a fake that records commands.
+type Executor interface {
+	Run(ctx context.Context, name string, args ...string) error
+	RunOutput(ctx context.Context, name string, args ...string) (string, error)
+	RunCmd(cmd *exec.Cmd) (string, error)
+	RunCmdWithProgress(cmd *exec.Cmd) error
+	RunCmdStreaming(cmd *exec.Cmd) (string, error)
+	CommandExists(name string) bool
+	CheckDependencies(deps []string) error
+}
+
+// Ensure CommandExecutor implements Executor
+var _ Executor = (*CommandExecutor)(nil)
+
+// CommandExecutor handles execution of external commands
+type CommandExecutor struct {
+	// dryRun prints commands to out instead of running them. Every
+	// external command goes through RunCmd or RunCmdWithProgress, mkfs
+	// and the filesystem resize tools included, so all of them are printed.
 	dryRun bool
 	debug  bool
+	out    io.Writer
+	// stderr, if set, replaces os.Stderr for debug messages and the
+	// output streamed by RunCmdWithProgress and RunCmdStreaming
+	stderr io.Writer
 }
 
 // NewExecutor creates a new executor
-func NewExecutor(debug bool) *Executor {
-	return &Executor{
+func NewExecutor(debug bool) *CommandExecutor {
+	return &CommandExecutor{
 		dryRun: false,
 		debug:  debug,
+		out:    os.Stdout,
+	}
+}
+
+// NewDryRunExecutor creates an executor that prints each command to out
+// instead of running it
+func NewDryRunExecutor(out io.Writer) *CommandExecutor {
+	return &CommandExecutor{
+		dryRun: true,
+		out:    out,
+	}
+}
+
+// errOut returns where debug messages and streamed output go
+func (e *CommandExecutor) errOut() io.Writer {
+	if e.stderr != nil {
+		return e.stderr
+	}
+	return os.Stderr
+}
+
+// DryRunLoopDevice stands in for the device a dry-run losetup --show would
+// have printed, so the commands using it still show where it goes
+const DryRunLoopDevice = "/dev/loopN"
+
+// dryRunOutput prints cmd instead of running it and returns the output
+// callers parse, as far as it can be known without running it
+func (e *CommandExecutor) dryRunOutput(cmd *exec.Cmd) string {
+	fmt.Fprintf(e.out, "[DRY RUN] %s\n", e.sanitizeCommand(cmd))
+	if filepath.Base(cmd.Args[0]) != "losetup" {
+		return ""
+	}
+	for _, arg := range cmd.Args[1:] {
+		if arg == "--show" {
+			return DryRunLoopDevice + "\n"
+		}
 	}
+	return ""
 }
 
 // Run executes a command and discards output
-func (e *Executor) Run(name string, args ...string) error {
-	_, err := e.RunOutput(name, args...)
+func (e *CommandExecutor) Run(ctx context.Context, name string, args ...string) error {
+	_, err := e.RunOutput(ctx, name, args...)
 	return err
 }
 
-// RunOutput executes a command and returns stdout
-func (e *Executor) RunOutput(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
+// RunOutput executes a command and returns stdout.
+// The command is killed if ctx is cancelled.
+func (e *CommandExecutor) RunOutput(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
 	return e.RunCmd(cmd)
 }
 
 // sanitizeCommand returns a sanitized command string for logging,
 // redacting sensitive arguments like keyfile paths
-func (e *Executor) sanitizeCommand(cmd *exec.Cmd) string {
+func (e *CommandExecutor) sanitizeCommand(cmd *exec.Cmd) string {
 	if cmd == nil || len(cmd.Args) == 0 {
 		return ""
 	}
@@ -51,6 +115,13 @@ func (e *Executor) sanitizeCommand(cmd *exec.Cmd) string {
 		}
 	}
 
+	// Redact keyfile paths passed as --flag=value (e.g., systemd-cryptenroll)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--unlock-key-file=") && arg != "--unlock-key-file=/dev/stdin" {
+			args[i] = "--unlock-key-file=[REDACTED]"
+		}
+	}
+
 	// For luksChangeKey, also redact the positional keyfile argument (if present)
 	// Format: cryptsetup luksChangeKey --key-slot 0 <device> [<new keyfile>]
 	if len(args) >= 2 && args[1] == "luksChangeKey" {
@@ -60,6 +131,12 @@ func (e *Executor) sanitizeCommand(cmd *exec.Cmd) string {
 		}
 	}
 
+	// For luksAddKey, the new keyfile follows the device
+	// Format: cryptsetup luksAddKey <device> <new keyfile> ...
+	if len(args) >= 4 && args[1] == "luksAddKey" {
+		args[3] = "[REDACTED]"
+	}
+
 	result := strings.Join(args, " ")
 
 	// Indicate if stdin is being used (potential password input)
@@ -70,15 +147,15 @@ func (e *Executor) sanitizeCommand(cmd *exec.Cmd) string {
 	return result
 }
 
-// RunCmd executes a prepared command
-func (e *Executor) RunCmd(cmd *exec.Cmd) (string, error) {
+// RunCmd executes a prepared command. Create it with exec.CommandContext
+// so that it can be cancelled.
+func (e *CommandExecutor) RunCmd(cmd *exec.Cmd) (string, error) {
 	if e.dryRun {
-		fmt.Printf("[DRY RUN] %s\n", e.sanitizeCommand(cmd))
-		return "", nil
+		return e.dryRunOutput(cmd), nil
 	}
 
 	if e.debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Executing: %s\n", e.sanitizeCommand(cmd))
+		fmt.Fprintf(e.errOut(), "[DEBUG] Executing: %s\n", e.sanitizeCommand(cmd))
 	}
 
 	var stdout, stderr bytes.Buffer
@@ -94,14 +171,85 @@ func (e *Executor) RunCmd(cmd *exec.Cmd) (string, error) {
 	return stdout.String(), nil
 }
 
+// RunCmdWithProgress executes a prepared long-running command, passing its
+// output straight through to stderr so the user can follow its progress
+func (e *CommandExecutor) RunCmdWithProgress(cmd *exec.Cmd) error {
+	if e.dryRun {
+		e.dryRunOutput(cmd)
+		return nil
+	}
+
+	if e.debug {
+		fmt.Fprintf(e.errOut(), "[DEBUG] Executing: %s\n", e.sanitizeCommand(cmd))
+	}
+
+	cmd.Stdout = e.errOut()
+	cmd.Stderr = e.errOut()
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", cmd.Args[0], err)
+	}
+
+	return nil
+}
+
+// streamingTick is how often RunCmdStreaming reports that a command is
+// still running
+var streamingTick = 5 * time.Second
+
+// RunCmdStreaming executes a prepared command that may run for a while
+// without output, such as luksFormat deriving its key. It behaves like
+// RunCmd, except that in debug mode stderr is also streamed as it is
+// written and the elapsed time is reported. Stdin is left to the caller,
+// so a password passed on stdin is unaffected.
+func (e *CommandExecutor) RunCmdStreaming(cmd *exec.Cmd) (string, error) {
+	if !e.debug || e.dryRun {
+		return e.RunCmd(cmd)
+	}
+
+	fmt.Fprintf(e.errOut(), "[DEBUG] Executing: %s\n", e.sanitizeCommand(cmd))
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.MultiWriter(e.errOut(), &stderr)
+
+	start := time.Now()
+	done := make(chan struct{})
+	ticker := time.NewTicker(streamingTick)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fmt.Fprintf(e.errOut(), "[DEBUG] %s still running (%s elapsed)\n",
+					cmd.Args[0], time.Since(start).Round(time.Second))
+			}
+		}
+	}()
+
+	err := cmd.Run()
+	close(done)
+	fmt.Fprintf(e.errOut(), "[DEBUG] %s finished after %s\n",
+		cmd.Args[0], time.Since(start).Round(100*time.Millisecond))
+
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w\nStderr: %s",
+			cmd.Args[0], err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
 // CommandExists checks if a command is available in PATH
-func (e *Executor) CommandExists(name string) bool {
+func (e *CommandExecutor) CommandExists(name string) bool {
 	_, err := exec.LookPath(name)
 	return err == nil
 }
 
 // CheckDependencies verifies required commands are available
-func (e *Executor) CheckDependencies(deps []string) error {
+func (e *CommandExecutor) CheckDependencies(deps []string) error {
 	var missing []string
 	for _, dep := range deps {
 		if !e.CommandExists(dep) {
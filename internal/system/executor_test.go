@@ -0,0 +1,144 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchedWriter collects what a command writes, calling onWrite with
+// everything written so far after each write
+type watchedWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	onWrite func(written string)
+}
+
+func (w *watchedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	if w.onWrite != nil {
+		w.onWrite(w.buf.String())
+	}
+	return len(p), nil
+}
+
+func (w *watchedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestRunCmdStreaming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The command only continues once its first line has reached the
+	// terminal, which buffering stderr until it exits would never do
+	stdin, send := io.Pipe()
+	var once sync.Once
+	out := &watchedWriter{onWrite: func(written string) {
+		if strings.Contains(written, "deriving key\n") {
+			once.Do(func() {
+				go func() {
+					io.WriteString(send, "hunter2\n")
+					send.Close()
+				}()
+			})
+		}
+	}}
+	e := NewExecutor(true)
+	e.stderr = out
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", `echo "deriving key" >&2; read pw; echo "stdin: $pw"; echo done >&2`)
+	cmd.Stdin = stdin
+	stdout, err := e.RunCmdStreaming(cmd)
+	if err != nil {
+		t.Fatalf("RunCmdStreaming: %v\n%s", err, out)
+	}
+	// The password on stdin is untouched by the streaming
+	if stdout != "stdin: hunter2\n" {
+		t.Errorf("stdout = %q", stdout)
+	}
+	got := out.String()
+	for _, want := range []string{"[DEBUG] Executing: sh -c", "deriving key\ndone\n", "[DEBUG] sh finished after"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("stderr %q lacks %q", got, want)
+		}
+	}
+	if strings.Contains(got, "hunter2") {
+		t.Errorf("stdin leaked to stderr: %q", got)
+	}
+}
+
+func TestRunCmdStreamingElapsed(t *testing.T) {
+	old := streamingTick
+	t.Cleanup(func() { streamingTick = old })
+	streamingTick = 10 * time.Millisecond
+
+	out := &watchedWriter{}
+	e := NewExecutor(true)
+	e.stderr = out
+	if _, err := e.RunCmdStreaming(exec.Command("sleep", "0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[DEBUG] sleep still running") {
+		t.Errorf("no elapsed time reported: %q", out)
+	}
+}
+
+func TestRunCmdStreamingQuiet(t *testing.T) {
+	// Without --debug the output is only returned, as by RunCmd
+	out := &watchedWriter{}
+	e := NewExecutor(false)
+	e.stderr = out
+	_, err := e.RunCmdStreaming(exec.Command("sh", "-c", "echo oops >&2; exit 3"))
+	if err == nil || !strings.Contains(err.Error(), "Stderr: oops") {
+		t.Errorf("RunCmdStreaming = %v, want the captured stderr", err)
+	}
+	if out.String() != "" {
+		t.Errorf("streamed %q without --debug", out)
+	}
+}
+
+func TestRunCmdWithProgress(t *testing.T) {
+	out := &watchedWriter{}
+	e := NewExecutor(false)
+	e.stderr = out
+	if err := e.RunCmdWithProgress(exec.Command("sh", "-c", "echo 50%; echo syncing >&2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "50%\nsyncing\n" {
+		t.Errorf("progress output = %q", got)
+	}
+}
+
+func TestRunCmdCancelled(t *testing.T) {
+	e := NewExecutor(true)
+	e.stderr = &watchedWriter{}
+	run := map[string]func(*exec.Cmd) error{
+		"RunCmdStreaming": func(cmd *exec.Cmd) error {
+			_, err := e.RunCmdStreaming(cmd)
+			return err
+		},
+		"RunCmdWithProgress": e.RunCmdWithProgress,
+	}
+	for name, run := range run {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := run(exec.CommandContext(ctx, "sleep", "30"))
+		cancel()
+		if err == nil {
+			t.Errorf("%s: cancelled command succeeded", name)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("%s: returned after %s, the child wasn't killed", name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Holder is a process keeping files under a mount point open, or using a
+// directory there as its working or root directory
+type Holder struct {
+	PID     int
+	Command string
+	// StartTime is when the process started, in clock ticks after boot
+	// (field 22 of /proc/<pid>/stat). A reused PID has a different one.
+	StartTime uint64
+}
+
+// holderPollInterval is how often TerminateHolders checks whether the
+// signalled processes have exited
+const holderPollInterval = 100 * time.Millisecond
+
+// FindHolders lists the processes using anything under mountPoint, by
+// reading their cwd, root, executable, and open files in /proc. brezno
+// itself is never included.
+func FindHolders(mountPoint string) ([]Holder, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	self := os.Getpid()
+	var holders []Holder
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		if !usesPath(pid, mountPoint) {
+			continue
+		}
+		// A process that can't be identified can't be signalled safely
+		if h, ok := holderOf(pid); ok {
+			holders = append(holders, h)
+		}
+	}
+
+	sort.Slice(holders, func(i, j int) bool { return holders[i].PID < holders[j].PID })
+	return holders, nil
+}
+
+// usesPath reports whether any of a process's /proc links point at or
+// below path. Processes that exit or can't be read are skipped.
+func usesPath(pid int, path string) bool {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+	links := []string{"cwd", "root", "exe"}
+	if fds, err := os.ReadDir(filepath.Join(procDir, "fd")); err == nil {
+		for _, fd := range fds {
+			links = append(links, filepath.Join("fd", fd.Name()))
+		}
+	}
+
+	for _, link := range links {
+		target, err := os.Readlink(filepath.Join(procDir, link))
+		if err != nil {
+			continue
+		}
+		if isWithin(strings.TrimSuffix(target, " (deleted)"), path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether target is path or below it
+func isWithin(target, path string) bool {
+	if path == "/" {
+		return strings.HasPrefix(target, "/")
+	}
+	return target == path || strings.HasPrefix(target, path+"/")
+}
+
+// processCommand returns a process's command name, or "" if it has exited
+func processCommand(pid int) string {
+	comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+// holderOf identifies a live process. It fails if the process has exited
+// or is a zombie.
+func holderOf(pid int) (Holder, bool) {
+	state, start, err := processStat(pid)
+	if err != nil || state == 'Z' {
+		return Holder{}, false
+	}
+	return Holder{PID: pid, Command: processCommand(pid), StartTime: start}, true
+}
+
+// processStat returns a process's state and start time from
+// /proc/<pid>/stat
+func processStat(pid int) (byte, uint64, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseProcStat(string(data))
+}
+
+// parseProcStat extracts the state (field 3) and start time (field 22)
+// from the contents of /proc/<pid>/stat. The command name in field 2 may
+// contain spaces and parentheses, so fields are counted from its last ')'.
+func parseProcStat(data string) (byte, uint64, error) {
+	end := strings.LastIndexByte(data, ')')
+	if end < 0 {
+		return 0, 0, fmt.Errorf("malformed process stat: %q", data)
+	}
+	// fields[0] is field 3
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 20 || len(fields[0]) != 1 {
+		return 0, 0, fmt.Errorf("malformed process stat: %q", data)
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed process start time: %w", err)
+	}
+	return fields[0][0], start, nil
+}
+
+// alive reports whether h is still the same live process: its PID has not
+// been reused by another process and it is not a zombie
+func alive(h Holder) bool {
+	current, ok := holderOf(h.PID)
+	return ok && current.StartTime == h.StartTime
+}
+
+// validateHolder refuses to signal init, brezno itself, or a PID that no
+// longer belongs to the process that was found (it exited and the PID was
+// reused)
+func validateHolder(h Holder) error {
+	if h.PID <= 1 {
+		return fmt.Errorf("refusing to signal PID %d", h.PID)
+	}
+	if h.PID == os.Getpid() {
+		return fmt.Errorf("refusing to signal brezno itself (PID %d)", h.PID)
+	}
+	if !alive(h) {
+		return fmt.Errorf("PID %d is no longer %s", h.PID, h.Command)
+	}
+	return nil
+}
+
+// TerminateHolders sends SIGTERM to each holder, waits up to grace for
+// them to exit, then sends SIGKILL to those still running. Holders that
+// fail validation are skipped and reported in the returned error.
+func TerminateHolders(ctx context.Context, holders []Holder, grace time.Duration) error {
+	var errs []string
+	var signalled []Holder
+	for _, h := range holders {
+		if err := validateHolder(h); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := syscall.Kill(h.PID, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+			errs = append(errs, fmt.Sprintf("SIGTERM to PID %d: %v", h.PID, err))
+			continue
+		}
+		signalled = append(signalled, h)
+	}
+
+	deadline := time.Now().Add(grace)
+	for len(running(signalled)) > 0 && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(holderPollInterval):
+		}
+	}
+
+	for _, h := range running(signalled) {
+		if err := syscall.Kill(h.PID, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			errs = append(errs, fmt.Sprintf("SIGKILL to PID %d: %v", h.PID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to stop some processes: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// running returns the holders that are still the same live process. A
+// zombie has exited and only waits for its parent to reap it.
+func running(holders []Holder) []Holder {
+	var live []Holder
+	for _, h := range holders {
+		if alive(h) {
+			live = append(live, h)
+		}
+	}
+	return live
+}
@@ -0,0 +1,141 @@
+package system
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		target, path string
+		want         bool
+	}{
+		{"/mnt/secrets", "/mnt/secrets", true},
+		{"/mnt/secrets/a/b", "/mnt/secrets", true},
+		{"/mnt/secrets2", "/mnt/secrets", false},
+		{"/mnt", "/mnt/secrets", false},
+		{"/anything", "/", true},
+		{"socket:[1234]", "/", false},
+		{"pipe:[42]", "/mnt", false},
+	}
+	for _, tt := range tests {
+		if got := isWithin(tt.target, tt.path); got != tt.want {
+			t.Errorf("isWithin(%q, %q) = %v, want %v", tt.target, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	stat := "4242 (my (odd) cmd) S 1 4242 4242 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 987654 1000 100\n"
+	state, start, err := parseProcStat(stat)
+	if err != nil || state != 'S' || start != 987654 {
+		t.Errorf("parseProcStat = %c, %d, %v; want S, 987654", state, start, err)
+	}
+
+	for _, bad := range []string{"", "4242 no parens", "4242 (sh) S 1 2 3", "4242 (sh) S 1 4242 4242 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 x 0 0"} {
+		if _, _, err := parseProcStat(bad); err == nil {
+			t.Errorf("parseProcStat(%q): expected an error", bad)
+		}
+	}
+}
+
+// startProcess starts a command that ends up running sleep, waits until it
+// does, and returns it with its holder
+func startProcess(t *testing.T, command string, args ...string) (*exec.Cmd, Holder) {
+	t.Helper()
+	cmd := exec.Command(command, args...)
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start %s: %v", command, err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if h, ok := holderOf(cmd.Process.Pid); ok && h.Command == "sleep" {
+			return cmd, h
+		}
+	}
+	t.Fatalf("%s did not start", command)
+	return nil, Holder{}
+}
+
+func TestValidateHolder(t *testing.T) {
+	_, h := startProcess(t, "sleep", "60")
+	if err := validateHolder(h); err != nil {
+		t.Errorf("validateHolder(live process) = %v", err)
+	}
+
+	reused := h
+	reused.StartTime++
+	if err := validateHolder(reused); err == nil {
+		t.Error("validateHolder accepted a PID with another start time")
+	}
+
+	self, ok := holderOf(os.Getpid())
+	if !ok {
+		t.Fatal("cannot identify the test process")
+	}
+	for _, h := range []Holder{{PID: 1}, {PID: 0}, {PID: -1}, self} {
+		if err := validateHolder(h); err == nil {
+			t.Errorf("validateHolder(PID %d) succeeded", h.PID)
+		}
+	}
+}
+
+func TestTerminateHolders(t *testing.T) {
+	cmd, h := startProcess(t, "sleep", "60")
+
+	start := time.Now()
+	if err := TerminateHolders(context.Background(), []Holder{h}, 5*time.Second); err != nil {
+		t.Fatalf("TerminateHolders: %v", err)
+	}
+	// The unreaped zombie counts as exited, so the grace period is cut short
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("TerminateHolders waited %s for a process that exited", elapsed)
+	}
+	if err := cmd.Wait(); !exitedBy(err, syscall.SIGTERM) {
+		t.Errorf("process ended with %v, want SIGTERM", err)
+	}
+}
+
+func TestTerminateHoldersKillsAfterGrace(t *testing.T) {
+	// An ignored SIGTERM stays ignored across exec
+	cmd, h := startProcess(t, "sh", "-c", `trap "" TERM; exec sleep 60`)
+
+	if err := TerminateHolders(context.Background(), []Holder{h}, 200*time.Millisecond); err != nil {
+		t.Fatalf("TerminateHolders: %v", err)
+	}
+	if err := cmd.Wait(); !exitedBy(err, syscall.SIGKILL) {
+		t.Errorf("process ended with %v, want SIGKILL", err)
+	}
+}
+
+func TestTerminateHoldersSkipsReusedPID(t *testing.T) {
+	cmd, h := startProcess(t, "sleep", "60")
+	h.StartTime++
+
+	err := TerminateHolders(context.Background(), []Holder{h}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "is no longer sleep") {
+		t.Fatalf("TerminateHolders = %v, want the PID refused", err)
+	}
+	if err := cmd.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("the process behind the reused PID was signalled: %v", err)
+	}
+}
+
+// exitedBy reports whether err is from a process killed by sig
+func exitedBy(err error, sig syscall.Signal) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == sig
+}
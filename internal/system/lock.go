@@ -0,0 +1,56 @@
+package system
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockDir holds the per-container lock files
+var lockDir = "/run/lock/brezno"
+
+// ErrLocked is returned by LockPath when another process holds the lock
+var ErrLocked = errors.New("lock is held by another process")
+
+// FileLock is an advisory flock(2) lock. It is released by Unlock or when
+// the process exits.
+type FileLock struct {
+	file *os.File
+}
+
+// LockPath takes an exclusive lock for path without blocking. The lock file
+// lives in /run/lock/brezno rather than next to path, so path need not exist
+// yet and no stray files are left beside containers.
+func LockPath(path string) (*FileLock, error) {
+	if err := os.MkdirAll(lockDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(path))
+	lockFile := filepath.Join(lockDir, hex.EncodeToString(sum[:8])+".lock")
+
+	file, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
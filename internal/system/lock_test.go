@@ -0,0 +1,75 @@
+package system
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// useTempLockDir points the lock files at a directory of the test's own
+func useTempLockDir(t *testing.T) {
+	t.Helper()
+	saved := lockDir
+	lockDir = t.TempDir()
+	t.Cleanup(func() { lockDir = saved })
+}
+
+func TestLockPathContention(t *testing.T) {
+	useTempLockDir(t)
+
+	const contenders = 2
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	locks := make(chan *FileLock, contenders)
+	errs := make(chan error, contenders)
+	for i := 0; i < contenders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			lock, err := LockPath("/data/secrets.img")
+			if err != nil {
+				errs <- err
+				return
+			}
+			locks <- lock
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(locks)
+	close(errs)
+
+	if len(locks) != 1 || len(errs) != 1 {
+		t.Fatalf("%d goroutines got the lock, %d failed; want one each", len(locks), len(errs))
+	}
+	if err := <-errs; !errors.Is(err, ErrLocked) {
+		t.Errorf("loser got %v, want ErrLocked", err)
+	}
+
+	// Once released, the lock can be taken again
+	if err := (<-locks).Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	lock, err := LockPath("/data/secrets.img")
+	if err != nil {
+		t.Fatalf("LockPath after Unlock: %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestLockPathIndependent(t *testing.T) {
+	useTempLockDir(t)
+
+	first, err := LockPath("/data/a.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Unlock()
+
+	second, err := LockPath("/data/b.img")
+	if err != nil {
+		t.Fatalf("lock on another container blocked: %v", err)
+	}
+	second.Unlock()
+}
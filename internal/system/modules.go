@@ -0,0 +1,89 @@
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Where ModuleAvailable looks for modules, replaced in tests
+var (
+	procModulesPath = "/proc/modules"
+	sysModuleDir    = "/sys/module"
+	libModulesDir   = "/lib/modules"
+)
+
+// ModuleAvailable checks whether a kernel module is loaded or built into the kernel.
+// Module names use underscores as in /proc/modules (e.g., dm_crypt).
+func ModuleAvailable(name string) (bool, error) {
+	data, err := os.ReadFile(procModulesPath)
+	if err == nil && moduleListed(string(data), name) {
+		return true, nil
+	}
+	procErr := err
+
+	// Built-in modules don't appear in /proc/modules but usually have a
+	// /sys/module entry
+	if _, err := os.Stat(filepath.Join(sysModuleDir, name)); err == nil {
+		return true, nil
+	}
+
+	// Built-in modules without parameters only appear in modules.builtin
+	if release, err := kernelRelease(); err == nil {
+		builtin := filepath.Join(libModulesDir, release, "modules.builtin")
+		if data, err := os.ReadFile(builtin); err == nil && builtinListed(string(data), name) {
+			return true, nil
+		}
+	}
+
+	if procErr != nil {
+		return false, fmt.Errorf("failed to read %s: %w", procModulesPath, procErr)
+	}
+	return false, nil
+}
+
+// moduleListed reports whether a module appears in /proc/modules content.
+// Format: "name size refcount deps state address"
+func moduleListed(procModules, name string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(procModules))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinListed reports whether a module appears in modules.builtin content.
+// Format: "kernel/drivers/md/dm-crypt.ko", with dashes or underscores in the name
+func builtinListed(builtin, name string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(builtin))
+	for scanner.Scan() {
+		base := strings.TrimSuffix(filepath.Base(scanner.Text()), ".ko")
+		if strings.ReplaceAll(base, "-", "_") == name {
+			return true
+		}
+	}
+	return false
+}
+
+// kernelRelease returns the running kernel release (uname -r)
+func kernelRelease() (string, error) {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		b.WriteByte(byte(c))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,81 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withModuleFixture points ModuleAvailable at a fixture tree: procModules
+// as /proc/modules (not created if empty), sysModules as the entries of
+// /sys/module, and builtin as modules.builtin of the running kernel
+func withModuleFixture(t *testing.T, procModules string, sysModules []string, builtin string) {
+	t.Helper()
+	root := t.TempDir()
+	saved := []string{procModulesPath, sysModuleDir, libModulesDir}
+	t.Cleanup(func() { procModulesPath, sysModuleDir, libModulesDir = saved[0], saved[1], saved[2] })
+	procModulesPath = filepath.Join(root, "proc", "modules")
+	sysModuleDir = filepath.Join(root, "sys", "module")
+	libModulesDir = filepath.Join(root, "lib", "modules")
+
+	if procModules != "" {
+		writeFixture(t, procModulesPath, procModules)
+	}
+	for _, name := range sysModules {
+		if err := os.MkdirAll(filepath.Join(sysModuleDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release, err := kernelRelease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFixture(t, filepath.Join(libModulesDir, release, "modules.builtin"), builtin)
+}
+
+func writeFixture(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModuleAvailable(t *testing.T) {
+	withModuleFixture(t,
+		"dm_crypt 65536 1 - Live 0x0000000000000000\nloop_fake 4096 0 - Live 0x0000000000000000\n",
+		[]string{"dm_crypt", "loop"},
+		"kernel/drivers/block/loop.ko\nkernel/drivers/md/dm-mod.ko\n")
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"dm_crypt", true}, // Loaded
+		{"loop", true},     // Built in, with a /sys/module entry
+		{"dm_mod", true},   // Built in, without parameters
+		{"dm_integrity", false},
+		{"loop_fak", false}, // Prefix of a loaded module
+	}
+	for _, tt := range tests {
+		got, err := ModuleAvailable(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ModuleAvailable(%s) = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestModuleAvailableWithoutProcModules(t *testing.T) {
+	withModuleFixture(t, "", []string{"loop"}, "")
+
+	// Other sources still answer
+	if got, err := ModuleAvailable("loop"); err != nil || !got {
+		t.Errorf("ModuleAvailable(loop) = %v, %v; want true", got, err)
+	}
+	// A module found nowhere may still be loaded, so that is an error
+	if _, err := ModuleAvailable("dm_crypt"); err == nil {
+		t.Error("unreadable /proc/modules: expected an error")
+	}
+}
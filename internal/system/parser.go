@@ -2,6 +2,7 @@ package system
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -32,6 +33,33 @@ func ParseSize(s string) (uint64, error) {
 	return value * multipliers[unit], nil
 }
 
+// ParseSizeWithContext converts a size string to bytes like ParseSize, and
+// additionally accepts a percentage (e.g., 50%) of availableBytes
+func ParseSizeWithContext(s string, availableBytes uint64) (uint64, error) {
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasSuffix(trimmed, "%") {
+		return ParseSize(s)
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(trimmed, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size percentage: %s", s)
+	}
+	// Written so that NaN, which compares false with everything, fails too
+	if !(percent > 0 && percent <= 100) {
+		return 0, fmt.Errorf("invalid size percentage: %s (must be greater than 0%% and at most 100%%)", s)
+	}
+	if availableBytes == 0 {
+		return 0, fmt.Errorf("cannot use percentage size %s: no available space", s)
+	}
+
+	size := uint64(float64(availableBytes) * percent / 100)
+	if size == 0 {
+		return 0, fmt.Errorf("size %s of %s is too small", s, FormatSize(availableBytes))
+	}
+	return size, nil
+}
+
 // FormatSize converts bytes to human-readable format
 func FormatSize(bytes uint64) string {
 	const unit = 1024
@@ -43,7 +71,7 @@ func FormatSize(bytes uint64) string {
 		div *= unit
 		exp++
 	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGT"[exp])
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
 // ParseDmsetupTable extracts backing device from dmsetup table output
@@ -56,3 +84,105 @@ func ParseDmsetupTable(output string) (string, error) {
 	// Backing device is typically the 7th field (index 6)
 	return fields[6], nil
 }
+
+// MountinfoEntry represents a single line of /proc/self/mountinfo
+type MountinfoEntry struct {
+	MountPoint   string
+	Options      []string // Per-mount options (e.g., rw, noatime)
+	Filesystem   string
+	Source       string
+	SuperOptions []string // Per-superblock options
+}
+
+// ReadOnly reports whether the mount is read-only
+func (e MountinfoEntry) ReadOnly() bool {
+	for _, opt := range e.Options {
+		if opt == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMountinfo parses the contents of /proc/self/mountinfo.
+// Format: "id parent major:minor root mount-point options [optional...] - fstype source super-options"
+// Malformed lines are skipped.
+func ParseMountinfo(data string) []MountinfoEntry {
+	var entries []MountinfoEntry
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+
+		// Optional fields are terminated by a single "-"
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || len(fields) < sep+3 {
+			continue
+		}
+
+		entry := MountinfoEntry{
+			MountPoint: UnescapeMountField(fields[4]),
+			Options:    strings.Split(fields[5], ","),
+			Filesystem: fields[sep+1],
+			Source:     UnescapeMountField(fields[sep+2]),
+		}
+		if len(fields) > sep+3 {
+			entry.SuperOptions = strings.Split(fields[sep+3], ",")
+		}
+
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// UnescapeMountField decodes the octal escapes the kernel uses for special
+// characters in /proc/mounts and /proc/self/mountinfo fields:
+// \040 (space), \011 (tab), \012 (newline) and \134 (backslash).
+// Backslashes not followed by three octal digits are left as-is.
+func UnescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// ParseCipherBenchmark extracts the encryption speed in bytes/s from
+// "cryptsetup benchmark --cipher ..." output, whose result line looks like:
+//
+//	aes-xts        512b      2500.3 MiB/s      2600.1 MiB/s
+func ParseCipherBenchmark(output string) (uint64, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		// algorithm, key size, encryption speed, unit, decryption speed, unit
+		if len(fields) < 6 || fields[3] != "MiB/s" || !strings.HasSuffix(fields[1], "b") {
+			continue
+		}
+		speed, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || !(speed > 0) || math.IsInf(speed, 1) {
+			continue
+		}
+		return uint64(speed * 1024 * 1024), nil
+	}
+	return 0, fmt.Errorf("no cipher benchmark result found")
+}
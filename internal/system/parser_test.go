@@ -0,0 +1,134 @@
+package system
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseMountinfo(t *testing.T) {
+	data := `22 1 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+36 22 253:0 / /mnt/my\040secrets rw,relatime shared:1 master:2 - ext4 /dev/mapper/brezno_secrets rw,errors=remount-ro
+37 22 253:1 / /srv/back\134slash ro,relatime - xfs /dev/mapper/brezno_ro rw
+malformed line
+38 22 253:2 / /mnt/nosep rw,relatime shared:3 ext4 /dev/mapper/x rw
+`
+	entries := ParseMountinfo(data)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(entries), entries)
+	}
+
+	want := MountinfoEntry{
+		MountPoint:   "/mnt/my secrets",
+		Options:      []string{"rw", "relatime"},
+		Filesystem:   "ext4",
+		Source:       "/dev/mapper/brezno_secrets",
+		SuperOptions: []string{"rw", "errors=remount-ro"},
+	}
+	if !reflect.DeepEqual(entries[1], want) {
+		t.Errorf("entry with optional fields = %+v, want %+v", entries[1], want)
+	}
+	if entries[1].ReadOnly() {
+		t.Error("rw mount reported read-only")
+	}
+
+	ro := entries[2]
+	if ro.MountPoint != `/srv/back\slash` || !ro.ReadOnly() || ro.Filesystem != "xfs" {
+		t.Errorf("read-only entry = %+v", ro)
+	}
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	tests := map[string]string{
+		`/mnt/plain`:         "/mnt/plain",
+		`/mnt/a\040b`:        "/mnt/a b",
+		`/mnt/tab\011here`:   "/mnt/tab\there",
+		`/mnt/nl\012`:        "/mnt/nl\n",
+		`/mnt/back\134slash`: `/mnt/back\slash`,
+		`/mnt/not\08octal`:   `/mnt/not\08octal`,
+		`/mnt/short\04`:      `/mnt/short\04`,
+	}
+	for in, want := range tests {
+		if got := UnescapeMountField(in); got != want {
+			t.Errorf("UnescapeMountField(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		bytes uint64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KB"},
+		{5 << 30, "5.0 GB"},
+		{3 << 50, "3.0 PB"},
+		{math.MaxUint64, "16.0 EB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.bytes); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+}
+
+func TestParseSizeWithContext(t *testing.T) {
+	tests := []struct {
+		size      string
+		available uint64
+		want      uint64
+		wantErr   bool
+	}{
+		{"1G", 0, 1 << 30, false},
+		{"100m", 1000, 100 << 20, false},
+		{"50%", 1000, 500, false},
+		{" 25% ", 1000, 250, false},
+		{"100%", 1000, 1000, false},
+		{"0.5%", 1000, 5, false},
+		{"33.3%", 3 << 30, 1072668082, false},
+		{"0.01%", 1000, 0, true}, // Rounds down to 0 bytes
+		{"0%", 1000, 0, true},
+		{"-5%", 1000, 0, true},
+		{"100.1%", 1000, 0, true},
+		{"NaN%", 1000, 0, true},
+		{"Inf%", 1000, 0, true},
+		{"-Inf%", 1000, 0, true},
+		{"half%", 1000, 0, true},
+		{"%", 1000, 0, true},
+		{"50%", 0, 0, true},
+		{"50", 1000, 50, false},
+		{"1.5G", 1000, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSizeWithContext(tt.size, tt.available)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSizeWithContext(%q, %d) = %d, %v; want %d, error %v",
+				tt.size, tt.available, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCipherBenchmark(t *testing.T) {
+	header := "# Tests are approximate using memory only (no storage IO).\n#     Algorithm |       Key |      Encryption |      Decryption\n"
+	tests := []struct {
+		output  string
+		want    uint64
+		wantErr bool
+	}{
+		{header + "        aes-xts        512b      2500.0 MiB/s      2600.1 MiB/s\n", 2500 << 20, false},
+		{header + "        aes-xts        256b       512.5 MiB/s       530.0 MiB/s\n", 537395200, false},
+		{header + "        aes-xts        512b               N/A               N/A\n", 0, true},
+		{header + "        aes-xts        512b         0.0 MiB/s         0.0 MiB/s\n", 0, true},
+		{header + "        aes-xts        512b         NaN MiB/s         NaN MiB/s\n", 0, true},
+		{header + "        aes-xts        512b        +Inf MiB/s        +Inf MiB/s\n", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCipherBenchmark(tt.output)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseCipherBenchmark(%q) = %d, %v; want %d", tt.output, got, err, tt.want)
+		}
+	}
+}
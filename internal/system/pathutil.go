@@ -2,22 +2,80 @@ package system
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 )
 
+// warningLogger receives warnings from functions here that have no logger
+// of their own, so they follow the --no-color and --quiet settings
+var warningLogger WarningLogger = plainWarnings{}
+
+// SetWarningLogger routes warnings such as insecure keyfile permissions
+// through logger
+func SetWarningLogger(logger WarningLogger) {
+	warningLogger = logger
+}
+
+// plainWarnings writes uncolored warnings to stderr until a logger is set
+type plainWarnings struct{}
+
+func (plainWarnings) Warning(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "[WARNING] "+format+"\n", args...)
+}
+
+// keyfileDir is where bare keyfile names are looked up (--keyfile-dir)
+var keyfileDir string
+
+// SetKeyfileDir makes ValidateKeyfilePath look up keyfile names that are
+// not an existing path in dir. An empty dir disables the lookup.
+func SetKeyfileDir(dir string) {
+	keyfileDir = dir
+}
+
 // ValidateKeyfilePath validates and resolves a keyfile path, checking for
 // security issues like symlinks, incorrect file types, and insecure permissions.
 // Returns the canonical absolute path if valid.
 func ValidateKeyfilePath(path string) (string, error) {
+	return validateSecretFile(lookupKeyfile(path), "keyfile")
+}
+
+// lookupKeyfile returns path itself if it exists or is absolute, and
+// otherwise the file of that name in the keyfile directory, if there is one
+func lookupKeyfile(path string) string {
+	if keyfileDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return path
+	}
+	candidate := filepath.Join(keyfileDir, path)
+	if _, err := os.Lstat(candidate); err == nil {
+		return candidate
+	}
+	return path
+}
+
+// ValidatePasswordFilePath validates and resolves a --password-file path
+// with the same checks as ValidateKeyfilePath.
+func ValidatePasswordFilePath(path string) (string, error) {
+	return validateSecretFile(path, "password file")
+}
+
+// validateSecretFile resolves a file holding a secret, requiring a regular
+// file and warning if it is readable by group or others. kind names the
+// file in messages (e.g., "keyfile").
+func validateSecretFile(path, kind string) (string, error) {
 	// Resolve symlinks to canonical path
 	resolved, err := filepath.EvalSymlinks(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "", fmt.Errorf("keyfile not found: %s", path)
+			return "", fmt.Errorf("%s not found: %s", kind, path)
 		}
-		return "", fmt.Errorf("failed to resolve keyfile path: %w", err)
+		return "", fmt.Errorf("failed to resolve %s path: %w", kind, err)
 	}
 
 	// Clean the path (remove . and ..)
@@ -26,25 +84,95 @@ func ValidateKeyfilePath(path string) (string, error) {
 	// Get file info
 	info, err := os.Stat(resolved)
 	if err != nil {
-		return "", fmt.Errorf("keyfile not accessible: %w", err)
+		return "", fmt.Errorf("%s not accessible: %w", kind, err)
 	}
 
 	// Verify it's a regular file (not directory, device, socket, etc.)
 	if !info.Mode().IsRegular() {
-		return "", fmt.Errorf("keyfile must be a regular file, not a directory or device: %s", resolved)
+		return "", fmt.Errorf("%s must be a regular file, not a directory or device: %s", kind, resolved)
 	}
 
 	// Check permissions - warn if readable by group or others
 	mode := info.Mode().Perm()
 	if mode&0044 != 0 {
-		fmt.Fprintf(os.Stderr, "\033[1;33mWARNING:\033[0m Keyfile %s has insecure permissions (%04o)\n", resolved, mode)
-		fmt.Fprintf(os.Stderr, "         The keyfile is readable by group or others.\n")
-		fmt.Fprintf(os.Stderr, "         Consider: chmod 600 %s\n\n", resolved)
+		title := strings.ToUpper(kind[:1]) + kind[1:]
+		warningLogger.Warning("%s %s has insecure permissions (%04o): it is readable by group or others. Consider: chmod 600 %s",
+			title, resolved, mode, resolved)
 	}
 
 	return resolved, nil
 }
 
+// systemPaths are directories that must not be mounted over, since hiding
+// them would break the running system. With a merged /usr, /bin and the
+// like are symlinks to the directories under /usr.
+var systemPaths = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/lib", "/lib64",
+	"/proc", "/run", "/sbin", "/sys", "/usr", "/var",
+	"/usr/bin", "/usr/lib", "/usr/lib64", "/usr/sbin",
+}
+
+// systemTrees are directories nothing below should be mounted on either:
+// kernel and boot filesystems
+var systemTrees = []string{"/boot", "/dev", "/proc", "/sys"}
+
+// IsSystemPath reports whether mounting on path would cover a directory
+// the system depends on. Symlinks in path are resolved as far as it exists,
+// so e.g. /mnt/link-to-etc is caught too.
+func IsSystemPath(path string) bool {
+	path = filepath.Clean(path)
+	return isSystemPath(path) || isSystemPath(ResolveExistingPath(path))
+}
+
+// isSystemPath checks a clean path against the system directories, without
+// resolving symlinks
+func isSystemPath(path string) bool {
+	for _, p := range systemPaths {
+		if path == p {
+			return true
+		}
+	}
+	for _, tree := range systemTrees {
+		if strings.HasPrefix(path, tree+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveExistingPath resolves symlinks in the longest existing prefix of
+// an absolute path and appends the rest unchanged, so paths that are yet
+// to be created are canonicalized too
+func ResolveExistingPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path
+	}
+	return filepath.Join(ResolveExistingPath(parent), filepath.Base(path))
+}
+
+// CountDirEntries counts the entries in a directory, stopping after max
+// of them (all if max <= 0). A directory that doesn't exist yet has none.
+func CountDirEntries(path string, max int) (int, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(max)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return len(names), nil
+}
+
 // GetFileSize returns the size of a file in bytes
 func GetFileSize(path string) (uint64, error) {
 	info, err := os.Stat(path)
@@ -60,6 +188,61 @@ func GetAvailableSpace(path string) (uint64, error) {
 	if err := syscall.Statfs(filepath.Dir(path), &stat); err != nil {
 		return 0, fmt.Errorf("failed to get filesystem stats: %w", err)
 	}
-	// Available blocks * block size
-	return stat.Bavail * uint64(stat.Bsize), nil
+	return statfsAvailable(&stat)
+}
+
+// statfsAvailable returns available blocks * block size. The Statfs_t field
+// types differ between architectures (Bsize is int64 on amd64, int32 on arm
+// and 386, uint32 on s390x), so both are converted explicitly and checked.
+func statfsAvailable(stat *syscall.Statfs_t) (uint64, error) {
+	bsize := int64(stat.Bsize)
+	if bsize <= 0 {
+		return 0, fmt.Errorf("invalid filesystem block size: %d", bsize)
+	}
+	bavail := uint64(stat.Bavail)
+
+	// Saturate rather than wrap on absurd values
+	if bavail > math.MaxUint64/uint64(bsize) {
+		return math.MaxUint64, nil
+	}
+	return bavail * uint64(bsize), nil
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so readers see either the old or the new contents
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	// Remove the temporary file on any failure
+	success := false
+	defer func() {
+		if !success {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	success = true
+	return nil
 }
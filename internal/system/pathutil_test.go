@@ -0,0 +1,228 @@
+package system
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestStatfsAvailable(t *testing.T) {
+	var stat syscall.Statfs_t
+	stat.Bsize = 4096
+	stat.Bavail = 10
+	if got, err := statfsAvailable(&stat); err != nil || got != 40960 {
+		t.Errorf("statfsAvailable = %d, %v; want 40960", got, err)
+	}
+
+	// Saturates instead of wrapping around
+	stat.Bavail = 1 << 62
+	if got, err := statfsAvailable(&stat); err != nil || got != math.MaxUint64 {
+		t.Errorf("statfsAvailable(huge) = %d, %v; want MaxUint64", got, err)
+	}
+
+	stat.Bsize = 0
+	if _, err := statfsAvailable(&stat); err == nil {
+		t.Error("statfsAvailable accepted a zero block size")
+	}
+}
+
+func TestGetAvailableSpace(t *testing.T) {
+	// The container need not exist yet, its directory is what counts
+	available, err := GetAvailableSpace(filepath.Join(t.TempDir(), "new.img"))
+	if err != nil || available == 0 {
+		t.Errorf("GetAvailableSpace = %d, %v", available, err)
+	}
+}
+
+// writeKeyfile creates a keyfile readable only by its owner
+func writeKeyfile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateKeyfilePathKeyfileDir(t *testing.T) {
+	dir := t.TempDir()
+	defer SetKeyfileDir("")
+	SetKeyfileDir(dir)
+	writeKeyfile(t, filepath.Join(dir, "mykey"))
+
+	// A bare name that is not an existing path resolves in the directory
+	work := t.TempDir()
+	t.Chdir(work)
+	got, err := ValidateKeyfilePath("mykey")
+	if err != nil || got != filepath.Join(dir, "mykey") {
+		t.Errorf("ValidateKeyfilePath(mykey) = %q, %v; want the one in --keyfile-dir", got, err)
+	}
+
+	// An existing path beats the directory lookup
+	writeKeyfile(t, filepath.Join(work, "mykey"))
+	got, err = ValidateKeyfilePath("mykey")
+	if err != nil || got != "mykey" {
+		t.Errorf("ValidateKeyfilePath(mykey) = %q, %v; want the one in the working directory", got, err)
+	}
+
+	// Absolute paths are never looked up
+	if _, err := ValidateKeyfilePath("/nonexistent/mykey"); err == nil {
+		t.Error("missing absolute keyfile accepted")
+	}
+
+	// A name in neither place reports the name given
+	if _, err := ValidateKeyfilePath("other"); err == nil || err.Error() != "keyfile not found: other" {
+		t.Errorf("ValidateKeyfilePath(other) = %v", err)
+	}
+}
+
+func TestValidateKeyfilePathNoKeyfileDir(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyfile(t, filepath.Join(dir, "mykey"))
+	t.Chdir(t.TempDir())
+
+	if _, err := ValidateKeyfilePath("mykey"); err == nil {
+		t.Error("bare name resolved without --keyfile-dir")
+	}
+}
+
+func TestCountDirEntries(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		max  int
+		want int
+	}{
+		{dir, 0, 3},
+		{dir, -1, 3},
+		{dir, 1, 1},
+		{dir, 10, 3},
+		{t.TempDir(), 0, 0},
+		{t.TempDir(), 1, 0},
+		{filepath.Join(dir, "missing"), 0, 0},
+	}
+	for _, tt := range tests {
+		if got, err := CountDirEntries(tt.path, tt.max); err != nil || got != tt.want {
+			t.Errorf("CountDirEntries(%s, %d) = %d, %v; want %d", tt.path, tt.max, got, err, tt.want)
+		}
+	}
+
+	if _, err := CountDirEntries(filepath.Join(dir, "a"), 0); err == nil {
+		t.Error("a file was counted as a directory")
+	}
+}
+
+func TestIsSystemPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, link := range []struct{ name, target string }{
+		{"etc", "/etc"},
+		{"proc", "/proc"},
+		{"bin", "/usr/bin"},
+		{"mnt", dir},
+	} {
+		if err := os.Symlink(link.target, filepath.Join(dir, "link-to-"+link.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		// systemPaths
+		{"/", true},
+		{"/etc", true},
+		{"/etc/", true},
+		{"/usr/../etc", true},
+		{"/bin", true},
+		{"/usr/bin", true},
+		{"/var", true},
+		{"/home", false},
+		{"/mnt", false},
+		{"/etc/brezno", false},
+		{"/var/lib/brezno", false},
+		// systemTrees
+		{"/proc/self", true},
+		{"/sys/fs/cgroup", true},
+		{"/boot/efi", true},
+		{"/dev/shm", true},
+		{"/devices", false},
+		// Symlinks, existing or leading to a path yet to be created
+		{filepath.Join(dir, "link-to-etc"), true},
+		{filepath.Join(dir, "link-to-bin"), true},
+		{filepath.Join(dir, "link-to-proc", "new"), true},
+		{filepath.Join(dir, "link-to-mnt"), false},
+		{filepath.Join(dir, "new"), false},
+	}
+	for _, tt := range tests {
+		if got := IsSystemPath(tt.path); got != tt.want {
+			t.Errorf("IsSystemPath(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+// tempFiles returns the WriteFileAtomic temporary files left in dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inventory.json")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	info, err := os.Stat(path)
+	if err != nil || string(data) != "new" || info.Mode().Perm() != 0600 {
+		t.Errorf("file = %q, mode %v, %v; want \"new\" with mode 0600", data, info.Mode().Perm(), err)
+	}
+	if left := tempFiles(t, dir); len(left) != 0 {
+		t.Errorf("temporary files left behind: %v", left)
+	}
+}
+
+func TestWriteFileAtomicRenameFailure(t *testing.T) {
+	dir := t.TempDir()
+	// A non-empty directory cannot be renamed over
+	path := filepath.Join(dir, "inventory.json")
+	old := filepath.Join(path, "old")
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err == nil {
+		t.Fatal("WriteFileAtomic replaced a directory")
+	}
+	if data, err := os.ReadFile(old); err != nil || string(data) != "old" {
+		t.Errorf("previous contents = %q, %v", data, err)
+	}
+	if left := tempFiles(t, dir); len(left) != 0 {
+		t.Errorf("temporary files left behind: %v", left)
+	}
+}
+
+func TestWriteFileAtomicMissingDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "inventory.json")
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err == nil {
+		t.Error("WriteFileAtomic into a missing directory succeeded")
+	}
+}
@@ -3,17 +3,63 @@ package system
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"syscall"
 )
 
+// sudoReexec makes RequireRoot re-run the program under sudo (see --sudo)
+var sudoReexec bool
+
+// execProgram replaces the process. Tests replace it to see what would run.
+var execProgram = syscall.Exec
+
+// EnableSudoReexec makes RequireRoot re-exec the program under sudo
+// instead of failing when not running as root
+func EnableSudoReexec() {
+	sudoReexec = true
+}
+
 // IsRoot checks if running as root
 func IsRoot() bool {
 	return os.Geteuid() == 0
 }
 
-// RequireRoot ensures the program is running as root
+// RequireRoot ensures the program is running as root.
+// If sudo re-exec is enabled, it replaces the process with the same
+// command run under sudo and only returns if that fails.
 func RequireRoot() error {
-	if !IsRoot() {
-		return fmt.Errorf("this command must be run as root (try with sudo)")
+	if IsRoot() {
+		return nil
+	}
+	if sudoReexec {
+		return reexecWithSudo()
+	}
+	return fmt.Errorf("this command must be run as root (try with sudo, or pass --sudo)")
+}
+
+// reexecWithSudo replaces the current process with "sudo -- <self> <args>".
+// The terminal and stdin are inherited, so password prompts keep working.
+func reexecWithSudo() error {
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return fmt.Errorf("this command must be run as root and sudo was not found: %w", err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate brezno executable: %w", err)
+	}
+
+	if err := execProgram(sudo, sudoArgv(sudo, self, os.Args[1:]), os.Environ()); err != nil {
+		return fmt.Errorf("failed to re-run under sudo: %w", err)
 	}
 	return nil
 }
+
+// sudoArgv builds the argument vector for re-running self under sudo.
+// "--" stops sudo from parsing brezno's own flags.
+func sudoArgv(sudo, self string, args []string) []string {
+	argv := make([]string, 0, len(args)+3)
+	argv = append(argv, sudo, "--", self)
+	return append(argv, args...)
+}
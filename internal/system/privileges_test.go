@@ -0,0 +1,95 @@
+package system
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSudoArgv(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"no arguments", nil, []string{"/usr/bin/sudo", "--", "/usr/local/bin/brezno"}},
+		{
+			"flags before and after the command",
+			[]string{"--verbose", "mount", "-k", "/keys/k", "--readonly", "/data/secrets.img", "/mnt/secrets"},
+			[]string{"/usr/bin/sudo", "--", "/usr/local/bin/brezno", "--verbose", "mount", "-k", "/keys/k", "--readonly", "/data/secrets.img", "/mnt/secrets"},
+		},
+		{
+			// Each argument stays one argument: nothing passes through a shell
+			"arguments needing quotes",
+			[]string{"create", "/data/my secrets.img", "--label=it's mine", "$(reboot)", "*.img", ""},
+			[]string{"/usr/bin/sudo", "--", "/usr/local/bin/brezno", "create", "/data/my secrets.img", "--label=it's mine", "$(reboot)", "*.img", ""},
+		},
+		{
+			// sudo's own options can't be injected: everything follows "--"
+			"sudo-like flags",
+			[]string{"-u", "nobody", "--sudo", "--", "unmount", "-x"},
+			[]string{"/usr/bin/sudo", "--", "/usr/local/bin/brezno", "-u", "nobody", "--sudo", "--", "unmount", "-x"},
+		},
+	}
+	for _, tt := range tests {
+		args := append([]string(nil), tt.args...)
+		got := sudoArgv("/usr/bin/sudo", "/usr/local/bin/brezno", args)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: argv = %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: arguments changed to %q", tt.name, args)
+		}
+	}
+}
+
+func TestReexecWithSudo(t *testing.T) {
+	// A stand-in sudo found through PATH
+	bin := t.TempDir()
+	sudo := filepath.Join(bin, "sudo")
+	if err := os.WriteFile(sudo, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("BREZNO_TEST", "kept")
+
+	oldArgs, oldExec := os.Args, execProgram
+	t.Cleanup(func() { os.Args, execProgram = oldArgs, oldExec })
+	os.Args = []string{"brezno", "mount", "--password-stdin", "/data/my secrets.img", "/mnt/secrets"}
+
+	var gotPath string
+	var gotArgv, gotEnv []string
+	execProgram = func(path string, argv, env []string) error {
+		gotPath, gotArgv, gotEnv = path, argv, env
+		return errors.New("exec format error")
+	}
+
+	err := reexecWithSudo()
+	if err == nil {
+		t.Fatal("reexecWithSudo returned no error although the exec failed")
+	}
+	self, _ := os.Executable()
+	want := []string{sudo, "--", self, "mount", "--password-stdin", "/data/my secrets.img", "/mnt/secrets"}
+	if gotPath != sudo || !reflect.DeepEqual(gotArgv, want) {
+		t.Errorf("exec(%q, %q), want exec(%q, %q)", gotPath, gotArgv, sudo, want)
+	}
+	if !reflect.DeepEqual(gotEnv, os.Environ()) {
+		t.Errorf("environment not passed on: %q", gotEnv)
+	}
+}
+
+func TestReexecWithSudoMissing(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	oldExec := execProgram
+	t.Cleanup(func() { execProgram = oldExec })
+	execProgram = func(string, []string, []string) error {
+		t.Error("exec attempted without sudo")
+		return nil
+	}
+
+	if err := reexecWithSudo(); err == nil {
+		t.Error("expected an error when sudo is not installed")
+	}
+}
@@ -0,0 +1,84 @@
+package system
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ReportRecord is one line of a --report file
+type ReportRecord struct {
+	Operation  string    `json:"operation"` // create, mount, resize, resize-check
+	Path       string    `json:"path"`
+	UUID       string    `json:"uuid,omitempty"`
+	Size       uint64    `json:"size,omitempty"`
+	Filesystem string    `json:"filesystem,omitempty"`
+	MountPoint string    `json:"mount_point,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	Warnings   []string  `json:"warnings,omitempty"`
+}
+
+// AppendReport appends record as a single JSON line to the file at path,
+// creating it if needed. The file is locked while writing so concurrent
+// brezno invocations don't interleave their records.
+func AppendReport(path string, record ReportRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	data = append(data, '\n')
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open report file: %w", err)
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock report file: %w", err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// ReadReports reads the records of a --report file in file order.
+// Lines that are not valid records are skipped, and described in the
+// returned warnings.
+func ReadReports(path string) ([]ReportRecord, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open report file: %w", err)
+	}
+	defer file.Close()
+
+	var records []ReportRecord
+	var warnings []string
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var record ReportRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.Operation == "" {
+			warnings = append(warnings, fmt.Sprintf("Skipping malformed line %d in %s", lineNum, path))
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read report file: %w", err)
+	}
+
+	return records, warnings, nil
+}
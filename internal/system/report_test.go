@@ -0,0 +1,115 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppendReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	records := []ReportRecord{
+		{Operation: "create", Path: "/data/secrets.img", UUID: "1234", Size: 1 << 30, Filesystem: "ext4",
+			Timestamp: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), Success: true},
+		{Operation: "mount", Path: "/data/secrets.img", MountPoint: "/mnt/secrets",
+			Timestamp: time.Date(2026, 3, 1, 12, 5, 0, 0, time.UTC), Error: "wrong passphrase", Warnings: []string{"Mount point is not empty"}},
+	}
+	for _, r := range records {
+		if err := AppendReport(path, r); err != nil {
+			t.Fatalf("AppendReport: %v", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("report file mode = %o, want 600", perm)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(records) || !strings.HasSuffix(string(data), "\n") {
+		t.Fatalf("report file holds %q, want %d newline-terminated lines", data, len(records))
+	}
+	for i, line := range lines {
+		var got ReportRecord
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(got, records[i]) {
+			t.Errorf("line %d = %+v, want %+v", i+1, got, records[i])
+		}
+	}
+	if !strings.Contains(lines[0], `"success":true`) || strings.Contains(lines[0], `"error"`) {
+		t.Errorf("line 1 = %s", lines[0])
+	}
+
+	read, warnings, err := ReadReports(path)
+	if err != nil || len(warnings) != 0 || !reflect.DeepEqual(read, records) {
+		t.Errorf("ReadReports = %+v, %v, %v", read, warnings, err)
+	}
+}
+
+func TestAppendReportKeepsExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte("not a record\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendReport(path, ReportRecord{Operation: "resize", Path: "/data/x.img", Success: true}); err != nil {
+		t.Fatalf("AppendReport: %v", err)
+	}
+
+	// The existing line stays, and so do the permissions the user chose
+	info, _ := os.Stat(path)
+	if perm := info.Mode().Perm(); perm != 0640 {
+		t.Errorf("report file mode = %o, want 640", perm)
+	}
+	records, warnings, err := ReadReports(path)
+	if err != nil || len(records) != 1 || records[0].Operation != "resize" {
+		t.Errorf("ReadReports = %+v, %v", records, err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "line 1") {
+		t.Errorf("warnings = %q, want the existing line skipped", warnings)
+	}
+}
+
+func TestAppendReportConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	const writers = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			record := ReportRecord{Operation: "mount", Path: fmt.Sprintf("/data/%d.img", i), Warnings: []string{strings.Repeat("x", 8192)}}
+			if err := AppendReport(path, record); err != nil {
+				t.Errorf("AppendReport: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	records, warnings, err := ReadReports(path)
+	if err != nil || len(warnings) != 0 || len(records) != writers {
+		t.Errorf("read %d records, warnings %q, err %v; want %d intact records", len(records), warnings, err, writers)
+	}
+}
+
+func TestAppendReportMissingDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "audit.jsonl")
+	if err := AppendReport(path, ReportRecord{Operation: "create"}); err == nil || !strings.Contains(err.Error(), "failed to open report file") {
+		t.Errorf("AppendReport = %v", err)
+	}
+}
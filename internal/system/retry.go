@@ -0,0 +1,41 @@
+package system
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// busyRetryAttempts is how many times RetryBusy tries an operation
+const busyRetryAttempts = 5
+
+// busyRetryDelay is the pause between attempts. Tests shorten it.
+var busyRetryDelay = 500 * time.Millisecond
+
+// RetryBusy runs fn until it succeeds, retrying while it fails because a
+// device is busy. Mapper and loop devices often stay busy for a moment after
+// their user goes away (udev probing, a lazy unmount finishing).
+// Other errors are returned immediately.
+func RetryBusy(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= busyRetryAttempts; attempt++ {
+		err = fn()
+		if err == nil || !IsBusyError(err) || attempt == busyRetryAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(busyRetryDelay):
+		}
+	}
+	return err
+}
+
+// IsBusyError reports whether err looks like a "device busy" failure
+// from cryptsetup, losetup, or umount
+func IsBusyError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "busy") || strings.Contains(msg, "in use")
+}
@@ -0,0 +1,60 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBusy(t *testing.T) {
+	old := busyRetryDelay
+	t.Cleanup(func() { busyRetryDelay = old })
+	busyRetryDelay = time.Millisecond
+
+	errBusy := errors.New("cryptsetup failed: exit status 5\nStderr: Device new_img is still in use.")
+	errOther := errors.New("cryptsetup failed: exit status 4\nStderr: Device new_img is not active.")
+
+	tests := []struct {
+		name      string
+		failures  []error // Errors of the first attempts, then success
+		wantCalls int
+		wantErr   error
+	}{
+		{"success", nil, 1, nil},
+		{"busy once", []error{errBusy}, 2, nil},
+		{"busy until the last attempt", []error{errBusy, errBusy, errBusy, errBusy}, busyRetryAttempts, nil},
+		{"busy beyond the limit", []error{errBusy, errBusy, errBusy, errBusy, errBusy, errBusy}, busyRetryAttempts, errBusy},
+		{"other error", []error{errOther, errOther}, 1, errOther},
+		{"other error after busy", []error{errBusy, errOther}, 2, errOther},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := RetryBusy(context.Background(), func() error {
+			calls++
+			if calls <= len(tt.failures) {
+				return tt.failures[calls-1]
+			}
+			return nil
+		})
+		if calls != tt.wantCalls || err != tt.wantErr {
+			t.Errorf("%s: %d calls, err %v; want %d calls, err %v", tt.name, calls, err, tt.wantCalls, tt.wantErr)
+		}
+	}
+}
+
+func TestRetryBusyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errBusy := errors.New("losetup: /dev/loop3: detach failed: Device or resource busy")
+	calls := 0
+	start := time.Now()
+	err := RetryBusy(ctx, func() error {
+		calls++
+		return errBusy
+	})
+	if calls != 1 || err != errBusy || time.Since(start) >= busyRetryDelay {
+		t.Errorf("%d calls, err %v after %v; want one attempt without waiting", calls, err, time.Since(start))
+	}
+}
@@ -0,0 +1,42 @@
+package system
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+)
+
+// wipeChunkSize is how much random data OverwriteRandom writes at a time
+const wipeChunkSize = 1024 * 1024
+
+// OverwriteRandom overwrites the first length bytes of the file at path
+// with random data and syncs it to disk. The file is not truncated or
+// extended.
+func OverwriteRandom(path string, length uint64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, wipeChunkSize)
+	for written := uint64(0); written < length; {
+		n := uint64(len(buf))
+		if remaining := length - written; remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(rand.Reader, buf[:n]); err != nil {
+			return fmt.Errorf("failed to generate random data: %w", err)
+		}
+		if _, err := file.Write(buf[:n]); err != nil {
+			return fmt.Errorf("failed to overwrite %s: %w", path, err)
+		}
+		written += n
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return file.Close()
+}
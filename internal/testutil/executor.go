@@ -0,0 +1,199 @@
+// Package testutil provides fakes for testing code that runs external
+// commands, without root or the real binaries
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/nace/brezno/internal/system"
+)
+
+// Ensure FakeExecutor implements system.Executor
+var _ system.Executor = (*FakeExecutor)(nil)
+
+// Command is a command run through a FakeExecutor
+type Command struct {
+	Args       []string // Command name followed by its arguments
+	Stdin      string   // What the command would have read from stdin
+	ExtraFiles int      // Number of inherited file descriptors
+}
+
+// Line returns the command and its arguments joined by spaces
+func (c Command) Line() string {
+	return strings.Join(c.Args, " ")
+}
+
+// Response is a canned result for a command
+type Response struct {
+	Output string
+	Err    error
+}
+
+// FakeExecutor records the commands it is asked to run and answers them
+// from canned responses. Commands without a response succeed with no
+// output.
+type FakeExecutor struct {
+	mu        sync.Mutex
+	commands  []Command
+	responses map[string][]Response
+	missing   map[string]bool
+}
+
+// NewFakeExecutor creates a fake executor with no canned responses
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		responses: make(map[string][]Response),
+		missing:   make(map[string]bool),
+	}
+}
+
+// On makes commands whose line starts with prefix (e.g. "losetup -l")
+// return output and err. The longest matching prefix wins. Calling On
+// again for the same prefix queues another response; the last one is
+// repeated once the queue is used up.
+func (f *FakeExecutor) On(prefix, output string, err error) *FakeExecutor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[prefix] = append(f.responses[prefix], Response{Output: output, Err: err})
+	return f
+}
+
+// Missing makes CommandExists report that the named commands are not
+// installed
+func (f *FakeExecutor) Missing(names ...string) *FakeExecutor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range names {
+		f.missing[name] = true
+	}
+	return f
+}
+
+// Commands returns the commands run so far, in order
+func (f *FakeExecutor) Commands() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Command(nil), f.commands...)
+}
+
+// Lines returns the command lines run so far, in order
+func (f *FakeExecutor) Lines() []string {
+	var lines []string
+	for _, c := range f.Commands() {
+		lines = append(lines, c.Line())
+	}
+	return lines
+}
+
+// Ran returns the first command whose line starts with prefix
+func (f *FakeExecutor) Ran(prefix string) (Command, bool) {
+	for _, c := range f.Commands() {
+		if strings.HasPrefix(c.Line(), prefix) {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// respond records a command and returns its canned response
+func (f *FakeExecutor) respond(c Command) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, c)
+
+	line := c.Line()
+	best := ""
+	found := false
+	for prefix := range f.responses {
+		if strings.HasPrefix(line, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	if !found {
+		return "", nil
+	}
+
+	queue := f.responses[best]
+	r := queue[0]
+	if len(queue) > 1 {
+		f.responses[best] = queue[1:]
+	}
+	return r.Output, r.Err
+}
+
+// record turns a prepared command into a Command, reading its stdin
+func record(cmd *exec.Cmd) Command {
+	c := Command{Args: append([]string(nil), cmd.Args...), ExtraFiles: len(cmd.ExtraFiles)}
+	if cmd.Stdin != nil {
+		data, _ := io.ReadAll(cmd.Stdin)
+		c.Stdin = string(data)
+	}
+	return c
+}
+
+// Run records the command and returns its canned error
+func (f *FakeExecutor) Run(ctx context.Context, name string, args ...string) error {
+	_, err := f.RunOutput(ctx, name, args...)
+	return err
+}
+
+// RunOutput records the command and returns its canned response. Like
+// the real executor, it runs nothing once ctx is done.
+func (f *FakeExecutor) RunOutput(ctx context.Context, name string, args ...string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return f.respond(Command{Args: append([]string{name}, args...)})
+}
+
+// RunCmd records the prepared command and returns its canned response
+func (f *FakeExecutor) RunCmd(cmd *exec.Cmd) (string, error) {
+	return f.respond(record(cmd))
+}
+
+// RunCmdWithProgress records the prepared command and returns its canned
+// error
+func (f *FakeExecutor) RunCmdWithProgress(cmd *exec.Cmd) error {
+	_, err := f.respond(record(cmd))
+	return err
+}
+
+// RunCmdStreaming records the prepared command and returns its canned
+// response
+func (f *FakeExecutor) RunCmdStreaming(cmd *exec.Cmd) (string, error) {
+	return f.respond(record(cmd))
+}
+
+// CommandExists reports every command as installed unless marked Missing
+func (f *FakeExecutor) CommandExists(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.missing[name]
+}
+
+// CheckDependencies fails for commands marked Missing
+func (f *FakeExecutor) CheckDependencies(deps []string) error {
+	var missing []string
+	for _, dep := range deps {
+		if !f.CommandExists(dep) {
+			missing = append(missing, dep)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required commands: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ExitError returns the error the real executor returns when name exits
+// with code and writes stderr, wrapping a genuine *exec.ExitError so
+// callers checking the exit code see it
+func ExitError(name string, code int, stderr string) error {
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	return fmt.Errorf("%s failed: %w\nStderr: %s", name, err, stderr)
+}
@@ -10,6 +10,9 @@ type Logger struct {
 	Verbose bool
 	Quiet   bool
 	NoColor bool
+
+	// Collector, if set, receives every warning for the end-of-command summary
+	Collector *WarningCollector
 }
 
 // NewLogger creates a new logger
@@ -59,6 +62,9 @@ func (l *Logger) Success(format string, args ...interface{}) {
 // Warning logs a warning message
 func (l *Logger) Warning(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
+	if l.Collector != nil {
+		l.Collector.Add(msg)
+	}
 	fmt.Fprintf(os.Stderr, "%s\n", l.colorize(colorYellow, "[WARNING] "+msg))
 }
 
@@ -68,6 +74,22 @@ func (l *Logger) Error(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "%s\n", l.colorize(colorRed, "[ERROR] "+msg))
 }
 
+// Summary repeats the collected warnings once a command has finished, so
+// they aren't lost in long output. Like Info, it is suppressed by --quiet.
+func (l *Logger) Summary() {
+	if l.Quiet || l.Collector == nil {
+		return
+	}
+	warnings := l.Collector.List()
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", l.colorize(colorYellow, fmt.Sprintf("Completed with %d warning(s):", len(warnings))))
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "  - %s\n", w)
+	}
+}
+
 // Debug logs a debug message (only if verbose is enabled)
 func (l *Logger) Debug(format string, args ...interface{}) {
 	if !l.Verbose {
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -11,13 +12,17 @@ import (
 type Table struct {
 	Headers []string
 	Rows    [][]string
+	NoColor bool
+
+	warnings map[int]bool
 }
 
 // NewTable creates a new table
 func NewTable(headers ...string) *Table {
 	return &Table{
-		Headers: headers,
-		Rows:    make([][]string, 0),
+		Headers:  headers,
+		Rows:     make([][]string, 0),
+		warnings: make(map[int]bool),
 	}
 }
 
@@ -26,6 +31,12 @@ func (t *Table) AddRow(cells ...string) {
 	t.Rows = append(t.Rows, cells)
 }
 
+// AddWarningRow adds a row that is highlighted when color output is enabled
+func (t *Table) AddWarningRow(cells ...string) {
+	t.warnings[len(t.Rows)] = true
+	t.AddRow(cells...)
+}
+
 // Print prints the table to stdout
 func (t *Table) Print() {
 	if len(t.Rows) == 0 {
@@ -53,7 +64,7 @@ func (t *Table) Print() {
 	fmt.Println(strings.Join(headerParts, "  "))
 
 	// Print rows
-	for _, row := range t.Rows {
+	for r, row := range t.Rows {
 		rowParts := make([]string, len(row))
 		for i, cell := range row {
 			if i < len(widths) {
@@ -62,7 +73,11 @@ func (t *Table) Print() {
 				rowParts[i] = cell
 			}
 		}
-		fmt.Println(strings.Join(rowParts, "  "))
+		line := strings.Join(rowParts, "  ")
+		if t.warnings[r] && !t.NoColor {
+			line = colorYellow + line + colorReset
+		}
+		fmt.Println(line)
 	}
 }
 
@@ -73,9 +88,70 @@ func padRight(s string, length int) string {
 	return s + strings.Repeat(" ", length-len(s))
 }
 
+// JSONSchemaVersion is the version of the JSON output format. Bump it when
+// fields are renamed or removed; adding fields does not need a bump.
+const JSONSchemaVersion = 1
+
+// Envelope wraps JSON output in an object carrying the schema version,
+// with the payload under Key:
+//
+//	{"schema_version": 1, "<Key>": <Value>}
+type Envelope struct {
+	Key   string
+	Value interface{}
+}
+
+// MarshalJSON encodes the envelope with schema_version as the first field
+func (e Envelope) MarshalJSON() ([]byte, error) {
+	key, err := json.Marshal(e.Key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(e.Value)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, `{"schema_version":%d,%s:%s}`, JSONSchemaVersion, key, value), nil
+}
+
+// JSONOutput returns value wrapped in an Envelope under key, or bare for
+// --legacy-json, the shape printed before schema_version was added
+func JSONOutput(key string, value interface{}, legacy bool) interface{} {
+	if legacy {
+		return value
+	}
+	return Envelope{Key: key, Value: value}
+}
+
 // PrintJSON prints data as JSON
 func PrintJSON(v interface{}) error {
-	encoder := json.NewEncoder(os.Stdout)
+	data, err := FormatJSON(v)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// PrintJSONLine prints data as a single line of JSON, for newline-delimited
+// streams. The line is written with one call, so readers tailing the
+// stream never see half a document.
+func PrintJSONLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
+
+// FormatJSON encodes data as indented JSON, as printed by PrintJSON
+func FormatJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
 	encoder.SetIndent("", "  ")
-	return encoder.Encode(v)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
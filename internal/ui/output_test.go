@@ -0,0 +1,35 @@
+package ui
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEnvelopeMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Envelope{Key: "containers", Value: []map[string]int{{"b": 2, "a": 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"schema_version":1,"containers":[{"a":1,"b":2}]}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	tests := []struct {
+		legacy bool
+		want   string
+	}{
+		{false, `{"schema_version":1,"stats":{"count":2}}`},
+		{true, `{"count":2}`},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(JSONOutput("stats", map[string]int{"count": 2}, tt.legacy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("legacy %v: got %s, want %s", tt.legacy, data, tt.want)
+		}
+	}
+}
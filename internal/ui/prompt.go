@@ -2,9 +2,14 @@ package ui
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nace/brezno/internal/system"
 	"golang.org/x/term"
@@ -30,10 +35,24 @@ func PromptStringWithDefault(prompt, defaultValue string) string {
 	return input
 }
 
+// ErrPromptTimeout is returned when no password is entered before the
+// prompt timeout expires
+var ErrPromptTimeout = errors.New("timed out waiting for password")
+
+// promptTimeout bounds how long PromptPassword waits, 0 waits forever
+var promptTimeout time.Duration
+
+// SetPromptTimeout makes PromptPassword give up after d without input,
+// so automation that forgot --password-stdin fails instead of hanging.
+// 0 disables the timeout.
+func SetPromptTimeout(d time.Duration) {
+	promptTimeout = d
+}
+
 // PromptPassword prompts for a password without echoing
 func PromptPassword(prompt string) (*system.SecureBytes, error) {
 	fmt.Fprintf(os.Stderr, "%s: ", prompt)
-	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	password, err := readPassword(int(os.Stdin.Fd()), promptTimeout)
 	fmt.Fprintln(os.Stderr) // New line after password input
 	if err != nil {
 		return nil, err
@@ -41,6 +60,100 @@ func PromptPassword(prompt string) (*system.SecureBytes, error) {
 	return system.NewSecureBytes(password), nil
 }
 
+// Terminal operations, replaced in tests, which have no terminal
+var (
+	getTermState     = term.GetState
+	restoreTerm      = term.Restore
+	readTermPassword = term.ReadPassword
+)
+
+// savedTerminal is the terminal state from before a password prompt
+// disabled echo, kept while the prompt is active so that an interrupt
+// can restore it
+var (
+	savedTerminalMu sync.Mutex
+	savedTerminal   *term.State
+	savedTerminalFd int
+)
+
+// RestoreTerminal re-enables echo if a password prompt is in progress.
+// The signal handler calls it before exiting, since an interrupted prompt
+// would otherwise leave the shell with echo disabled.
+func RestoreTerminal() {
+	savedTerminalMu.Lock()
+	defer savedTerminalMu.Unlock()
+
+	if savedTerminal == nil {
+		return
+	}
+	restoreTerm(savedTerminalFd, savedTerminal)
+	savedTerminal = nil
+	fmt.Fprintln(os.Stderr) // End the prompt line
+}
+
+// saveTerminal records the state of fd for RestoreTerminal
+func saveTerminal(fd int, state *term.State) {
+	savedTerminalMu.Lock()
+	defer savedTerminalMu.Unlock()
+	savedTerminal = state
+	savedTerminalFd = fd
+}
+
+// forgetTerminal is called once a prompt has finished, after which
+// there is nothing for RestoreTerminal to undo
+func forgetTerminal() {
+	savedTerminalMu.Lock()
+	defer savedTerminalMu.Unlock()
+	savedTerminal = nil
+}
+
+// readPassword reads a password from the terminal fd, giving up after
+// timeout (if non-zero). The terminal state is restored however the
+// read ends, including on timeout, where the abandoned read would
+// otherwise leave echo disabled.
+func readPassword(fd int, timeout time.Duration) ([]byte, error) {
+	state, err := getTermState(fd)
+	if err != nil {
+		// Not a terminal: let ReadPassword report it
+		return readTermPassword(fd)
+	}
+	saveTerminal(fd, state)
+	defer func() {
+		forgetTerminal()
+		restoreTerm(fd, state)
+	}()
+
+	if timeout <= 0 {
+		return readTermPassword(fd)
+	}
+
+	type result struct {
+		password []byte
+		err      error
+	}
+	done := make(chan result, 1)
+	read := readTermPassword
+	go func() {
+		password, err := read(fd)
+		done <- result{password, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.password, r.err
+	case <-timer.C:
+		// The read can't be interrupted; zero whatever it returns later
+		go func() {
+			r := <-done
+			system.NewSecureBytes(r.password).Zeroize()
+		}()
+		return nil, fmt.Errorf("%w after %s (use --password-stdin or --keyfile for automation)", ErrPromptTimeout, timeout)
+	}
+}
+
 // ReadPasswordFromStdin reads a password from stdin (for automation/testing)
 // The password should be provided as a single line.
 // This is useful for scripting and CI/CD pipelines.
@@ -71,6 +184,59 @@ func ReadPasswordFromStdin() (*system.SecureBytes, error) {
 	return system.NewSecureBytes(password), nil
 }
 
+// MaxStdinKeySize is the largest key ReadKeyFromStdin accepts, the same as
+// cryptsetup's default limit for keyfiles
+const MaxStdinKeySize = 8 << 20
+
+// ReadKeyFromStdin reads raw key material from stdin until EOF. Unlike
+// ReadPasswordFromStdin, newlines are part of the key.
+func ReadKeyFromStdin() (*system.SecureBytes, error) {
+	return readKey(os.Stdin, MaxStdinKeySize)
+}
+
+// readKey reads at most max bytes of key material from r. It reads into one
+// buffer allocated up front, since a growing buffer would leave copies of
+// the key behind, and zeroizes the buffer whatever happens.
+func readKey(r io.Reader, max int) (*system.SecureBytes, error) {
+	// One byte more than allowed tells a key of exactly max bytes from a
+	// longer one
+	buf := system.NewSecureBytes(make([]byte, max+1))
+	defer buf.Zeroize()
+
+	n, err := io.ReadFull(r, buf.Bytes())
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("key is larger than %d bytes", max)
+	case err != io.EOF && err != io.ErrUnexpectedEOF:
+		return nil, err
+	}
+
+	key := make([]byte, n)
+	copy(key, buf.Bytes()[:n])
+	return system.NewSecureBytes(key), nil
+}
+
+// ReadPasswordFromFile reads a password from the first line of a file
+// (for orchestration tools that write secrets to a tmpfs file)
+func ReadPasswordFromFile(path string) (*system.SecureBytes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer system.NewSecureBytes(data).Zeroize()
+
+	line := data
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+
+	// Copy out of data, which is zeroized on return
+	password := make([]byte, len(line))
+	copy(password, line)
+	return system.NewSecureBytes(password), nil
+}
+
 // PromptConfirm prompts for yes/no confirmation
 func PromptConfirm(prompt string) bool {
 	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
@@ -0,0 +1,198 @@
+package ui
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// spyReader serves data and then err, keeping the buffers it was asked to
+// fill so a test can check they were zeroized
+type spyReader struct {
+	data []byte
+	err  error
+	bufs [][]byte
+}
+
+func (r *spyReader) Read(p []byte) (int, error) {
+	r.bufs = append(r.bufs, p)
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// assertZeroized fails unless every buffer r filled is zero again
+func (r *spyReader) assertZeroized(t *testing.T) {
+	t.Helper()
+	for _, buf := range r.bufs {
+		if !bytes.Equal(buf, make([]byte, len(buf))) {
+			t.Errorf("read buffer not zeroized: %q", bytes.TrimRight(buf, "\x00"))
+		}
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{"binary key with newlines", "k\x00e\ny\n", "k\x00e\ny\n", false},
+		{"exactly the maximum", "12345678", "12345678", false},
+		{"empty", "", "", false},
+		{"too large", "123456789", "", true},
+	}
+	for _, tt := range tests {
+		r := &spyReader{data: []byte(tt.data), err: io.EOF}
+		key, err := readKey(r, 8)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+		} else if err != nil || string(key.Bytes()) != tt.want {
+			t.Errorf("%s: readKey = %q, %v; want %q", tt.name, key.Bytes(), err, tt.want)
+		}
+		r.assertZeroized(t)
+	}
+}
+
+func TestReadKeyError(t *testing.T) {
+	failure := errors.New("read failed")
+	r := &spyReader{data: []byte("partial"), err: failure}
+	if _, err := readKey(r, 64); !errors.Is(err, failure) {
+		t.Errorf("readKey = %v, want %v", err, failure)
+	}
+	r.assertZeroized(t)
+}
+
+// fakeTerminal makes a pipe stand in for the terminal: password reads come
+// from it a line at a time, and restores of the saved state are counted.
+// It returns the read end's fd and the write end, which delivers input.
+func fakeTerminal(t *testing.T, restores *atomic.Int32) (int, *os.File) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		w.Close() // Ends any read abandoned by a timeout
+		r.Close()
+	})
+
+	oldGet, oldRestore, oldRead := getTermState, restoreTerm, readTermPassword
+	t.Cleanup(func() { getTermState, restoreTerm, readTermPassword = oldGet, oldRestore, oldRead })
+	getTermState = func(int) (*term.State, error) { return &term.State{}, nil }
+	restoreTerm = func(int, *term.State) error {
+		restores.Add(1)
+		return nil
+	}
+	readTermPassword = func(fd int) ([]byte, error) {
+		var password []byte
+		var b [1]byte
+		for {
+			n, err := syscall.Read(fd, b[:])
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return nil, io.EOF
+			}
+			if b[0] == '\n' {
+				return password, nil
+			}
+			password = append(password, b[0])
+		}
+	}
+	return int(r.Fd()), w
+}
+
+func TestReadPasswordTimeout(t *testing.T) {
+	var restores atomic.Int32
+	fd, _ := fakeTerminal(t, &restores)
+
+	start := time.Now()
+	password, err := readPassword(fd, 50*time.Millisecond)
+	if !errors.Is(err, ErrPromptTimeout) || password != nil {
+		t.Fatalf("readPassword = %q, %v; want ErrPromptTimeout", password, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out after %s", elapsed)
+	}
+	if n := restores.Load(); n != 1 {
+		t.Errorf("terminal restored %d times, want once", n)
+	}
+
+	// Nothing is left for the signal handler to restore
+	RestoreTerminal()
+	if n := restores.Load(); n != 1 {
+		t.Errorf("RestoreTerminal after the prompt restored again (%d)", n)
+	}
+}
+
+func TestReadPasswordInTime(t *testing.T) {
+	var restores atomic.Int32
+	fd, w := fakeTerminal(t, &restores)
+	if _, err := w.WriteString("secret\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	password, err := readPassword(fd, time.Minute)
+	if err != nil || string(password) != "secret" {
+		t.Errorf("readPassword = %q, %v", password, err)
+	}
+	if n := restores.Load(); n != 1 {
+		t.Errorf("terminal restored %d times, want once", n)
+	}
+}
+
+func TestRestoreTerminalDuringPrompt(t *testing.T) {
+	var restores atomic.Int32
+	fd, w := fakeTerminal(t, &restores)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := readPassword(fd, 0)
+		done <- err
+	}()
+	// Wait for the prompt to save the terminal state
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		savedTerminalMu.Lock()
+		saved := savedTerminal != nil
+		savedTerminalMu.Unlock()
+		if saved {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("prompt never saved the terminal state")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The signal handler may race with other exit paths: only the first
+	// call restores
+	RestoreTerminal()
+	RestoreTerminal()
+	if n := restores.Load(); n != 1 {
+		t.Errorf("RestoreTerminal restored %d times, want once", n)
+	}
+
+	w.WriteString("x\n")
+	if err := <-done; err != nil {
+		t.Fatalf("readPassword: %v", err)
+	}
+	RestoreTerminal()
+	if n := restores.Load(); n != 2 {
+		t.Errorf("restored %d times in all, want the prompt's own restore only", n)
+	}
+}
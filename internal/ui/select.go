@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Option is one choice offered by SelectOption
+type Option struct {
+	Value       string
+	Description string // One line shown next to the value
+}
+
+// IsInteractive reports whether stdin is a terminal a user can answer
+// prompts on
+func IsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// SelectOption shows a numbered menu and returns the chosen option's value.
+// The first option is the default, picked by pressing Enter. The answer may
+// be the number or the value itself.
+func SelectOption(prompt string, options []Option) (string, error) {
+	if len(options) == 0 {
+		return "", fmt.Errorf("nothing to choose from")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s:\n", prompt)
+	for i, opt := range options {
+		fmt.Fprintf(os.Stderr, "  %d) %-8s %s\n", i+1, opt.Value, opt.Description)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "Choice [%s]: ", options[0].Value)
+		input, err := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" {
+			if err != nil {
+				return "", fmt.Errorf("failed to read choice: %w", err)
+			}
+			return options[0].Value, nil
+		}
+
+		if n, convErr := strconv.Atoi(input); convErr == nil && n >= 1 && n <= len(options) {
+			return options[n-1].Value, nil
+		}
+		for _, opt := range options {
+			if input == opt.Value {
+				return opt.Value, nil
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid choice: %s", input)
+		}
+		fmt.Fprintf(os.Stderr, "Invalid choice %q, enter 1-%d\n", input, len(options))
+	}
+}
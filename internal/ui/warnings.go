@@ -0,0 +1,24 @@
+package ui
+
+import "sync"
+
+// WarningCollector keeps the warnings logged during a command so they can
+// be repeated in a summary at the end, where they are harder to miss
+type WarningCollector struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+// Add records a warning
+func (w *WarningCollector) Add(msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.warnings = append(w.warnings, msg)
+}
+
+// List returns the warnings recorded so far, in order
+func (w *WarningCollector) List() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.warnings...)
+}
@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// captureStderr returns what f writes to stderr
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestWarningCollector(t *testing.T) {
+	w := &WarningCollector{}
+	if got := w.List(); len(got) != 0 {
+		t.Errorf("new collector lists %v", got)
+	}
+
+	w.Add("first")
+	w.Add("second")
+	list := w.List()
+	if !reflect.DeepEqual(list, []string{"first", "second"}) {
+		t.Errorf("List() = %v", list)
+	}
+
+	// The returned slice is a copy
+	list[0] = "changed"
+	if got := w.List()[0]; got != "first" {
+		t.Errorf("List() shares its backing array, first warning is now %q", got)
+	}
+}
+
+func TestLoggerSummary(t *testing.T) {
+	logger := NewLogger(false, false, true)
+	logger.Collector = &WarningCollector{}
+
+	out := captureStderr(t, func() {
+		logger.Warning("disk %s is almost full", "/data")
+		logger.Warning("no TRIM")
+	})
+	if !strings.Contains(out, "[WARNING] disk /data is almost full") {
+		t.Errorf("warning not printed: %q", out)
+	}
+
+	summary := captureStderr(t, logger.Summary)
+	want := "Completed with 2 warning(s):\n  - disk /data is almost full\n  - no TRIM\n"
+	if summary != want {
+		t.Errorf("Summary() printed %q, want %q", summary, want)
+	}
+}
+
+func TestLoggerSummaryQuiet(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger *Logger
+	}{
+		{"no warnings", &Logger{NoColor: true, Collector: &WarningCollector{}}},
+		{"quiet", &Logger{NoColor: true, Quiet: true, Collector: &WarningCollector{}}},
+		{"no collector", &Logger{NoColor: true}},
+	}
+	for _, tt := range tests {
+		if tt.name != "no warnings" {
+			captureStderr(t, func() { tt.logger.Warning("ignored") })
+		}
+		if out := captureStderr(t, tt.logger.Summary); out != "" {
+			t.Errorf("%s: Summary() printed %q", tt.name, out)
+		}
+	}
+}